@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -46,7 +47,88 @@ func ConnectToMongo() (*mongo.Client, error) {
 		return nil, err
 	}
 
+	indexOnce.Do(func() { ensureIndexes(client) })
+
 	fmt.Println("✅ Connected Successfully")
 
 	return client, nil
 }
+
+var indexOnce sync.Once
+
+// ensureIndexes creates the indexes the handlers rely on. Failures are only
+// logged so a bad index on existing data does not take the API down.
+func ensureIndexes(client *mongo.Client) {
+	db := client.Database("paymentx")
+
+	indexes := map[string][]mongo.IndexModel{
+		"transactions": {
+			{Keys: bson.M{"transactionid": 1}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "transactiondate", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "account_id", Value: 1}, {Key: "transactiondate", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "amount", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "category", Value: 1}, {Key: "transactiondate", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "merchant", Value: 1}, {Key: "transactiondate", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "type", Value: 1}, {Key: "transactiondate", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "balance", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "tags", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "searchterms", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "batch_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+			{Keys: bson.M{"deleted_at": 1}, Options: options.Index().SetSparse(true)},
+		},
+		"transaction_history": {
+			{Keys: bson.D{{Key: "transaction_id", Value: 1}, {Key: "version", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"undo_tokens": {
+			{Keys: bson.M{"token": 1}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.M{"expiresat": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"idempotency_keys": {
+			{Keys: bson.D{{Key: "scope", Value: 1}, {Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.M{"expiresat": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"saved_views": {
+			{Keys: bson.M{"user_id": 1}},
+			{Keys: bson.M{"household_id": 1}, Options: options.Index().SetSparse(true)},
+		},
+		"households": {
+			{Keys: bson.M{"member_ids": 1}},
+		},
+		"household_invitations": {
+			{Keys: bson.D{{Key: "household_id", Value: 1}, {Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "email", Value: 1}, {Key: "status", Value: 1}}},
+		},
+		"accounts": {
+			{Keys: bson.M{"user_id": 1}},
+		},
+		"duplicate_candidates": {
+			{Keys: bson.M{"dedupkey": 1}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}}},
+		},
+		"statements": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "periodstart", Value: -1}}},
+		},
+		"alerts": {
+			{Keys: bson.M{"dedupkey": 1}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "createdat", Value: -1}}},
+		},
+		"alert_rules": {
+			{Keys: bson.M{"user_id": 1}},
+		},
+		"jobs": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "createdat", Value: -1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextrunat", Value: 1}}},
+			{Keys: bson.M{"expiresat": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"anomalies": {
+			{Keys: bson.M{"dedupkey": 1}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "date", Value: -1}}},
+		},
+	}
+
+	for name, models := range indexes {
+		if _, err := db.Collection(name).Indexes().CreateMany(context.Background(), models); err != nil {
+			fmt.Println("Failed to create indexes on", name, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package exporters
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+type csvExporter struct {
+	w       *csv.Writer
+	columns []Column
+	layout  string
+}
+
+func newCSV(w io.Writer, columns []Column, layout string, _ Options) Exporter {
+	return &csvExporter{w: csv.NewWriter(w), columns: columns, layout: layout}
+}
+
+func (e *csvExporter) Begin() error {
+	header := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		header[i] = column.Name
+	}
+	return e.w.Write(header)
+}
+
+func (e *csvExporter) Write(row Row) error {
+	record := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		value := column.value(row, e.layout)
+		if !column.Number {
+			value = neutralizeFormula(value)
+		}
+		record[i] = value
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExporter) End() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// neutralizeFormula stops spreadsheets from running text that looks like a
+// formula, such as a narration starting with "=".
+func neutralizeFormula(value string) string {
+	if value == "" {
+		return value
+	}
+	switch value[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		return "'" + value
+	}
+	return value
+}
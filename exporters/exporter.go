@@ -0,0 +1,174 @@
+// Package exporters writes transactions out in file formats other tools can
+// read. Exporters write one row at a time so a large history can be streamed
+// straight from a Mongo cursor.
+package exporters
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+// Row is a transaction with the fields derived from other collections.
+type Row struct {
+	models.Transaction
+	AccountName string
+}
+
+// Exporter writes rows in one file format. Begin is called once before the
+// first row and End once after the last one.
+type Exporter interface {
+	Begin() error
+	Write(row Row) error
+	End() error
+}
+
+// Options are the choices a user can make about an export. Columns and
+// DateFormat do not apply to OFX and the journals, whose layout is fixed.
+type Options struct {
+	Columns    []string
+	DateFormat string
+	// Start and End are the period the export covers, and Account the
+	// account it is for, used by formats that declare them up front
+	Start, End time.Time
+	Account    string
+	// Ledger is needed by the journal formats
+	Ledger *Ledger
+}
+
+// Format describes an export format.
+type Format struct {
+	ContentType string
+	Extension   string
+	new         func(w io.Writer, columns []Column, layout string, opts Options) Exporter
+}
+
+var formats = map[string]Format{
+	"csv":    {"text/csv; charset=utf-8", "csv", newCSV},
+	"xlsx":   {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx", newXLSX},
+	"ndjson": {"application/x-ndjson", "ndjson", newNDJSON},
+	"ofx":    {"application/x-ofx", "ofx", newOFX},
+	// Journals ignore Columns and DateFormat too
+	"beancount": {"text/plain; charset=utf-8", "beancount", newBeancount},
+	"hledger":   {"text/plain; charset=utf-8", "journal", newHledger},
+}
+
+// dateFormats are the date formats a user can pick, by name.
+var dateFormats = map[string]string{
+	"iso":         "2006-01-02",
+	"dd/mm/yyyy":  "02/01/2006",
+	"mm/dd/yyyy":  "01/02/2006",
+	"dd-mm-yyyy":  "02-01-2006",
+	"dd-mon-yyyy": "02-Jan-2006",
+	"rfc3339":     time.RFC3339,
+}
+
+// Column is one exportable field. Number columns are written as numbers by
+// formats that tell numbers and text apart, and left empty when the value is
+// missing.
+type Column struct {
+	Name   string
+	Number bool
+	value  func(row Row, layout string) string
+}
+
+func text(name string, value func(row Row) string) Column {
+	return Column{Name: name, value: func(row Row, _ string) string { return value(row) }}
+}
+
+func number(name string, value func(row Row) float64) Column {
+	return Column{Name: name, Number: true, value: func(row Row, _ string) string {
+		return strconv.FormatFloat(value(row), 'f', -1, 64)
+	}}
+}
+
+// optionalNumber is a number column whose value may be missing.
+func optionalNumber(name string, value func(row Row) *float64) Column {
+	return Column{Name: name, Number: true, value: func(row Row, _ string) string {
+		if v := value(row); v != nil {
+			return strconv.FormatFloat(*v, 'f', -1, 64)
+		}
+		return ""
+	}}
+}
+
+var columns = []Column{
+	text("id", func(row Row) string { return row.ID.Hex() }),
+	{Name: "transaction_date", value: func(row Row, layout string) string {
+		return row.TransactionDate.Time().UTC().Format(layout)
+	}},
+	text("transaction_time", func(row Row) string { return row.TransactionTime }),
+	text("value_date", func(row Row) string { return row.ValueDate }),
+	text("details", func(row Row) string { return row.Details }),
+	text("type", func(row Row) string { return string(row.Type) }),
+	number("amount", func(row Row) float64 { return row.Amount }),
+	optionalNumber("balance", func(row Row) *float64 { return row.Balance }),
+	text("merchant", func(row Row) string { return row.Merchant }),
+	text("counterparty", func(row Row) string { return row.Counterparty }),
+	text("category", func(row Row) string { return row.Category }),
+	text("account", func(row Row) string { return row.AccountName }),
+	text("account_id", func(row Row) string {
+		if row.AccountID.IsZero() {
+			return ""
+		}
+		return row.AccountID.Hex()
+	}),
+	text("tags", func(row Row) string { return strings.Join(row.Tags, ",") }),
+	text("notes", func(row Row) string { return row.Notes }),
+	text("transaction_id", func(row Row) string { return row.TransactionID }),
+	text("source", func(row Row) string { return row.Source }),
+	text("is_transfer", func(row Row) string { return strconv.FormatBool(row.IsTransfer) }),
+	number("refunded_amount", func(row Row) float64 { return row.RefundedAmount }),
+}
+
+// DefaultColumns are exported when the user does not choose any.
+var DefaultColumns = []string{"transaction_date", "details", "type", "amount", "balance", "merchant", "category", "account", "tags", "notes"}
+
+// New returns an exporter writing format to w.
+func New(format string, w io.Writer, opts Options) (Exporter, error) {
+	f, ok := formats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	names := opts.Columns
+	if len(names) == 0 {
+		names = DefaultColumns
+	}
+	selected := make([]Column, 0, len(names))
+	for _, name := range names {
+		column, ok := findColumn(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		selected = append(selected, column)
+	}
+
+	layout := dateFormats["iso"]
+	if opts.DateFormat != "" {
+		if layout, ok = dateFormats[opts.DateFormat]; !ok {
+			return nil, fmt.Errorf("unknown date format %q", opts.DateFormat)
+		}
+	}
+
+	return f.new(w, selected, layout, opts), nil
+}
+
+// LookupFormat returns the description of a format.
+func LookupFormat(format string) (Format, bool) {
+	f, ok := formats[format]
+	return f, ok
+}
+
+func findColumn(name string) (Column, bool) {
+	for _, column := range columns {
+		if column.Name == name {
+			return column, true
+		}
+	}
+	return Column{}, false
+}
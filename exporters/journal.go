@@ -0,0 +1,645 @@
+package exporters
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Dialect is a plain-text accounting file format.
+type Dialect string
+
+const (
+	Beancount Dialect = "beancount"
+	Hledger   Dialect = "hledger"
+)
+
+const (
+	journalCurrency = "INR"
+	openingAccount  = "Equity:Opening-Balances"
+	// transferClearing holds transfers whose two legs are not written as
+	// one transaction, such as legs booked on different days
+	transferClearing = "Assets:Transfers"
+	// transferFees takes the difference when the two legs of a transfer
+	// differ in amount
+	transferFees     = "Expenses:Transfer-Fees"
+	journalTolerance = 0.005
+)
+
+// Ledger is what a journal needs to know beyond the transactions
+// themselves.
+type Ledger struct {
+	Accounts map[primitive.ObjectID]models.Account
+	// Transfers holds every transfer leg in the export, so a pair can be
+	// written as one transaction from one account to the other
+	Transfers map[primitive.ObjectID]models.Transaction
+}
+
+// journalExporter writes a double-entry journal. Rows must come in statement
+// order: by date, then time of day, then insertion order.
+//
+// Each account opens with the balance implied by the first transaction that
+// reports one, and every day closes with an assertion of the last reported
+// balance. Categories become Expenses and Income accounts, and a transfer
+// pair booked on the same day becomes one transaction between the two
+// accounts.
+type journalExporter struct {
+	w       *bufio.Writer
+	dialect Dialect
+	ledger  Ledger
+	// start is when accounts are opened, so that an opening balance dated
+	// back to an account's first transaction is never before its open
+	start primitive.DateTime
+
+	opened  map[string]bool
+	running map[string]float64
+	first   map[string]primitive.DateTime
+	// balanced are accounts whose opening balance has been written
+	balanced map[string]bool
+	// closing holds the last reported balance of each account on day
+	day     string
+	closing map[string]float64
+	// paired are transfer legs already written with their partner
+	paired map[primitive.ObjectID]bool
+}
+
+func newBeancount(w io.Writer, _ []Column, _ string, opts Options) Exporter {
+	return newJournal(w, Beancount, opts)
+}
+
+func newHledger(w io.Writer, _ []Column, _ string, opts Options) Exporter {
+	return newJournal(w, Hledger, opts)
+}
+
+func newJournal(w io.Writer, dialect Dialect, opts Options) *journalExporter {
+	e := &journalExporter{
+		w:        bufio.NewWriter(w),
+		dialect:  dialect,
+		opened:   make(map[string]bool),
+		running:  make(map[string]float64),
+		first:    make(map[string]primitive.DateTime),
+		balanced: make(map[string]bool),
+		closing:  make(map[string]float64),
+		paired:   make(map[primitive.ObjectID]bool),
+	}
+	if opts.Ledger != nil {
+		e.ledger = *opts.Ledger
+	}
+	if !opts.Start.IsZero() {
+		e.start = primitive.NewDateTimeFromTime(opts.Start)
+	}
+	return e
+}
+
+func (e *journalExporter) Begin() error {
+	if e.dialect == Beancount {
+		e.w.WriteString(`option "title" "PaymentX"` + "\n")
+		e.w.WriteString(`option "operating_currency" "` + journalCurrency + `"` + "\n\n")
+	} else {
+		e.w.WriteString("; PaymentX\n\n")
+	}
+	return e.w.Flush()
+}
+
+func (e *journalExporter) Write(row Row) error {
+	day := dateOf(row.TransactionDate)
+	if day != e.day {
+		e.closeDay()
+		e.day = day
+	}
+
+	account := e.bankAccount(row.AccountID)
+	signed := signedAmount(row.Transaction)
+
+	switch {
+	case e.paired[row.ID]:
+		// Written along with its partner
+	case row.IsTransfer && !row.TransferPairID.IsZero():
+		partner, ok := e.ledger.Transfers[row.TransferPairID]
+		if ok && dateOf(partner.TransactionDate) == day {
+			postings := []posting{{account, signed}, {e.bankAccount(partner.AccountID), signedAmount(partner)}}
+			if diff := round2(signed + signedAmount(partner)); math.Abs(diff) > journalTolerance {
+				postings = append(postings, posting{transferFees, -diff})
+			}
+			e.transaction(row, postings)
+			e.paired[partner.ID] = true
+		} else {
+			e.transaction(row, []posting{{account, signed}, {transferClearing, -signed}})
+		}
+	default:
+		e.transaction(row, append([]posting{{account, signed}}, e.categoryPostings(row.Transaction, signed)...))
+	}
+
+	if row.Balance != nil {
+		if !e.balanced[account] {
+			e.balanced[account] = true
+			if opening := round2(*row.Balance - e.running[account]); math.Abs(opening) > journalTolerance {
+				e.running[account] += opening
+				e.writeTransaction(e.first[account], "", "Opening balance", nil, nil,
+					[]posting{{account, opening}, {openingAccount, -opening}})
+			}
+		}
+		e.closing[account] = *row.Balance
+	}
+
+	// Errors stick to the bufio.Writer, so an empty write reports any
+	_, err := e.w.WriteString("")
+	return err
+}
+
+func (e *journalExporter) End() error {
+	e.closeDay()
+	return e.w.Flush()
+}
+
+type posting struct {
+	account string
+	amount  float64
+}
+
+func (e *journalExporter) transaction(row Row, postings []posting) {
+	for _, p := range postings {
+		if _, ok := e.first[p.account]; !ok {
+			e.first[p.account] = row.TransactionDate
+		}
+		e.running[p.account] = round2(e.running[p.account] + p.amount)
+	}
+
+	meta := []string{"id", row.ID.Hex()}
+	if row.TransactionID != "" {
+		meta = append(meta, "bank_ref", row.TransactionID)
+	}
+	if row.Notes != "" {
+		meta = append(meta, "notes", row.Notes)
+	}
+	e.writeTransaction(row.TransactionDate, row.Merchant, row.Details, row.Tags, meta, postings)
+}
+
+func (e *journalExporter) writeTransaction(date primitive.DateTime, payee, narration string, tags, meta []string, postings []posting) {
+	for _, p := range postings {
+		e.open(p.account, date)
+	}
+
+	day := dateOf(date)
+	if e.dialect == Beancount {
+		e.w.WriteString(day + " *")
+		if payee != "" {
+			e.w.WriteString(" " + beancountString(payee))
+		}
+		e.w.WriteString(" " + beancountString(narration))
+		for _, tag := range tags {
+			if tag = tagName(tag); tag != "" {
+				e.w.WriteString(" #" + tag)
+			}
+		}
+		e.w.WriteString("\n")
+		for i := 0; i+1 < len(meta); i += 2 {
+			e.w.WriteString("  " + meta[i] + ": " + beancountString(meta[i+1]) + "\n")
+		}
+	} else {
+		// A semicolon would start a comment
+		description := strings.ReplaceAll(oneLine(narration), ";", ",")
+		if payee != "" {
+			description = strings.ReplaceAll(oneLine(payee), ";", ",") + " | " + description
+		}
+		e.w.WriteString(day + " * " + description)
+		var comments []string
+		for _, tag := range tags {
+			if tag = tagName(tag); tag != "" {
+				comments = append(comments, tag+":")
+			}
+		}
+		for i := 0; i+1 < len(meta); i += 2 {
+			comments = append(comments, meta[i]+":"+strings.ReplaceAll(oneLine(meta[i+1]), ",", ";"))
+		}
+		if len(comments) > 0 {
+			e.w.WriteString("  ; " + strings.Join(comments, ", "))
+		}
+		e.w.WriteString("\n")
+	}
+
+	for _, p := range postings {
+		e.w.WriteString("  " + p.account + "  " + formatAmount(p.amount) + "\n")
+	}
+	e.w.WriteString("\n")
+}
+
+// open declares an account the first time it is used. hledger does not need
+// accounts declared.
+func (e *journalExporter) open(account string, date primitive.DateTime) {
+	if e.opened[account] {
+		return
+	}
+	e.opened[account] = true
+	if e.start != 0 && e.start < date {
+		date = e.start
+	}
+	if e.dialect == Beancount {
+		e.w.WriteString(dateOf(date) + " open " + account + " " + journalCurrency + "\n\n")
+	}
+}
+
+// closeDay asserts the closing balance of every account that reported one
+// on the current day. Beancount checks a balance at the start of its date,
+// so the assertion goes on the next day.
+func (e *journalExporter) closeDay() {
+	if len(e.closing) == 0 {
+		return
+	}
+
+	accounts := make([]string, 0, len(e.closing))
+	for account := range e.closing {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+
+	for _, account := range accounts {
+		balance := e.closing[account]
+		if e.dialect == Beancount {
+			day, _ := time.Parse("2006-01-02", e.day)
+			e.w.WriteString(day.AddDate(0, 0, 1).Format("2006-01-02") + " balance " + account + "  " + formatAmount(balance) + "\n")
+		} else {
+			e.w.WriteString(e.day + " * Closing balance\n")
+			e.w.WriteString("  " + account + "  " + formatAmount(0) + " = " + formatAmount(balance) + "\n\n")
+		}
+	}
+	if e.dialect == Beancount {
+		e.w.WriteString("\n")
+	}
+	e.closing = make(map[string]float64)
+}
+
+// bankAccount is the journal account of one of the user's accounts.
+func (e *journalExporter) bankAccount(id primitive.ObjectID) string {
+	account, ok := e.ledger.Accounts[id]
+	if !ok {
+		return "Assets:Bank:Unassigned"
+	}
+	name := accountComponent(account.Name)
+	switch account.Type {
+	case models.CreditCardAccount:
+		return "Liabilities:CreditCard:" + name
+	case models.WalletAccount:
+		return "Assets:Wallet:" + name
+	}
+	return "Assets:Bank:" + name
+}
+
+// categoryAccount is the other side of an ordinary transaction: an expense
+// for debits, and income for credits unless the credit refunds an expense.
+// categoryPostings balance a transaction of signed amount against its
+// category, or against the category of each part when it is split. The
+// last part takes up any rounding so the postings balance.
+func (e *journalExporter) categoryPostings(txn models.Transaction, signed float64) []posting {
+	if len(txn.Splits) == 0 {
+		return []posting{{e.categoryAccount(txn), -signed}}
+	}
+
+	postings := make([]posting, 0, len(txn.Splits))
+	rest := -signed
+	for i, split := range txn.Splits {
+		part := txn
+		part.Category, part.Amount = split.Category, split.Amount
+		amount := -signedAmount(part)
+		if i == len(txn.Splits)-1 {
+			amount = rest
+		}
+		rest = round2(rest - amount)
+		postings = append(postings, posting{e.categoryAccount(part), amount})
+	}
+	return postings
+}
+
+func (e *journalExporter) categoryAccount(txn models.Transaction) string {
+	category := txn.Category
+	if category == "" {
+		category = "Uncategorized"
+	}
+	if txn.Type == models.Credit && txn.RefundOf.IsZero() {
+		return "Income:" + accountComponent(category)
+	}
+	return "Expenses:" + accountComponent(category)
+}
+
+// accountComponent turns a name such as "Food & dining" into a valid
+// account name component, "Food-Dining".
+func accountComponent(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !(r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)))
+	})
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	if len(words) == 0 {
+		return "Other"
+	}
+	return strings.Join(words, "-")
+}
+
+// tagName keeps the characters both formats allow in a tag, joining words
+// with dashes.
+func tagName(tag string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_'):
+			return r
+		case unicode.IsSpace(r):
+			return '-'
+		}
+		return -1
+	}, strings.TrimSpace(tag))
+}
+
+func beancountString(s string) string {
+	s = strings.ReplaceAll(oneLine(s), `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(round2(amount), 'f', 2, 64) + " " + journalCurrency
+}
+
+func signedAmount(txn models.Transaction) float64 {
+	if txn.Type == models.Debit {
+		return -txn.Amount
+	}
+	return txn.Amount
+}
+
+func dateOf(date primitive.DateTime) string {
+	return date.Time().UTC().Format("2006-01-02")
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// ValidateJournal checks that a journal written by this package is well
+// formed: dates parse, account names are valid, every transaction balances,
+// and in Beancount every account is opened before it is used. It reports the
+// first problem with its line number.
+func ValidateJournal(r io.Reader, dialect Dialect) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	opened := make(map[string]string)
+	var txn *journalEntry
+	finish := func() error {
+		if txn == nil {
+			return nil
+		}
+		err := txn.check(dialect, opened)
+		txn = nil
+		return err
+	}
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+
+		fail := func(format string, args ...interface{}) error {
+			return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+		}
+
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, ";"):
+			if trimmed == "" {
+				if err := finish(); err != nil {
+					return err
+				}
+			}
+			continue
+		case text != trimmed && strings.HasPrefix(text, " "):
+			if txn == nil {
+				return fail("posting outside a transaction")
+			}
+			if err := txn.addLine(dialect, trimmed, line); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := finish(); err != nil {
+			return err
+		}
+
+		if dialect == Beancount && strings.HasPrefix(trimmed, "option ") {
+			if len(beancountStrings(strings.TrimPrefix(trimmed, "option "))) != 2 {
+				return fail("option needs a name and a value")
+			}
+			continue
+		}
+
+		fields := strings.Fields(trimmed)
+		if len(fields) < 2 {
+			return fail("expected a dated directive")
+		}
+		if _, err := time.Parse("2006-01-02", fields[0]); err != nil {
+			return fail("invalid date %q", fields[0])
+		}
+		date := fields[0]
+
+		switch {
+		case fields[1] == "*":
+			txn = &journalEntry{date: date, line: line}
+			if dialect == Beancount {
+				rest := strings.TrimSpace(strings.TrimPrefix(trimmed, date+" *"))
+				if n := len(beancountStrings(rest)); n < 1 || n > 2 {
+					return fail("expected a payee and narration")
+				}
+			}
+		case dialect == Beancount && fields[1] == "open":
+			if len(fields) < 3 || !validAccount(fields[2]) {
+				return fail("invalid account in open")
+			}
+			if _, ok := opened[fields[2]]; ok {
+				return fail("%s opened twice", fields[2])
+			}
+			opened[fields[2]] = date
+		case dialect == Beancount && fields[1] == "balance":
+			if len(fields) != 5 || !validAccount(fields[2]) {
+				return fail("expected balance ACCOUNT AMOUNT CURRENCY")
+			}
+			if _, err := strconv.ParseFloat(fields[3], 64); err != nil {
+				return fail("invalid amount %q", fields[3])
+			}
+			if err := checkOpened(opened, fields[2], date); err != nil {
+				return fail("%v", err)
+			}
+		default:
+			return fail("unknown directive %q", fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return finish()
+}
+
+type journalEntry struct {
+	date     string
+	line     int
+	postings []posting
+	missing  int
+	accounts []string
+}
+
+func (t *journalEntry) addLine(dialect Dialect, text string, line int) error {
+	fields := strings.Fields(text)
+	if dialect == Beancount && strings.HasSuffix(fields[0], ":") && !validAccount(strings.TrimSuffix(fields[0], ":")) {
+		// Metadata
+		if len(beancountStrings(text)) != 1 {
+			return fmt.Errorf("line %d: metadata value must be a string", line)
+		}
+		return nil
+	}
+
+	if !validAccount(fields[0]) {
+		return fmt.Errorf("line %d: invalid account %q", line, fields[0])
+	}
+	t.accounts = append(t.accounts, fields[0])
+
+	rest := fields[1:]
+	if len(rest) == 0 {
+		t.missing++
+		return nil
+	}
+	if len(rest) < 2 || rest[1] != journalCurrency {
+		return fmt.Errorf("line %d: expected AMOUNT %s", line, journalCurrency)
+	}
+	amount, err := strconv.ParseFloat(rest[0], 64)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid amount %q", line, rest[0])
+	}
+	rest = rest[2:]
+	if dialect == Hledger && len(rest) > 0 {
+		if len(rest) != 3 || rest[0] != "=" || rest[2] != journalCurrency {
+			return fmt.Errorf("line %d: expected = AMOUNT %s", line, journalCurrency)
+		}
+		if _, err := strconv.ParseFloat(rest[1], 64); err != nil {
+			return fmt.Errorf("line %d: invalid assertion %q", line, rest[1])
+		}
+		rest = nil
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("line %d: unexpected %q", line, strings.Join(rest, " "))
+	}
+	t.postings = append(t.postings, posting{fields[0], amount})
+	return nil
+}
+
+func (t *journalEntry) check(dialect Dialect, opened map[string]string) error {
+	if len(t.accounts) == 0 {
+		return fmt.Errorf("line %d: transaction has no postings", t.line)
+	}
+	if t.missing > 1 {
+		return fmt.Errorf("line %d: more than one posting without an amount", t.line)
+	}
+
+	sum := 0.0
+	for _, p := range t.postings {
+		sum += p.amount
+	}
+	if t.missing == 0 && math.Abs(sum) > journalTolerance {
+		return fmt.Errorf("line %d: transaction does not balance by %.2f", t.line, sum)
+	}
+
+	if dialect == Beancount {
+		for _, account := range t.accounts {
+			if err := checkOpened(opened, account, t.date); err != nil {
+				return fmt.Errorf("line %d: %v", t.line, err)
+			}
+		}
+	}
+	return nil
+}
+
+func checkOpened(opened map[string]string, account, date string) error {
+	since, ok := opened[account]
+	if !ok {
+		return fmt.Errorf("%s is not opened", account)
+	}
+	if date < since {
+		return fmt.Errorf("%s is used before it is opened on %s", account, since)
+	}
+	return nil
+}
+
+// validAccount reports whether name is an account both formats accept: a
+// root type and components starting with a capital letter or digit.
+func validAccount(name string) bool {
+	parts := strings.Split(name, ":")
+	switch parts[0] {
+	case "Assets", "Liabilities", "Equity", "Income", "Expenses":
+	default:
+		return false
+	}
+	if len(parts) < 2 {
+		return false
+	}
+	for _, part := range parts[1:] {
+		if part == "" || !(unicode.IsUpper(rune(part[0])) || unicode.IsDigit(rune(part[0]))) {
+			return false
+		}
+		for _, r := range part {
+			if !(r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-')) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// beancountStrings returns the double quoted strings in s, or nil if s has
+// anything else apart from tags.
+func beancountStrings(s string) []string {
+	var strs []string
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		if strings.HasPrefix(s, "#") {
+			end := strings.IndexByte(s, ' ')
+			if end < 0 {
+				end = len(s)
+			}
+			s = s[end:]
+			continue
+		}
+		if s[0] != '"' {
+			// Metadata keys come before their value
+			if end := strings.Index(s, ": "); end > 0 && len(strs) == 0 && !strings.ContainsAny(s[:end], ` "`) {
+				s = s[end+2:]
+				continue
+			}
+			return nil
+		}
+
+		var b strings.Builder
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+			}
+			b.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return nil
+		}
+		strs = append(strs, b.String())
+		s = s[i+1:]
+	}
+	return strs
+}
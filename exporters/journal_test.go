@@ -0,0 +1,190 @@
+package exporters
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func day(s string) primitive.DateTime {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return primitive.NewDateTimeFromTime(t)
+}
+
+func balance(v float64) *float64 {
+	return &v
+}
+
+// journalFixture is a short history of a bank account and a credit card:
+// spending, income, a refund, a bill split across categories, a transfer
+// booked on one day and one whose legs fall on different days, and
+// narrations with characters either format has to escape.
+func journalFixture() ([]Row, Ledger) {
+	bank := models.Account{ID: primitive.NewObjectID(), Name: "HDFC Savings", Type: models.SavingsAccount}
+	card := models.Account{ID: primitive.NewObjectID(), Name: "Amex Gold", Type: models.CreditCardAccount}
+
+	payDebit := models.Transaction{ID: primitive.NewObjectID(), AccountID: bank.ID, TransactionDate: day("2024-03-05"), Amount: 12000, Type: models.Debit, Details: "CC BILL PAYMENT AMEX", IsTransfer: true, Balance: balance(63000)}
+	payCredit := models.Transaction{ID: primitive.NewObjectID(), AccountID: card.ID, TransactionDate: day("2024-03-05"), Amount: 11990, Type: models.Credit, Details: "PAYMENT RECEIVED", IsTransfer: true}
+	payDebit.TransferPairID, payCredit.TransferPairID = payCredit.ID, payDebit.ID
+
+	sentDebit := models.Transaction{ID: primitive.NewObjectID(), AccountID: bank.ID, TransactionDate: day("2024-03-08"), Amount: 5000, Type: models.Debit, Details: "NEFT TO SELF", IsTransfer: true, Balance: balance(57550)}
+	sentCredit := models.Transaction{ID: primitive.NewObjectID(), AccountID: card.ID, TransactionDate: day("2024-03-09"), Amount: 4990, Type: models.Credit, Details: "PAYMENT RECEIVED", IsTransfer: true}
+	sentDebit.TransferPairID, sentCredit.TransferPairID = sentCredit.ID, sentDebit.ID
+
+	purchase := models.Transaction{ID: primitive.NewObjectID(), AccountID: card.ID, TransactionDate: day("2024-03-02"), Amount: 1450, Type: models.Debit, Details: "AMAZON; order \"A-1\"", Merchant: "Amazon", Category: "Shopping", Tags: []string{"home office", "gift"}}
+
+	txns := []models.Transaction{
+		{ID: primitive.NewObjectID(), AccountID: bank.ID, TransactionDate: day("2024-03-01"), Amount: 75000, Type: models.Credit, Details: "SALARY MARCH", Category: "Salary", Balance: balance(75450), TransactionID: "abc123"},
+		purchase,
+		{ID: primitive.NewObjectID(), AccountID: bank.ID, TransactionDate: day("2024-03-03"), Amount: 450, Type: models.Debit, Details: `UPI/SWIGGY\blr`, Merchant: "Swiggy", Category: "Food & Dining", Notes: "team lunch, split later; ask Ravi", Balance: balance(75000)},
+		payDebit,
+		payCredit,
+		{ID: primitive.NewObjectID(), AccountID: card.ID, TransactionDate: day("2024-03-06"), Amount: 450, Type: models.Credit, Details: "AMAZON REFUND", Category: "Shopping", RefundOf: purchase.ID},
+		{ID: primitive.NewObjectID(), AccountID: bank.ID, TransactionDate: day("2024-03-07"), Amount: 450, Type: models.Debit, Details: "ATM CASH", Balance: balance(62550)},
+		{ID: primitive.NewObjectID(), AccountID: card.ID, TransactionDate: day("2024-03-07"), Amount: 1000, Type: models.Debit, Details: "DMART", Merchant: "DMart", Category: "Groceries", Splits: []models.TransactionSplit{
+			{Amount: 700, Category: "Groceries"},
+			{Amount: 300, Category: "Household"},
+		}},
+		sentDebit,
+		sentCredit,
+		{ID: primitive.NewObjectID(), TransactionDate: day("2024-03-09"), Amount: 99, Type: models.Debit, Details: "cash, no account"},
+	}
+
+	ledger := Ledger{
+		Accounts:  map[primitive.ObjectID]models.Account{bank.ID: bank, card.ID: card},
+		Transfers: map[primitive.ObjectID]models.Transaction{},
+	}
+	rows := make([]Row, len(txns))
+	for i, txn := range txns {
+		rows[i] = Row{Transaction: txn}
+		if txn.IsTransfer {
+			ledger.Transfers[txn.ID] = txn
+		}
+	}
+	return rows, ledger
+}
+
+func exportJournal(t *testing.T, format string) []byte {
+	t.Helper()
+	rows, ledger := journalFixture()
+
+	var buf bytes.Buffer
+	exporter, err := New(format, &buf, Options{Ledger: &ledger, Start: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := exporter.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := exporter.End(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestJournalRoundTrip(t *testing.T) {
+	tests := []struct {
+		format  string
+		dialect Dialect
+		// checker is the tool of the format, run when it is installed
+		checker []string
+		want    []string
+	}{
+		{
+			format:  "beancount",
+			dialect: Beancount,
+			checker: []string{"bean-check"},
+			want: []string{
+				"open Assets:Bank:HDFC-Savings INR",
+				"open Liabilities:CreditCard:Amex-Gold INR",
+				"Expenses:Food-Dining  450.00 INR",
+				"Expenses:Groceries  700.00 INR",
+				"Expenses:Household  300.00 INR",
+				"Income:Salary  -75000.00 INR",
+				"Assets:Transfers",
+				"Expenses:Transfer-Fees  10.00 INR",
+				"2024-03-04 balance Assets:Bank:HDFC-Savings  75000.00 INR",
+				"#home-office #gift",
+				`"AMAZON; order \"A-1\""`,
+			},
+		},
+		{
+			format:  "hledger",
+			dialect: Hledger,
+			checker: []string{"hledger", "check", "-f"},
+			want: []string{
+				"Amazon | AMAZON, order \"A-1\"",
+				"home-office:, gift:",
+				"Assets:Bank:HDFC-Savings  0.00 INR = 75000.00 INR",
+				"Expenses:Shopping  -450.00 INR",
+				"Assets:Bank:Unassigned  -99.00 INR",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			journal := exportJournal(t, tt.format)
+
+			if err := ValidateJournal(bytes.NewReader(journal), tt.dialect); err != nil {
+				t.Fatalf("%v\n%s", err, journal)
+			}
+			for _, want := range tt.want {
+				if !bytes.Contains(journal, []byte(want)) {
+					t.Errorf("journal lacks %q\n%s", want, journal)
+				}
+			}
+
+			if _, err := exec.LookPath(tt.checker[0]); err != nil {
+				return
+			}
+			path := filepath.Join(t.TempDir(), "export."+tt.format)
+			if err := os.WriteFile(path, journal, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if out, err := exec.Command(tt.checker[0], append(tt.checker[1:], path)...).CombinedOutput(); err != nil {
+				t.Errorf("%s: %v\n%s", tt.checker[0], err, out)
+			}
+		})
+	}
+}
+
+func TestValidateJournalRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		journal string
+		want    string
+	}{
+		{"unbalanced", Hledger, "2024-03-01 * Lunch\n  Assets:Bank:Cash  -10.00 INR\n  Expenses:Food  9.00 INR\n", "does not balance"},
+		{"bad account", Hledger, "2024-03-01 * Lunch\n  Assets:bank  -10.00 INR\n  Expenses:Food  10.00 INR\n", "invalid account"},
+		{"bad date", Hledger, "2024-13-01 * Lunch\n  Assets:Bank  -10.00 INR\n  Expenses:Food  10.00 INR\n", "invalid date"},
+		{"not opened", Beancount, "2024-03-01 open Assets:Bank INR\n\n2024-03-01 * \"Lunch\"\n  Assets:Bank  -10.00 INR\n  Expenses:Food  10.00 INR\n", "not opened"},
+		{"used before open", Beancount, "2024-03-02 open Assets:Bank INR\n2024-03-02 open Expenses:Food INR\n\n2024-03-01 * \"Lunch\"\n  Assets:Bank  -10.00 INR\n  Expenses:Food  10.00 INR\n", "before it is opened"},
+		{"unquoted narration", Beancount, "2024-03-01 open Assets:Bank INR\n2024-03-01 open Expenses:Food INR\n\n2024-03-01 * Lunch\n  Assets:Bank  -10.00 INR\n  Expenses:Food  10.00 INR\n", "payee and narration"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateJournal(strings.NewReader(tt.journal), tt.dialect)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
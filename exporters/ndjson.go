@@ -0,0 +1,55 @@
+package exporters
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// ndjsonExporter writes one JSON object per line, with the keys in column
+// order.
+type ndjsonExporter struct {
+	w       *bufio.Writer
+	columns []Column
+	layout  string
+}
+
+func newNDJSON(w io.Writer, columns []Column, layout string, _ Options) Exporter {
+	return &ndjsonExporter{w: bufio.NewWriter(w), columns: columns, layout: layout}
+}
+
+func (e *ndjsonExporter) Begin() error {
+	return nil
+}
+
+func (e *ndjsonExporter) Write(row Row) error {
+	e.w.WriteByte('{')
+	for i, column := range e.columns {
+		if i > 0 {
+			e.w.WriteByte(',')
+		}
+		key, _ := json.Marshal(column.Name)
+		e.w.Write(key)
+		e.w.WriteByte(':')
+
+		value := column.value(row, e.layout)
+		if column.Number && value == "" {
+			e.w.WriteString("null")
+		} else if column.Number {
+			e.w.WriteString(value)
+		} else {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			e.w.Write(encoded)
+		}
+	}
+	// Errors stick to the bufio.Writer, so the last write reports any
+	_, err := e.w.WriteString("}\n")
+	return err
+}
+
+func (e *ndjsonExporter) End() error {
+	return e.w.Flush()
+}
@@ -0,0 +1,114 @@
+package exporters
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const ofxHeader = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+`
+
+// ofxNameLength is the longest NAME the OFX specification allows.
+const ofxNameLength = 32
+
+// ofxExporter writes an OFX 2.2 bank statement. Debits have a negative
+// TRNAMT, and the closing balance is that of the latest transaction that
+// reports one.
+type ofxExporter struct {
+	w         *bufio.Writer
+	opts      Options
+	latest    models.Transaction
+	balance   float64
+	balanceAt primitive.DateTime
+}
+
+func newOFX(w io.Writer, _ []Column, _ string, opts Options) Exporter {
+	return &ofxExporter{w: bufio.NewWriter(w), opts: opts}
+}
+
+func (e *ofxExporter) Begin() error {
+	account := e.opts.Account
+	if account == "" {
+		account = "PAYMENTX"
+	}
+	end := e.opts.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	e.w.WriteString(ofxHeader)
+	e.w.WriteString("<OFX>\n<SIGNONMSGSRSV1><SONRS>")
+	e.w.WriteString("<STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>")
+	e.w.WriteString("<DTSERVER>" + ofxDateTime(time.Now()) + "</DTSERVER><LANGUAGE>ENG</LANGUAGE>")
+	e.w.WriteString("</SONRS></SIGNONMSGSRSV1>\n")
+	e.w.WriteString("<BANKMSGSRSV1><STMTTRNRS><TRNUID>0</TRNUID>")
+	e.w.WriteString("<STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>\n")
+	e.w.WriteString("<STMTRS><CURDEF>INR</CURDEF>")
+	e.w.WriteString("<BANKACCTFROM><BANKID>PAYMENTX</BANKID><ACCTID>")
+	xml.EscapeText(e.w, []byte(account))
+	e.w.WriteString("</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>\n")
+	e.w.WriteString("<BANKTRANLIST><DTSTART>" + ofxDateTime(e.opts.Start) + "</DTSTART>")
+	_, err := e.w.WriteString("<DTEND>" + ofxDateTime(end) + "</DTEND>\n")
+	return err
+}
+
+func (e *ofxExporter) Write(row Row) error {
+	if row.TransactionDate >= e.latest.TransactionDate {
+		e.latest = row.Transaction
+	}
+	if row.Balance != nil && row.TransactionDate >= e.balanceAt {
+		e.balance, e.balanceAt = *row.Balance, row.TransactionDate
+	}
+
+	amount := row.Amount
+	if row.Type == models.Debit {
+		amount = -amount
+	}
+	fitid := row.TransactionID
+	if fitid == "" {
+		fitid = row.ID.Hex()
+	}
+	name := row.Merchant
+	if name == "" {
+		name = row.Details
+	}
+	if r := []rune(name); len(r) > ofxNameLength {
+		name = string(r[:ofxNameLength])
+	}
+
+	e.w.WriteString("<STMTTRN><TRNTYPE>" + string(row.Type) + "</TRNTYPE>")
+	e.w.WriteString("<DTPOSTED>" + ofxDateTime(row.TransactionDate.Time()) + "</DTPOSTED>")
+	e.w.WriteString("<TRNAMT>" + strconv.FormatFloat(amount, 'f', 2, 64) + "</TRNAMT>")
+	e.w.WriteString("<FITID>")
+	xml.EscapeText(e.w, []byte(fitid))
+	e.w.WriteString("</FITID><NAME>")
+	xml.EscapeText(e.w, []byte(name))
+	e.w.WriteString("</NAME><MEMO>")
+	xml.EscapeText(e.w, []byte(row.Details))
+	_, err := e.w.WriteString("</MEMO></STMTTRN>\n")
+	return err
+}
+
+func (e *ofxExporter) End() error {
+	asOf := e.latest.TransactionDate.Time()
+	if e.latest.TransactionDate == 0 {
+		asOf = time.Now()
+	}
+
+	e.w.WriteString("</BANKTRANLIST>\n")
+	e.w.WriteString("<LEDGERBAL><BALAMT>" + strconv.FormatFloat(e.balance, 'f', 2, 64) + "</BALAMT>")
+	e.w.WriteString("<DTASOF>" + ofxDateTime(asOf) + "</DTASOF></LEDGERBAL>\n")
+	e.w.WriteString("</STMTRS></STMTTRNRS></BANKMSGSRSV1>\n</OFX>\n")
+	return e.w.Flush()
+}
+
+func ofxDateTime(t time.Time) string {
+	return t.UTC().Format("20060102150405")
+}
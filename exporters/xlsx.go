@@ -0,0 +1,124 @@
+package exporters
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+// xlsxParts are the fixed parts of a workbook with a single sheet. The sheet
+// itself is written last so it can be streamed.
+var xlsxParts = []struct{ name, body string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Transactions" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+// xlsxExporter writes a workbook with inline strings, which avoids having
+// to collect a shared string table before the sheet is written.
+type xlsxExporter struct {
+	zip     *zip.Writer
+	sheet   *bufio.Writer
+	columns []Column
+	layout  string
+	row     int
+}
+
+func newXLSX(w io.Writer, columns []Column, layout string, _ Options) Exporter {
+	return &xlsxExporter{zip: zip.NewWriter(w), columns: columns, layout: layout}
+}
+
+func (e *xlsxExporter) Begin() error {
+	for _, part := range xlsxParts {
+		f, err := e.zip.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return err
+		}
+	}
+
+	f, err := e.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	e.sheet = bufio.NewWriter(f)
+	e.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	e.sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	header := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		header[i] = column.Name
+	}
+	return e.writeRow(header, nil)
+}
+
+func (e *xlsxExporter) Write(row Row) error {
+	values := make([]string, len(e.columns))
+	numbers := make([]bool, len(e.columns))
+	for i, column := range e.columns {
+		values[i] = column.value(row, e.layout)
+		numbers[i] = column.Number
+	}
+	return e.writeRow(values, numbers)
+}
+
+func (e *xlsxExporter) writeRow(values []string, numbers []bool) error {
+	e.row++
+	ref := strconv.Itoa(e.row)
+
+	e.sheet.WriteString(`<row r="` + ref + `">`)
+	for i, value := range values {
+		cell := columnName(i) + ref
+		if numbers != nil && numbers[i] {
+			// A missing number is an empty cell
+			if value == "" {
+				continue
+			}
+			e.sheet.WriteString(`<c r="` + cell + `"><v>` + value + `</v></c>`)
+			continue
+		}
+		e.sheet.WriteString(`<c r="` + cell + `" t="inlineStr"><is><t xml:space="preserve">`)
+		xml.EscapeText(e.sheet, []byte(value))
+		e.sheet.WriteString(`</t></is></c>`)
+	}
+	_, err := e.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (e *xlsxExporter) End() error {
+	e.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := e.sheet.Flush(); err != nil {
+		return err
+	}
+	return e.zip.Close()
+}
+
+// columnName is the spreadsheet name of a zero based column: A, B, ... Z,
+// AA, AB and so on.
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
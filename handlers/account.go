@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func CreateAccount(w http.ResponseWriter, r *http.Request) {
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var account models.Account
+	if err := json.NewDecoder(r.Body).Decode(&account); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	account.Name = strings.TrimSpace(account.Name)
+	if account.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	account.ID = primitive.NewObjectID()
+	account.UserID = userDB.ID
+	account.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	collection := client.Database("paymentx").Collection("accounts")
+	if _, err := collection.InsertOne(context.Background(), account); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(account)
+}
+
+func GetAccounts(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	accounts, err := userAccounts(client, userDB.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	results := []models.Account{}
+	for _, account := range accounts {
+		results = append(results, account)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid account id", http.StatusBadRequest)
+		return
+	}
+
+	db := client.Database("paymentx")
+	result, err := db.Collection("accounts").DeleteOne(context.Background(), bson.M{"_id": id, "user_id": userDB.ID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		http.Error(w, "Account not found", http.StatusNotFound)
+		return
+	}
+
+	// Transactions are kept but no longer point at the deleted account
+	_, err = db.Collection("transactions").UpdateMany(context.Background(),
+		bson.M{"user_id": userDB.ID, "account_id": id},
+		bson.M{"$unset": bson.M{"account_id": ""}},
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// userAccounts returns the user's accounts keyed by ID.
+func userAccounts(client *mongo.Client, userID primitive.ObjectID) (map[primitive.ObjectID]models.Account, error) {
+	collection := client.Database("paymentx").Collection("accounts")
+	cursor, err := collection.Find(context.Background(), bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []models.Account
+	if err := cursor.All(context.Background(), &accounts); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[primitive.ObjectID]models.Account)
+	for _, account := range accounts {
+		byID[account.ID] = account
+	}
+	return byID, nil
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func validateAlertRule(rule models.AlertRule) error {
+	switch rule.Kind {
+	case models.AlertDebitAbove, models.AlertBalanceBelow:
+		if rule.Threshold <= 0 {
+			return fmt.Errorf("threshold must be greater than 0")
+		}
+	case models.AlertMerchantFrequency:
+		if rule.Count <= 0 {
+			return fmt.Errorf("count must be greater than 0")
+		}
+	case models.AlertCreditContains:
+		if rule.Keyword == "" {
+			return fmt.Errorf("keyword is required")
+		}
+	default:
+		return fmt.Errorf("invalid rule kind %q", rule.Kind)
+	}
+
+	for _, channel := range rule.Channels {
+		switch channel {
+		case models.ChannelInApp, models.ChannelEmail:
+		case models.ChannelWebhook:
+			if rule.WebhookURL == "" {
+				return fmt.Errorf("webhook_url is required for webhook channel")
+			}
+			if err := helpers.ValidateWebhookURL(rule.WebhookURL); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("invalid channel %q", channel)
+		}
+	}
+
+	return nil
+}
+
+func CreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rule := models.AlertRule{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(rule.Channels) == 0 {
+		rule.Channels = []models.AlertChannel{models.ChannelInApp}
+	}
+
+	if err := validateAlertRule(rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rule.ID = primitive.NewObjectID()
+	rule.UserID = userDB.ID
+	rule.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	collection := client.Database("paymentx").Collection("alert_rules")
+	if _, err := collection.InsertOne(context.Background(), rule); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+func GetAlertRules(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("alert_rules")
+	cursor, err := collection.Find(context.Background(), bson.M{"user_id": userDB.ID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	rules := []models.AlertRule{}
+	if err := cursor.All(context.Background(), &rules); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+func DeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid rule id", http.StatusBadRequest)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("alert_rules")
+	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": id, "user_id": userDB.ID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		http.Error(w, "Rule not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func GetAlerts(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	filter := bson.M{"user_id": userDB.ID}
+	if r.URL.Query().Get("unread") == "true" {
+		filter["read"] = false
+	}
+
+	collection := client.Database("paymentx").Collection("alerts")
+	cursor, err := collection.Find(context.Background(), filter, options.Find().SetSort(bson.M{"createdat": -1}))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	alerts := []models.Alert{}
+	if err := cursor.All(context.Background(), &alerts); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts)
+}
+
+func MarkAlertRead(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid alert id", http.StatusBadRequest)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("alerts")
+	result, err := collection.UpdateOne(context.Background(),
+		bson.M{"_id": id, "user_id": userDB.ID},
+		bson.M{"$set": bson.M{"read": true}},
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		http.Error(w, "Alert not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// runAlertRules evaluates the user's alert rules against newly inserted
+// transactions, stores the alerts that have not fired before and delivers
+// them. It is shared by every path that inserts transactions.
+func runAlertRules(client *mongo.Client, user models.User, inserted []models.Transaction) error {
+	if len(inserted) == 0 {
+		return nil
+	}
+
+	db := client.Database("paymentx")
+
+	cursor, err := db.Collection("alert_rules").Find(context.Background(), bson.M{"user_id": user.ID, "enabled": true})
+	if err != nil {
+		return err
+	}
+	var rules []models.AlertRule
+	if err := cursor.All(context.Background(), &rules); err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	var sameDay []models.Transaction
+	for _, rule := range rules {
+		if rule.Kind != models.AlertMerchantFrequency {
+			continue
+		}
+
+		first, last := inserted[0].TransactionDate.Time(), inserted[0].TransactionDate.Time()
+		for _, txn := range inserted {
+			if t := txn.TransactionDate.Time(); t.Before(first) {
+				first = t
+			} else if t.After(last) {
+				last = t
+			}
+		}
+		from := first.UTC().Truncate(24 * time.Hour)
+		to := last.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+
+		cursor, err := db.Collection("transactions").Find(context.Background(), activeTransactions(bson.M{
+			"user_id":         user.ID,
+			"transactiondate": bson.M{"$gte": primitive.NewDateTimeFromTime(from), "$lt": primitive.NewDateTimeFromTime(to)},
+		}))
+		if err != nil {
+			return err
+		}
+		if err := cursor.All(context.Background(), &sameDay); err != nil {
+			return err
+		}
+		break
+	}
+
+	alerts := helpers.EvaluateAlertRules(rules, inserted, sameDay)
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, len(alerts))
+	for i := range alerts {
+		alerts[i].ID = primitive.NewObjectID()
+		alerts[i].CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+		docs[i] = alerts[i]
+	}
+
+	// Alerts that already fired fail the unique dedupkey index and are skipped
+	skipped, err := insertManySkippingDuplicates(db.Collection("alerts"), docs)
+	if err != nil {
+		return err
+	}
+
+	rulesByID := make(map[primitive.ObjectID]models.AlertRule)
+	for _, rule := range rules {
+		rulesByID[rule.ID] = rule
+	}
+
+	for i, alert := range alerts {
+		if skipped[i] {
+			continue
+		}
+		go func(alert models.Alert, rule models.AlertRule) {
+			for _, err := range helpers.DeliverAlert(alert, rule, user) {
+				fmt.Println("Failed to deliver alert:", err)
+			}
+		}(alert, rulesByID[alert.RuleID])
+	}
+
+	return nil
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// anomalyBaselineDays is how much history is used as the user's baseline.
+const anomalyBaselineDays = 365
+
+func GetAnomalies(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	filter := bson.M{"user_id": userDB.ID}
+	if r.URL.Query().Get("include_dismissed") != "true" {
+		filter["dismissed"] = false
+	}
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		filter["kind"] = kind
+	}
+
+	collection := client.Database("paymentx").Collection("anomalies")
+	cursor, err := collection.Find(context.Background(), filter, options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "score", Value: -1}}))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	anomalies := []models.Anomaly{}
+	if err := cursor.All(context.Background(), &anomalies); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(anomalies)
+}
+
+// ScanAnomalies re-runs detection over the user's whole baseline window.
+func ScanAnomalies(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	history, err := anomalyBaseline(client, userDB.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	found, err := storeAnomalies(client, helpers.DetectAnomalies(history, history))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status string `json:"status"`
+		Found  int    `json:"found"`
+	}{
+		Status: "success",
+		Found:  found,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func DismissAnomaly(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid anomaly id", http.StatusBadRequest)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("anomalies")
+	result, err := collection.UpdateOne(context.Background(),
+		bson.M{"_id": id, "user_id": userDB.ID},
+		bson.M{"$set": bson.M{"dismissed": true}},
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		http.Error(w, "Anomaly not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func anomalyBaseline(client *mongo.Client, userID primitive.ObjectID) ([]models.Transaction, error) {
+	since := time.Now().AddDate(0, 0, -anomalyBaselineDays)
+
+	collection := client.Database("paymentx").Collection("transactions")
+	cursor, err := collection.Find(context.Background(), activeTransactions(bson.M{
+		"user_id":         userID,
+		"type":            models.Debit,
+		"transactiondate": bson.M{"$gte": primitive.NewDateTimeFromTime(since)},
+		"istransfer":      bson.M{"$ne": true},
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	var history []models.Transaction
+	if err := cursor.All(context.Background(), &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// storeAnomalies saves anomalies that were not flagged before. Anomalies a
+// user dismissed keep their dedupkey so they are never raised again.
+func storeAnomalies(client *mongo.Client, anomalies []models.Anomaly) (int, error) {
+	if len(anomalies) == 0 {
+		return 0, nil
+	}
+
+	docs := make([]interface{}, len(anomalies))
+	for i := range anomalies {
+		anomalies[i].ID = primitive.NewObjectID()
+		anomalies[i].CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+		docs[i] = anomalies[i]
+	}
+
+	skipped, err := insertManySkippingDuplicates(client.Database("paymentx").Collection("anomalies"), docs)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(anomalies) - len(skipped), nil
+}
+
+// runAnomalyDetection checks newly inserted transactions against the user's
+// baseline.
+func runAnomalyDetection(client *mongo.Client, user models.User, inserted []models.Transaction) error {
+	if len(inserted) == 0 {
+		return nil
+	}
+
+	history, err := anomalyBaseline(client, user.ID)
+	if err != nil {
+		return err
+	}
+
+	// Use the stored copies so transactions since linked as transfers are skipped
+	insertedIDs := make(map[primitive.ObjectID]bool)
+	for _, txn := range inserted {
+		insertedIDs[txn.ID] = true
+	}
+	var candidates []models.Transaction
+	for _, txn := range history {
+		if insertedIDs[txn.ID] {
+			candidates = append(candidates, txn)
+		}
+	}
+
+	_, err = storeAnomalies(client, helpers.DetectAnomalies(history, candidates))
+	return err
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// balanceLookbackDays is how far before an upload the previous statement
+// balance is looked for.
+const balanceLookbackDays = 45
+
+type accountBalanceHistory struct {
+	AccountID   primitive.ObjectID     `json:"account_id,omitempty"`
+	AccountName string                 `json:"account_name,omitempty"`
+	Balances    []helpers.BalancePoint `json:"balances"`
+}
+
+type accountReconciliation struct {
+	AccountID    primitive.ObjectID   `json:"account_id,omitempty"`
+	AccountName  string               `json:"account_name,omitempty"`
+	Transactions int                  `json:"transactions"`
+	Gaps         []helpers.BalanceGap `json:"gaps"`
+}
+
+// GetBalanceHistory returns the daily closing balance per account.
+func GetBalanceHistory(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	filter, err := balanceFilter(r, userDB.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	groups, accounts, err := loadAccountTransactions(client, userDB.ID, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	results := []accountBalanceHistory{}
+	for accountID, txns := range groups {
+		results = append(results, accountBalanceHistory{
+			AccountID:   accountID,
+			AccountName: accounts[accountID].Name,
+			Balances:    helpers.DailyClosingBalances(txns),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// GetReconciliation runs the balance continuity check over every account.
+func GetReconciliation(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	filter, err := balanceFilter(r, userDB.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	groups, accounts, err := loadAccountTransactions(client, userDB.ID, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	results := []accountReconciliation{}
+	for accountID, txns := range groups {
+		gaps := helpers.CheckBalanceContinuity(txns, accounts[accountID].Type == models.CreditCardAccount)
+		if gaps == nil {
+			gaps = []helpers.BalanceGap{}
+		}
+		results = append(results, accountReconciliation{
+			AccountID:    accountID,
+			AccountName:  accounts[accountID].Name,
+			Transactions: len(txns),
+			Gaps:         gaps,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func balanceFilter(r *http.Request, userID primitive.ObjectID) (bson.M, error) {
+	filter := bson.M{"user_id": userID}
+
+	if accountID := r.URL.Query().Get("account_id"); accountID != "" {
+		id, err := primitive.ObjectIDFromHex(accountID)
+		if err != nil {
+			return nil, err
+		}
+		filter["account_id"] = id
+	}
+
+	daterange := bson.M{}
+	if r.URL.Query().Get("start_date") != "" {
+		startDate, err := convertStringToDateTime(r.URL.Query().Get("start_date"))
+		if err != nil {
+			return nil, err
+		}
+		daterange["$gte"] = startDate
+	}
+	if r.URL.Query().Get("end_date") != "" {
+		endDate, err := convertStringToDateTime(r.URL.Query().Get("end_date"))
+		if err != nil {
+			return nil, err
+		}
+		daterange["$lte"] = endDate
+	}
+	if len(daterange) > 0 {
+		filter["transactiondate"] = daterange
+	}
+
+	return filter, nil
+}
+
+// loadAccountTransactions loads the matching transactions grouped per account
+// in statement order, along with the user's accounts.
+func loadAccountTransactions(client *mongo.Client, userID primitive.ObjectID, filter bson.M) (map[primitive.ObjectID][]models.Transaction, map[primitive.ObjectID]models.Account, error) {
+	accounts, err := userAccounts(client, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+	cursor, err := collection.Find(context.Background(), activeTransactions(filter))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var txns []models.Transaction
+	if err := cursor.All(context.Background(), &txns); err != nil {
+		return nil, nil, err
+	}
+
+	groups := helpers.GroupByAccount(txns)
+	for accountID := range groups {
+		helpers.SortForBalance(groups[accountID])
+	}
+
+	return groups, accounts, nil
+}
+
+// checkUploadContinuity checks the running balance around newly inserted
+// transactions, including the last transaction stored before them, and
+// returns the gaps that involve an inserted transaction.
+func checkUploadContinuity(client *mongo.Client, userID primitive.ObjectID, inserted []models.Transaction) ([]helpers.BalanceGap, error) {
+	insertedIDs := make(map[primitive.ObjectID]bool)
+	for _, txn := range inserted {
+		insertedIDs[txn.ID] = true
+	}
+
+	var gaps []helpers.BalanceGap
+	for accountID, txns := range helpers.GroupByAccount(inserted) {
+		first, last := txns[0].TransactionDate.Time(), txns[0].TransactionDate.Time()
+		for _, txn := range txns {
+			if t := txn.TransactionDate.Time(); t.Before(first) {
+				first = t
+			} else if t.After(last) {
+				last = t
+			}
+		}
+
+		filter := bson.M{
+			"user_id": userID,
+			"transactiondate": bson.M{
+				"$gte": primitive.NewDateTimeFromTime(first.AddDate(0, 0, -balanceLookbackDays)),
+				"$lte": primitive.NewDateTimeFromTime(last.Add(24 * time.Hour)),
+			},
+		}
+		if accountID.IsZero() {
+			filter["account_id"] = bson.M{"$exists": false}
+		} else {
+			filter["account_id"] = accountID
+		}
+
+		groups, accounts, err := loadAccountTransactions(client, userID, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		liability := accounts[accountID].Type == models.CreditCardAccount
+		for _, gap := range helpers.CheckBalanceContinuity(groups[accountID], liability) {
+			if insertedIDs[gap.TransactionID] || insertedIDs[gap.PreviousID] {
+				gaps = append(gaps, gap)
+			}
+		}
+	}
+
+	return gaps, nil
+}
+
+// balanceMigration names the migration that drops the zero balances stored
+// while a transaction without a balance was saved with 0.
+const balanceMigration = "optional_balance"
+
+// MigrateOptionalBalances removes the zero balance of transactions stored
+// before Balance was optional, when zero stood for no balance, and returns
+// how many it updated. It runs once: later zero balances are real ones.
+func MigrateOptionalBalances() (int, error) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		return 0, err
+	}
+	defer client.Disconnect(context.Background())
+
+	db := client.Database("paymentx")
+	err = db.Collection("migrations").FindOne(context.Background(), bson.M{"_id": balanceMigration}).Err()
+	if err == nil {
+		return 0, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+
+	result, err := db.Collection("transactions").UpdateMany(context.Background(),
+		bson.M{"balance": 0},
+		bson.M{"$unset": bson.M{"balance": ""}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	_, err = db.Collection("migrations").InsertOne(context.Background(), bson.M{
+		"_id":       balanceMigration,
+		"appliedat": primitive.NewDateTimeFromTime(time.Now()),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		err = nil
+	}
+	return int(result.ModifiedCount), err
+}
+
+// StartBalanceMigration runs MigrateOptionalBalances once in the background.
+func StartBalanceMigration() {
+	go func() {
+		updated, err := MigrateOptionalBalances()
+		if err != nil {
+			fmt.Println("Failed to migrate transaction balances:", err)
+		}
+		if updated > 0 {
+			fmt.Println("Cleared the zero balance of", updated, "transactions")
+		}
+	}()
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/importers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// smsMaxMessages caps how many messages one request may send.
+const smsMaxMessages = 5000
+
+var blankLines = regexp.MustCompile(`\n\s*\n`)
+
+// smsMessage is one SMS as sent by the client. A bare string is read as the
+// text of a message received now.
+type smsMessage struct {
+	Text     string    `json:"text"`
+	Sender   string    `json:"sender"`
+	Received time.Time `json:"received"`
+}
+
+func (m *smsMessage) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		m.Text = text
+		return nil
+	}
+	type plain smsMessage
+	return json.Unmarshal(data, (*plain)(m))
+}
+
+// ImportSMSAlerts reads bank SMS alerts in bulk, sent as {"messages": [...]}
+// of texts or of objects with the text, sender and time received, or as
+// plain text with a blank line between messages. The debits and credits
+// they announce are stored as provisional transactions, in the account whose
+// number the alert shows, until the statement or sync brings them in.
+func ImportSMSAlerts(w http.ResponseWriter, r *http.Request) {
+	serveJob(w, r, importSMSAlerts)
+}
+
+// importSMSAlerts does the work of ImportSMSAlerts.
+func importSMSAlerts(req jobRequest) (interface{}, error) {
+	var body struct {
+		Messages []smsMessage `json:"messages"`
+	}
+	if strings.HasPrefix(req.contentType, "text/plain") {
+		for _, text := range blankLines.Split(strings.ReplaceAll(string(req.body), "\r\n", "\n"), -1) {
+			if text = strings.TrimSpace(text); text != "" {
+				body.Messages = append(body.Messages, smsMessage{Text: text})
+			}
+		}
+	} else if err := json.Unmarshal(req.body, &body); err != nil {
+		return nil, badRequest("Invalid request body")
+	}
+	if len(body.Messages) == 0 {
+		return nil, badRequest("No messages to import")
+	}
+	if len(body.Messages) > smsMaxMessages {
+		return nil, badRequest("Too many messages in one request")
+	}
+
+	now := time.Now()
+	messages := make([]importers.Message, len(body.Messages))
+	for i, m := range body.Messages {
+		messages[i] = importers.Message{Text: m.Text, Sender: m.Sender, Received: m.Received}
+		if m.Received.IsZero() {
+			messages[i].Received = now
+		}
+	}
+
+	return importAlerts(req, messages, nil, "sms")
+}
+
+// importAlerts stores the transactions of bank alerts as provisional ones
+// and returns the outcome of the import. unreadable are the messages of the
+// file that could not be read, reported with the alerts that failed.
+func importAlerts(req jobRequest, messages []importers.Message, unreadable []importers.RowError, source string) (interface{}, error) {
+	req.run.progress("parsing", 0, len(messages))
+	alerts, skipped, rowErrors := importers.ParseAlerts(messages)
+	rowErrors = append(unreadable, rowErrors...)
+	sort.SliceStable(rowErrors, func(i, j int) bool { return rowErrors[i].Row < rowErrors[j].Row })
+	if len(alerts) == 0 && len(rowErrors) > 0 {
+		return nil, badRequest("No transaction alerts could be read")
+	}
+
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(context.Background())
+
+	accounts, err := userAccounts(client, req.user.ID)
+	if err != nil {
+		return nil, err
+	}
+	known := make([]models.Account, 0, len(accounts))
+	for _, account := range accounts {
+		known = append(known, account)
+	}
+
+	txns := make([]models.Transaction, 0, len(alerts))
+	unmatched := 0
+	for i, alert := range alerts {
+		req.run.progress("parsing", i, len(alerts))
+		if req.run.canceled() {
+			return nil, errImportCanceled
+		}
+		var accountID primitive.ObjectID
+		if account, ok := findAccountByNumber(known, alert.Record.Account); ok {
+			accountID = account.ID
+		} else {
+			unmatched++
+		}
+		txn := importedTransaction(alert.Record, accountID)
+		txn.Provisional = true
+		txns = append(txns, txn)
+	}
+
+	req.run.progress("storing", 0, len(txns))
+	if req.run.canceled() {
+		return nil, errImportCanceled
+	}
+	batchID, inserted, err := storeUpload(client, req.user, req.run, txns, source)
+	if err != nil {
+		return nil, err
+	}
+
+	response := struct {
+		Status    string               `json:"status"`
+		Read      int                  `json:"read"`
+		Parsed    int                  `json:"parsed"`
+		Inserted  int                  `json:"inserted"`
+		Unmatched int                  `json:"unmatched_accounts"`
+		BatchID   primitive.ObjectID   `json:"batch_id"`
+		Errors    []importers.RowError `json:"errors,omitempty"`
+		Skipped   []importers.RowError `json:"skipped,omitempty"`
+	}{
+		Status:    "success",
+		Read:      len(messages) + len(unreadable),
+		Parsed:    len(alerts),
+		Inserted:  len(inserted),
+		Unmatched: unmatched,
+		BatchID:   batchID,
+		Errors:    rowErrors,
+		Skipped:   skipped,
+	}
+
+	return response, nil
+}
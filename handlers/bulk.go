@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type bulkOperation string
+
+const (
+	bulkSetCategory  bulkOperation = "set_category"
+	bulkAddTags      bulkOperation = "add_tags"
+	bulkRemoveTags   bulkOperation = "remove_tags"
+	bulkMarkTransfer bulkOperation = "mark_transfer"
+	bulkExclude      bulkOperation = "exclude"
+	bulkInclude      bulkOperation = "include"
+	bulkDelete       bulkOperation = "delete"
+)
+
+// bulkRequest selects transactions either by id or with the date range and
+// type filter the analysis endpoint takes, and names what to do with them.
+type bulkRequest struct {
+	IDs    []primitive.ObjectID `json:"ids"`
+	Filter *struct {
+		StartDate string `json:"start_date"`
+		EndDate   string `json:"end_date"`
+		Type      string `json:"type"`
+	} `json:"filter"`
+	Operation bulkOperation `json:"operation"`
+	Category  string        `json:"category"`
+	Tags      []string      `json:"tags"`
+	// Preview only counts what the operation would touch
+	Preview bool `json:"preview"`
+}
+
+// selection builds the filter for the transactions the request targets.
+// Locked transactions are counted separately and never changed, and so are
+// split ones when setting a category.
+func (body bulkRequest) selection(userID primitive.ObjectID) (bson.M, error) {
+	if (len(body.IDs) == 0) == (body.Filter == nil) {
+		return nil, errors.New("exactly one of ids and filter is required")
+	}
+
+	filter := bson.M{"user_id": userID}
+	if len(body.IDs) > 0 {
+		filter["_id"] = bson.M{"$in": body.IDs}
+		return activeTransactions(filter), nil
+	}
+
+	// Read the way the list reads them, so end_date takes in its whole day
+	query, err := helpers.ParseTransactionQuery(url.Values{
+		"start_date": {body.Filter.StartDate},
+		"end_date":   {body.Filter.EndDate},
+		"type":       {body.Filter.Type},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range query.Filter {
+		filter[key] = value
+	}
+
+	return activeTransactions(filter), nil
+}
+
+// update is the Mongo update the operation applies. Delete has none as it
+// goes through the trash, and neither has mark_transfer, which links the two
+// sides of a transfer to each other.
+func (body bulkRequest) update() (bson.M, error) {
+	var tags []string
+	for _, tag := range body.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	switch body.Operation {
+	case bulkSetCategory:
+		category := strings.TrimSpace(body.Category)
+		if category == "" {
+			return nil, errors.New("category is required")
+		}
+		return bson.M{"$set": bson.M{"category": category}}, nil
+	case bulkAddTags:
+		if len(tags) == 0 {
+			return nil, errors.New("tags is required")
+		}
+		return bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": tags}}}, nil
+	case bulkRemoveTags:
+		if len(tags) == 0 {
+			return nil, errors.New("tags is required")
+		}
+		return bson.M{"$pull": bson.M{"tags": bson.M{"$in": tags}}}, nil
+	case bulkMarkTransfer:
+		return nil, nil
+	case bulkExclude:
+		return bson.M{"$set": bson.M{"excluded": true}}, nil
+	case bulkInclude:
+		return bson.M{"$set": bson.M{"excluded": false}}, nil
+	case bulkDelete:
+		return nil, nil
+	}
+	return nil, errors.New("unknown operation")
+}
+
+// BulkUpdateTransactions applies one operation to many transactions at once,
+// inside a Mongo transaction when the deployment supports them.
+func BulkUpdateTransactions(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body bulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := body.selection(userDB.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	update, err := body.update()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+
+	filter["locked"] = true
+	locked, err := collection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	filter["locked"] = bson.M{"$ne": true}
+
+	// A split transaction takes its categories from its parts, so one
+	// category for the whole is not set on it
+	var split int64
+	if body.Operation == bulkSetCategory {
+		filter["splits.0"] = bson.M{"$exists": true}
+		split, err = collection.CountDocuments(context.Background(), filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		filter["splits.0"] = bson.M{"$exists": false}
+	}
+
+	cursor, err := collection.Find(context.Background(), filter, options.Find().SetProjection(bson.M{"_id": 1, "type": 1, "istransfer": 1}))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var selected []models.Transaction
+	if err := cursor.All(context.Background(), &selected); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ids := make([]primitive.ObjectID, len(selected))
+	for i, txn := range selected {
+		ids[i] = txn.ID
+	}
+
+	response := struct {
+		Status    string            `json:"status"`
+		Operation bulkOperation     `json:"operation"`
+		Preview   bool              `json:"preview"`
+		Matched   int               `json:"matched"`
+		Locked    int64             `json:"locked"`
+		Split     int64             `json:"split,omitempty"`
+		Modified  int64             `json:"modified"`
+		Atomic    bool              `json:"atomic"`
+		Undo      *models.UndoToken `json:"undo,omitempty"`
+	}{
+		Status:    "success",
+		Operation: body.Operation,
+		Preview:   body.Preview,
+		Matched:   len(ids),
+		Locked:    locked,
+		Split:     split,
+	}
+
+	// A transfer has exactly two sides, one going out and one coming in
+	var debitID, creditID primitive.ObjectID
+	if body.Operation == bulkMarkTransfer && len(ids) > 0 {
+		if len(selected) != 2 {
+			http.Error(w, "mark_transfer needs exactly two transactions, a DEBIT and a CREDIT", http.StatusBadRequest)
+			return
+		}
+		for _, txn := range selected {
+			if txn.IsTransfer {
+				http.Error(w, "Transaction is already linked as a transfer", http.StatusConflict)
+				return
+			}
+			switch txn.Type {
+			case models.Debit:
+				debitID = txn.ID
+			case models.Credit:
+				creditID = txn.ID
+			}
+		}
+		if debitID.IsZero() || creditID.IsZero() {
+			http.Error(w, "mark_transfer needs exactly two transactions, a DEBIT and a CREDIT", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if body.Preview || len(ids) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	if body.Operation == bulkDelete {
+		// Deleting also releases links on other transactions, so it goes
+		// through the trash rather than a single update
+		trashed, err := trashTransactions(client, userDB.ID, bson.M{"_id": bson.M{"$in": ids}}, userActor(userDB))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response.Modified = int64(len(trashed))
+		if len(trashed) > 0 {
+			response.Undo, err = issueUndoToken(client, userDB.ID, "bulk_delete", trashed)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	} else {
+		response.Atomic, err = recordHistoryAtomically(client, userDB.ID, userActor(userDB), models.ChangeUpdate, ids, func(ctx context.Context) error {
+			if body.Operation == bulkMarkTransfer {
+				if err := linkTransferPair(ctx, collection, userDB.ID, debitID, creditID); err != nil {
+					return err
+				}
+				response.Modified = 2
+				return nil
+			}
+
+			// A row may have been locked since it was selected
+			target := bson.M{"_id": bson.M{"$in": ids}, "user_id": userDB.ID, "locked": bson.M{"$ne": true}}
+			if body.Operation == bulkSetCategory {
+				target["splits.0"] = bson.M{"$exists": false}
+			}
+			result, err := collection.UpdateMany(ctx, target, update)
+			if err != nil {
+				return err
+			}
+			response.Modified = result.ModifiedCount
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// runAtomically runs fn inside a Mongo transaction. Standalone servers do not
+// support transactions, in which case fn runs on its own and false is
+// returned.
+func runAtomically(client *mongo.Client, fn func(ctx context.Context) error) (bool, error) {
+	session, err := client.StartSession()
+	if err != nil {
+		return false, fn(context.Background())
+	}
+	defer session.EndSession(context.Background())
+
+	_, err = session.WithTransaction(context.Background(), func(ctx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(ctx)
+	})
+	if transactionsUnsupported(err) {
+		return false, fn(context.Background())
+	}
+	return err == nil, err
+}
+
+// transactionsUnsupported reports whether err is the server refusing a
+// transaction because it is not part of a replica set.
+func transactionsUnsupported(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code == 20
+	}
+	return err != nil && strings.Contains(err.Error(), "Transaction numbers are only allowed")
+}
@@ -0,0 +1,451 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ScanDuplicates runs the fuzzy duplicate detector over the user's whole
+// history.
+func ScanDuplicates(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+	cursor, err := collection.Find(context.Background(), activeTransactions(bson.M{"user_id": userDB.ID, "locked": bson.M{"$ne": true}}))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var txns []models.Transaction
+	if err := cursor.All(context.Background(), &txns); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	merged, review, err := applyDuplicateMatches(client, userDB.ID, helpers.FindDuplicates(txns, txns))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status string `json:"status"`
+		Merged int    `json:"merged"`
+		Review int    `json:"review"`
+	}{
+		Status: "success",
+		Merged: merged,
+		Review: review,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func GetDuplicates(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = string(models.DuplicatePending)
+	}
+
+	collection := client.Database("paymentx").Collection("duplicate_candidates")
+	cursor, err := collection.Find(context.Background(),
+		bson.M{"user_id": userDB.ID, "status": status},
+		options.Find().SetSort(bson.D{{Key: "score", Value: -1}, {Key: "createdat", Value: -1}}),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	candidates := []models.DuplicateCandidate{}
+	if err := cursor.All(context.Background(), &candidates); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(candidates)
+}
+
+// MergeDuplicate folds duplicate_id into keep_id. The duplicate is kept with
+// a merged_into link so its source stays on record.
+func MergeDuplicate(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body struct {
+		KeepID      primitive.ObjectID `json:"keep_id"`
+		DuplicateID primitive.ObjectID `json:"duplicate_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.KeepID == body.DuplicateID {
+		http.Error(w, "keep_id and duplicate_id must differ", http.StatusBadRequest)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+	var keep, duplicate models.Transaction
+	if err := collection.FindOne(context.Background(), activeTransactions(bson.M{"_id": body.KeepID, "user_id": userDB.ID})).Decode(&keep); err != nil {
+		http.Error(w, "Transaction to keep not found", http.StatusNotFound)
+		return
+	}
+	if err := collection.FindOne(context.Background(), activeTransactions(bson.M{"_id": body.DuplicateID, "user_id": userDB.ID})).Decode(&duplicate); err != nil {
+		http.Error(w, "Duplicate transaction not found", http.StatusNotFound)
+		return
+	}
+
+	if keep.Locked || duplicate.Locked {
+		http.Error(w, errStatementLocked.Error(), http.StatusConflict)
+		return
+	}
+
+	score, reasons := helpers.ScoreDuplicate(keep, duplicate)
+	match := helpers.DuplicateMatch{KeepID: keep.ID, DuplicateID: duplicate.ID, Score: score, Reasons: append(reasons, "manual")}
+
+	if err := mergeDuplicate(client, userDB.ID, duplicate, match, userActor(userDB)); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(match)
+}
+
+// UnmergeDuplicate restores a merged duplicate as a transaction of its own.
+func UnmergeDuplicate(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body struct {
+		DuplicateID primitive.ObjectID `json:"duplicate_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db := client.Database("paymentx")
+
+	var duplicate models.Transaction
+	if err := db.Collection("transactions").FindOne(context.Background(), bson.M{"_id": body.DuplicateID, "user_id": userDB.ID}).Decode(&duplicate); err != nil {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+	if duplicate.MergedInto.IsZero() {
+		http.Error(w, "Transaction is not merged", http.StatusBadRequest)
+		return
+	}
+
+	err = recordHistory(client, userDB.ID, userActor(userDB), models.ChangeUpdate, []primitive.ObjectID{duplicate.ID, duplicate.MergedInto}, func(ctx context.Context) error {
+		if _, err := db.Collection("transactions").UpdateOne(ctx,
+			bson.M{"_id": duplicate.ID},
+			bson.M{"$unset": bson.M{"merged_into": ""}},
+		); err != nil {
+			return err
+		}
+		_, err := db.Collection("transactions").UpdateOne(ctx,
+			bson.M{"_id": duplicate.MergedInto, "user_id": userDB.ID},
+			bson.M{"$pull": bson.M{"merged_from": duplicate.ID}},
+		)
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Dismiss the pair so the detector does not merge it again
+	if _, err := db.Collection("duplicate_candidates").UpdateOne(context.Background(),
+		bson.M{"dedupkey": duplicateDedupKey(duplicate.MergedInto, duplicate.ID)},
+		bson.M{"$set": bson.M{"status": models.DuplicateDismissed, "resolvedat": primitive.NewDateTimeFromTime(time.Now())}},
+	); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status string `json:"status"`
+	}{
+		Status: "success",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DismissDuplicate marks a pending candidate as not a duplicate.
+func DismissDuplicate(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid candidate id", http.StatusBadRequest)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("duplicate_candidates")
+	result, err := collection.UpdateOne(context.Background(),
+		bson.M{"_id": id, "user_id": userDB.ID, "status": models.DuplicatePending},
+		bson.M{"$set": bson.M{"status": models.DuplicateDismissed, "resolvedat": primitive.NewDateTimeFromTime(time.Now())}},
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		http.Error(w, "Candidate not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func duplicateDedupKey(keepID, duplicateID primitive.ObjectID) string {
+	first, second := keepID.Hex(), duplicateID.Hex()
+	if second < first {
+		first, second = second, first
+	}
+	return fmt.Sprintf("%s|%s", first, second)
+}
+
+// applyDuplicateMatches merges the confident matches and stores the rest for
+// review. Pairs that were reviewed before are left alone.
+func applyDuplicateMatches(client *mongo.Client, userID primitive.ObjectID, matches []helpers.DuplicateMatch) (int, int, error) {
+	collection := client.Database("paymentx").Collection("duplicate_candidates")
+
+	merged, review := 0, 0
+	for _, match := range matches {
+		key := duplicateDedupKey(match.KeepID, match.DuplicateID)
+
+		var existing models.DuplicateCandidate
+		err := collection.FindOne(context.Background(), bson.M{"dedupkey": key}).Decode(&existing)
+		if err == nil && existing.Status != models.DuplicatePending {
+			continue
+		}
+		if err != nil && err != mongo.ErrNoDocuments {
+			return merged, review, err
+		}
+
+		if !match.AutoMerge {
+			candidate := models.DuplicateCandidate{
+				ID:          primitive.NewObjectID(),
+				UserID:      userID,
+				KeepID:      match.KeepID,
+				DuplicateID: match.DuplicateID,
+				Score:       match.Score,
+				Reasons:     match.Reasons,
+				Status:      models.DuplicatePending,
+				DedupKey:    key,
+				CreatedAt:   primitive.NewDateTimeFromTime(time.Now()),
+			}
+			if _, err := insertManySkippingDuplicates(collection, []interface{}{candidate}); err != nil {
+				return merged, review, err
+			}
+			review++
+			continue
+		}
+
+		var duplicate models.Transaction
+		if err := client.Database("paymentx").Collection("transactions").FindOne(context.Background(), bson.M{"_id": match.DuplicateID}).Decode(&duplicate); err != nil {
+			return merged, review, err
+		}
+		if err := mergeDuplicate(client, userID, duplicate, match, duplicateRule); err != nil {
+			return merged, review, err
+		}
+		merged++
+	}
+
+	return merged, review, nil
+}
+
+// mergeDuplicate links duplicate to the transaction it duplicates, releases
+// the transfer and refund links it held and records the decision.
+func mergeDuplicate(client *mongo.Client, userID primitive.ObjectID, duplicate models.Transaction, match helpers.DuplicateMatch, actor changeActor) error {
+	db := client.Database("paymentx")
+	transactions := db.Collection("transactions")
+
+	if duplicate.IsTransfer {
+		if err := unlinkTransfer(client, userID, duplicate, false, actor); err != nil {
+			return err
+		}
+	}
+	if !duplicate.RefundOf.IsZero() {
+		if err := unlinkRefund(client, userID, duplicate, actor); err != nil {
+			return err
+		}
+	}
+
+	err := recordHistory(client, userID, actor, models.ChangeUpdate, []primitive.ObjectID{duplicate.ID, match.KeepID}, func(ctx context.Context) error {
+		for _, update := range []struct {
+			id     primitive.ObjectID
+			change bson.M
+		}{
+			{duplicate.ID, bson.M{"$set": bson.M{"merged_into": match.KeepID}}},
+			{match.KeepID, bson.M{"$addToSet": bson.M{"merged_from": duplicate.ID}}},
+		} {
+			result, err := transactions.UpdateOne(ctx, bson.M{"_id": update.id, "user_id": userID, "locked": bson.M{"$ne": true}}, update.change)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return errStatementLocked
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	_, err = db.Collection("duplicate_candidates").UpdateOne(context.Background(),
+		bson.M{"dedupkey": duplicateDedupKey(match.KeepID, match.DuplicateID)},
+		bson.M{
+			"$set": bson.M{
+				"user_id": userID, "keep_id": match.KeepID, "duplicate_id": match.DuplicateID,
+				"score": match.Score, "reasons": match.Reasons, "status": models.DuplicateMerged,
+				"auto": actor.source == models.ChangeRule, "resolvedat": now,
+			},
+			"$setOnInsert": bson.M{"createdat": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// runDuplicateDetection compares newly inserted transactions with what is
+// already stored around the same dates, and returns the inserted
+// transactions that were not merged away as duplicates.
+func runDuplicateDetection(client *mongo.Client, user models.User, inserted []models.Transaction) ([]models.Transaction, error) {
+	if len(inserted) == 0 {
+		return inserted, nil
+	}
+
+	first, last := inserted[0].TransactionDate.Time(), inserted[0].TransactionDate.Time()
+	for _, txn := range inserted {
+		if t := txn.TransactionDate.Time(); t.Before(first) {
+			first = t
+		} else if t.After(last) {
+			last = t
+		}
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+	cursor, err := collection.Find(context.Background(), activeTransactions(bson.M{
+		"user_id": user.ID,
+		"locked":  bson.M{"$ne": true},
+		"transactiondate": bson.M{
+			"$gte": primitive.NewDateTimeFromTime(first.Add(-helpers.DuplicateWindow)),
+			"$lte": primitive.NewDateTimeFromTime(last.Add(helpers.DuplicateWindow)),
+		},
+	}))
+	if err != nil {
+		return inserted, err
+	}
+
+	var nearby []models.Transaction
+	if err := cursor.All(context.Background(), &nearby); err != nil {
+		return inserted, err
+	}
+
+	matches := helpers.FindDuplicates(nearby, inserted)
+	if _, _, err := applyDuplicateMatches(client, user.ID, matches); err != nil {
+		return inserted, err
+	}
+
+	mergedAway := make(map[primitive.ObjectID]bool)
+	for _, match := range matches {
+		if match.AutoMerge {
+			mergedAway[match.DuplicateID] = true
+		}
+	}
+
+	var remaining []models.Transaction
+	for _, txn := range inserted {
+		if !mergedAway[txn.ID] {
+			remaining = append(remaining, txn)
+		}
+	}
+	return remaining, nil
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/exporters"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// exportBatchSize is how many transactions the export cursor fetches at a
+// time.
+const exportBatchSize = 500
+
+// ExportTransactions streams the transactions matching the list filters as
+// a csv, xlsx, ndjson or ofx file, or as a beancount or hledger journal.
+// columns picks and orders the columns and date_format the way dates are
+// written.
+func ExportTransactions(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	values := r.URL.Query()
+	query, err := helpers.ParseTransactionQuery(values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := values.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	f, ok := exporters.LookupFormat(format)
+	if !ok {
+		http.Error(w, "format must be csv, xlsx, ndjson, ofx, beancount or hledger", http.StatusBadRequest)
+		return
+	}
+
+	accounts, err := userAccounts(client, userDB.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	opts := exporters.Options{DateFormat: values.Get("date_format")}
+	for _, column := range strings.Split(values.Get("columns"), ",") {
+		if column = strings.TrimSpace(column); column != "" {
+			opts.Columns = append(opts.Columns, column)
+		}
+	}
+	if start, err := time.Parse("2006-01-02", values.Get("start_date")); err == nil {
+		opts.Start = start
+	}
+	if end, err := time.Parse("2006-01-02", values.Get("end_date")); err == nil {
+		opts.End = end.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	if id, err := primitive.ObjectIDFromHex(values.Get("account_id")); err == nil {
+		opts.Account = id.Hex()
+		if account, ok := accounts[id]; ok && account.Number != "" {
+			opts.Account = account.Number
+		}
+	}
+
+	filter := activeTransactions(query.Filter)
+	filter["user_id"] = userDB.ID
+	// A split transaction is exported when one of its parts matches
+	matchSplitParts(filter)
+
+	collection := client.Database("paymentx").Collection("transactions")
+
+	journal := format == "beancount" || format == "hledger"
+	sort := query.Sort
+	if journal {
+		// Journals follow the running balance, so they are always written
+		// in statement order
+		sort = bson.D{{Key: "transactiondate", Value: 1}, {Key: "transactiontime", Value: 1}, {Key: "_id", Value: 1}}
+
+		transfers, err := exportedTransfers(collection, filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		opts.Ledger = &exporters.Ledger{Accounts: accounts, Transfers: transfers}
+	}
+
+	// OFX and journals declare the period up front, so without a start_date
+	// it begins at the earliest transaction exported
+	if (format == "ofx" || journal) && opts.Start.IsZero() {
+		var first models.Transaction
+		err := collection.FindOne(context.Background(), filter, options.FindOne().SetSort(bson.M{"transactiondate": 1})).Decode(&first)
+		if err == nil {
+			opts.Start = first.TransactionDate.Time()
+		}
+	}
+
+	exporter, err := exporters.New(format, w, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cursor, err := collection.Find(context.Background(), filter,
+		options.Find().SetSort(sort).SetBatchSize(exportBatchSize),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	w.Header().Set("Content-Type", f.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="transactions-%s.%s"`, time.Now().Format("20060102"), f.Extension))
+
+	// Past this point the status is sent, so failures can only cut the
+	// file short
+	if err := exporter.Begin(); err != nil {
+		fmt.Println("Export failed:", err)
+		return
+	}
+	for cursor.Next(context.Background()) {
+		var txn models.Transaction
+		if err := cursor.Decode(&txn); err != nil {
+			fmt.Println("Export failed:", err)
+			return
+		}
+
+		row := exporters.Row{Transaction: txn}
+		if account, ok := accounts[txn.AccountID]; ok {
+			row.AccountName = account.Name
+		}
+		if err := exporter.Write(row); err != nil {
+			fmt.Println("Export failed:", err)
+			return
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		fmt.Println("Export failed:", err)
+		return
+	}
+	if err := exporter.End(); err != nil {
+		fmt.Println("Export failed:", err)
+	}
+}
+
+// exportedTransfers returns the transfer legs matching an export filter, by
+// ID.
+func exportedTransfers(collection *mongo.Collection, filter bson.M) (map[primitive.ObjectID]models.Transaction, error) {
+	transfers := bson.M{}
+	for key, value := range filter {
+		transfers[key] = value
+	}
+	transfers["istransfer"] = true
+
+	cursor, err := collection.Find(context.Background(), transfers, options.Find().SetProjection(bson.M{
+		"_id": 1, "account_id": 1, "transactiondate": 1, "type": 1, "amount": 1, "transfer_pair_id": 1,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	var txns []models.Transaction
+	if err := cursor.All(context.Background(), &txns); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[primitive.ObjectID]models.Transaction, len(txns))
+	for _, txn := range txns {
+		byID[txn.ID] = txn
+	}
+	return byID, nil
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetCashFlowForecast projects the user's balance for the next 30, 60 or 90
+// days from the balance of each asset account, recurring items and daily
+// spend.
+func GetCashFlowForecast(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	days := 30
+	if daysStr := r.URL.Query().Get("days"); daysStr != "" {
+		days, err = strconv.Atoi(daysStr)
+		if err != nil || (days != 30 && days != 60 && days != 90) {
+			http.Error(w, "days must be 30, 60 or 90", http.StatusBadRequest)
+			return
+		}
+	}
+
+	floor := 0.0
+	if floorStr := r.URL.Query().Get("floor"); floorStr != "" {
+		floor, err = strconv.ParseFloat(floorStr, 64)
+		if err != nil {
+			http.Error(w, "invalid floor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+
+	balance, latest, err := latestBalance(client, userDB.ID)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "No transactions with a balance found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Spend is read the way analytics reads it: without transfers, excluded
+	// transactions and refunds, which are netted against their debit instead
+	since := latest.AddDate(-1, 0, 0)
+	match, err := analyticsMatchValues(url.Values{"net": {"true"}}, bson.M{
+		"user_id":         userDB.ID,
+		"transactiondate": bson.M{"$gte": primitive.NewDateTimeFromTime(since)},
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cursor, err := collection.Find(context.Background(), match)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var history []models.Transaction
+	if err := cursor.All(context.Background(), &history); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range history {
+		history[i].Amount -= history[i].RefundedAmount
+	}
+
+	asOf := time.Now()
+	if latest.After(asOf) {
+		asOf = latest
+	}
+
+	forecast := helpers.BuildForecast(balance, asOf, history, days, floor)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(forecast)
+}
+
+// latestBalance adds up the balance of each of the user's asset accounts:
+// its most recent statement balance, moved on by the transactions posted
+// after it. Credit cards are left out, their balance being money owed. It
+// returns the total with the date of the latest statement balance.
+func latestBalance(client *mongo.Client, userID primitive.ObjectID) (float64, time.Time, error) {
+	cards, err := client.Database("paymentx").Collection("accounts").Distinct(context.Background(), "_id", bson.M{
+		"user_id": userID,
+		"type":    models.CreditCardAccount,
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if cards == nil {
+		cards = []interface{}{}
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+	cursor, err := collection.Aggregate(context.Background(), bson.A{
+		bson.M{"$match": activeTransactions(bson.M{
+			"user_id":    userID,
+			"account_id": bson.M{"$nin": cards},
+			"balance":    bson.M{"$ne": nil},
+		})},
+		bson.M{"$sort": bson.D{{Key: "transactiondate", Value: -1}, {Key: "_id", Value: -1}}},
+		bson.M{"$group": bson.M{
+			"_id":             "$account_id",
+			"balance":         bson.M{"$first": "$balance"},
+			"transactiondate": bson.M{"$first": "$transactiondate"},
+			"last":            bson.M{"$first": "$_id"},
+		}},
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer cursor.Close(context.Background())
+
+	var accounts []struct {
+		AccountID       primitive.ObjectID `bson:"_id"`
+		Balance         float64            `bson:"balance"`
+		TransactionDate primitive.DateTime `bson:"transactiondate"`
+		Last            primitive.ObjectID `bson:"last"`
+	}
+	if err := cursor.All(context.Background(), &accounts); err != nil {
+		return 0, time.Time{}, err
+	}
+	if len(accounts) == 0 {
+		return 0, time.Time{}, mongo.ErrNoDocuments
+	}
+
+	balance, latest := 0.0, time.Time{}
+	for _, account := range accounts {
+		since, err := postedSince(collection, userID, account.AccountID, account.TransactionDate, account.Last)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		balance += account.Balance + since
+		if at := account.TransactionDate.Time(); at.After(latest) {
+			latest = at
+		}
+	}
+	return balance, latest, nil
+}
+
+// postedSince is the net amount credited to an account by the transactions
+// that come after the one with id last, dated at, in statement order.
+func postedSince(collection *mongo.Collection, userID, accountID primitive.ObjectID, at primitive.DateTime, last primitive.ObjectID) (float64, error) {
+	account := interface{}(accountID)
+	if accountID.IsZero() {
+		account = nil
+	}
+	cursor, err := collection.Aggregate(context.Background(), bson.A{
+		bson.M{"$match": activeTransactions(bson.M{
+			"user_id":    userID,
+			"account_id": account,
+			"$or": bson.A{
+				bson.M{"transactiondate": bson.M{"$gt": at}},
+				bson.M{"transactiondate": at, "_id": bson.M{"$gt": last}},
+			},
+		})},
+		bson.M{"$group": bson.M{
+			"_id": nil,
+			"net": bson.M{"$sum": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{"$type", models.Credit}}, "$amount", bson.M{"$multiply": bson.A{"$amount", -1}},
+			}}},
+		}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(context.Background())
+
+	var totals []struct {
+		Net float64 `bson:"net"`
+	}
+	if err := cursor.All(context.Background(), &totals); err != nil {
+		return 0, err
+	}
+	if len(totals) == 0 {
+		return 0, nil
+	}
+	return totals[0].Net, nil
+}
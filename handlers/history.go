@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// changeActor is who or what made a change to a transaction.
+type changeActor struct {
+	source models.ChangeSource
+	name   string
+}
+
+var (
+	transferRule  = changeActor{source: models.ChangeRule, name: "transfer_detection"}
+	refundRule    = changeActor{source: models.ChangeRule, name: "refund_matching"}
+	duplicateRule = changeActor{source: models.ChangeRule, name: "duplicate_detection"}
+)
+
+func userActor(user models.User) changeActor {
+	return changeActor{source: models.ChangeManual, name: user.ID.Hex()}
+}
+
+// importActor is the actor for transactions stored by an upload. Plaid
+// transactions come from a sync rather than an import.
+func importActor(source string) changeActor {
+	if source == "plaid" || source == "sync" {
+		return changeActor{source: models.ChangeSync, name: source}
+	}
+	return changeActor{source: models.ChangeImport, name: source}
+}
+
+func GetTransactionHistory(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	txn, err := findUserTransaction(client, userDB.ID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+
+	paginator, err := helpers.NewPaginator(r.URL.Query(), bson.D{{Key: "version", Value: 1}, {Key: "_id", Value: 1}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter := bson.M{"transaction_id": txn.ID, "user_id": userDB.ID}
+
+	collection := client.Database("paymentx").Collection("transaction_history")
+	cursor, err := collection.Find(context.Background(), paginator.Filter(filter), paginator.FindOptions())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var docs []bson.Raw
+	if err := cursor.All(context.Background(), &docs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	docs, pageInfo := paginator.Paginate(docs)
+
+	history := make([]models.TransactionChange, len(docs))
+	for i, doc := range docs {
+		if err := bson.Unmarshal(doc, &history[i]); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := struct {
+		Status  string                     `json:"status"`
+		History []models.TransactionChange `json:"history"`
+		helpers.PageInfo
+	}{
+		Status:   "success",
+		History:  history,
+		PageInfo: pageInfo,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevertTransaction puts the editable fields of a transaction back to how
+// they were at a version of its history. The revert is itself recorded.
+func RevertTransaction(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body struct {
+		Version int `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	txn, err := findUserTransaction(client, userDB.ID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+	if txn.Locked {
+		http.Error(w, "Transaction belongs to a locked statement", http.StatusConflict)
+		return
+	}
+	if !txn.MergedInto.IsZero() {
+		http.Error(w, "Transaction is merged into another one", http.StatusConflict)
+		return
+	}
+
+	var change models.TransactionChange
+	collection := client.Database("paymentx").Collection("transaction_history")
+	if err := collection.FindOne(context.Background(), bson.M{"transaction_id": txn.ID, "user_id": userDB.ID, "version": body.Version}).Decode(&change); err != nil {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+
+	reverted := txn
+	snapshot := change.Snapshot
+	reverted.Amount = snapshot.Amount
+	reverted.TransactionDate = snapshot.TransactionDate
+	reverted.TransactionTime = snapshot.TransactionTime
+	reverted.ValueDate = snapshot.ValueDate
+	reverted.Details = snapshot.Details
+	reverted.Type = snapshot.Type
+	reverted.Balance = snapshot.Balance
+	reverted.Merchant = snapshot.Merchant
+	reverted.Category = snapshot.Category
+	reverted.AccountID = snapshot.AccountID
+	reverted.Notes = snapshot.Notes
+	reverted.Tags = snapshot.Tags
+	reverted.Splits = snapshot.Splits
+	reverted.Excluded = snapshot.Excluded
+
+	reverted, err = saveTransactionEdit(client, userDB, txn, reverted, userActor(userDB), models.ChangeRevert)
+	if mongo.IsDuplicateKeyError(err) {
+		http.Error(w, "An identical transaction already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reverted)
+}
+
+// recordHistory runs fn, which changes the transactions in ids, and logs
+// what it changed on each of them, in one Mongo transaction where the
+// deployment supports them. fn is given the context of that transaction to
+// make its writes with. Transactions fn left untouched get no entry.
+func recordHistory(client *mongo.Client, userID primitive.ObjectID, actor changeActor, action models.ChangeAction, ids []primitive.ObjectID, fn func(ctx context.Context) error) error {
+	_, err := recordHistoryAtomically(client, userID, actor, action, ids, fn)
+	return err
+}
+
+// recordHistoryAtomically is recordHistory, also telling whether the change
+// and its history were written in a Mongo transaction.
+func recordHistoryAtomically(client *mongo.Client, userID primitive.ObjectID, actor changeActor, action models.ChangeAction, ids []primitive.ObjectID, fn func(ctx context.Context) error) (bool, error) {
+	return runAtomically(client, func(ctx context.Context) error {
+		if len(ids) == 0 {
+			return fn(ctx)
+		}
+
+		before, err := loadTransactionsByID(ctx, client, userID, ids)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(ctx); err != nil {
+			return err
+		}
+
+		after, err := loadTransactionsByID(ctx, client, userID, ids)
+		if err != nil {
+			return err
+		}
+
+		var entries []models.TransactionChange
+		for _, id := range ids {
+			txn, ok := after[id]
+			if !ok {
+				continue
+			}
+			changes := helpers.DiffTransactions(before[id], txn)
+			if len(changes) == 0 {
+				continue
+			}
+			entries = append(entries, newChange(userID, actor, action, txn, changes))
+		}
+
+		return writeHistory(ctx, client, entries)
+	})
+}
+
+// recordCreated logs the first version of newly stored transactions.
+func recordCreated(client *mongo.Client, userID primitive.ObjectID, actor changeActor, txns []models.Transaction) error {
+	entries := make([]models.TransactionChange, len(txns))
+	for i, txn := range txns {
+		entries[i] = newChange(userID, actor, models.ChangeCreate, txn, nil)
+	}
+	return writeHistory(context.Background(), client, entries)
+}
+
+func newChange(userID primitive.ObjectID, actor changeActor, action models.ChangeAction, txn models.Transaction, changes []models.FieldChange) models.TransactionChange {
+	return models.TransactionChange{
+		ID:            primitive.NewObjectID(),
+		UserID:        userID,
+		TransactionID: txn.ID,
+		Action:        action,
+		Source:        actor.source,
+		Actor:         actor.name,
+		Changes:       changes,
+		Snapshot:      txn,
+		CreatedAt:     primitive.NewDateTimeFromTime(time.Now()),
+	}
+}
+
+// writeHistory numbers entries after the latest version of each transaction
+// and stores them. Versions are taken from the transaction's own counter,
+// which a single update moves on by the number of entries, so concurrent
+// writers never share one. Transactions whose history predates the counter
+// start it from their latest stored version.
+func writeHistory(ctx context.Context, client *mongo.Client, entries []models.TransactionChange) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	counts := make(map[primitive.ObjectID]int)
+	var ids []primitive.ObjectID
+	for _, entry := range entries {
+		if counts[entry.TransactionID] == 0 {
+			ids = append(ids, entry.TransactionID)
+		}
+		counts[entry.TransactionID]++
+	}
+
+	collection := client.Database("paymentx").Collection("transaction_history")
+	cursor, err := collection.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"transaction_id": bson.M{"$in": ids}}},
+		bson.M{"$group": bson.M{"_id": "$transaction_id", "version": bson.M{"$max": "$version"}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	var latest []struct {
+		ID      primitive.ObjectID `bson:"_id"`
+		Version int                `bson:"version"`
+	}
+	if err := cursor.All(ctx, &latest); err != nil {
+		return err
+	}
+	stored := make(map[primitive.ObjectID]int, len(latest))
+	for _, l := range latest {
+		stored[l.ID] = l.Version
+	}
+
+	// next holds the version the next entry of each transaction gets
+	next := make(map[primitive.ObjectID]int, len(ids))
+	transactions := client.Database("paymentx").Collection("transactions")
+	for _, id := range ids {
+		var txn models.Transaction
+		err := transactions.FindOneAndUpdate(ctx,
+			bson.M{"_id": id},
+			bson.A{bson.M{"$set": bson.M{"historyversion": bson.M{"$add": bson.A{
+				bson.M{"$max": bson.A{bson.M{"$ifNull": bson.A{"$historyversion", 0}}, stored[id]}},
+				counts[id],
+			}}}}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"historyversion": 1}),
+		).Decode(&txn)
+		if err != nil {
+			return err
+		}
+		next[id] = txn.HistoryVersion - counts[id] + 1
+	}
+
+	docs := make([]interface{}, len(entries))
+	for i := range entries {
+		entries[i].Version = next[entries[i].TransactionID]
+		next[entries[i].TransactionID]++
+		entries[i].Snapshot.HistoryVersion = 0
+		docs[i] = entries[i]
+	}
+
+	_, err = collection.InsertMany(ctx, docs)
+	return err
+}
+
+func loadTransactionsByID(ctx context.Context, client *mongo.Client, userID primitive.ObjectID, ids []primitive.ObjectID) (map[primitive.ObjectID]models.Transaction, error) {
+	collection := client.Database("paymentx").Collection("transactions")
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	var txns []models.Transaction
+	if err := cursor.All(ctx, &txns); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[primitive.ObjectID]models.Transaction, len(txns))
+	for _, txn := range txns {
+		byID[txn.ID] = txn
+	}
+	return byID, nil
+}
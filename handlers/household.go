@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func CreateHousehold(w http.ResponseWriter, r *http.Request) {
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var household models.Household
+	if err := json.NewDecoder(r.Body).Decode(&household); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	household.Name = strings.TrimSpace(household.Name)
+	if household.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	household.ID = primitive.NewObjectID()
+	household.OwnerID = userDB.ID
+	household.MemberIDs = []primitive.ObjectID{userDB.ID}
+	household.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	collection := client.Database("paymentx").Collection("households")
+	if _, err := collection.InsertOne(context.Background(), household); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(household)
+}
+
+func GetHouseholds(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("households")
+	cursor, err := collection.Find(context.Background(), bson.M{"member_ids": userDB.ID}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	households := []models.Household{}
+	if err := cursor.All(context.Background(), &households); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(households)
+}
+
+// AddHouseholdMember invites whoever signs in with an email to a household.
+// They become a member once they accept. Only the owner can invite, and the
+// answer is the same whether or not anyone is registered with the email.
+func AddHouseholdMember(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(body.Email))
+	if email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+
+	household, err := findUserHousehold(client, userDB.ID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Household not found", http.StatusNotFound)
+		return
+	}
+	if household.OwnerID != userDB.ID {
+		http.Error(w, "Only the owner can add members", http.StatusForbidden)
+		return
+	}
+
+	// Inviting again renews a declined or answered invitation
+	_, err = client.Database("paymentx").Collection("household_invitations").UpdateOne(context.Background(),
+		bson.M{"household_id": household.ID, "email": email},
+		bson.M{
+			"$set": bson.M{
+				"householdname": household.Name,
+				"invited_by":    userDB.ID,
+				"status":        models.InvitationPending,
+				"createdat":     primitive.NewDateTimeFromTime(time.Now()),
+			},
+			"$unset": bson.M{"respondedat": ""},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{
+		Status:  "success",
+		Message: "Invitation sent",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetHouseholdInvitations lists the pending invitations to the user's email.
+func GetHouseholdInvitations(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("household_invitations")
+	cursor, err := collection.Find(context.Background(),
+		bson.M{"email": strings.ToLower(userDB.Email), "status": models.InvitationPending},
+		options.Find().SetSort(bson.M{"createdat": -1}),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	invitations := []models.HouseholdInvitation{}
+	if err := cursor.All(context.Background(), &invitations); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// AcceptHouseholdInvitation makes the user a member of the household they
+// were invited to.
+func AcceptHouseholdInvitation(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	invitation, err := answerInvitation(client, userDB, mux.Vars(r)["id"], models.InvitationAccepted)
+	if err != nil {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+
+	result, err := client.Database("paymentx").Collection("households").UpdateOne(context.Background(),
+		bson.M{"_id": invitation.HouseholdID},
+		bson.M{"$addToSet": bson.M{"member_ids": userDB.ID}},
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "Household not found", http.StatusNotFound)
+		return
+	}
+
+	response := struct {
+		Status      string             `json:"status"`
+		HouseholdID primitive.ObjectID `json:"household_id"`
+	}{
+		Status:      "success",
+		HouseholdID: invitation.HouseholdID,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeclineHouseholdInvitation turns an invitation down.
+func DeclineHouseholdInvitation(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := answerInvitation(client, userDB, mux.Vars(r)["id"], models.InvitationDeclined); err != nil {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+
+	response := struct {
+		Status string `json:"status"`
+	}{
+		Status: "success",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// answerInvitation records the user's answer to a pending invitation to
+// their email and returns the invitation.
+func answerInvitation(client *mongo.Client, user models.User, hexID string, status models.InvitationStatus) (models.HouseholdInvitation, error) {
+	var invitation models.HouseholdInvitation
+
+	id, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return invitation, err
+	}
+
+	collection := client.Database("paymentx").Collection("household_invitations")
+	err = collection.FindOneAndUpdate(context.Background(),
+		bson.M{"_id": id, "email": strings.ToLower(user.Email), "status": models.InvitationPending},
+		bson.M{"$set": bson.M{"status": status, "respondedat": primitive.NewDateTimeFromTime(time.Now())}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&invitation)
+	return invitation, err
+}
+
+// RemoveHouseholdMember takes a member out of a household. The owner can
+// remove anyone but themselves, and members can remove themselves. Views the
+// member shared with the household become private again.
+func RemoveHouseholdMember(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	household, err := findUserHousehold(client, userDB.ID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Household not found", http.StatusNotFound)
+		return
+	}
+
+	memberID, err := primitive.ObjectIDFromHex(mux.Vars(r)["member"])
+	if err != nil {
+		http.Error(w, "Invalid member id", http.StatusBadRequest)
+		return
+	}
+	if memberID == household.OwnerID {
+		http.Error(w, "The owner cannot leave the household", http.StatusBadRequest)
+		return
+	}
+	if userDB.ID != household.OwnerID && userDB.ID != memberID {
+		http.Error(w, "Only the owner can remove other members", http.StatusForbidden)
+		return
+	}
+
+	db := client.Database("paymentx")
+	result, err := db.Collection("households").UpdateOne(context.Background(),
+		bson.M{"_id": household.ID},
+		bson.M{"$pull": bson.M{"member_ids": memberID}},
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.ModifiedCount == 0 {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	_, err = db.Collection("saved_views").UpdateMany(context.Background(),
+		bson.M{"user_id": memberID, "household_id": household.ID},
+		bson.M{"$unset": bson.M{"household_id": ""}},
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// findUserHousehold loads a household the user is a member of.
+func findUserHousehold(client *mongo.Client, userID primitive.ObjectID, hexID string) (models.Household, error) {
+	var household models.Household
+
+	id, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return household, err
+	}
+
+	collection := client.Database("paymentx").Collection("households")
+	err = collection.FindOne(context.Background(), bson.M{"_id": id, "member_ids": userID}).Decode(&household)
+	return household, err
+}
+
+// userHouseholdIDs returns the households the user is a member of.
+func userHouseholdIDs(client *mongo.Client, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	collection := client.Database("paymentx").Collection("households")
+	cursor, err := collection.Find(context.Background(), bson.M{"member_ids": userID}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var households []models.Household
+	if err := cursor.All(context.Background(), &households); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, len(households))
+	for i, household := range households {
+		ids[i] = household.ID
+	}
+	return ids, nil
+}
@@ -0,0 +1,459 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/importers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// importMaxBytes caps the size of an imported file.
+const importMaxBytes = 20 << 20
+
+// ImportTransactions imports the export of another finance app, a bank
+// statement spreadsheet (CSV, XLS or XLSX), or a bank file in OFX/QFX,
+// camt.053 or MT940, sent as the "file" field of a multipart form or as the
+// raw body. format names the app, bank or file kind and is detected when
+// left out. Accounts are matched by name, or by number for bank statements,
+// and created when missing unless create_accounts=false; account_id files a
+// bank statement under that account instead, and is needed for statements
+// without an account number; it is also the account of app export rows that
+// name none. The import is stored like an upload, under one batch id, and the
+// balances of bank files are reconciled as statements. Bank alert emails, as an .eml or mbox file, are stored as
+// provisional transactions like SMS alerts.
+func ImportTransactions(w http.ResponseWriter, r *http.Request) {
+	serveJob(w, r, importTransactions)
+}
+
+// importTransactions does the work of ImportTransactions.
+func importTransactions(req jobRequest) (interface{}, error) {
+	data, err := importFile(req.contentType, req.body)
+	if err != nil {
+		return nil, badRequest("%s", err.Error())
+	}
+
+	req.run.progress("parsing", 0, 0)
+	format := req.query.Get("format")
+	if format == "" {
+		format, _ = importers.DetectStatement(data)
+	}
+	if format == "" {
+		format = importers.DetectMail(data)
+	}
+	if importers.IsMailFormat(format) {
+		messages, unreadable, err := importers.ReadMail(format, data)
+		if err != nil {
+			return nil, badRequest("%s", err.Error())
+		}
+		return importAlerts(req, messages, unreadable, "email")
+	}
+
+	var records []importers.Record
+	var rowErrors, skipped []importers.RowError
+	var statements []importers.Statement
+	if importers.IsStatementFormat(format) {
+		statements, err = importers.ParseStatements(format, data)
+		if err != nil {
+			return nil, badRequest("%s", err.Error())
+		}
+	} else {
+		rows, err := importers.ReadSheet(data)
+		if err != nil {
+			return nil, badRequest("%s", err.Error())
+		}
+
+		if format == "" && len(rows) > 0 {
+			format, _ = importers.Detect(rows[0])
+		}
+		opts := importers.Options{
+			DateOrder: req.query.Get("date_order"),
+			Member:    req.query.Get("member"),
+		}
+
+		if format == "" || importers.IsBankFormat(format) {
+			// A bank statement is imported as one statement without
+			// balances, so it goes to the account whose number it shows
+			sheet, err := importers.ImportBankSheet(format, rows, opts)
+			if err != nil {
+				if format == "" {
+					err = errors.New("could not tell which app or bank the file was exported from")
+				}
+				return nil, badRequest("%s", err.Error())
+			}
+			format = sheet.Bank
+			rowErrors, skipped = sheet.Errors, sheet.Skipped
+			statements = []importers.Statement{{
+				Account: sheet.Account,
+				Name:    importers.BankName(sheet.Bank),
+				Records: sheet.Records,
+			}}
+		} else {
+			records, rowErrors, err = importers.Import(format, rows, opts)
+			if err != nil {
+				return nil, badRequest("%s", err.Error())
+			}
+		}
+	}
+
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(context.Background())
+
+	create := req.query.Get("create_accounts") != "false"
+
+	// account_id files a bank statement, and the rows of an app export that
+	// name no account, under that account
+	var defaultAccount primitive.ObjectID
+	if accountID := req.query.Get("account_id"); accountID != "" {
+		defaultAccount, err = primitive.ObjectIDFromHex(accountID)
+		if err != nil {
+			return nil, badRequest("Invalid account id")
+		}
+		accounts, err := userAccounts(client, req.user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := accounts[defaultAccount]; !ok {
+			return nil, badRequest("Account not found")
+		}
+	}
+
+	var txns []models.Transaction
+	var created []models.Account
+	// ends[i] is where the transactions of statements[i] end in txns
+	var ends []int
+	if statements != nil {
+		// A statement must land in an account: one named by account_id, or
+		// the one its number matches or creates
+		if defaultAccount.IsZero() {
+			for _, statement := range statements {
+				if statement.Account == "" {
+					return nil, badRequest("Statement has no account number, pass account_id")
+				}
+			}
+		}
+		var accountIDs []primitive.ObjectID
+		if defaultAccount.IsZero() {
+			accountIDs, created, err = statementAccounts(client, req.user.ID, statements, format, create)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			accountIDs = make([]primitive.ObjectID, len(statements))
+			for i := range accountIDs {
+				accountIDs[i] = defaultAccount
+			}
+		}
+		for i, statement := range statements {
+			if accountIDs[i].IsZero() {
+				return nil, badRequest("No account matches statement account %s, pass account_id or create_accounts=true", statement.Account)
+			}
+		}
+		total := 0
+		for _, statement := range statements {
+			total += len(statement.Records)
+		}
+		for i, statement := range statements {
+			for _, rec := range statement.Records {
+				req.run.progress("parsing", len(txns), total)
+				if req.run.canceled() {
+					return nil, errImportCanceled
+				}
+				txns = append(txns, importedTransaction(rec, accountIDs[i]))
+			}
+			ends = append(ends, len(txns))
+		}
+	} else {
+		var accountIDs map[string]primitive.ObjectID
+		accountIDs, created, err = importAccounts(client, req.user.ID, records, format, create)
+		if err != nil {
+			return nil, err
+		}
+		// App exports carry no bank reference and may list alike rows, such
+		// as two coffees on one day. Those are numbered in the order they
+		// come, so a later, longer export of the same app keys them the same
+		seen := make(map[string]int)
+		for i, rec := range records {
+			req.run.progress("parsing", i, len(records))
+			if req.run.canceled() {
+				return nil, errImportCanceled
+			}
+			accountID, ok := accountIDs[strings.ToLower(rec.Account)]
+			if !ok {
+				accountID = defaultAccount
+			}
+			txn := importedTransaction(rec, accountID)
+			if txn.Reference == "" {
+				txn.UserID = req.user.ID
+				content := generateHash(txn)
+				seen[content]++
+				txn.Occurrence = seen[content]
+			}
+			txns = append(txns, txn)
+		}
+	}
+
+	req.run.progress("storing", 0, len(txns))
+	if req.run.canceled() {
+		return nil, errImportCanceled
+	}
+	batchID, inserted, err := storeUpload(client, req.user, req.run, txns, format)
+	if err != nil {
+		return nil, err
+	}
+	req.run.progress("reconciling", len(txns), len(txns))
+
+	gaps, err := checkUploadContinuity(client, req.user.ID, inserted)
+	if err != nil {
+		fmt.Println("Failed to check balance continuity:", err)
+	}
+
+	var reconciled []*models.Statement
+	start := 0
+	for i, statement := range statements {
+		uploaded := txns[start:ends[i]]
+		start = ends[i]
+		if !statement.HasBalances {
+			continue
+		}
+
+		reconciledStatements, err := reconcileUpload(client, req.user.ID, models.Statement{
+			PeriodStart:    primitive.NewDateTimeFromTime(importDay(statement.Start)),
+			PeriodEnd:      primitive.NewDateTimeFromTime(importDay(statement.End)),
+			OpeningBalance: statement.Opening,
+			ClosingBalance: statement.Closing,
+			Derived:        statement.Derived,
+		}, uploaded)
+		if err != nil {
+			return nil, err
+		}
+		reconciled = append(reconciled, reconciledStatements...)
+	}
+
+	response := struct {
+		Status          string               `json:"status"`
+		Format          string               `json:"format"`
+		Read            int                  `json:"read"`
+		Inserted        int                  `json:"inserted"`
+		BatchID         primitive.ObjectID   `json:"batch_id"`
+		AccountsCreated []models.Account     `json:"accounts_created,omitempty"`
+		BalanceGaps     []helpers.BalanceGap `json:"balance_gaps,omitempty"`
+		Statements      []*models.Statement  `json:"statements,omitempty"`
+		Errors          []importers.RowError `json:"errors,omitempty"`
+		Skipped         []importers.RowError `json:"skipped,omitempty"`
+	}{
+		Status:          "success",
+		Format:          format,
+		Read:            len(txns),
+		Inserted:        len(inserted),
+		BatchID:         batchID,
+		AccountsCreated: created,
+		BalanceGaps:     gaps,
+		Statements:      reconciled,
+		Errors:          rowErrors,
+		Skipped:         skipped,
+	}
+
+	return response, nil
+}
+
+// importFile returns the "file" field of a multipart form body, or else the
+// body itself.
+func importFile(contentType string, body []byte) ([]byte, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		return body, nil
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("no file in the form")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return io.ReadAll(part)
+		}
+	}
+}
+
+// importAccounts maps the account names used in records to the user's
+// accounts, matching names without regard to case. Missing accounts are
+// created when create is set; the ones created are returned.
+func importAccounts(client *mongo.Client, userID primitive.ObjectID, records []importers.Record, format string, create bool) (map[string]primitive.ObjectID, []models.Account, error) {
+	accounts, err := userAccounts(client, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make(map[string]primitive.ObjectID)
+	for _, account := range accounts {
+		ids[strings.ToLower(account.Name)] = account.ID
+	}
+
+	var created []models.Account
+	collection := client.Database("paymentx").Collection("accounts")
+	for _, rec := range records {
+		key := strings.ToLower(rec.Account)
+		if _, ok := ids[key]; ok || key == "" || !create {
+			continue
+		}
+
+		account := models.Account{
+			ID:          primitive.NewObjectID(),
+			UserID:      userID,
+			Name:        rec.Account,
+			Institution: format,
+			Type:        importers.AccountType(rec.Account),
+			CreatedAt:   primitive.NewDateTimeFromTime(time.Now()),
+		}
+		if _, err := collection.InsertOne(context.Background(), account); err != nil {
+			return nil, nil, err
+		}
+		ids[key] = account.ID
+		created = append(created, account)
+	}
+	return ids, created, nil
+}
+
+// importedTransaction turns an imported record into a transaction. Dates are
+// stored at midnight UTC like those of uploads, with any time of day kept
+// separately.
+func importedTransaction(rec importers.Record, accountID primitive.ObjectID) models.Transaction {
+	txn := models.Transaction{
+		AccountID:       accountID,
+		Amount:          rec.Amount,
+		TransactionDate: primitive.NewDateTimeFromTime(importDay(rec.Date)),
+		Details:         rec.Details,
+		Type:            rec.Type,
+		Merchant:        rec.Payee,
+		Category:        rec.Category,
+		Notes:           rec.Notes,
+		Tags:            rec.Tags,
+		Reference:       rec.Reference,
+		Balance:         rec.Balance,
+	}
+	if h, m, s := rec.Date.Clock(); h != 0 || m != 0 || s != 0 {
+		txn.TransactionTime = rec.Date.Format("15:04:05")
+	}
+	return txn
+}
+
+// importDay is the midnight UTC a date is stored at.
+func importDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// statementAccounts finds the user's account for each statement of a bank
+// file by its number, falling back on the last four digits since account
+// numbers are often stored masked. Missing accounts are created when create
+// is set; the ones created are returned.
+func statementAccounts(client *mongo.Client, userID primitive.ObjectID, statements []importers.Statement, format string, create bool) ([]primitive.ObjectID, []models.Account, error) {
+	accounts, err := userAccounts(client, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	known := make([]models.Account, 0, len(accounts))
+	for _, account := range accounts {
+		known = append(known, account)
+	}
+
+	ids := make([]primitive.ObjectID, len(statements))
+	var created []models.Account
+	collection := client.Database("paymentx").Collection("accounts")
+	for i, statement := range statements {
+		if statement.Account == "" {
+			continue
+		}
+		if account, ok := findAccountByNumber(known, statement.Account); ok {
+			ids[i] = account.ID
+			continue
+		}
+		if !create {
+			continue
+		}
+
+		name := statement.Name
+		if name == "" {
+			name = "Account"
+		}
+		account := models.Account{
+			ID:          primitive.NewObjectID(),
+			UserID:      userID,
+			Name:        name + " " + lastDigits(statement.Account),
+			Institution: statement.Name,
+			Type:        statement.Type,
+			Number:      statement.Account,
+			CreatedAt:   primitive.NewDateTimeFromTime(time.Now()),
+		}
+		if account.Institution == "" {
+			account.Institution = format
+		}
+		if account.Type == "" {
+			account.Type = importers.AccountType(name)
+		}
+		if _, err := collection.InsertOne(context.Background(), account); err != nil {
+			return nil, nil, err
+		}
+		ids[i] = account.ID
+		known = append(known, account)
+		created = append(created, account)
+	}
+	return ids, created, nil
+}
+
+// findAccountByNumber finds the account with number, or else the only one
+// whose number ends in the same four digits.
+func findAccountByNumber(accounts []models.Account, number string) (models.Account, bool) {
+	normalize := func(s string) string {
+		return strings.ToUpper(strings.Join(strings.Fields(strings.NewReplacer("-", "", "/", "").Replace(s)), ""))
+	}
+	for _, account := range accounts {
+		if account.Number != "" && normalize(account.Number) == normalize(number) {
+			return account, true
+		}
+	}
+
+	var match models.Account
+	matches := 0
+	last := lastDigits(number)
+	for _, account := range accounts {
+		if len(last) == 4 && lastDigits(account.Number) == last {
+			match = account
+			matches++
+		}
+	}
+	return match, matches == 1
+}
+
+// lastDigits returns the last four digits of an account number.
+func lastDigits(number string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, number)
+	if len(digits) > 4 {
+		digits = digits[len(digits)-4:]
+	}
+	return digits
+}
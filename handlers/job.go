@@ -0,0 +1,774 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// jobMaxAttempts is how often a job is tried before it fails.
+	jobMaxAttempts = 3
+	// jobRetryBase is the wait before the first retry, doubled for each
+	// one after.
+	jobRetryBase = 30 * time.Second
+	// jobLease is how long a claimed job stays with its worker without
+	// word from it, after which another worker may take it over.
+	jobLease = 2 * time.Minute
+	// jobPollInterval is how often idle workers look for queued jobs.
+	jobPollInterval = 2 * time.Second
+	// jobRetention is how long finished jobs are kept.
+	jobRetention = 7 * 24 * time.Hour
+	// jobProgressInterval throttles progress writes within a stage.
+	jobProgressInterval = 500 * time.Millisecond
+)
+
+// jobRequest is a request whose work may run as a job: who sent it, its
+// query and its body, read in full. run is set when a job worker serves it.
+type jobRequest struct {
+	user        models.User
+	query       url.Values
+	contentType string
+	body        []byte
+	run         *jobRun
+}
+
+// jobFunc does the work of a kind of job and returns the response. A
+// requestError tells the request itself was at fault.
+type jobFunc func(req jobRequest) (interface{}, error)
+
+// jobFuncs do the work of each kind of job, for the endpoint that queues it
+// and for the worker that runs it alike.
+var jobFuncs = map[string]jobFunc{
+	models.JobUpload:    uploadTransactions,
+	models.JobImport:    importTransactions,
+	models.JobSMSAlerts: importSMSAlerts,
+}
+
+// requestError is a failure of the request itself, such as a body that does
+// not parse or names an account the user does not have. It is answered with
+// its status, and a job failing with one is not retried.
+type requestError struct {
+	code    int
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &requestError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+// errImportCanceled ends a job canceled before it stored anything.
+var errImportCanceled = &requestError{http.StatusConflict, "Import canceled"}
+
+// errorStatus is the status err is answered with.
+func errorStatus(err error) int {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return reqErr.code
+	}
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+var (
+	// jobWake tells the dispatcher a job was queued
+	jobWake = make(chan struct{}, 1)
+	// jobCancels holds the cancel functions of the jobs running in this
+	// process, by job id
+	jobCancels sync.Map
+)
+
+// jobRun lets the work of a job report progress and notice cancellation. A
+// nil jobRun, for requests served directly, does neither.
+type jobRun struct {
+	ctx        context.Context
+	client     *mongo.Client
+	id         primitive.ObjectID
+	attempt    int
+	stage      string
+	reportedAt time.Time
+}
+
+// progress records how far the job has got, at most every
+// jobProgressInterval within a stage.
+func (run *jobRun) progress(stage string, done, total int) {
+	if run == nil {
+		return
+	}
+	if stage == run.stage && time.Since(run.reportedAt) < jobProgressInterval {
+		return
+	}
+	run.stage, run.reportedAt = stage, time.Now()
+
+	_, err := run.client.Database("paymentx").Collection("jobs").UpdateOne(context.Background(),
+		bson.M{"_id": run.id, "attempts": run.attempt, "status": models.JobRunning},
+		bson.M{"$set": bson.M{
+			"progress":  models.JobProgress{Stage: stage, Done: done, Total: total},
+			"updatedat": primitive.NewDateTimeFromTime(time.Now()),
+		}},
+	)
+	if err != nil {
+		fmt.Println("Failed to record job progress:", err)
+	}
+}
+
+// canceled reports whether the job was canceled. Jobs can be canceled until
+// their transactions are stored.
+func (run *jobRun) canceled() bool {
+	return run != nil && run.ctx.Err() != nil
+}
+
+// QueueJob serves a request whose work is a job of kind. It is queued and
+// answered at once with 202 and the job, which GET /jobs/{id} and its event
+// stream then follow. Clients that want the result in the response, as
+// uploads used to give it, opt out with async=false and are served directly,
+// without the retries of a job.
+func QueueJob(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("async") == "false" {
+			serveJob(w, r, jobFuncs[kind])
+			return
+		}
+
+		userContext := cont.Get(r, "user")
+		userDB, err := GetUserFromContext(userContext)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, importMaxBytes)
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+
+		client, err := config.ConnectToMongo()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer client.Disconnect(context.Background())
+
+		query := r.URL.Query()
+		query.Del("async")
+		now := primitive.NewDateTimeFromTime(time.Now())
+		job := models.Job{
+			ID:          primitive.NewObjectID(),
+			UserID:      userDB.ID,
+			Kind:        kind,
+			Status:      models.JobQueued,
+			Query:       query.Encode(),
+			ContentType: r.Header.Get("Content-Type"),
+			Progress:    models.JobProgress{Stage: string(models.JobQueued)},
+			MaxAttempts: jobMaxAttempts,
+			NextRunAt:   now,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+
+		bucket, err := jobPayloads(client)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := bucket.UploadFromStreamWithID(job.ID, job.ID.Hex(), bytes.NewReader(body)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := client.Database("paymentx").Collection("jobs").InsertOne(context.Background(), job); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		select {
+		case jobWake <- struct{}{}:
+		default:
+		}
+
+		response := struct {
+			Status string     `json:"status"`
+			JobID  string     `json:"job_id"`
+			Job    models.Job `json:"job"`
+		}{
+			Status: "queued",
+			JobID:  job.ID.Hex(),
+			Job:    job,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// serveJob does the work of a job directly and writes its response.
+func serveJob(w http.ResponseWriter, r *http.Request, fn jobFunc) {
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, importMaxBytes)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	response, err := fn(jobRequest{
+		user:        userDB,
+		query:       r.URL.Query(),
+		contentType: r.Header.Get("Content-Type"),
+		body:        body,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// jobPayloads is the GridFS bucket holding the bodies of queued requests,
+// which may be larger than a document can be.
+func jobPayloads(client *mongo.Client) (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(client.Database("paymentx"), options.GridFSBucket().SetName("job_payloads"))
+}
+
+// StartJobWorkers runs queued jobs in the background, at most workers at a
+// time, until the process exits.
+func StartJobWorkers(workers int) {
+	go func() {
+		slots := make(chan struct{}, workers)
+		var client *mongo.Client
+
+		for {
+			if client == nil {
+				c, err := config.ConnectToMongo()
+				if err != nil {
+					fmt.Println("Failed to connect job workers:", err)
+					time.Sleep(jobPollInterval)
+					continue
+				}
+				client = c
+			}
+
+			slots <- struct{}{}
+			job, err := claimJob(client)
+			if err != nil || job == nil {
+				<-slots
+				if err != nil {
+					fmt.Println("Failed to claim job:", err)
+				}
+				select {
+				case <-jobWake:
+				case <-time.After(jobPollInterval):
+				}
+				continue
+			}
+
+			go func(job models.Job) {
+				defer func() { <-slots }()
+				runJob(client, job)
+			}(*job)
+		}
+	}()
+}
+
+// claimJob takes the next job that is due, or one whose worker stopped
+// renewing its lease, and counts the attempt.
+func claimJob(client *mongo.Client) (*models.Job, error) {
+	now := time.Now()
+	var job models.Job
+	err := client.Database("paymentx").Collection("jobs").FindOneAndUpdate(context.Background(),
+		bson.M{"$or": bson.A{
+			bson.M{"status": models.JobQueued, "nextrunat": bson.M{"$lte": primitive.NewDateTimeFromTime(now)}},
+			bson.M{"status": models.JobRunning, "leaseuntil": bson.M{"$lt": primitive.NewDateTimeFromTime(now)}},
+		}},
+		bson.M{
+			"$set": bson.M{
+				"status":     models.JobRunning,
+				"startedat":  primitive.NewDateTimeFromTime(now),
+				"leaseuntil": primitive.NewDateTimeFromTime(now.Add(jobLease)),
+				"updatedat":  primitive.NewDateTimeFromTime(now),
+				"progress":   models.JobProgress{Stage: string(models.JobRunning)},
+			},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().SetSort(bson.M{"nextrunat": 1}).SetReturnDocument(options.After),
+	).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// runJob does the work of a claimed job and records the outcome. Running a
+// job again after it was cut off part way is safe as transactions already
+// stored are skipped by their dedup hash.
+func runJob(client *mongo.Client, job models.Job) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobCancels.Store(job.ID, cancel)
+	defer jobCancels.Delete(job.ID)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go keepJobLease(client, job.ID, cancel, stop)
+
+	var response interface{}
+	var err error
+	fn, ok := jobFuncs[job.Kind]
+	switch {
+	case job.Attempts > job.MaxAttempts:
+		// Taken over from workers that stopped while running it
+		err = errors.New("the job stopped before it could finish")
+	case !ok:
+		err = errors.New("unknown job kind " + job.Kind)
+	default:
+		response, err = doJob(client, &jobRun{ctx: ctx, client: client, id: job.ID, attempt: job.Attempts}, fn, job)
+	}
+
+	code, result := http.StatusOK, []byte(nil)
+	if err != nil {
+		code, result = errorStatus(err), []byte(err.Error())
+	} else if result, err = json.Marshal(response); err != nil {
+		code, result = http.StatusInternalServerError, []byte(err.Error())
+	}
+
+	if err := finishJob(client, job, ctx.Err() != nil, code, result); err != nil {
+		fmt.Println("Failed to record job outcome:", err)
+	}
+}
+
+// doJob runs the work of a job as its user, turning a panic into a server
+// error so the worker survives it.
+func doJob(client *mongo.Client, run *jobRun, fn jobFunc, job models.Job) (response interface{}, err error) {
+	var user models.User
+	if err := client.Database("paymentx").Collection("users").FindOne(context.Background(), bson.M{"_id": job.UserID}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, &requestError{http.StatusUnauthorized, "No user found"}
+		}
+		return nil, err
+	}
+	body, err := loadJobPayload(client, job.ID)
+	if err != nil {
+		return nil, err
+	}
+	query, err := url.ParseQuery(job.Query)
+	if err != nil {
+		return nil, badRequest("%s", err.Error())
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			response, err = nil, fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	return fn(jobRequest{
+		user:        user,
+		query:       query,
+		contentType: job.ContentType,
+		body:        body,
+		run:         run,
+	})
+}
+
+// keepJobLease renews the lease of a running job until stop is closed, and
+// cancels it when a cancellation was asked for through another process.
+func keepJobLease(client *mongo.Client, id primitive.ObjectID, cancel context.CancelFunc, stop chan struct{}) {
+	ticker := time.NewTicker(jobLease / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		var job models.Job
+		err := client.Database("paymentx").Collection("jobs").FindOneAndUpdate(context.Background(),
+			bson.M{"_id": id, "status": models.JobRunning},
+			bson.M{"$set": bson.M{"leaseuntil": primitive.NewDateTimeFromTime(time.Now().Add(jobLease))}},
+		).Decode(&job)
+		if err != nil {
+			fmt.Println("Failed to renew job lease:", err)
+			continue
+		}
+		if job.Cancel {
+			cancel()
+		}
+	}
+}
+
+func loadJobPayload(client *mongo.Client, id primitive.ObjectID) ([]byte, error) {
+	bucket, err := jobPayloads(client)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	if _, err := bucket.DownloadToStream(id, &body); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// finishJob records how an attempt went. Server errors are retried with
+// backoff until the attempts run out, while a request at fault fails at
+// once; a job canceled before it stored anything ends canceled. The request
+// is dropped once the job is over. An attempt that lost the job to another
+// worker, after its lease ran out, records nothing.
+func finishJob(client *mongo.Client, job models.Job, canceled bool, code int, result []byte) error {
+	now := time.Now()
+	set := bson.M{
+		"statuscode": code,
+		"updatedat":  primitive.NewDateTimeFromTime(now),
+	}
+
+	message := strings.TrimSpace(string(result))
+	switch {
+	case code < http.StatusMultipleChoices:
+		set["status"] = models.JobSucceeded
+		set["progress.stage"] = "done"
+		set["error"] = ""
+		if json.Valid(result) {
+			set["result"] = json.RawMessage(result)
+		}
+	case canceled:
+		set["status"] = models.JobCanceled
+		set["error"] = "canceled"
+	case code >= http.StatusInternalServerError && job.Attempts < job.MaxAttempts:
+		set["status"] = models.JobQueued
+		set["error"] = message
+		set["nextrunat"] = primitive.NewDateTimeFromTime(now.Add(jobRetryBase << (job.Attempts - 1)))
+		set["progress"] = models.JobProgress{Stage: "retrying"}
+	default:
+		set["status"] = models.JobFailed
+		set["error"] = message
+	}
+
+	final := set["status"] != models.JobQueued
+	if final {
+		set["finishedat"] = primitive.NewDateTimeFromTime(now)
+		set["expiresat"] = primitive.NewDateTimeFromTime(now.Add(jobRetention))
+	}
+
+	updated, err := client.Database("paymentx").Collection("jobs").UpdateOne(context.Background(),
+		bson.M{"_id": job.ID, "attempts": job.Attempts, "status": models.JobRunning},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return err
+	}
+	if updated.MatchedCount == 0 {
+		return nil
+	}
+	if final {
+		return deleteJobPayload(client, job.ID)
+	}
+	return nil
+}
+
+func deleteJobPayload(client *mongo.Client, id primitive.ObjectID) error {
+	bucket, err := jobPayloads(client)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Delete(id); err != nil && err != gridfs.ErrFileNotFound {
+		return err
+	}
+	return nil
+}
+
+// findUserJob loads a job by its hex id, scoped to the user.
+func findUserJob(client *mongo.Client, userID primitive.ObjectID, hexID string) (models.Job, error) {
+	var job models.Job
+
+	id, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return job, err
+	}
+
+	err = client.Database("paymentx").Collection("jobs").FindOne(context.Background(), bson.M{"_id": id, "user_id": userID}).Decode(&job)
+	return job, err
+}
+
+// GetJobs lists the user's recent jobs, newest first, optionally only those
+// with ?status.
+func GetJobs(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	paginator, err := helpers.NewPaginator(r.URL.Query(), bson.D{{Key: "createdat", Value: -1}, {Key: "_id", Value: -1}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter := bson.M{"user_id": userDB.ID}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+
+	cursor, err := client.Database("paymentx").Collection("jobs").Find(context.Background(), paginator.Filter(filter),
+		paginator.FindOptions().SetProjection(bson.M{"result": 0}))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var docs []bson.Raw
+	if err := cursor.All(context.Background(), &docs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	docs, pageInfo := paginator.Paginate(docs)
+
+	jobs := make([]models.Job, len(docs))
+	for i, doc := range docs {
+		if err := bson.Unmarshal(doc, &jobs[i]); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := struct {
+		Status string       `json:"status"`
+		Jobs   []models.Job `json:"jobs"`
+		helpers.PageInfo
+	}{
+		Status:   "success",
+		Jobs:     jobs,
+		PageInfo: pageInfo,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetJob returns a job with its progress, and its result once it has
+// succeeded.
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	job, err := findUserJob(client, userDB.ID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// CancelJob cancels a queued job at once, and asks the worker of a running
+// one to stop. A running job that has already stored its transactions
+// finishes anyway.
+func CancelJob(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	job, err := findUserJob(client, userDB.ID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("jobs")
+	now := time.Now()
+	result, err := collection.UpdateOne(context.Background(),
+		bson.M{"_id": job.ID, "status": models.JobQueued},
+		bson.M{"$set": bson.M{
+			"status":     models.JobCanceled,
+			"error":      "canceled",
+			"updatedat":  primitive.NewDateTimeFromTime(now),
+			"finishedat": primitive.NewDateTimeFromTime(now),
+			"expiresat":  primitive.NewDateTimeFromTime(now.Add(jobRetention)),
+		}},
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if result.ModifiedCount > 0 {
+		if err := deleteJobPayload(client, job.ID); err != nil {
+			fmt.Println("Failed to delete job payload:", err)
+		}
+	} else {
+		// The job was claimed in the meantime or is already running
+		result, err = collection.UpdateOne(context.Background(),
+			bson.M{"_id": job.ID, "status": models.JobRunning},
+			bson.M{"$set": bson.M{"cancel": true, "updatedat": primitive.NewDateTimeFromTime(now)}},
+		)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if result.ModifiedCount == 0 {
+			http.Error(w, "Job has already finished", http.StatusConflict)
+			return
+		}
+		if cancel, ok := jobCancels.Load(job.ID); ok {
+			cancel.(context.CancelFunc)()
+		}
+	}
+
+	job, err = findUserJob(client, userDB.ID, job.ID.Hex())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// StreamJobEvents streams a job's progress as server-sent events: a
+// "progress" event whenever it changes and a final event named after the
+// status the job ended in, after which the stream closes.
+func StreamJobEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	job, err := findUserJob(client, userDB.ID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var last primitive.DateTime
+	idle := 0
+
+	for {
+		if job.UpdatedAt != last {
+			last, idle = job.UpdatedAt, 0
+
+			event := "progress"
+			switch job.Status {
+			case models.JobSucceeded, models.JobFailed, models.JobCanceled:
+				event = string(job.Status)
+			}
+			data, err := json.Marshal(job)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+			flusher.Flush()
+			if event != "progress" {
+				return
+			}
+		} else if idle++; idle%15 == 0 {
+			// Keeps proxies from closing a quiet stream
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		job, err = findUserJob(client, userDB.ID, job.ID.Hex())
+		if err != nil {
+			return
+		}
+	}
+}
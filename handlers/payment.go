@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -23,14 +25,33 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// hashVersion is the version of the dedup key made by generateHash. Bump it
+// whenever the key changes so BackfillTransactionHashes recomputes the
+// stored ones.
+const hashVersion = 2
+
+// generateHash is the dedup key of a transaction. The account and type are
+// part of it so the two legs of a transfer never share a key, and the
+// narration is compared without regard to case and spacing. Rows with the
+// same contents are told apart by their Occurrence. A bank reference is
+// unique within its account, so the same row downloaded again keeps its key
+// even if the bank rewords the narration.
 func generateHash(txn models.Transaction) string {
-	key := fmt.Sprintf("%s|%s|%s|%s|%s",
+	key := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s",
 		txn.TransactionDate.Time().Format(time.RFC3339),
 		fmt.Sprintf("%v", txn.Amount),
-		txn.Details,
+		strings.Join(strings.Fields(strings.ToUpper(txn.Details)), " "),
 		txn.TransactionTime,
-		txn.UserID,
+		txn.UserID.Hex(),
+		txn.AccountID.Hex(),
+		txn.Type,
 	)
+	if txn.Occurrence > 1 {
+		key += fmt.Sprintf("|#%d", txn.Occurrence)
+	}
+	if txn.Reference != "" {
+		key = fmt.Sprintf("ref|%s|%s|%s", txn.UserID.Hex(), txn.AccountID.Hex(), txn.Reference)
+	}
 	hash := sha256.Sum256([]byte(key))
 	return hex.EncodeToString(hash[:])
 }
@@ -54,11 +75,139 @@ func convertStringToDateTime(dateStr string) (primitive.DateTime, error) {
 	return primitive.NewDateTimeFromTime(date), nil
 }
 
-func absInt(x int64) int64 {
-	if x < 0 {
-		return -x
+// activeTransactions restricts a transactions filter to the ones that are
+// live, leaving out duplicates merged into another transaction and
+// transactions in the trash.
+func activeTransactions(filter bson.M) bson.M {
+	filter["merged_into"] = bson.M{"$exists": false}
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+// analyticsMatch adds the default exclusions of spend and income analytics to
+// a filter. Transactions excluded by the user are always left out, internal
+// transfers unless include_transfers=true, and with net=true refunds are
+// left out of income. The list filters of the request, such as category or
+// transfers=only, narrow it further where the handler has not set that
+// field.
+func analyticsMatch(r *http.Request, match bson.M) (bson.M, error) {
+	return analyticsMatchValues(r.URL.Query(), match)
+}
+
+func analyticsMatchValues(values url.Values, match bson.M) (bson.M, error) {
+	match = activeTransactions(match)
+	match["excluded"] = bson.M{"$ne": true}
+	if values.Get("include_transfers") != "true" && values.Get("transfers") == "" {
+		match["istransfer"] = bson.M{"$ne": true}
 	}
-	return x
+	if values.Get("net") == "true" {
+		match["refund_of"] = bson.M{"$exists": false}
+	}
+
+	query, err := helpers.ParseTransactionQuery(values)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range query.Filter {
+		if _, ok := match[key]; !ok {
+			match[key] = value
+		}
+	}
+	return match, nil
+}
+
+// analyticsAmount is the amount expression summed by analytics. With
+// net=true refunds are netted against the debit they belong to.
+func analyticsAmount(r *http.Request) interface{} {
+	return analyticsAmountValues(r.URL.Query())
+}
+
+func analyticsAmountValues(values url.Values) interface{} {
+	if values.Get("net") == "true" {
+		return bson.M{"$subtract": bson.A{"$amount", bson.M{"$ifNull": bson.A{"$refundedamount", 0}}}}
+	}
+	return "$amount"
+}
+
+// splitFilters are the list filters that apply to the parts of a split
+// transaction rather than to the transaction as a whole.
+var splitFilters = []string{"category", "tags"}
+
+// matchSplitParts rewrites the splitFilters of filter to also match a
+// transaction with a part that passes them, and returns them as the filter
+// of the parts themselves.
+func matchSplitParts(filter bson.M) bson.M {
+	parts := bson.M{}
+	var either bson.A
+	for _, field := range splitFilters {
+		if value, ok := filter[field]; ok {
+			parts[field] = value
+			either = append(either, bson.M{"$or": bson.A{
+				bson.M{field: value},
+				bson.M{"splits." + field: value},
+			}})
+			delete(filter, field)
+		}
+	}
+	if len(either) > 0 {
+		if existing, ok := filter["$and"].(bson.A); ok {
+			filter["$and"] = append(existing, either...)
+		} else {
+			filter["$and"] = either
+		}
+	}
+	return parts
+}
+
+// analyticsStages starts an analytics pipeline with the analyticsMatch
+// filter. When the pipeline groups by category, or the request filters on
+// category or tags, split transactions are then unwound into their parts,
+// each standing in for a transaction with the part's amount, category and
+// tags and its share of any refund.
+func analyticsStages(r *http.Request, match bson.M, byCategory bool) (bson.A, error) {
+	return analyticsStagesValues(r.URL.Query(), match, byCategory)
+}
+
+func analyticsStagesValues(values url.Values, match bson.M, byCategory bool) (bson.A, error) {
+	match, err := analyticsMatchValues(values, match)
+	if err != nil {
+		return nil, err
+	}
+
+	// Transactions with a matching part pass the first match, and the
+	// parts themselves are filtered once unwound
+	parts := matchSplitParts(match)
+
+	if !byCategory && len(parts) == 0 {
+		return bson.A{bson.M{"$match": match}}, nil
+	}
+
+	stages := bson.A{
+		bson.M{"$match": match},
+		bson.M{"$set": bson.M{"parts": bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$splits", bson.A{}}}}, 0}},
+			"$splits",
+			bson.A{bson.M{"amount": "$amount", "category": "$category", "tags": "$tags"}},
+		}}}},
+		bson.M{"$unwind": "$parts"},
+		bson.M{"$set": bson.M{
+			"refundedamount": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$amount", 0}},
+				bson.M{"$multiply": bson.A{
+					bson.M{"$ifNull": bson.A{"$refundedamount", 0}},
+					bson.M{"$divide": bson.A{"$parts.amount", "$amount"}},
+				}},
+				0,
+			}},
+			"amount":   "$parts.amount",
+			"category": "$parts.category",
+			"tags":     bson.M{"$ifNull": bson.A{"$parts.tags", bson.A{}}},
+		}},
+	}
+	if len(parts) > 0 {
+		stages = append(stages, bson.M{"$match": parts})
+	}
+	return stages, nil
 }
 
 func GetUserFromContext(userContext interface{}) (models.User, error) {
@@ -191,74 +340,397 @@ func LinkUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// InputTransactionData stores an upload of transactions. The body is either
+// a plain array of transactions or an object that also carries the
+// statement's period and opening and closing balances.
 func InputTransactionData(w http.ResponseWriter, r *http.Request) {
-	userContext := cont.Get(r, "user")
+	serveJob(w, r, uploadTransactions)
+}
 
-	userDB, err := GetUserFromContext(userContext)
+// uploadTransactions does the work of InputTransactionData.
+func uploadTransactions(req jobRequest) (interface{}, error) {
+	var upload struct {
+		Statement    *models.Statement    `json:"statement"`
+		Transactions []models.Transaction `json:"transactions"`
+	}
+
+	var err error
+	if trimmed := bytes.TrimSpace(req.body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &upload.Transactions)
+	} else {
+		err = json.Unmarshal(trimmed, &upload)
+	}
 
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+		return nil, badRequest("%s", err.Error())
 	}
 
-	var transactionsArr []models.Transaction
+	transactionsArr := upload.Transactions
 
-	if err := json.NewDecoder(r.Body).Decode(&transactionsArr); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		return nil, err
+	}
+
+	defer client.Disconnect(context.Background())
+
+	accounts, err := userAccounts(client, req.user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Transactions without their own account_id belong to the ?account_id one
+	var defaultAccount primitive.ObjectID
+	if accountID := req.query.Get("account_id"); accountID != "" {
+		defaultAccount, err = primitive.ObjectIDFromHex(accountID)
+		if err != nil {
+			return nil, badRequest("Invalid account id")
+		}
 	}
 
-	var transactionInterface []interface{}
+	source := req.query.Get("source")
+	if source == "" {
+		source = "api"
+	}
 
 	for i := range transactionsArr {
-		transactionsArr[i].TransactionID = generateHash(transactionsArr[i])
-		transactionsArr[i].UserID = userDB.ID
-		transactionInterface = append(transactionInterface, transactionsArr[i])
+		req.run.progress("parsing", i, len(transactionsArr))
+		if req.run.canceled() {
+			return nil, errImportCanceled
+		}
+		if transactionsArr[i].AccountID.IsZero() {
+			transactionsArr[i].AccountID = defaultAccount
+		}
+		if _, ok := accounts[transactionsArr[i].AccountID]; !ok && !transactionsArr[i].AccountID.IsZero() {
+			return nil, badRequest("Account not found")
+		}
 	}
 
-	client, err := config.ConnectToMongo()
+	// The balances of a statement belong to one account, so a statement
+	// sent with a mixed upload has to name it
+	if upload.Statement != nil && !upload.Statement.AccountID.IsZero() {
+		if _, ok := accounts[upload.Statement.AccountID]; !ok {
+			return nil, badRequest("Statement account not found")
+		}
+	}
+	if upload.Statement != nil && (upload.Statement.OpeningBalance != 0 || upload.Statement.ClosingBalance != 0) {
+		uploadAccounts := make(map[primitive.ObjectID]bool)
+		for _, txn := range transactionsArr {
+			uploadAccounts[txn.AccountID] = true
+		}
+		if upload.Statement.AccountID.IsZero() && len(uploadAccounts) > 1 {
+			return nil, badRequest("Transactions span several accounts, set statement.account_id")
+		}
+		if !upload.Statement.AccountID.IsZero() && !uploadAccounts[upload.Statement.AccountID] {
+			return nil, badRequest("No uploaded transaction belongs to the statement account")
+		}
+	}
+
+	req.run.progress("storing", 0, len(transactionsArr))
+	if req.run.canceled() {
+		return nil, errImportCanceled
+	}
+	batchID, inserted, err := storeUpload(client, req.user, req.run, transactionsArr, source)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
+	req.run.progress("reconciling", len(transactionsArr), len(transactionsArr))
 
-	defer client.Disconnect(context.Background())
+	gaps, err := checkUploadContinuity(client, req.user.ID, inserted)
+	if err != nil {
+		fmt.Println("Failed to check balance continuity:", err)
+	}
 
-	collection := client.Database("paymentx").Collection("transactions")
-	// Insert many, skip duplicates based on TransactionID (unique index recommended on TransactionID)
-	opts := options.InsertMany().SetOrdered(false)
-	_, err = collection.InsertMany(context.Background(), transactionInterface, opts)
-	if err != nil {
-		// If error is due to duplicate key, ignore it (continue)
-		if we, ok := err.(mongo.BulkWriteException); ok {
-			// Check if all errors are duplicate key errors
-			allDup := true
-			for _, writeErr := range we.WriteErrors {
-				if writeErr.Code != 11000 {
-					allDup = false
-					break
-				}
+	// statement is the one of the account the balances were sent for; a
+	// mixed upload also lists the statements of its other accounts
+	var statement *models.Statement
+	var statements []*models.Statement
+	if upload.Statement != nil {
+		statements, err = reconcileUpload(client, req.user.ID, *upload.Statement, transactionsArr)
+		if err != nil {
+			return nil, err
+		}
+		for _, reconciled := range statements {
+			if len(statements) == 1 || reconciled.AccountID == upload.Statement.AccountID {
+				statement = reconciled
 			}
-			if allDup {
-				err = nil // ignore duplicate key errors
+		}
+		if len(statements) < 2 {
+			statements = nil
+		}
+	}
+
+	response := struct {
+		Status      string               `json:"status"`
+		Message     string               `json:"message"`
+		Inserted    int                  `json:"inserted"`
+		BatchID     primitive.ObjectID   `json:"batch_id"`
+		BalanceGaps []helpers.BalanceGap `json:"balance_gaps,omitempty"`
+		Statement   *models.Statement    `json:"statement,omitempty"`
+		Statements  []*models.Statement  `json:"statements,omitempty"`
+	}{
+		Status:      "success",
+		Message:     "Transaction Added Successfully",
+		Inserted:    len(inserted),
+		BatchID:     batchID,
+		BalanceGaps: gaps,
+		Statement:   statement,
+		Statements:  statements,
+	}
+
+	return response, nil
+}
+
+// storeUpload stores the transactions of one upload and runs them through
+// the post-insert stages. Every transaction stored shares a batch id so the
+// whole upload can be rolled back. An upload job keeps its id as the batch
+// id across attempts, so rows stored by an attempt that failed before their
+// post-insert stages ran are taken up again rather than skipped as
+// duplicates. It returns the batch id and the transactions that were stored
+// and not merged away as duplicates.
+func storeUpload(client *mongo.Client, user models.User, run *jobRun, txns []models.Transaction, source string) (primitive.ObjectID, []models.Transaction, error) {
+	batchID := primitive.NewObjectID()
+	if run != nil {
+		batchID = run.id
+	}
+
+	for i := range txns {
+		txns[i].BatchID = batchID
+		if txns[i].Source == "" {
+			txns[i].Source = source
+		}
+		txns[i].ID = primitive.NewObjectID()
+		txns[i].UserID = user.ID
+		txns[i].Unprocessed = true
+		prepareTransaction(&txns[i])
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+	inserted, err := insertTransactions(collection, txns)
+	if err != nil {
+		return batchID, nil, err
+	}
+
+	if err := recordCreated(client, user.ID, importActor(source), inserted); err != nil {
+		fmt.Println("Failed to record transaction history:", err)
+	}
+
+	pending := inserted
+	if run != nil {
+		cursor, err := collection.Find(context.Background(), activeTransactions(bson.M{
+			"user_id":     user.ID,
+			"batch_id":    batchID,
+			"unprocessed": true,
+		}))
+		if err != nil {
+			return batchID, nil, err
+		}
+		pending = nil
+		if err := cursor.All(context.Background(), &pending); err != nil {
+			return batchID, nil, err
+		}
+	}
+	if len(pending) == 0 {
+		return batchID, nil, nil
+	}
+
+	remaining := processInserted(client, user, pending)
+
+	ids := make([]primitive.ObjectID, len(pending))
+	for i := range pending {
+		ids[i] = pending[i].ID
+	}
+	if _, err := collection.UpdateMany(context.Background(),
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"$unset": bson.M{"unprocessed": ""}},
+	); err != nil {
+		return batchID, nil, err
+	}
+	for i := range remaining {
+		remaining[i].Unprocessed = false
+	}
+
+	return batchID, remaining, nil
+}
+
+// processInserted runs the post-insert stages over newly stored
+// transactions. Failures are logged rather than failing the upload. It
+// returns the transactions that were not merged away as duplicates.
+func processInserted(client *mongo.Client, user models.User, inserted []models.Transaction) []models.Transaction {
+	// Later stages only see transactions that were not merged as duplicates
+	remaining, err := runDuplicateDetection(client, user, inserted)
+	if err != nil {
+		fmt.Println("Failed to detect duplicates:", err)
+	}
+
+	if err := runTransferDetection(client, user, remaining); err != nil {
+		fmt.Println("Failed to detect transfers:", err)
+	}
+
+	if err := runRefundMatching(client, user, remaining); err != nil {
+		fmt.Println("Failed to match refunds:", err)
+	}
+
+	if err := runAlertRules(client, user, remaining); err != nil {
+		fmt.Println("Failed to evaluate alert rules:", err)
+	}
+
+	if err := runAnomalyDetection(client, user, remaining); err != nil {
+		fmt.Println("Failed to detect anomalies:", err)
+	}
+
+	return remaining
+}
+
+// prepareTransaction fills the fields derived from the others: the dedup
+// hash, the merchant, counterparty and category unless they were given, and
+// the search index.
+func prepareTransaction(txn *models.Transaction) {
+	txn.TransactionID = generateHash(*txn)
+	txn.HashVersion = hashVersion
+	if txn.Merchant == "" {
+		txn.Merchant = helpers.ExtractMerchant(txn.Details)
+	}
+	if txn.Counterparty == "" {
+		txn.Counterparty = helpers.ExtractCounterparty(txn.Details)
+	}
+	if txn.Category == "" {
+		txn.Category = helpers.Categorize(txn.Details, txn.Merchant)
+	}
+	txn.SearchTerms = helpers.SearchTokens(*txn)
+}
+
+// insertTransactions stores txns, skipping the ones whose TransactionID is
+// already present, and returns the transactions that were actually inserted.
+func insertTransactions(collection *mongo.Collection, txns []models.Transaction) ([]models.Transaction, error) {
+	if len(txns) == 0 {
+		return nil, nil
+	}
+
+	docs := make([]interface{}, len(txns))
+	for i := range txns {
+		docs[i] = txns[i]
+	}
+
+	// Insert many, skip duplicates based on TransactionID (unique index on transactionid)
+	skipped, err := insertManySkippingDuplicates(collection, docs)
+	if err != nil {
+		return nil, err
+	}
+
+	var inserted []models.Transaction
+	for i := range txns {
+		if !skipped[i] {
+			inserted = append(inserted, txns[i])
+		}
+	}
+
+	return inserted, nil
+}
+
+// insertManySkippingDuplicates inserts docs unordered and reports the indexes
+// that were rejected by a unique index. Any other write error is returned.
+func insertManySkippingDuplicates(collection *mongo.Collection, docs []interface{}) (map[int]bool, error) {
+	skipped := make(map[int]bool)
+
+	_, err := collection.InsertMany(context.Background(), docs, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		we, ok := err.(mongo.BulkWriteException)
+		if !ok {
+			return nil, err
+		}
+		for _, writeErr := range we.WriteErrors {
+			if writeErr.Code != 11000 {
+				return nil, err
 			}
+			skipped[writeErr.Index] = true
 		}
 	}
 
+	return skipped, nil
+}
+
+// BackfillTransactionHashes recomputes the dedup hash of transactions stored
+// under an older version of generateHash, so uploading their statement again
+// still finds them, and returns how many it updated. A trashed transaction
+// keeps its id after the hash. When the new hash is already taken by another
+// transaction, the old one is kept.
+func BackfillTransactionHashes() (int, error) {
+	client, err := config.ConnectToMongo()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return 0, err
 	}
+	defer client.Disconnect(context.Background())
 
-	response := struct {
-		Status  string `json:"status"`
-		Message string `json:"message"`
-	}{
-		Status:  "success",
-		Message: "Transaction Added Successfully",
+	collection := client.Database("paymentx").Collection("transactions")
+	updated := 0
+	for {
+		cursor, err := collection.Find(context.Background(),
+			bson.M{"hashversion": bson.M{"$ne": hashVersion}},
+			options.Find().SetLimit(searchBackfillBatch).SetProjection(bson.M{
+				"transactiondate": 1, "amount": 1, "details": 1, "transactiontime": 1, "user_id": 1,
+				"account_id": 1, "type": 1, "occurrence": 1, "reference": 1, "deleted_at": 1,
+			}),
+		)
+		if err != nil {
+			return updated, err
+		}
+		var txns []models.Transaction
+		err = cursor.All(context.Background(), &txns)
+		cursor.Close(context.Background())
+		if err != nil {
+			return updated, err
+		}
+		if len(txns) == 0 {
+			return updated, nil
+		}
+
+		writes := make([]mongo.WriteModel, len(txns))
+		for i, txn := range txns {
+			hash := generateHash(txn)
+			if txn.DeletedAt != 0 {
+				hash += trashedHashMarker + txn.ID.Hex()
+			}
+			writes[i] = mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": txn.ID}).
+				SetUpdate(bson.M{"$set": bson.M{"transactionid": hash, "hashversion": hashVersion}})
+		}
+		_, err = collection.BulkWrite(context.Background(), writes, options.BulkWrite().SetOrdered(false))
+		taken := 0
+		if err != nil {
+			we, ok := err.(mongo.BulkWriteException)
+			if !ok {
+				return updated, err
+			}
+			var kept []mongo.WriteModel
+			for _, writeErr := range we.WriteErrors {
+				if writeErr.Code != 11000 {
+					return updated, err
+				}
+				kept = append(kept, mongo.NewUpdateOneModel().
+					SetFilter(bson.M{"_id": txns[writeErr.Index].ID}).
+					SetUpdate(bson.M{"$set": bson.M{"hashversion": hashVersion}}))
+			}
+			if _, err := collection.BulkWrite(context.Background(), kept); err != nil {
+				return updated, err
+			}
+			taken = len(kept)
+		}
+		updated += len(txns) - taken
 	}
+}
 
-	json.NewEncoder(w).Encode(response)
+// StartHashBackfill runs BackfillTransactionHashes once in the background.
+func StartHashBackfill() {
+	go func() {
+		updated, err := BackfillTransactionHashes()
+		if err != nil {
+			fmt.Println("Failed to backfill transaction hashes:", err)
+		}
+		if updated > 0 {
+			fmt.Println("Rehashed", updated, "transactions")
+		}
+	}()
 }
 
 func GetUserTransaction(w http.ResponseWriter, r *http.Request) {
@@ -280,46 +752,46 @@ func GetUserTransaction(w http.ResponseWriter, r *http.Request) {
 
 	defer client.Disconnect(context.Background())
 
-	page := r.URL.Query().Get("page")
-	if page == "" {
-		page = "0"
-	}
-	pageInt, err := strconv.Atoi(page)
+	query, err := helpers.ParseTransactionQuery(r.URL.Query())
 
 	if err != nil {
-		http.Error(w, "Invalid page number", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	limit := r.URL.Query().Get("limit")
-
-	if limit == "" {
-		limit = "10"
-	}
-
-	limitInt, err := strconv.Atoi(limit)
-	fmt.Println(limitInt)
-	fmt.Println(pageInt)
+	paginator, err := helpers.NewPaginator(r.URL.Query(), query.Sort)
 
 	if err != nil {
-		http.Error(w, "Invalid limit number", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	sort := map[string]interface{}{
-		"transactiondate": -1,
-	}
+	filter := query.Filter
+	filter["user_id"] = userDB.ID
+	filter = activeTransactions(filter)
+	// A split transaction is listed when one of its parts matches
+	matchSplitParts(filter)
 
 	collection := client.Database("paymentx").Collection("transactions")
-	cursor, err := collection.Find(context.Background(), bson.M{"user_id": userDB.ID}, helpers.NewMongoPaginate(absInt(int64(limitInt)), absInt(int64(pageInt)), sort).GetPaginatedOpts().SortQuery(sort).BuildFindOptions())
+
+	// Get total count of matching transactions
+	count, err := collection.CountDocuments(context.Background(), filter)
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Get total count of transactions
-	count, err := collection.CountDocuments(context.Background(), bson.M{"user_id": userDB.ID})
+	opts := paginator.FindOptions()
+	if query.Projection != nil {
+		// The sort keys are needed to build the cursors
+		for _, key := range query.Sort {
+			query.Projection[key.Key] = 1
+		}
+		opts.SetProjection(query.Projection)
+	}
+
+	cursor, err := collection.Find(context.Background(), paginator.Filter(filter), opts)
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -327,22 +799,34 @@ func GetUserTransaction(w http.ResponseWriter, r *http.Request) {
 	}
 	defer cursor.Close(context.Background())
 
-	var transactions []models.Transaction
-	if err = cursor.All(context.Background(), &transactions); err != nil {
+	var docs []bson.Raw
+	if err = cursor.All(context.Background(), &docs); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	docs, pageInfo := paginator.Paginate(docs)
+
+	transactions := make([]models.Transaction, len(docs))
+	for i, doc := range docs {
+		if err := bson.Unmarshal(doc, &transactions[i]); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	response := struct {
 		Data  []models.Transaction `json:"data"`
 		Total int                  `json:"total"`
 		Page  int                  `json:"page"`
 		Limit int                  `json:"limit"`
+		helpers.PageInfo
 	}{
-		Data:  transactions,
-		Total: int(count),
-		Page:  pageInt,
-		Limit: limitInt,
+		Data:     transactions,
+		Total:    int(count),
+		Page:     int(paginator.Page),
+		Limit:    int(paginator.Limit),
+		PageInfo: pageInfo,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -358,20 +842,6 @@ func GetTransactionAnalysis(w http.ResponseWriter, r *http.Request) {
 
 	}
 
-	startDate, err := convertStringToDateTime(r.URL.Query().Get("start_date"))
-
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	endDate, err := convertStringToDateTime(r.URL.Query().Get("end_date"))
-
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
 	defer client.Disconnect(context.Background())
 
 	userContext := cont.Get(r, "user")
@@ -382,28 +852,24 @@ func GetTransactionAnalysis(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Build Filter Options
-	opts := options.Find().SetSort(bson.M{"transactiondate": 1})
+	query, err := helpers.ParseTransactionQuery(r.URL.Query())
 
-	// Set the date range filter
-	filter := bson.M{}
-	daterange := bson.M{}
-
-	if r.URL.Query().Get("start_date") != "" {
-		daterange["$gte"] = startDate
-		filter["transactiondate"] = daterange
-	}
-
-	if r.URL.Query().Get("end_date") != "" {
-		daterange["$lte"] = endDate
-		filter["transactiondate"] = daterange
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if r.URL.Query().Get("type") != "" {
-		filter["type"] = r.URL.Query().Get("type")
-	}
+	// Build Filter Options
+	opts := options.Find().SetSort(bson.D{{Key: "transactiondate", Value: 1}, {Key: "_id", Value: 1}})
 
+	filter := query.Filter
 	filter["user_id"] = userDB.ID
+	filter, err = analyticsMatch(r, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	matchSplitParts(filter)
 
 	fmt.Println(bson.M{"filter": filter})	
 
@@ -460,17 +926,21 @@ func GetMonthlyTransactions(w http.ResponseWriter, r *http.Request){
 
 	
 	// Group by year and month extracted from transactiondate (which is a date/time field)
-	pipeline := bson.A{
-		bson.M{"$match": bson.M{"user_id": userDB.ID, "type": tp}},
+	stages, err := analyticsStages(r, bson.M{"user_id": userDB.ID, "type": tp}, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pipeline := append(stages,
 		bson.M{"$group": bson.M{
 			"_id": bson.M{
 				"year":  bson.M{"$year": "$transactiondate"},
 				"month": bson.M{"$month": "$transactiondate"},
 			},
-			"total_spend": bson.M{"$sum": "$amount"},
+			"total_spend": bson.M{"$sum": analyticsAmount(r)},
 		}},
 		bson.M{"$sort": bson.M{"_id.year": 1, "_id.month": 1}},
-	}
+	)
 
 	cursor, err := collection.Aggregate(context.Background(), pipeline)
 	if err != nil {
@@ -556,24 +1026,28 @@ func GetUserAverageMonthlySpend(w http.ResponseWriter, r *http.Request){
 	}
 
 	// Pipeline for current month
-	pipeline := bson.A{
-		bson.M{"$match": bson.M{
-			"user_id": userDB.ID,
-			"type":    tp,
-			"$expr": bson.M{
-				"$and": bson.A{
-					bson.M{"$eq": bson.A{bson.M{"$year": "$transactiondate"}, year}},
-					bson.M{"$eq": bson.A{bson.M{"$month": "$transactiondate"}, month}},
-				},
+	stages, err := analyticsStages(r, bson.M{
+		"user_id": userDB.ID,
+		"type":    tp,
+		"$expr": bson.M{
+			"$and": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$year": "$transactiondate"}, year}},
+				bson.M{"$eq": bson.A{bson.M{"$month": "$transactiondate"}, month}},
 			},
-		}},
+		},
+	}, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pipeline := append(stages,
 		bson.M{"$group": bson.M{
 			"_id": bson.M{
 				"year":  bson.M{"$year": "$transactiondate"},
 				"month": bson.M{"$month": "$transactiondate"},
 				"day":   bson.M{"$dayOfMonth": "$transactiondate"},
 			},
-			"daily_spend": bson.M{"$sum": "$amount"},
+			"daily_spend": bson.M{"$sum": analyticsAmount(r)},
 		}},
 		bson.M{"$group": bson.M{
 			"_id": bson.M{
@@ -582,7 +1056,7 @@ func GetUserAverageMonthlySpend(w http.ResponseWriter, r *http.Request){
 			},
 			"average_daily_spend": bson.M{"$avg": "$daily_spend"},
 		}},
-	}
+	)
 
 	cursor, err := collection.Aggregate(context.Background(), pipeline)
 	if err != nil {
@@ -614,24 +1088,28 @@ func GetUserAverageMonthlySpend(w http.ResponseWriter, r *http.Request){
 		prevMonth = 12
 		prevYear = year - 1
 	}
-	prevPipeline := bson.A{
-		bson.M{"$match": bson.M{
-			"user_id": userDB.ID,
-			"type":    tp,
-			"$expr": bson.M{
-				"$and": bson.A{
-					bson.M{"$eq": bson.A{bson.M{"$year": "$transactiondate"}, prevYear}},
-					bson.M{"$eq": bson.A{bson.M{"$month": "$transactiondate"}, prevMonth}},
-				},
+	prevStages, err := analyticsStages(r, bson.M{
+		"user_id": userDB.ID,
+		"type":    tp,
+		"$expr": bson.M{
+			"$and": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$year": "$transactiondate"}, prevYear}},
+				bson.M{"$eq": bson.A{bson.M{"$month": "$transactiondate"}, prevMonth}},
 			},
-		}},
+		},
+	}, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	prevPipeline := append(prevStages,
 		bson.M{"$group": bson.M{
 			"_id": bson.M{
 				"year":  bson.M{"$year": "$transactiondate"},
 				"month": bson.M{"$month": "$transactiondate"},
 				"day":   bson.M{"$dayOfMonth": "$transactiondate"},
 			},
-			"daily_spend": bson.M{"$sum": "$amount"},
+			"daily_spend": bson.M{"$sum": analyticsAmount(r)},
 		}},
 		bson.M{"$group": bson.M{
 			"_id": bson.M{
@@ -640,7 +1118,7 @@ func GetUserAverageMonthlySpend(w http.ResponseWriter, r *http.Request){
 			},
 			"average_daily_spend": bson.M{"$avg": "$daily_spend"},
 		}},
-	}
+	)
 
 	prevCursor, err := collection.Aggregate(context.Background(), prevPipeline)
 	if err != nil {
@@ -734,27 +1212,31 @@ func MonthlyWeeklyPattern(w http.ResponseWriter, r *http.Request){
 	}
 
 	// Group by year, month, week extracted from transactiondate, filter by year and month
-	pipeline := bson.A{
-		bson.M{"$match": bson.M{
-			"user_id": userDB.ID,
-			"type":    tp,
-			"$expr": bson.M{
-				"$and": bson.A{
-					bson.M{"$eq": bson.A{bson.M{"$year": "$transactiondate"}, year}},
-					bson.M{"$eq": bson.A{bson.M{"$month": "$transactiondate"}, month}},
-				},
+	stages, err := analyticsStages(r, bson.M{
+		"user_id": userDB.ID,
+		"type":    tp,
+		"$expr": bson.M{
+			"$and": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$year": "$transactiondate"}, year}},
+				bson.M{"$eq": bson.A{bson.M{"$month": "$transactiondate"}, month}},
 			},
-		}},
+		},
+	}, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pipeline := append(stages,
 		bson.M{"$group": bson.M{
 			"_id": bson.M{
 				"year":      bson.M{"$year": "$transactiondate"},
             "month":     bson.M{"$month": "$transactiondate"},
 				"dayOfWeek": bson.M{"$dayOfWeek": "$transactiondate"},
 			},
-			"total_spend": bson.M{"$sum": "$amount"},
+			"total_spend": bson.M{"$sum": analyticsAmount(r)},
 		}},
 		bson.M{"$sort": bson.M{"_id.dayOfWeek": 1}},
-	}
+	)
 
 	cursor, err := collection.Aggregate(context.Background(), pipeline)
 	if err != nil {
@@ -823,11 +1305,15 @@ func GetSpendingTimeAnalysis(w http.ResponseWriter, r *http.Request) {
 
 	// Group by hour of the day
 	// Pipeline to group by hour and get each transaction's amount for scatter plot
-	pipeline := bson.A{
-		bson.M{"$match": bson.M{
-			"user_id": userDB.ID,
-			"type":    tp,
-		}},
+	stages, err := analyticsStages(r, bson.M{
+		"user_id": userDB.ID,
+		"type":    tp,
+	}, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pipeline := append(stages,
 		bson.M{"$addFields": bson.M{
 			"hour24": bson.M{
 				"$let": bson.M{
@@ -878,7 +1364,7 @@ func GetSpendingTimeAnalysis(w http.ResponseWriter, r *http.Request) {
 			"_id":    0,
 		}},
 		bson.M{"$sort": bson.M{"hour": 1}},
-	}
+	)
 
 	cursor, err := collection.Aggregate(context.Background(), pipeline)
 	if err != nil {
@@ -942,22 +1428,26 @@ func GetDebitVsCredit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Group by month and type for the given year
-	pipeline := bson.A{
-		bson.M{"$match": bson.M{
-			"user_id": userDB.ID,
-			"$expr": bson.M{
-				"$eq": bson.A{bson.M{"$year": "$transactiondate"}, year},
-			},
-		}},
+	stages, err := analyticsStages(r, bson.M{
+		"user_id": userDB.ID,
+		"$expr": bson.M{
+			"$eq": bson.A{bson.M{"$year": "$transactiondate"}, year},
+		},
+	}, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pipeline := append(stages,
 		bson.M{"$group": bson.M{
 			"_id": bson.M{
 				"month": bson.M{"$month": "$transactiondate"},
 				"type":  "$type",
 			},
-			"total": bson.M{"$sum": "$amount"},
+			"total": bson.M{"$sum": analyticsAmount(r)},
 		}},
 		bson.M{"$sort": bson.M{"_id.month": 1}},
-	}
+	)
 
 	cursor, err := collection.Aggregate(context.Background(), pipeline)
 	if err != nil {
@@ -1020,4 +1510,108 @@ func GetDebitVsCredit(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
-}
\ No newline at end of file
+}
+
+// groupKey is the $group _id for a breakdown by category, merchant or
+// month.
+func groupKey(groupBy string) (interface{}, bool) {
+	switch groupBy {
+	case "category", "merchant":
+		return bson.M{"$ifNull": bson.A{"$" + groupBy, helpers.UncategorizedCategory}}, true
+	case "month":
+		return bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": "$transactiondate"}}, true
+	}
+	return nil, false
+}
+
+// GetSpendBreakdown totals spend (or income) per category or merchant. With
+// net=true refunds are netted against the category and merchant of the
+// original debit.
+func GetSpendBreakdown(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = "category"
+	}
+	groupID, ok := groupKey(groupBy)
+	if !ok {
+		http.Error(w, "group_by must be category, merchant or month", http.StatusBadRequest)
+		return
+	}
+
+	tp := r.URL.Query().Get("type")
+	if tp == "" {
+		tp = "DEBIT"
+	}
+
+	// The date range and other list filters are added by analyticsMatch
+	match := bson.M{"user_id": userDB.ID, "type": tp}
+
+	stages, err := analyticsStages(r, match, groupBy == "category")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pipeline := append(stages,
+		bson.M{"$group": bson.M{
+			"_id":   groupID,
+			"total": bson.M{"$sum": analyticsAmount(r)},
+			"count": bson.M{"$sum": 1},
+		}},
+		bson.M{"$sort": bson.M{"total": -1}},
+	)
+
+	collection := client.Database("paymentx").Collection("transactions")
+	cursor, err := collection.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	type Breakdown struct {
+		Key   string  `json:"key"`
+		Total float64 `json:"total"`
+		Count int     `json:"count"`
+	}
+
+	results := []Breakdown{}
+	for cursor.Next(context.Background()) {
+		var doc struct {
+			ID    string  `bson:"_id"`
+			Total float64 `bson:"total"`
+			Count int     `bson:"count"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		results = append(results, Breakdown{Key: doc.ID, Total: doc.Total, Count: doc.Count})
+	}
+
+	resp := struct {
+		GroupBy string      `json:"group_by"`
+		Net     bool        `json:"net"`
+		Results []Breakdown `json:"results"`
+	}{
+		GroupBy: groupBy,
+		Net:     r.URL.Query().Get("net") == "true",
+		Results: results,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// refundWindow reads how long after a debit a refund may arrive from
+// ?window_days, falling back to REFUND_WINDOW_DAYS and then 60 days.
+func refundWindow(r *http.Request) (time.Duration, error) {
+	value := os.Getenv("REFUND_WINDOW_DAYS")
+	if r != nil && r.URL.Query().Get("window_days") != "" {
+		value = r.URL.Query().Get("window_days")
+	}
+	if value == "" {
+		return 60 * 24 * time.Hour, nil
+	}
+
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 || days > 365 {
+		return 0, strconv.ErrRange
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// DetectRefunds links refund and reversal credits to their debits over the
+// user's whole history. With dry_run=true nothing is changed.
+func DetectRefunds(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	window, err := refundWindow(r)
+	if err != nil {
+		http.Error(w, "window_days must be between 0 and 365", http.StatusBadRequest)
+		return
+	}
+
+	matches, err := matchUserRefunds(client, bson.M{"user_id": userDB.ID}, window)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var linked, suggested []helpers.RefundMatch
+	for _, match := range matches {
+		if match.Score >= helpers.RefundMatchThreshold {
+			linked = append(linked, match)
+		} else {
+			suggested = append(suggested, match)
+		}
+	}
+
+	if r.URL.Query().Get("dry_run") != "true" {
+		if err := linkRefunds(client, userDB.ID, linked, refundRule); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := struct {
+		Status    string                `json:"status"`
+		Linked    []helpers.RefundMatch `json:"linked"`
+		Suggested []helpers.RefundMatch `json:"suggested"`
+	}{
+		Status:    "success",
+		Linked:    linked,
+		Suggested: suggested,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// LinkRefund manually marks a credit as a (partial) refund of a debit.
+func LinkRefund(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body struct {
+		DebitID  primitive.ObjectID `json:"debit_id"`
+		CreditID primitive.ObjectID `json:"credit_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+
+	var debit, credit models.Transaction
+	if err := collection.FindOne(context.Background(), activeTransactions(bson.M{"_id": body.DebitID, "user_id": userDB.ID})).Decode(&debit); err != nil {
+		http.Error(w, "Debit transaction not found", http.StatusNotFound)
+		return
+	}
+	if err := collection.FindOne(context.Background(), activeTransactions(bson.M{"_id": body.CreditID, "user_id": userDB.ID})).Decode(&credit); err != nil {
+		http.Error(w, "Credit transaction not found", http.StatusNotFound)
+		return
+	}
+
+	if debit.Type != models.Debit || credit.Type != models.Credit {
+		http.Error(w, "debit_id must be a DEBIT and credit_id a CREDIT", http.StatusBadRequest)
+		return
+	}
+	if debit.Locked || credit.Locked {
+		http.Error(w, errStatementLocked.Error(), http.StatusConflict)
+		return
+	}
+	if !credit.RefundOf.IsZero() {
+		http.Error(w, "Credit is already linked as a refund", http.StatusConflict)
+		return
+	}
+	if credit.Amount > debit.Amount-debit.RefundedAmount+0.01 {
+		http.Error(w, "Refund exceeds the amount left on the debit", http.StatusBadRequest)
+		return
+	}
+
+	match := helpers.RefundMatch{
+		CreditID: credit.ID,
+		DebitID:  debit.ID,
+		Amount:   credit.Amount,
+		Partial:  credit.Amount < debit.Amount,
+		Score:    1,
+		Reasons:  []string{"manual"},
+	}
+	if err := linkRefunds(client, userDB.ID, []helpers.RefundMatch{match}, userActor(userDB)); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(match)
+}
+
+// UnlinkRefund removes the refund link of a credit and gives the amount back
+// to its debit.
+func UnlinkRefund(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid transaction id", http.StatusBadRequest)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+
+	var credit models.Transaction
+	if err := collection.FindOne(context.Background(), bson.M{"_id": id, "user_id": userDB.ID}).Decode(&credit); err != nil {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+	if credit.RefundOf.IsZero() {
+		http.Error(w, "Transaction is not a refund", http.StatusBadRequest)
+		return
+	}
+
+	if err := unlinkRefund(client, userDB.ID, credit, userActor(userDB)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status string `json:"status"`
+	}{
+		Status: "success",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func matchUserRefunds(client *mongo.Client, filter bson.M, window time.Duration) ([]helpers.RefundMatch, error) {
+	filter["istransfer"] = bson.M{"$ne": true}
+	filter["locked"] = bson.M{"$ne": true}
+	filter = activeTransactions(filter)
+
+	collection := client.Database("paymentx").Collection("transactions")
+	cursor, err := collection.Find(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var txns []models.Transaction
+	if err := cursor.All(context.Background(), &txns); err != nil {
+		return nil, err
+	}
+
+	var debits, credits []models.Transaction
+	for _, txn := range txns {
+		if txn.Type == models.Debit {
+			debits = append(debits, txn)
+		} else if txn.RefundOf.IsZero() {
+			credits = append(credits, txn)
+		}
+	}
+
+	return helpers.MatchRefunds(debits, credits, window), nil
+}
+
+func linkRefunds(client *mongo.Client, userID primitive.ObjectID, matches []helpers.RefundMatch, actor changeActor) error {
+	collection := client.Database("paymentx").Collection("transactions")
+
+	for _, match := range matches {
+		err := recordHistory(client, userID, actor, models.ChangeUpdate, []primitive.ObjectID{match.CreditID, match.DebitID}, func(ctx context.Context) error {
+			result, err := collection.UpdateOne(ctx,
+				bson.M{"_id": match.CreditID, "user_id": userID, "refund_of": bson.M{"$exists": false}, "locked": bson.M{"$ne": true}},
+				bson.M{"$set": bson.M{"refund_of": match.DebitID}},
+			)
+			if err != nil || result.ModifiedCount == 0 {
+				return err
+			}
+
+			result, err = collection.UpdateOne(ctx,
+				bson.M{"_id": match.DebitID, "user_id": userID, "locked": bson.M{"$ne": true}},
+				bson.M{"$inc": bson.M{"refundedamount": match.Amount}},
+			)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return errStatementLocked
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unlinkRefund(client *mongo.Client, userID primitive.ObjectID, credit models.Transaction, actor changeActor) error {
+	collection := client.Database("paymentx").Collection("transactions")
+
+	return recordHistory(client, userID, actor, models.ChangeUpdate, []primitive.ObjectID{credit.ID, credit.RefundOf}, func(ctx context.Context) error {
+		if _, err := collection.UpdateOne(ctx,
+			bson.M{"_id": credit.ID, "user_id": userID},
+			bson.M{"$unset": bson.M{"refund_of": ""}},
+		); err != nil {
+			return err
+		}
+
+		_, err := collection.UpdateOne(ctx,
+			bson.M{"_id": credit.RefundOf, "user_id": userID},
+			bson.M{"$inc": bson.M{"refundedamount": -credit.Amount}},
+		)
+		return err
+	})
+}
+
+// runRefundMatching links newly inserted credits to earlier debits, and newly
+// inserted debits to credits that arrived out of order in the same upload.
+func runRefundMatching(client *mongo.Client, user models.User, inserted []models.Transaction) error {
+	if len(inserted) == 0 {
+		return nil
+	}
+
+	window, err := refundWindow(nil)
+	if err != nil {
+		return err
+	}
+
+	first, last := inserted[0].TransactionDate.Time(), inserted[0].TransactionDate.Time()
+	for _, txn := range inserted {
+		if t := txn.TransactionDate.Time(); t.Before(first) {
+			first = t
+		} else if t.After(last) {
+			last = t
+		}
+	}
+
+	matches, err := matchUserRefunds(client, bson.M{
+		"user_id": user.ID,
+		"transactiondate": bson.M{
+			"$gte": primitive.NewDateTimeFromTime(first.Add(-window)),
+			"$lte": primitive.NewDateTimeFromTime(last.Add(window)),
+		},
+	}, window)
+	if err != nil {
+		return err
+	}
+
+	var confident []helpers.RefundMatch
+	for _, match := range matches {
+		if match.Score >= helpers.RefundMatchThreshold {
+			confident = append(confident, match)
+		}
+	}
+	return linkRefunds(client, user.ID, confident, refundRule)
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SearchTransactions finds transactions whose merchant, counterparty,
+// narration or notes contain words starting with every term of q, best
+// match first. The list filters of GET /transactions narrow the search
+// further, and it pages like the list, with page or cursor and limit.
+func SearchTransactions(w http.ResponseWriter, r *http.Request) {
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	terms := helpers.SearchTerms(r.URL.Query().Get("q"))
+	if len(terms) == 0 {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+
+	// q is matched below along with the score, so the list query must not
+	// add its own filter for it
+	values := r.URL.Query()
+	values.Del("q")
+	query, err := helpers.ParseTransactionQuery(values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	paginator, err := helpers.NewPaginator(values, bson.D{
+		{Key: "score", Value: -1},
+		{Key: "transactiondate", Value: -1},
+		{Key: "_id", Value: -1},
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	filter := query.Filter
+	filter["user_id"] = userDB.ID
+	for key, value := range helpers.SearchFilter(terms) {
+		if and, ok := filter["$and"].(bson.A); ok && key == "$and" {
+			filter["$and"] = append(and, value.(bson.A)...)
+			continue
+		}
+		filter[key] = value
+	}
+	// A split transaction is found when one of its parts matches
+	matchSplitParts(filter)
+	filter = activeTransactions(filter)
+
+	collection := client.Database("paymentx").Collection("transactions")
+	count, err := collection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pipeline := []bson.M{
+		{"$match": filter},
+		{"$project": bson.M{"searchterms": 0}},
+		{"$addFields": bson.M{"score": helpers.SearchScore(terms)}},
+	}
+	pipeline = append(pipeline, paginator.Stages()...)
+
+	cursor, err := collection.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var docs []bson.Raw
+	if err := cursor.All(context.Background(), &docs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	docs, pageInfo := paginator.Paginate(docs)
+
+	hits := make([]helpers.SearchHit, len(docs))
+	for i, doc := range docs {
+		if err := bson.Unmarshal(doc, &hits[i]); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		hits[i].Highlights = helpers.SearchHighlights(hits[i].Transaction, terms)
+	}
+
+	response := struct {
+		Data  []helpers.SearchHit `json:"data"`
+		Total int                 `json:"total"`
+		Page  int                 `json:"page"`
+		Limit int                 `json:"limit"`
+		helpers.PageInfo
+	}{
+		Data:     hits,
+		Total:    int(count),
+		Page:     int(paginator.Page),
+		Limit:    int(paginator.Limit),
+		PageInfo: pageInfo,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// searchBackfillBatch is how many transactions the search backfill updates
+// at a time.
+const searchBackfillBatch = 500
+
+// BackfillSearchIndex fills the counterparty and search index of
+// transactions stored before search had them, and returns how many it
+// updated.
+func BackfillSearchIndex() (int, error) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		return 0, err
+	}
+	defer client.Disconnect(context.Background())
+
+	collection := client.Database("paymentx").Collection("transactions")
+	updated := 0
+	for {
+		cursor, err := collection.Find(context.Background(),
+			bson.M{"searchterms": bson.M{"$exists": false}},
+			options.Find().SetLimit(searchBackfillBatch).SetProjection(bson.M{"details": 1, "merchant": 1, "counterparty": 1, "notes": 1}),
+		)
+		if err != nil {
+			return updated, err
+		}
+		var txns []models.Transaction
+		err = cursor.All(context.Background(), &txns)
+		cursor.Close(context.Background())
+		if err != nil {
+			return updated, err
+		}
+		if len(txns) == 0 {
+			return updated, nil
+		}
+
+		writes := make([]mongo.WriteModel, len(txns))
+		for i, txn := range txns {
+			if txn.Counterparty == "" {
+				txn.Counterparty = helpers.ExtractCounterparty(txn.Details)
+			}
+			writes[i] = mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": txn.ID}).
+				SetUpdate(bson.M{"$set": bson.M{"counterparty": txn.Counterparty, "searchterms": helpers.SearchTokens(txn)}})
+		}
+		if _, err := collection.BulkWrite(context.Background(), writes); err != nil {
+			return updated, err
+		}
+		updated += len(txns)
+	}
+}
+
+// StartSearchBackfill runs BackfillSearchIndex once in the background.
+func StartSearchBackfill() {
+	go func() {
+		updated, err := BackfillSearchIndex()
+		if err != nil {
+			fmt.Println("Failed to backfill the search index:", err)
+		}
+		if updated > 0 {
+			fmt.Println("Indexed", updated, "transactions for search")
+		}
+	}()
+}
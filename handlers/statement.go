@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errStatementLocked refuses a change to a transaction of a locked
+// statement.
+var errStatementLocked = &requestError{http.StatusConflict, "Transaction belongs to a locked statement"}
+
+func CreateStatement(w http.ResponseWriter, r *http.Request) {
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var statement models.Statement
+	if err := json.NewDecoder(r.Body).Decode(&statement); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if statement.PeriodStart == 0 || statement.PeriodEnd == 0 || statement.PeriodEnd < statement.PeriodStart {
+		http.Error(w, "A valid period_start and period_end are required", http.StatusBadRequest)
+		return
+	}
+
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	if !statement.AccountID.IsZero() {
+		accounts, err := userAccounts(client, userDB.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, ok := accounts[statement.AccountID]; !ok {
+			http.Error(w, "Account not found", http.StatusBadRequest)
+			return
+		}
+	}
+
+	statement.ID = primitive.NewObjectID()
+	statement.UserID = userDB.ID
+	statement.Derived = false
+	statement.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	statement, err = reconcileAndSaveStatement(client, statement)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(statement)
+}
+
+func GetStatements(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	filter := bson.M{"user_id": userDB.ID}
+	if accountID := r.URL.Query().Get("account_id"); accountID != "" {
+		id, err := primitive.ObjectIDFromHex(accountID)
+		if err != nil {
+			http.Error(w, "Invalid account id", http.StatusBadRequest)
+			return
+		}
+		filter["account_id"] = id
+	}
+
+	collection := client.Database("paymentx").Collection("statements")
+	cursor, err := collection.Find(context.Background(), filter, options.Find().SetSort(bson.M{"periodstart": -1}))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	statements := []models.Statement{}
+	if err := cursor.All(context.Background(), &statements); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statements)
+}
+
+// ReconcileStatementAgain re-runs reconciliation, for example after missing
+// rows were uploaded.
+func ReconcileStatementAgain(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	statement, err := findStatement(client, userDB.ID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Statement not found", http.StatusNotFound)
+		return
+	}
+
+	statement, err = reconcileAndSaveStatement(client, statement)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statement)
+}
+
+// UnlockStatement allows the transactions of a reconciled statement to be
+// edited again.
+func UnlockStatement(w http.ResponseWriter, r *http.Request) {
+	setStatementLock(w, r, false)
+}
+
+func LockStatement(w http.ResponseWriter, r *http.Request) {
+	setStatementLock(w, r, true)
+}
+
+func setStatementLock(w http.ResponseWriter, r *http.Request, locked bool) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	statement, err := findStatement(client, userDB.ID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Statement not found", http.StatusNotFound)
+		return
+	}
+
+	if locked && statement.Status != models.StatementReconciled {
+		http.Error(w, "Only reconciled statements can be locked", http.StatusConflict)
+		return
+	}
+
+	db := client.Database("paymentx")
+	if _, err := db.Collection("statements").UpdateOne(context.Background(),
+		bson.M{"_id": statement.ID},
+		bson.M{"$set": bson.M{"locked": locked}},
+	); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := db.Collection("transactions").UpdateMany(context.Background(),
+		bson.M{"user_id": userDB.ID, "statement_id": statement.ID},
+		bson.M{"$set": bson.M{"locked": locked}},
+	); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	statement.Locked = locked
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statement)
+}
+
+func findStatement(client *mongo.Client, userID primitive.ObjectID, hexID string) (models.Statement, error) {
+	var statement models.Statement
+
+	id, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return statement, err
+	}
+
+	collection := client.Database("paymentx").Collection("statements")
+	err = collection.FindOne(context.Background(), bson.M{"_id": id, "user_id": userID}).Decode(&statement)
+	return statement, err
+}
+
+// statementFilter matches the transactions of the statement's account inside
+// its period. Provisional alerts the statement did not bring in again are
+// not part of it.
+func statementFilter(statement models.Statement) bson.M {
+	end := statement.PeriodEnd.Time().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+
+	filter := bson.M{
+		"user_id": statement.UserID,
+		"transactiondate": bson.M{
+			"$gte": statement.PeriodStart,
+			"$lt":  primitive.NewDateTimeFromTime(end),
+		},
+		"provisional": bson.M{"$ne": true},
+	}
+	if statement.AccountID.IsZero() {
+		filter["account_id"] = bson.M{"$exists": false}
+	} else {
+		filter["account_id"] = statement.AccountID
+	}
+	return filter
+}
+
+// reconcileAndSaveStatement reconciles the statement against the stored
+// transactions of its period and saves it. A reconciled statement locks its
+// transactions; one that no longer reconciles releases them.
+func reconcileAndSaveStatement(client *mongo.Client, statement models.Statement) (models.Statement, error) {
+	db := client.Database("paymentx")
+	filter := activeTransactions(statementFilter(statement))
+
+	cursor, err := db.Collection("transactions").Find(context.Background(), filter)
+	if err != nil {
+		return statement, err
+	}
+	var txns []models.Transaction
+	if err := cursor.All(context.Background(), &txns); err != nil {
+		return statement, err
+	}
+	helpers.SortForBalance(txns)
+
+	var account models.Account
+	if !statement.AccountID.IsZero() {
+		err := db.Collection("accounts").FindOne(context.Background(), bson.M{"_id": statement.AccountID, "user_id": statement.UserID}).Decode(&account)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return statement, err
+		}
+	}
+
+	statement = helpers.ReconcileStatement(statement, txns, account.Type == models.CreditCardAccount)
+	statement.Locked = statement.Status == models.StatementReconciled
+	if statement.Locked {
+		statement.ReconciledAt = primitive.NewDateTimeFromTime(time.Now())
+	}
+
+	if _, err := db.Collection("statements").ReplaceOne(context.Background(),
+		bson.M{"_id": statement.ID},
+		statement,
+		options.Replace().SetUpsert(true),
+	); err != nil {
+		return statement, err
+	}
+
+	if statement.Locked {
+		_, err = db.Collection("transactions").UpdateMany(context.Background(), filter,
+			bson.M{"$set": bson.M{"statement_id": statement.ID, "locked": true}},
+		)
+	} else {
+		_, err = db.Collection("transactions").UpdateMany(context.Background(),
+			bson.M{"user_id": statement.UserID, "statement_id": statement.ID},
+			bson.M{"$set": bson.M{"locked": false}},
+		)
+	}
+
+	return statement, err
+}
+
+// reconcileUpload reconciles the statement sent along with an upload, one
+// account at a time. The balances sent belong to statement.AccountID, or to
+// the only account of the upload; the other accounts of a mixed upload get
+// statements derived from their own rows over the same period.
+func reconcileUpload(client *mongo.Client, userID primitive.ObjectID, statement models.Statement, uploaded []models.Transaction) ([]*models.Statement, error) {
+	var order []primitive.ObjectID
+	byAccount := make(map[primitive.ObjectID][]models.Transaction)
+	for _, txn := range uploaded {
+		if _, ok := byAccount[txn.AccountID]; !ok {
+			order = append(order, txn.AccountID)
+		}
+		byAccount[txn.AccountID] = append(byAccount[txn.AccountID], txn)
+	}
+
+	target := statement.AccountID
+	if target.IsZero() && len(order) == 1 {
+		target = order[0]
+	}
+
+	var reconciled []*models.Statement
+	for _, accountID := range order {
+		accountStatement := statement
+		if accountID != target {
+			accountStatement = models.Statement{PeriodStart: statement.PeriodStart, PeriodEnd: statement.PeriodEnd}
+		}
+		accountStatement.AccountID = accountID
+
+		saved, err := reconcileAccountUpload(client, userID, accountStatement, byAccount[accountID])
+		if err != nil {
+			return reconciled, err
+		}
+		if saved != nil {
+			reconciled = append(reconciled, saved)
+		}
+	}
+	return reconciled, nil
+}
+
+// reconcileAccountUpload reconciles the statement of the uploaded
+// transactions of one account. Missing balances and period are read off the
+// transactions themselves.
+func reconcileAccountUpload(client *mongo.Client, userID primitive.ObjectID, statement models.Statement, uploaded []models.Transaction) (*models.Statement, error) {
+	if len(uploaded) == 0 {
+		return nil, nil
+	}
+
+	sorted := append([]models.Transaction(nil), uploaded...)
+	helpers.SortForBalance(sorted)
+
+	derived, ok := helpers.DeriveStatement(sorted)
+	if statement.PeriodStart == 0 {
+		statement.PeriodStart = sorted[0].TransactionDate
+	}
+	if statement.PeriodEnd == 0 {
+		statement.PeriodEnd = sorted[len(sorted)-1].TransactionDate
+	}
+	if statement.OpeningBalance == 0 && statement.ClosingBalance == 0 {
+		if !ok {
+			return nil, nil
+		}
+		statement.OpeningBalance = derived.OpeningBalance
+		statement.ClosingBalance = derived.ClosingBalance
+		statement.Derived = true
+	}
+
+	statement.ID = primitive.NewObjectID()
+	statement.UserID = userID
+	statement.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	statement, err := reconcileAndSaveStatement(client, statement)
+	if err != nil {
+		return nil, err
+	}
+	return &statement, nil
+}
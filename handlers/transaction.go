@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// transactionPatch holds the editable fields of a transaction. Nil fields are
+// left unchanged.
+type transactionPatch struct {
+	Amount          *float64                `json:"amount"`
+	TransactionDate *primitive.DateTime     `json:"transaction_date"`
+	TransactionTime *string                 `json:"transaction_time"`
+	ValueDate       *string                 `json:"value_date"`
+	Details         *string                 `json:"details"`
+	Type            *models.TransactionType `json:"type"`
+	Balance         *float64                `json:"balance"`
+	Merchant        *string                 `json:"merchant"`
+	Category        *string                 `json:"category"`
+	AccountID       *primitive.ObjectID     `json:"account_id"`
+	Notes           *string                 `json:"notes"`
+	Tags            *[]string               `json:"tags"`
+	Excluded        *bool                   `json:"excluded"`
+	// Splits replaces the parts of the transaction; an empty list unsplits it
+	Splits *[]models.TransactionSplit `json:"splits"`
+}
+
+func validTransactionType(t models.TransactionType) bool {
+	return t == models.Debit || t == models.Credit
+}
+
+// findUserTransaction loads a transaction by its hex id, scoped to the user.
+// Transactions in the trash are not found.
+func findUserTransaction(client *mongo.Client, userID primitive.ObjectID, hexID string) (models.Transaction, error) {
+	var txn models.Transaction
+
+	id, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return txn, err
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+	err = collection.FindOne(context.Background(), bson.M{"_id": id, "user_id": userID, "deleted_at": bson.M{"$exists": false}}).Decode(&txn)
+	return txn, err
+}
+
+func GetTransaction(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	txn, err := findUserTransaction(client, userDB.ID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(txn)
+}
+
+// CreateTransaction adds a single, manually entered transaction such as a
+// cash expense.
+func CreateTransaction(w http.ResponseWriter, r *http.Request) {
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var txn models.Transaction
+	if err := json.NewDecoder(r.Body).Decode(&txn); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if txn.Amount <= 0 {
+		http.Error(w, "amount must be positive", http.StatusBadRequest)
+		return
+	}
+	if !validTransactionType(txn.Type) {
+		http.Error(w, "type must be DEBIT or CREDIT", http.StatusBadRequest)
+		return
+	}
+	if txn.TransactionDate == 0 {
+		http.Error(w, "transaction_date is required", http.StatusBadRequest)
+		return
+	}
+	if txn.Splits, err = helpers.NormalizeSplits(txn.Amount, txn.Splits); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	if !txn.AccountID.IsZero() {
+		accounts, err := userAccounts(client, userDB.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, ok := accounts[txn.AccountID]; !ok {
+			http.Error(w, "Account not found", http.StatusBadRequest)
+			return
+		}
+	}
+
+	txn.ID = primitive.NewObjectID()
+	txn.UserID = userDB.ID
+	if txn.Source == "" {
+		txn.Source = "manual"
+	}
+	// Fields owned by the server are never taken from the body
+	txn.StatementID = primitive.NilObjectID
+	txn.Locked = false
+	txn.IsTransfer = false
+	txn.TransferPairID = primitive.NilObjectID
+	txn.DismissedTransfers = nil
+	txn.RefundOf = primitive.NilObjectID
+	txn.RefundedAmount = 0
+	txn.MergedInto = primitive.NilObjectID
+	txn.MergedFrom = nil
+	txn.BatchID = primitive.NilObjectID
+	txn.DeletedAt = 0
+	prepareTransaction(&txn)
+
+	collection := client.Database("paymentx").Collection("transactions")
+	if _, err := collection.InsertOne(context.Background(), txn); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			http.Error(w, "An identical transaction already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := recordCreated(client, userDB.ID, userActor(userDB), []models.Transaction{txn}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	processInserted(client, userDB, []models.Transaction{txn})
+
+	// Reload so the response reflects links made by the post-insert stages
+	if err := collection.FindOne(context.Background(), bson.M{"_id": txn.ID}).Decode(&txn); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(txn)
+}
+
+// UpdateTransaction edits a transaction and recomputes the fields that
+// depend on what changed: the dedup hash, merchant and category, and the
+// transfer and refund links.
+func UpdateTransaction(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	txn, err := findUserTransaction(client, userDB.ID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+	if txn.Locked {
+		http.Error(w, "Transaction belongs to a locked statement", http.StatusConflict)
+		return
+	}
+	if !txn.MergedInto.IsZero() {
+		http.Error(w, "Transaction is merged into another one", http.StatusConflict)
+		return
+	}
+
+	var patch transactionPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	original := txn
+
+	if patch.Amount != nil {
+		if *patch.Amount <= 0 {
+			http.Error(w, "amount must be positive", http.StatusBadRequest)
+			return
+		}
+		txn.Amount = *patch.Amount
+	}
+	if patch.Type != nil {
+		if !validTransactionType(*patch.Type) {
+			http.Error(w, "type must be DEBIT or CREDIT", http.StatusBadRequest)
+			return
+		}
+		txn.Type = *patch.Type
+	}
+	if patch.AccountID != nil {
+		if !patch.AccountID.IsZero() {
+			accounts, err := userAccounts(client, userDB.ID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if _, ok := accounts[*patch.AccountID]; !ok {
+				http.Error(w, "Account not found", http.StatusBadRequest)
+				return
+			}
+		}
+		txn.AccountID = *patch.AccountID
+	}
+	if patch.TransactionDate != nil {
+		txn.TransactionDate = *patch.TransactionDate
+	}
+	if patch.TransactionTime != nil {
+		txn.TransactionTime = *patch.TransactionTime
+	}
+	if patch.ValueDate != nil {
+		txn.ValueDate = *patch.ValueDate
+	}
+	if patch.Balance != nil {
+		txn.Balance = patch.Balance
+	}
+	if patch.Details != nil {
+		txn.Details = *patch.Details
+		// Derived from the old narration, so work them out again
+		txn.Merchant = ""
+		txn.Counterparty = ""
+		txn.Category = ""
+	}
+	if patch.Merchant != nil {
+		txn.Merchant = strings.TrimSpace(*patch.Merchant)
+	}
+	if patch.Category != nil {
+		txn.Category = strings.TrimSpace(*patch.Category)
+	}
+	if patch.Notes != nil {
+		txn.Notes = *patch.Notes
+	}
+	if patch.Tags != nil {
+		txn.Tags = *patch.Tags
+	}
+	if patch.Excluded != nil {
+		txn.Excluded = *patch.Excluded
+	}
+	if patch.Splits != nil {
+		txn.Splits = *patch.Splits
+	}
+	// Checked against the new amount too, so the parts keep adding up
+	if txn.Splits, err = helpers.NormalizeSplits(txn.Amount, txn.Splits); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	txn, err = saveTransactionEdit(client, userDB, original, txn, userActor(userDB), models.ChangeUpdate)
+	if mongo.IsDuplicateKeyError(err) {
+		http.Error(w, "An identical transaction already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(txn)
+}
+
+// saveTransactionEdit stores txn over original, recomputing the dedup hash,
+// merchant and category, and redoing the transfer and refund links when the
+// fields they were made from changed.
+func saveTransactionEdit(client *mongo.Client, user models.User, original, txn models.Transaction, actor changeActor, action models.ChangeAction) (models.Transaction, error) {
+	prepareTransaction(&txn)
+
+	// Links were made for the old amount, date, type and account
+	relink := txn.Amount != original.Amount || txn.Type != original.Type ||
+		txn.TransactionDate != original.TransactionDate || txn.AccountID != original.AccountID
+
+	if relink {
+		if err := releaseLinks(client, user.ID, original, actor); err != nil {
+			return txn, err
+		}
+		txn.IsTransfer = false
+		txn.TransferPairID = primitive.NilObjectID
+		txn.RefundOf = primitive.NilObjectID
+		txn.RefundedAmount = 0
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+	err := recordHistory(client, user.ID, actor, action, []primitive.ObjectID{txn.ID}, func(ctx context.Context) error {
+		_, err := collection.ReplaceOne(ctx, bson.M{"_id": txn.ID, "user_id": user.ID}, txn)
+		return err
+	})
+	if err != nil {
+		return txn, err
+	}
+
+	if relink {
+		if err := runTransferDetection(client, user, []models.Transaction{txn}); err != nil {
+			return txn, err
+		}
+		if err := runRefundMatching(client, user, []models.Transaction{txn}); err != nil {
+			return txn, err
+		}
+		if err := collection.FindOne(context.Background(), bson.M{"_id": txn.ID}).Decode(&txn); err != nil {
+			return txn, err
+		}
+	}
+
+	return txn, nil
+}
+
+// DeleteTransaction moves a transaction to the trash, from where it can be
+// restored until the retention window runs out.
+func DeleteTransaction(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	txn, err := findUserTransaction(client, userDB.ID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+	if txn.Locked {
+		http.Error(w, "Transaction belongs to a locked statement", http.StatusConflict)
+		return
+	}
+
+	if !txn.MergedInto.IsZero() {
+		http.Error(w, "Transaction is merged into another one", http.StatusConflict)
+		return
+	}
+
+	// Duplicates merged into it stay merged and are purged with it
+	trashed, err := trashTransactions(client, userDB.ID, bson.M{"_id": txn.ID}, userActor(userDB))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(trashed) == 0 {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+
+	response := struct {
+		Status string `json:"status"`
+	}{
+		Status: "success",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// releaseLinks clears the transfer pairing of txn and every refund link it
+// takes part in, as the refunding credit or as the refunded debit.
+func releaseLinks(client *mongo.Client, userID primitive.ObjectID, txn models.Transaction, actor changeActor) error {
+	if txn.IsTransfer {
+		if err := unlinkTransfer(client, userID, txn, false, actor); err != nil {
+			return err
+		}
+	}
+
+	if !txn.RefundOf.IsZero() {
+		if err := unlinkRefund(client, userID, txn, actor); err != nil {
+			return err
+		}
+	}
+
+	if txn.RefundedAmount == 0 {
+		return nil
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+	cursor, err := collection.Find(context.Background(), bson.M{"user_id": userID, "refund_of": txn.ID})
+	if err != nil {
+		return err
+	}
+
+	var credits []models.Transaction
+	if err := cursor.All(context.Background(), &credits); err != nil {
+		return err
+	}
+
+	ids := make([]primitive.ObjectID, len(credits))
+	for i, credit := range credits {
+		ids[i] = credit.ID
+	}
+
+	return recordHistory(client, userID, actor, models.ChangeUpdate, ids, func(ctx context.Context) error {
+		_, err := collection.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": ids}, "user_id": userID},
+			bson.M{"$unset": bson.M{"refund_of": ""}},
+		)
+		return err
+	})
+}
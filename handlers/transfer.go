@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// transferWindow reads the matching window from ?window_days, falling back
+// to TRANSFER_WINDOW_DAYS and then 3 days.
+func transferWindow(r *http.Request) (time.Duration, error) {
+	value := os.Getenv("TRANSFER_WINDOW_DAYS")
+	if r != nil && r.URL.Query().Get("window_days") != "" {
+		value = r.URL.Query().Get("window_days")
+	}
+	if value == "" {
+		return 3 * 24 * time.Hour, nil
+	}
+
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 || days > 30 {
+		return 0, strconv.ErrRange
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// DetectTransfers runs the transfer matcher over the user's history and marks
+// the confident pairs. With dry_run=true nothing is changed.
+func DetectTransfers(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	window, err := transferWindow(r)
+	if err != nil {
+		http.Error(w, "window_days must be between 0 and 30", http.StatusBadRequest)
+		return
+	}
+
+	matches, err := matchUserTransfers(client, userDB.ID, bson.M{"user_id": userDB.ID}, window)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var linked, suggested []helpers.TransferMatch
+	for _, match := range matches {
+		if match.AutoLink {
+			linked = append(linked, match)
+		} else {
+			suggested = append(suggested, match)
+		}
+	}
+
+	if r.URL.Query().Get("dry_run") != "true" {
+		if err := linkTransfers(client, userDB.ID, linked, transferRule); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := struct {
+		Status    string                  `json:"status"`
+		Linked    []helpers.TransferMatch `json:"linked"`
+		Suggested []helpers.TransferMatch `json:"suggested"`
+	}{
+		Status:    "success",
+		Linked:    linked,
+		Suggested: suggested,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// LinkTransfer manually pairs a debit and a credit as an internal transfer.
+func LinkTransfer(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body struct {
+		DebitID  primitive.ObjectID `json:"debit_id"`
+		CreditID primitive.ObjectID `json:"credit_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+
+	var debit, credit models.Transaction
+	if err := collection.FindOne(context.Background(), activeTransactions(bson.M{"_id": body.DebitID, "user_id": userDB.ID})).Decode(&debit); err != nil {
+		http.Error(w, "Debit transaction not found", http.StatusNotFound)
+		return
+	}
+	if err := collection.FindOne(context.Background(), activeTransactions(bson.M{"_id": body.CreditID, "user_id": userDB.ID})).Decode(&credit); err != nil {
+		http.Error(w, "Credit transaction not found", http.StatusNotFound)
+		return
+	}
+
+	if debit.Type != models.Debit || credit.Type != models.Credit {
+		http.Error(w, "debit_id must be a DEBIT and credit_id a CREDIT", http.StatusBadRequest)
+		return
+	}
+	if debit.Locked || credit.Locked {
+		http.Error(w, errStatementLocked.Error(), http.StatusConflict)
+		return
+	}
+	if debit.IsTransfer || credit.IsTransfer {
+		http.Error(w, "Transaction is already linked as a transfer", http.StatusConflict)
+		return
+	}
+
+	match := helpers.TransferMatch{DebitID: debit.ID, CreditID: credit.ID, Amount: debit.Amount, Score: 1, Reasons: []string{"manual"}}
+	if err := linkTransfers(client, userDB.ID, []helpers.TransferMatch{match}, userActor(userDB)); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(match)
+}
+
+// UnlinkTransfer removes the transfer link of a transaction and its pair and
+// stops the matcher from pairing them again.
+func UnlinkTransfer(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid transaction id", http.StatusBadRequest)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+
+	var txn models.Transaction
+	if err := collection.FindOne(context.Background(), bson.M{"_id": id, "user_id": userDB.ID}).Decode(&txn); err != nil {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+	if !txn.IsTransfer {
+		http.Error(w, "Transaction is not a transfer", http.StatusBadRequest)
+		return
+	}
+
+	if err := unlinkTransfer(client, userDB.ID, txn, true, userActor(userDB)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status string `json:"status"`
+	}{
+		Status: "success",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func matchUserTransfers(client *mongo.Client, userID primitive.ObjectID, filter bson.M, window time.Duration) ([]helpers.TransferMatch, error) {
+	accountsByID, err := userAccounts(client, userID)
+	if err != nil {
+		return nil, err
+	}
+	var accounts []models.Account
+	for _, account := range accountsByID {
+		accounts = append(accounts, account)
+	}
+
+	filter["istransfer"] = bson.M{"$ne": true}
+	filter["locked"] = bson.M{"$ne": true}
+	filter = activeTransactions(filter)
+
+	collection := client.Database("paymentx").Collection("transactions")
+	cursor, err := collection.Find(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var txns []models.Transaction
+	if err := cursor.All(context.Background(), &txns); err != nil {
+		return nil, err
+	}
+
+	return helpers.MatchTransfers(txns, accounts, window), nil
+}
+
+func linkTransfers(client *mongo.Client, userID primitive.ObjectID, matches []helpers.TransferMatch, actor changeActor) error {
+	collection := client.Database("paymentx").Collection("transactions")
+
+	for _, match := range matches {
+		err := recordHistory(client, userID, actor, models.ChangeUpdate, []primitive.ObjectID{match.DebitID, match.CreditID}, func(ctx context.Context) error {
+			return linkTransferPair(ctx, collection, userID, match.DebitID, match.CreditID)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkTransferPair points a debit and a credit at each other as the two
+// sides of a transfer.
+func linkTransferPair(ctx context.Context, collection *mongo.Collection, userID, debitID, creditID primitive.ObjectID) error {
+	for _, pair := range [][2]primitive.ObjectID{{debitID, creditID}, {creditID, debitID}} {
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": pair[0], "user_id": userID, "locked": bson.M{"$ne": true}},
+			bson.M{"$set": bson.M{"istransfer": true, "transfer_pair_id": pair[1]}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errStatementLocked
+		}
+	}
+	return nil
+}
+
+// unlinkTransfer clears the transfer link on txn and its pair. dismiss keeps
+// the matcher from pairing the two again; either may still be paired with
+// another transaction.
+func unlinkTransfer(client *mongo.Client, userID primitive.ObjectID, txn models.Transaction, dismiss bool, actor changeActor) error {
+	ids := []primitive.ObjectID{txn.ID}
+	if !txn.TransferPairID.IsZero() {
+		ids = append(ids, txn.TransferPairID)
+	}
+
+	collection := client.Database("paymentx").Collection("transactions")
+	return recordHistory(client, userID, actor, models.ChangeUpdate, ids, func(ctx context.Context) error {
+		for i, id := range ids {
+			update := bson.M{
+				"$set":   bson.M{"istransfer": false},
+				"$unset": bson.M{"transfer_pair_id": ""},
+			}
+			if dismiss && len(ids) == 2 {
+				update["$addToSet"] = bson.M{"dismissed_transfers": ids[1-i]}
+			}
+			if _, err := collection.UpdateOne(ctx, bson.M{"_id": id, "user_id": userID}, update); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// runTransferDetection pairs newly inserted transactions with transfers
+// already stored around the same dates.
+func runTransferDetection(client *mongo.Client, user models.User, inserted []models.Transaction) error {
+	if len(inserted) == 0 {
+		return nil
+	}
+
+	window, err := transferWindow(nil)
+	if err != nil {
+		return err
+	}
+
+	first, last := inserted[0].TransactionDate.Time(), inserted[0].TransactionDate.Time()
+	for _, txn := range inserted {
+		if t := txn.TransactionDate.Time(); t.Before(first) {
+			first = t
+		} else if t.After(last) {
+			last = t
+		}
+	}
+
+	matches, err := matchUserTransfers(client, user.ID, bson.M{
+		"user_id": user.ID,
+		"transactiondate": bson.M{
+			"$gte": primitive.NewDateTimeFromTime(first.Add(-window)),
+			"$lte": primitive.NewDateTimeFromTime(last.Add(window)),
+		},
+	}, window)
+	if err != nil {
+		return err
+	}
+
+	var confident []helpers.TransferMatch
+	for _, match := range matches {
+		if match.AutoLink {
+			confident = append(confident, match)
+		}
+	}
+	return linkTransfers(client, user.ID, confident, transferRule)
+}
@@ -0,0 +1,517 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultTrashRetentionDays = 30
+	undoWindow                = 10 * time.Minute
+	// trashedHashMarker joins the dedup hash of a trashed transaction to its
+	// id, which frees the hash for the same row uploaded again.
+	trashedHashMarker = "#deleted-"
+)
+
+// trashRetention is how long deleted transactions can be restored, set with
+// TRASH_RETENTION_DAYS.
+func trashRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		days = defaultTrashRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// DeleteTransactions moves the given transactions to the trash and returns an
+// undo token. Locked transactions are skipped.
+func DeleteTransactions(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body struct {
+		IDs []primitive.ObjectID `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body.IDs) == 0 {
+		http.Error(w, "ids is required", http.StatusBadRequest)
+		return
+	}
+
+	writeTrashResult(w, client, userDB, "delete", bson.M{"_id": bson.M{"$in": body.IDs}})
+}
+
+// RollbackUpload moves every transaction stored by one upload to the trash.
+func RollbackUpload(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	batchID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid upload id", http.StatusBadRequest)
+		return
+	}
+
+	writeTrashResult(w, client, userDB, "rollback", bson.M{"batch_id": batchID})
+}
+
+func writeTrashResult(w http.ResponseWriter, client *mongo.Client, user models.User, action string, filter bson.M) {
+	trashed, err := trashTransactions(client, user.ID, filter, userActor(user))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(trashed) == 0 {
+		http.Error(w, "No transactions to delete", http.StatusNotFound)
+		return
+	}
+
+	undo, err := issueUndoToken(client, user.ID, action, trashed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status  string            `json:"status"`
+		Deleted int               `json:"deleted"`
+		Undo    *models.UndoToken `json:"undo"`
+	}{
+		Status:  "success",
+		Deleted: len(trashed),
+		Undo:    undo,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func GetTrash(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	paginator, err := helpers.NewPaginator(r.URL.Query(), bson.D{{Key: "deleted_at", Value: -1}, {Key: "_id", Value: -1}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter := bson.M{"user_id": userDB.ID, "deleted_at": bson.M{"$exists": true}}
+
+	collection := client.Database("paymentx").Collection("transactions")
+	cursor, err := collection.Find(context.Background(), paginator.Filter(filter), paginator.FindOptions())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var docs []bson.Raw
+	if err := cursor.All(context.Background(), &docs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	docs, pageInfo := paginator.Paginate(docs)
+
+	transactions := make([]models.Transaction, len(docs))
+	for i, doc := range docs {
+		if err := bson.Unmarshal(doc, &transactions[i]); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := struct {
+		Status        string               `json:"status"`
+		RetentionDays int                  `json:"retention_days"`
+		Transactions  []models.Transaction `json:"transactions"`
+		helpers.PageInfo
+	}{
+		Status:        "success",
+		RetentionDays: int(trashRetention().Hours() / 24),
+		Transactions:  transactions,
+		PageInfo:      pageInfo,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func RestoreTransaction(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid transaction id", http.StatusBadRequest)
+		return
+	}
+
+	restored, conflicts, err := restoreTransactions(client, userDB, []primitive.ObjectID{id}, userActor(userDB))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(conflicts) > 0 {
+		http.Error(w, "The transaction was uploaded again since it was deleted", http.StatusConflict)
+		return
+	}
+	if restored == 0 {
+		http.Error(w, "Transaction not found in trash or past the retention window", http.StatusNotFound)
+		return
+	}
+
+	response := struct {
+		Status   string `json:"status"`
+		Restored int    `json:"restored"`
+	}{
+		Status:   "success",
+		Restored: restored,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Undo reverses the action an undo token was issued for, as long as the
+// token has not expired.
+func Undo(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The TTL monitor only runs once a minute, so expiry is checked here too
+	var undo models.UndoToken
+	collection := client.Database("paymentx").Collection("undo_tokens")
+	err = collection.FindOneAndDelete(context.Background(), bson.M{
+		"token":     body.Token,
+		"user_id":   userDB.ID,
+		"expiresat": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+	}).Decode(&undo)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Undo token not found or expired", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	restored, conflicts, err := restoreTransactions(client, userDB, undo.TransactionIDs, userActor(userDB))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status   string `json:"status"`
+		Action   string `json:"action"`
+		Restored int    `json:"restored"`
+		// Conflicts were uploaded again since they were deleted, so they
+		// stay in the trash
+		Conflicts []primitive.ObjectID `json:"conflicts,omitempty"`
+	}{
+		Status:    "success",
+		Action:    undo.Action,
+		Restored:  restored,
+		Conflicts: conflicts,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// trashTransactions moves the user's live, unlocked transactions matching
+// filter to the trash, releasing their transfer and refund links, and
+// returns their ids.
+func trashTransactions(client *mongo.Client, userID primitive.ObjectID, filter bson.M, actor changeActor) ([]primitive.ObjectID, error) {
+	filter["user_id"] = userID
+	filter["locked"] = bson.M{"$ne": true}
+
+	collection := client.Database("paymentx").Collection("transactions")
+	cursor, err := collection.Find(context.Background(), activeTransactions(filter))
+	if err != nil {
+		return nil, err
+	}
+
+	var txns []models.Transaction
+	if err := cursor.All(context.Background(), &txns); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(txns))
+	for _, txn := range txns {
+		if err := releaseLinks(client, userID, txn, actor); err != nil {
+			return nil, err
+		}
+		ids = append(ids, txn.ID)
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	err = recordHistory(client, userID, actor, models.ChangeDelete, ids, func(ctx context.Context) error {
+		_, err := collection.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": ids}, "user_id": userID},
+			bson.A{bson.M{"$set": bson.M{
+				"deleted_at":    primitive.NewDateTimeFromTime(time.Now()),
+				"transactionid": bson.M{"$concat": bson.A{"$transactionid", trashedHashMarker, bson.M{"$toString": "$_id"}}},
+			}}},
+		)
+		return err
+	})
+	return ids, err
+}
+
+// restoreTransactions takes the given transactions out of the trash, unless
+// they have been there longer than the retention window, and runs transfer
+// and refund matching on them again. A transaction uploaded again since it
+// was trashed stays in the trash, as restoring it would store it twice; the
+// ids of those are returned as conflicts.
+func restoreTransactions(client *mongo.Client, user models.User, ids []primitive.ObjectID, actor changeActor) (int, []primitive.ObjectID, error) {
+	collection := client.Database("paymentx").Collection("transactions")
+	cutoff := primitive.NewDateTimeFromTime(time.Now().Add(-trashRetention()))
+	filter := bson.M{"_id": bson.M{"$in": ids}, "user_id": user.ID, "deleted_at": bson.M{"$gte": cutoff}}
+
+	cursor, err := collection.Find(context.Background(), filter)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var trashed []models.Transaction
+	if err := cursor.All(context.Background(), &trashed); err != nil {
+		return 0, nil, err
+	}
+	if len(trashed) == 0 {
+		return 0, nil, nil
+	}
+
+	hashes := make(bson.A, len(trashed))
+	for i := range trashed {
+		trashed[i].TransactionID = strings.SplitN(trashed[i].TransactionID, trashedHashMarker, 2)[0]
+		hashes[i] = trashed[i].TransactionID
+	}
+	cursor, err = collection.Find(context.Background(),
+		bson.M{"transactionid": bson.M{"$in": hashes}},
+		options.Find().SetProjection(bson.M{"transactionid": 1}),
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+	var live []models.Transaction
+	if err := cursor.All(context.Background(), &live); err != nil {
+		return 0, nil, err
+	}
+	taken := make(map[string]bool, len(live))
+	for _, txn := range live {
+		taken[txn.TransactionID] = true
+	}
+
+	var txns []models.Transaction
+	var restored, conflicts []primitive.ObjectID
+	for _, txn := range trashed {
+		if taken[txn.TransactionID] {
+			conflicts = append(conflicts, txn.ID)
+			continue
+		}
+		txns = append(txns, txn)
+		restored = append(restored, txn.ID)
+	}
+	if len(restored) == 0 {
+		return 0, conflicts, nil
+	}
+
+	filter["_id"] = bson.M{"$in": restored}
+	err = recordHistory(client, user.ID, actor, models.ChangeRestore, restored, func(ctx context.Context) error {
+		_, err := collection.UpdateMany(ctx, filter, bson.A{
+			bson.M{"$set": bson.M{"transactionid": bson.M{"$arrayElemAt": bson.A{
+				bson.M{"$split": bson.A{"$transactionid", trashedHashMarker}}, 0,
+			}}}},
+			bson.M{"$unset": "deleted_at"},
+		})
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := runTransferDetection(client, user, txns); err != nil {
+		fmt.Println("Failed to detect transfers:", err)
+	}
+	if err := runRefundMatching(client, user, txns); err != nil {
+		fmt.Println("Failed to match refunds:", err)
+	}
+
+	return len(txns), conflicts, nil
+}
+
+func issueUndoToken(client *mongo.Client, userID primitive.ObjectID, action string, ids []primitive.ObjectID) (*models.UndoToken, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	undo := models.UndoToken{
+		ID:             primitive.NewObjectID(),
+		UserID:         userID,
+		Token:          hex.EncodeToString(random),
+		Action:         action,
+		TransactionIDs: ids,
+		CreatedAt:      primitive.NewDateTimeFromTime(now),
+		ExpiresAt:      primitive.NewDateTimeFromTime(now.Add(undoWindow)),
+	}
+
+	collection := client.Database("paymentx").Collection("undo_tokens")
+	if _, err := collection.InsertOne(context.Background(), undo); err != nil {
+		return nil, err
+	}
+	return &undo, nil
+}
+
+// PurgeTrash permanently removes transactions that have been in the trash
+// longer than the retention window, along with the duplicates merged into
+// them.
+func PurgeTrash() (int64, error) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		return 0, err
+	}
+	defer client.Disconnect(context.Background())
+
+	collection := client.Database("paymentx").Collection("transactions")
+	cutoff := primitive.NewDateTimeFromTime(time.Now().Add(-trashRetention()))
+
+	cursor, err := collection.Find(context.Background(),
+		bson.M{"deleted_at": bson.M{"$lt": cutoff}},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var expired []models.Transaction
+	if err := cursor.All(context.Background(), &expired); err != nil {
+		return 0, err
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(expired))
+	for i, txn := range expired {
+		ids[i] = txn.ID
+	}
+
+	result, err := collection.DeleteMany(context.Background(), bson.M{"$or": bson.A{
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"merged_into": bson.M{"$in": ids}},
+	}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// StartTrashPurger runs PurgeTrash every interval until the process exits.
+func StartTrashPurger(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			purged, err := PurgeTrash()
+			if err != nil {
+				fmt.Println("Failed to purge trash:", err)
+				continue
+			}
+			if purged > 0 {
+				fmt.Println("Purged", purged, "transactions from trash")
+			}
+		}
+	}()
+}
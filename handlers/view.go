@@ -0,0 +1,475 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+	cont "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// viewTopGroups is how many groups the aggregates of each view list.
+const viewTopGroups = 5
+
+func CreateView(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var view models.SavedView
+	if err := json.NewDecoder(r.Body).Decode(&view); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if status, err := validateView(client, userDB.ID, &view); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	view.ID = primitive.NewObjectID()
+	view.UserID = userDB.ID
+	view.CreatedAt = now
+	view.UpdatedAt = now
+
+	collection := client.Database("paymentx").Collection("saved_views")
+	if _, err := collection.InsertOne(context.Background(), view); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(view)
+}
+
+// GetViews lists the user's own views and those shared with their
+// households.
+func GetViews(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	paginator, err := helpers.NewPaginator(r.URL.Query(), bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := accessibleViewsFilter(client, userDB.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("saved_views")
+	cursor, err := collection.Find(context.Background(), paginator.Filter(filter), paginator.FindOptions())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var docs []bson.Raw
+	if err := cursor.All(context.Background(), &docs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	docs, pageInfo := paginator.Paginate(docs)
+
+	views := make([]models.SavedView, len(docs))
+	for i, doc := range docs {
+		if err := bson.Unmarshal(doc, &views[i]); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := struct {
+		Status string             `json:"status"`
+		Views  []models.SavedView `json:"views"`
+		helpers.PageInfo
+	}{
+		Status:   "success",
+		Views:    views,
+		PageInfo: pageInfo,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// UpdateView replaces the definition of a view. Only its owner can change
+// it.
+func UpdateView(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	existing, err := findAccessibleView(client, userDB.ID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "View not found", http.StatusNotFound)
+		return
+	}
+	if existing.UserID != userDB.ID {
+		http.Error(w, "Only the owner can change a view", http.StatusForbidden)
+		return
+	}
+
+	var view models.SavedView
+	if err := json.NewDecoder(r.Body).Decode(&view); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if status, err := validateView(client, userDB.ID, &view); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	view.ID = existing.ID
+	view.UserID = existing.UserID
+	view.CreatedAt = existing.CreatedAt
+	view.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	collection := client.Database("paymentx").Collection("saved_views")
+	if _, err := collection.ReplaceOne(context.Background(), bson.M{"_id": view.ID, "user_id": userDB.ID}, view); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+func DeleteView(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid view id", http.StatusBadRequest)
+		return
+	}
+
+	collection := client.Database("paymentx").Collection("saved_views")
+	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": id, "user_id": userDB.ID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		http.Error(w, "View not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// viewGroup is one row of the aggregates of a view. The totals row has no
+// key.
+type viewGroup struct {
+	Key    string  `json:"key,omitempty" bson:"_id"`
+	Total  float64 `json:"total"`
+	Debit  float64 `json:"debit"`
+	Credit float64 `json:"credit"`
+	Count  int     `json:"count"`
+}
+
+// GetViewAggregates returns live totals, and the top groups by the view's
+// grouping, for every view the user can see. Shared views are applied to the
+// user's own transactions. All views are computed in one aggregation.
+func GetViewAggregates(w http.ResponseWriter, r *http.Request) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	views, err := accessibleViews(client, userDB.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type viewAggregate struct {
+		View   models.SavedView `json:"view"`
+		Totals viewGroup        `json:"totals"`
+		Groups []viewGroup      `json:"groups"`
+	}
+	response := struct {
+		Status string          `json:"status"`
+		Data   []viewAggregate `json:"data"`
+	}{
+		Status: "success",
+		Data:   []viewAggregate{},
+	}
+
+	if len(views) > 0 {
+		now := time.Now()
+		facets := bson.M{}
+		for _, view := range views {
+			values := helpers.ViewValues(view, nil, now)
+			groupBy := view.GroupBy
+			if groupBy == "" {
+				groupBy = "category"
+			}
+			groupID, _ := groupKey(groupBy)
+
+			amount := analyticsAmountValues(values)
+			sums := func(id interface{}) bson.M {
+				return bson.M{"$group": bson.M{
+					"_id":    id,
+					"total":  bson.M{"$sum": amount},
+					"debit":  bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$type", "DEBIT"}}, amount, 0}}},
+					"credit": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$type", "CREDIT"}}, amount, 0}}},
+					"count":  bson.M{"$sum": 1},
+				}}
+			}
+
+			totalStages, err := analyticsStagesValues(values, bson.M{}, false)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			groupStages, err := analyticsStagesValues(values, bson.M{}, groupBy == "category")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			key := view.ID.Hex()
+			facets[key] = append(totalStages, sums(nil))
+			facets[key+"_groups"] = append(groupStages,
+				sums(groupID),
+				bson.M{"$sort": bson.D{{Key: "total", Value: -1}, {Key: "_id", Value: 1}}},
+				bson.M{"$limit": viewTopGroups},
+			)
+		}
+
+		collection := client.Database("paymentx").Collection("transactions")
+		cursor, err := collection.Aggregate(context.Background(), bson.A{
+			bson.M{"$match": bson.M{"user_id": userDB.ID}},
+			bson.M{"$facet": facets},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var results []map[string][]viewGroup
+		if err := cursor.All(context.Background(), &results); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		for _, view := range views {
+			aggregate := viewAggregate{View: view, Groups: []viewGroup{}}
+			if len(results) > 0 {
+				key := view.ID.Hex()
+				if totals := results[0][key]; len(totals) > 0 {
+					aggregate.Totals = totals[0]
+				}
+				if groups := results[0][key+"_groups"]; groups != nil {
+					aggregate.Groups = groups
+				}
+			}
+			response.Data = append(response.Data, aggregate)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// WithSavedView lets list and analytics endpoints take ?view=<id>. The
+// view's filters, period, sort and grouping become query parameters, with
+// any given on the request itself taking precedence. The resulting query is
+// validated before next runs.
+func WithSavedView(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+
+		if id := values.Get("view"); id != "" {
+			view, status, err := loadRequestView(r, id)
+			if err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+			values.Del("view")
+			values = helpers.ViewValues(view, values, time.Now())
+			r.URL.RawQuery = values.Encode()
+		}
+
+		if _, err := helpers.ParseTransactionQuery(values); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func loadRequestView(r *http.Request, id string) (models.SavedView, int, error) {
+	client, err := config.ConnectToMongo()
+	if err != nil {
+		return models.SavedView{}, http.StatusInternalServerError, err
+	}
+	defer client.Disconnect(context.Background())
+
+	userContext := cont.Get(r, "user")
+	userDB, err := GetUserFromContext(userContext)
+	if err != nil {
+		return models.SavedView{}, http.StatusUnauthorized, errors.New("Unauthorized")
+	}
+
+	view, err := findAccessibleView(client, userDB.ID, id)
+	if err != nil {
+		return view, http.StatusNotFound, errors.New("View not found")
+	}
+	return view, http.StatusOK, nil
+}
+
+// validateView cleans up a view sent by the user and checks it. Sharing
+// needs the user to be a member of the household.
+func validateView(client *mongo.Client, userID primitive.ObjectID, view *models.SavedView) (int, error) {
+	view.Name = strings.TrimSpace(view.Name)
+	if view.Name == "" {
+		return http.StatusBadRequest, errors.New("name is required")
+	}
+	if view.Filters == nil {
+		view.Filters = map[string]string{}
+	}
+	if view.GroupBy != "" {
+		if _, ok := groupKey(view.GroupBy); !ok {
+			return http.StatusBadRequest, errors.New("group_by must be category, merchant or month")
+		}
+	}
+	if err := helpers.ValidateView(*view); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if !view.HouseholdID.IsZero() {
+		if _, err := findUserHousehold(client, userID, view.HouseholdID.Hex()); err != nil {
+			return http.StatusBadRequest, errors.New("not a member of the household")
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// accessibleViewsFilter matches the user's own views and those shared with
+// any of their households.
+func accessibleViewsFilter(client *mongo.Client, userID primitive.ObjectID) (bson.M, error) {
+	households, err := userHouseholdIDs(client, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(households) == 0 {
+		return bson.M{"user_id": userID}, nil
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"user_id": userID},
+		bson.M{"household_id": bson.M{"$in": households}},
+	}}, nil
+}
+
+func accessibleViews(client *mongo.Client, userID primitive.ObjectID) ([]models.SavedView, error) {
+	filter, err := accessibleViewsFilter(client, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	collection := client.Database("paymentx").Collection("saved_views")
+	cursor, err := collection.Find(context.Background(), filter, options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	views := []models.SavedView{}
+	if err := cursor.All(context.Background(), &views); err != nil {
+		return nil, err
+	}
+	return views, nil
+}
+
+func findAccessibleView(client *mongo.Client, userID primitive.ObjectID, hexID string) (models.SavedView, error) {
+	var view models.SavedView
+
+	id, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return view, err
+	}
+
+	filter, err := accessibleViewsFilter(client, userID)
+	if err != nil {
+		return view, err
+	}
+
+	collection := client.Database("paymentx").Collection("saved_views")
+	err = collection.FindOne(context.Background(), bson.M{"$and": bson.A{bson.M{"_id": id}, filter}}).Decode(&view)
+	return view, err
+}
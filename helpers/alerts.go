@@ -0,0 +1,134 @@
+package helpers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EvaluateAlertRules returns the alerts triggered by the incoming transactions.
+// sameDay holds every stored transaction on the days touched by incoming and is
+// only used by merchant frequency rules; it may include the incoming ones.
+// Alerts are deduplicated on DedupKey.
+func EvaluateAlertRules(rules []models.AlertRule, incoming []models.Transaction, sameDay []models.Transaction) []models.Alert {
+	var alerts []models.Alert
+	seen := make(map[string]bool)
+
+	add := func(alert models.Alert) {
+		if seen[alert.DedupKey] {
+			return
+		}
+		seen[alert.DedupKey] = true
+		alerts = append(alerts, alert)
+	}
+
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+
+		switch rule.Kind {
+		case models.AlertMerchantFrequency:
+			for _, alert := range evaluateMerchantFrequency(rule, incoming, sameDay) {
+				add(alert)
+			}
+		default:
+			for _, txn := range incoming {
+				if message, ok := matchAlertRule(rule, txn); ok {
+					add(newAlert(rule, txn.ID, message, fmt.Sprintf("%s|%s", rule.ID.Hex(), txn.ID.Hex())))
+				}
+			}
+		}
+	}
+
+	return alerts
+}
+
+func matchAlertRule(rule models.AlertRule, txn models.Transaction) (string, bool) {
+	switch rule.Kind {
+	case models.AlertDebitAbove:
+		if txn.Type == models.Debit && txn.Amount > rule.Threshold {
+			return fmt.Sprintf("Debit of %.2f at %s is above %.2f", txn.Amount, describeTransaction(txn), rule.Threshold), true
+		}
+	case models.AlertBalanceBelow:
+		// The rule fires when the balance crosses the threshold, not for
+		// every transaction made while it stays below.
+		if txn.Balance != nil && *txn.Balance < rule.Threshold && balanceBefore(txn) >= rule.Threshold {
+			return fmt.Sprintf("Balance dropped to %.2f after %s, below %.2f", *txn.Balance, describeTransaction(txn), rule.Threshold), true
+		}
+	case models.AlertCreditContains:
+		keyword := strings.ToUpper(strings.TrimSpace(rule.Keyword))
+		if keyword != "" && txn.Type == models.Credit && strings.Contains(strings.ToUpper(txn.Details), keyword) {
+			return fmt.Sprintf("Credit of %.2f contains %q: %s", txn.Amount, rule.Keyword, txn.Details), true
+		}
+	}
+	return "", false
+}
+
+// balanceBefore is the balance of the account before txn, worked back from
+// the balance after it. txn must have a balance.
+func balanceBefore(txn models.Transaction) float64 {
+	return *txn.Balance - SignedAmount(txn)
+}
+
+func evaluateMerchantFrequency(rule models.AlertRule, incoming []models.Transaction, sameDay []models.Transaction) []models.Alert {
+	type bucket struct {
+		merchant string
+		day      string
+	}
+
+	counted := make(map[string]bool)
+	counts := make(map[bucket]int)
+	for _, group := range [][]models.Transaction{sameDay, incoming} {
+		for _, txn := range group {
+			if counted[txn.ID.Hex()] {
+				continue
+			}
+			counted[txn.ID.Hex()] = true
+			counts[bucket{merchantOf(txn), dayOf(txn)}]++
+		}
+	}
+
+	var alerts []models.Alert
+	for _, txn := range incoming {
+		b := bucket{merchantOf(txn), dayOf(txn)}
+		if b.merchant == "" || counts[b] <= rule.Count {
+			continue
+		}
+		message := fmt.Sprintf("%d transactions at %s on %s, more than %d", counts[b], b.merchant, b.day, rule.Count)
+		alerts = append(alerts, newAlert(rule, txn.ID, message, fmt.Sprintf("%s|%s|%s", rule.ID.Hex(), b.merchant, b.day)))
+	}
+	return alerts
+}
+
+func newAlert(rule models.AlertRule, txnID primitive.ObjectID, message, dedupKey string) models.Alert {
+	return models.Alert{
+		UserID:        rule.UserID,
+		RuleID:        rule.ID,
+		TransactionID: txnID,
+		Kind:          rule.Kind,
+		Message:       message,
+		DedupKey:      dedupKey,
+	}
+}
+
+func merchantOf(txn models.Transaction) string {
+	if txn.Merchant != "" {
+		return txn.Merchant
+	}
+	return ExtractMerchant(txn.Details)
+}
+
+func dayOf(txn models.Transaction) string {
+	return txn.TransactionDate.Time().UTC().Format(time.DateOnly)
+}
+
+func describeTransaction(txn models.Transaction) string {
+	if merchant := merchantOf(txn); merchant != "" {
+		return merchant
+	}
+	return txn.Details
+}
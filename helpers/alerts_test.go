@@ -0,0 +1,142 @@
+package helpers
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestEvaluateAlertRules(t *testing.T) {
+	at := func(day string) primitive.DateTime {
+		d, err := time.Parse(time.DateOnly, day)
+		if err != nil {
+			panic(err)
+		}
+		return primitive.NewDateTimeFromTime(d)
+	}
+	txn := func(kind models.TransactionType, amount, balance float64, details, day string) models.Transaction {
+		txn := models.Transaction{ID: primitive.NewObjectID(), Type: kind, Amount: amount, Details: details, Merchant: details, TransactionDate: at(day)}
+		// A zero balance stands for none reported
+		if balance != 0 {
+			txn.Balance = &balance
+		}
+		return txn
+	}
+
+	coffee := txn(models.Debit, 250, 0, "BLUE TOKAI", "2024-03-05")
+	morning := txn(models.Debit, 300, 0, "BLUE TOKAI", "2024-03-05")
+	lunch := txn(models.Debit, 400, 0, "BLUE TOKAI", "2024-03-05")
+
+	tests := []struct {
+		name     string
+		rule     models.AlertRule
+		incoming []models.Transaction
+		sameDay  []models.Transaction
+		disabled bool
+		// want is how many alerts fire
+		want int
+	}{
+		{"debit above", models.AlertRule{Kind: models.AlertDebitAbove, Threshold: 5000},
+			[]models.Transaction{txn(models.Debit, 6000, 0, "CROMA", "2024-03-05")}, nil, false, 1},
+		{"debit at threshold", models.AlertRule{Kind: models.AlertDebitAbove, Threshold: 5000},
+			[]models.Transaction{txn(models.Debit, 5000, 0, "CROMA", "2024-03-05")}, nil, false, 0},
+		{"credit is not a debit", models.AlertRule{Kind: models.AlertDebitAbove, Threshold: 5000},
+			[]models.Transaction{txn(models.Credit, 6000, 0, "REFUND", "2024-03-05")}, nil, false, 0},
+		{"balance crosses below", models.AlertRule{Kind: models.AlertBalanceBelow, Threshold: 1000},
+			[]models.Transaction{txn(models.Debit, 500, 800, "RENT", "2024-03-05")}, nil, false, 1},
+		{"balance already below", models.AlertRule{Kind: models.AlertBalanceBelow, Threshold: 1000},
+			[]models.Transaction{txn(models.Debit, 100, 700, "TEA", "2024-03-05")}, nil, false, 0},
+		{"credit while below", models.AlertRule{Kind: models.AlertBalanceBelow, Threshold: 1000},
+			[]models.Transaction{txn(models.Credit, 100, 900, "CASHBACK", "2024-03-05")}, nil, false, 0},
+		{"balance not reported", models.AlertRule{Kind: models.AlertBalanceBelow, Threshold: 1000},
+			[]models.Transaction{txn(models.Debit, 500, 0, "RENT", "2024-03-05")}, nil, false, 0},
+		{"credit contains", models.AlertRule{Kind: models.AlertCreditContains, Keyword: "salary"},
+			[]models.Transaction{txn(models.Credit, 75000, 0, "ACME SALARY MAR", "2024-03-01")}, nil, false, 1},
+		{"debit does not contain", models.AlertRule{Kind: models.AlertCreditContains, Keyword: "salary"},
+			[]models.Transaction{txn(models.Debit, 75000, 0, "SALARY ADVANCE REPAID", "2024-03-01")}, nil, false, 0},
+		{"merchant frequency", models.AlertRule{Kind: models.AlertMerchantFrequency, Count: 2},
+			[]models.Transaction{lunch}, []models.Transaction{coffee, morning, lunch}, false, 1},
+		{"merchant frequency at count", models.AlertRule{Kind: models.AlertMerchantFrequency, Count: 2},
+			[]models.Transaction{morning}, []models.Transaction{coffee, morning}, false, 0},
+		{"merchant frequency fires once a day", models.AlertRule{Kind: models.AlertMerchantFrequency, Count: 1},
+			[]models.Transaction{morning, lunch}, []models.Transaction{coffee}, false, 1},
+		{"disabled", models.AlertRule{Kind: models.AlertDebitAbove, Threshold: 5000},
+			[]models.Transaction{txn(models.Debit, 6000, 0, "CROMA", "2024-03-05")}, nil, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := tt.rule
+			rule.ID = primitive.NewObjectID()
+			rule.Enabled = !tt.disabled
+			if got := EvaluateAlertRules([]models.AlertRule{rule}, tt.incoming, tt.sameDay); len(got) != tt.want {
+				t.Errorf("got %d alerts, want %d: %+v", len(got), tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://93.184.216.34/hook", "https"},
+		{"https:///hook", "https"},
+		{"https://127.0.0.1/hook", "not a public address"},
+		{"https://10.0.0.5/hook", "not a public address"},
+		{"https://169.254.169.254/latest/meta-data", "not a public address"},
+		{"https://[::1]/hook", "not a public address"},
+		{"https://[fd00::1]/hook", "not a public address"},
+		{"https://100.64.1.1/hook", "not a public address"},
+		{"https://93.184.216.34/hook", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := ValidateWebhookURL(tt.url)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("got %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Big spend", "Big spend"},
+		{"Big spend\r\nBcc: someone@example.com", "Big spend Bcc: someone@example.com"},
+		{"\nrent\r", "rent"},
+	}
+
+	for _, tt := range tests {
+		if got := headerText(tt.in); got != tt.want {
+			t.Errorf("headerText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0", "::1", "fe80::1", "::ffff:127.0.0.1"} {
+		if publicIP(net.ParseIP(addr)) {
+			t.Errorf("%s is public, want not", addr)
+		}
+	}
+	for _, addr := range []string{"8.8.8.8", "93.184.216.34", "2606:4700:4700::1111"} {
+		if !publicIP(net.ParseIP(addr)) {
+			t.Errorf("%s is not public, want public", addr)
+		}
+	}
+}
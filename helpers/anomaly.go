@@ -0,0 +1,345 @@
+package helpers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	anomalyMinMerchantHistory = 5
+	anomalyMinCategoryHistory = 10
+	anomalyMinBaselineDays    = 14
+	anomalyMinHourSamples     = 30
+	anomalyZScore             = 3.0
+	anomalyDuplicateWindow    = 10 * time.Minute
+	anomalyRareHourShare      = 0.02
+)
+
+// ParseTransactionHour returns the hour of day of a transaction_time value
+// such as "03:04 PM", "3:04PM", "15:04" or "15:04:05".
+func ParseTransactionHour(value string) (int, bool) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, false
+	}
+
+	ampm := ""
+	if strings.HasSuffix(value, "AM") || strings.HasSuffix(value, "PM") {
+		ampm = value[len(value)-2:]
+		value = strings.TrimSpace(value[:len(value)-2])
+	}
+
+	hour, err := strconv.Atoi(strings.SplitN(value, ":", 2)[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, false
+	}
+
+	switch ampm {
+	case "AM":
+		if hour == 12 {
+			hour = 0
+		}
+	case "PM":
+		if hour != 12 {
+			hour += 12
+		}
+	}
+
+	return hour, hour < 24
+}
+
+// transactionInstant combines the date and time of day of a transaction.
+func transactionInstant(txn models.Transaction) (time.Time, bool) {
+	date := txn.TransactionDate.Time().UTC().Truncate(24 * time.Hour)
+
+	value := strings.ToUpper(strings.TrimSpace(txn.TransactionTime))
+	for _, layout := range []string{"03:04 PM", "3:04 PM", "03:04PM", "3:04PM", "15:04:05", "15:04", "03:04:05 PM"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return date.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second), true
+		}
+	}
+
+	return date, false
+}
+
+// DetectAnomalies flags unusual debits among candidates using history as the
+// user's baseline. history may contain the candidates themselves.
+func DetectAnomalies(history []models.Transaction, candidates []models.Transaction) []models.Anomaly {
+	var debits []models.Transaction
+	for _, txn := range history {
+		if txn.Type == models.Debit {
+			debits = append(debits, txn)
+		}
+	}
+
+	var anomalies []models.Anomaly
+	seen := make(map[string]bool)
+	add := func(found []models.Anomaly) {
+		for _, anomaly := range found {
+			if !seen[anomaly.DedupKey] {
+				seen[anomaly.DedupKey] = true
+				anomalies = append(anomalies, anomaly)
+			}
+		}
+	}
+
+	var candidateDebits []models.Transaction
+	for _, txn := range candidates {
+		if txn.Type == models.Debit {
+			candidateDebits = append(candidateDebits, txn)
+		}
+	}
+
+	add(detectMerchantOutliers(debits, candidateDebits))
+	add(detectDailySpikes(debits, candidateDebits))
+	add(detectDuplicateCharges(debits, candidateDebits))
+	add(detectUnusualHours(debits, candidateDebits))
+
+	return anomalies
+}
+
+// detectMerchantOutliers flags debits far above what the user usually
+// spends at the merchant. A merchant with too little history is judged
+// against the debits of its category instead.
+func detectMerchantOutliers(debits, candidates []models.Transaction) []models.Anomaly {
+	byMerchant := make(map[string][]models.Transaction)
+	byCategory := make(map[string][]models.Transaction)
+	for _, txn := range debits {
+		if merchant := merchantOf(txn); merchant != "" {
+			byMerchant[merchant] = append(byMerchant[merchant], txn)
+		}
+		if txn.Category != "" {
+			byCategory[txn.Category] = append(byCategory[txn.Category], txn)
+		}
+	}
+
+	var anomalies []models.Anomaly
+	for _, txn := range candidates {
+		merchant := merchantOf(txn)
+
+		kind, usual := models.AnomalyMerchantOutlier, merchant
+		amounts := amountsExcept(byMerchant[merchant], txn.ID)
+		if len(amounts) < anomalyMinMerchantHistory {
+			if txn.Category == "" {
+				continue
+			}
+			kind, usual = models.AnomalyCategoryOutlier, txn.Category
+			amounts = amountsExcept(byCategory[txn.Category], txn.ID)
+			if len(amounts) < anomalyMinCategoryHistory {
+				continue
+			}
+		}
+
+		mean, std := meanStd(amounts)
+		if std == 0 {
+			std = mean * 0.1
+		}
+		if std == 0 {
+			continue
+		}
+
+		score := (txn.Amount - mean) / std
+		if score < anomalyZScore || txn.Amount < 2*mean {
+			continue
+		}
+
+		explanation := fmt.Sprintf("%.2f at %s is %.1fx your usual %.2f", txn.Amount, merchant, txn.Amount/mean, mean)
+		if kind == models.AnomalyCategoryOutlier {
+			explanation = fmt.Sprintf("%.2f at %s is %.1fx your usual %.2f on %s", txn.Amount, merchant, txn.Amount/mean, mean, usual)
+		}
+
+		anomalies = append(anomalies, models.Anomaly{
+			UserID:        txn.UserID,
+			TransactionID: txn.ID,
+			Kind:          kind,
+			Score:         round2(score),
+			Explanation:   explanation,
+			Date:          txn.TransactionDate,
+			DedupKey:      fmt.Sprintf("%s|%s", kind, txn.ID.Hex()),
+		})
+	}
+	return anomalies
+}
+
+// amountsExcept lists the amounts of txns other than the one with id.
+func amountsExcept(txns []models.Transaction, id primitive.ObjectID) []float64 {
+	var amounts []float64
+	for _, txn := range txns {
+		if txn.ID != id {
+			amounts = append(amounts, txn.Amount)
+		}
+	}
+	return amounts
+}
+
+func detectDailySpikes(debits, candidates []models.Transaction) []models.Anomaly {
+	totals := make(map[string]float64)
+	for _, txn := range debits {
+		totals[dayOf(txn)] += txn.Amount
+	}
+	if len(totals) < anomalyMinBaselineDays {
+		return nil
+	}
+
+	// Largest candidate of each day is reported as the anomaly's transaction
+	largest := make(map[string]models.Transaction)
+	for _, txn := range candidates {
+		day := dayOf(txn)
+		if current, ok := largest[day]; !ok || txn.Amount > current.Amount {
+			largest[day] = txn
+		}
+	}
+
+	var anomalies []models.Anomaly
+	for day, txn := range largest {
+		var baseline []float64
+		for other, total := range totals {
+			if other != day {
+				baseline = append(baseline, total)
+			}
+		}
+
+		mean, std := meanStd(baseline)
+		if std == 0 {
+			continue
+		}
+
+		score := (totals[day] - mean) / std
+		if score < anomalyZScore {
+			continue
+		}
+
+		anomalies = append(anomalies, models.Anomaly{
+			UserID:        txn.UserID,
+			TransactionID: txn.ID,
+			Kind:          models.AnomalyDailySpike,
+			Score:         round2(score),
+			Explanation:   fmt.Sprintf("Spent %.2f on %s, %.1f standard deviations above your daily average of %.2f", totals[day], day, score, mean),
+			Date:          txn.TransactionDate,
+			DedupKey:      fmt.Sprintf("%s|%s|%s", models.AnomalyDailySpike, txn.UserID.Hex(), day),
+		})
+	}
+
+	sort.Slice(anomalies, func(i, j int) bool { return anomalies[i].Date < anomalies[j].Date })
+	return anomalies
+}
+
+func detectDuplicateCharges(debits, candidates []models.Transaction) []models.Anomaly {
+	var anomalies []models.Anomaly
+	for _, txn := range candidates {
+		at, ok := transactionInstant(txn)
+		if !ok {
+			continue
+		}
+
+		for _, other := range debits {
+			if other.ID == txn.ID || math.Abs(other.Amount-txn.Amount) > balanceTolerance || merchantOf(other) != merchantOf(txn) {
+				continue
+			}
+			otherAt, ok := transactionInstant(other)
+			if !ok {
+				continue
+			}
+
+			gap := at.Sub(otherAt)
+			if gap < 0 {
+				gap = -gap
+			}
+			if gap > anomalyDuplicateWindow {
+				continue
+			}
+
+			first, second := txn.ID, other.ID
+			if second.Hex() < first.Hex() {
+				first, second = second, first
+			}
+
+			anomalies = append(anomalies, models.Anomaly{
+				UserID:        txn.UserID,
+				TransactionID: txn.ID,
+				RelatedIDs:    []primitive.ObjectID{other.ID},
+				Kind:          models.AnomalyDuplicateCharge,
+				Score:         round2(1 - gap.Minutes()/anomalyDuplicateWindow.Minutes()),
+				Explanation:   fmt.Sprintf("Possible duplicate charge of %.2f at %s within %d minutes", txn.Amount, merchantOf(txn), int(gap.Minutes())),
+				Date:          txn.TransactionDate,
+				DedupKey:      fmt.Sprintf("%s|%s|%s", models.AnomalyDuplicateCharge, first.Hex(), second.Hex()),
+			})
+		}
+	}
+	return anomalies
+}
+
+func detectUnusualHours(debits, candidates []models.Transaction) []models.Anomaly {
+	var hours [24]int
+	total := 0
+	counted := make(map[primitive.ObjectID]bool)
+	for _, txn := range debits {
+		if hour, ok := ParseTransactionHour(txn.TransactionTime); ok {
+			hours[hour]++
+			total++
+			counted[txn.ID] = true
+		}
+	}
+	if total < anomalyMinHourSamples {
+		return nil
+	}
+
+	var anomalies []models.Anomaly
+	for _, txn := range candidates {
+		hour, ok := ParseTransactionHour(txn.TransactionTime)
+		if !ok {
+			continue
+		}
+
+		// Leave the candidate itself out of its own baseline, if it is in
+		// there
+		inHour, outOf := hours[hour], total
+		if counted[txn.ID] {
+			inHour, outOf = inHour-1, outOf-1
+		}
+		share := math.Min(math.Max(float64(inHour)/float64(outOf), 0), 1)
+		if share >= anomalyRareHourShare {
+			continue
+		}
+
+		anomalies = append(anomalies, models.Anomaly{
+			UserID:        txn.UserID,
+			TransactionID: txn.ID,
+			Kind:          models.AnomalyUnusualHour,
+			Score:         round2(1 - share/anomalyRareHourShare),
+			Explanation:   fmt.Sprintf("%.2f at %s around %02d:00, when only %.1f%% of your spending happens", txn.Amount, merchantOf(txn), hour, share*100),
+			Date:          txn.TransactionDate,
+			DedupKey:      fmt.Sprintf("%s|%s", models.AnomalyUnusualHour, txn.ID.Hex()),
+		})
+	}
+	return anomalies
+}
+
+func meanStd(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,115 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDetectUnusualHours(t *testing.T) {
+	txn := func(clock string) models.Transaction {
+		return models.Transaction{ID: primitive.NewObjectID(), Type: models.Debit, Amount: 500, Merchant: "SWIGGY", TransactionTime: clock}
+	}
+
+	var debits []models.Transaction
+	for i := 0; i < 40; i++ {
+		debits = append(debits, txn("13:15:00"))
+	}
+	night := txn("03:10:00")
+	history := append(append([]models.Transaction(nil), debits...), night)
+
+	tests := []struct {
+		name      string
+		debits    []models.Transaction
+		candidate models.Transaction
+		want      int
+	}{
+		{"rare hour, in the baseline", history, night, 1},
+		{"rare hour, not in the baseline", debits, night, 1},
+		{"usual hour, in the baseline", history, debits[0], 0},
+		{"usual hour, not in the baseline", debits, txn("13:40:00"), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := detectUnusualHours(tt.debits, []models.Transaction{tt.candidate})
+			if len(got) != tt.want {
+				t.Fatalf("got %d anomalies, want %d: %+v", len(got), tt.want, got)
+			}
+			for _, anomaly := range got {
+				if anomaly.Score < 0 || anomaly.Score > 1 {
+					t.Errorf("score %v out of range", anomaly.Score)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectMerchantOutliersCategoryFallback(t *testing.T) {
+	txn := func(merchant, category string, amount float64) models.Transaction {
+		return models.Transaction{ID: primitive.NewObjectID(), Type: models.Debit, Amount: amount, Merchant: merchant, Category: category}
+	}
+
+	var debits []models.Transaction
+	for i := 0; i < 12; i++ {
+		debits = append(debits, txn("SWIGGY", "Food & Dining", 400+float64(i*10)))
+	}
+	for i := 0; i < 2; i++ {
+		debits = append(debits, txn("TOIT", "Food & Dining", 1200))
+	}
+
+	tests := []struct {
+		name      string
+		candidate models.Transaction
+		want      models.AnomalyKind
+	}{
+		{"known merchant", txn("SWIGGY", "Food & Dining", 4000), models.AnomalyMerchantOutlier},
+		{"new merchant, judged by category", txn("TOIT", "Food & Dining", 4000), models.AnomalyCategoryOutlier},
+		{"new merchant, usual for the category", txn("TOIT", "Food & Dining", 450), ""},
+		{"new merchant, thin category", txn("IKEA", "Shopping", 40000), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := detectMerchantOutliers(debits, []models.Transaction{tt.candidate})
+			if tt.want == "" {
+				if len(got) != 0 {
+					t.Errorf("got %+v, want none", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0].Kind != tt.want {
+				t.Errorf("got %+v, want one %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectDuplicateChargesTolerance(t *testing.T) {
+	day := primitive.NewDateTimeFromTime(time.Date(2024, 3, 13, 0, 0, 0, 0, time.UTC))
+	txn := func(clock string, amount float64) models.Transaction {
+		return models.Transaction{ID: primitive.NewObjectID(), Type: models.Debit, Amount: amount, Merchant: "UBER", TransactionDate: day, TransactionTime: clock}
+	}
+
+	first := txn("21:02:00", 0.1+0.2)
+	tests := []struct {
+		name   string
+		second models.Transaction
+		want   int
+	}{
+		{"same amount in float arithmetic", txn("21:05:00", 0.3), 1},
+		{"different amount", txn("21:05:00", 0.35), 0},
+		{"same amount, far apart", txn("22:05:00", 0.3), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := detectDuplicateCharges([]models.Transaction{first, tt.second}, []models.Transaction{tt.second})
+			if len(got) != tt.want {
+				t.Errorf("got %d anomalies, want %d: %+v", len(got), tt.want, got)
+			}
+		})
+	}
+}
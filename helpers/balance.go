@@ -0,0 +1,146 @@
+package helpers
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	balanceTolerance = 0.01
+	// balanceMissingPeriodDays is the gap after which a break in the running
+	// balance is more likely a missed upload than a missing statement page.
+	balanceMissingPeriodDays = 31
+)
+
+// BalanceGap is a break in the running balance between two consecutive
+// transactions of an account.
+type BalanceGap struct {
+	AccountID     primitive.ObjectID `json:"account_id,omitempty"`
+	PreviousID    primitive.ObjectID `json:"previous_id"`
+	TransactionID primitive.ObjectID `json:"transaction_id"`
+	From          string             `json:"from"`
+	To            string             `json:"to"`
+	Expected      float64            `json:"expected_balance"`
+	Actual        float64            `json:"actual_balance"`
+	Difference    float64            `json:"difference"`
+	Reason        string             `json:"reason"`
+}
+
+type BalancePoint struct {
+	Date    string  `json:"date"`
+	Balance float64 `json:"balance"`
+}
+
+// SortForBalance orders transactions the way they appear on a statement:
+// by date, then time of day, then insertion order.
+func SortForBalance(txns []models.Transaction) {
+	sort.SliceStable(txns, func(i, j int) bool {
+		if txns[i].TransactionDate != txns[j].TransactionDate {
+			return txns[i].TransactionDate < txns[j].TransactionDate
+		}
+		ti, _ := transactionInstant(txns[i])
+		tj, _ := transactionInstant(txns[j])
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return txns[i].ID.Hex() < txns[j].ID.Hex()
+	})
+}
+
+// SignedAmount is the effect of a transaction on the account balance.
+func SignedAmount(txn models.Transaction) float64 {
+	if txn.Type == models.Debit {
+		return -txn.Amount
+	}
+	return txn.Amount
+}
+
+// CheckBalanceContinuity verifies that previous balance ± amount equals the
+// next balance across consecutive transactions of one account. txns must be
+// sorted with SortForBalance. Transactions without a balance are skipped.
+// liability is set for accounts such as credit cards, whose statements may
+// report what is owed, which debits raise and credits pay down, instead of a
+// balance that goes below zero.
+func CheckBalanceContinuity(txns []models.Transaction, liability bool) []BalanceGap {
+	var gaps []BalanceGap
+
+	var prev *models.Transaction
+	for i := range txns {
+		cur := &txns[i]
+		if cur.Balance == nil {
+			continue
+		}
+		if prev != nil {
+			expected := *prev.Balance + SignedAmount(*cur)
+			if owed := *prev.Balance - SignedAmount(*cur); liability && math.Abs(owed-*cur.Balance) < math.Abs(expected-*cur.Balance) {
+				expected = owed
+			}
+			if math.Abs(expected-*cur.Balance) > balanceTolerance {
+				reason := "missing_transactions"
+				if cur.TransactionDate.Time().Sub(prev.TransactionDate.Time()) > balanceMissingPeriodDays*24*time.Hour {
+					reason = "missing_period"
+				}
+				gaps = append(gaps, BalanceGap{
+					AccountID:     cur.AccountID,
+					PreviousID:    prev.ID,
+					TransactionID: cur.ID,
+					From:          prev.TransactionDate.Time().UTC().Format(time.DateOnly),
+					To:            cur.TransactionDate.Time().UTC().Format(time.DateOnly),
+					Expected:      round2(expected),
+					Actual:        round2(*cur.Balance),
+					Difference:    round2(*cur.Balance - expected),
+					Reason:        reason,
+				})
+			}
+		}
+		prev = cur
+	}
+
+	return gaps
+}
+
+// DailyClosingBalances returns the closing balance of every day between the
+// first and last transaction, carrying the balance over days with no activity.
+// txns must be sorted with SortForBalance.
+func DailyClosingBalances(txns []models.Transaction) []BalancePoint {
+	closing := make(map[string]float64)
+	var first, last time.Time
+	for _, txn := range txns {
+		if txn.Balance == nil {
+			continue
+		}
+		day := txn.TransactionDate.Time().UTC().Truncate(24 * time.Hour)
+		if first.IsZero() {
+			first = day
+		}
+		last = day
+		closing[day.Format(time.DateOnly)] = *txn.Balance
+	}
+
+	var points []BalancePoint
+	if first.IsZero() {
+		return points
+	}
+
+	balance := 0.0
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		if b, ok := closing[day.Format(time.DateOnly)]; ok {
+			balance = b
+		}
+		points = append(points, BalancePoint{Date: day.Format(time.DateOnly), Balance: round2(balance)})
+	}
+	return points
+}
+
+// GroupByAccount splits transactions per account, keeping their order.
+func GroupByAccount(txns []models.Transaction) map[primitive.ObjectID][]models.Transaction {
+	groups := make(map[primitive.ObjectID][]models.Transaction)
+	for _, txn := range txns {
+		groups[txn.AccountID] = append(groups[txn.AccountID], txn)
+	}
+	return groups
+}
@@ -0,0 +1,81 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCheckBalanceContinuity(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	txn := func(days int, kind models.TransactionType, amount float64, balance *float64) models.Transaction {
+		return models.Transaction{
+			ID:              primitive.NewObjectID(),
+			TransactionDate: primitive.NewDateTimeFromTime(day.AddDate(0, 0, days)),
+			Type:            kind,
+			Amount:          amount,
+			Balance:         balance,
+		}
+	}
+	balance := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name      string
+		txns      []models.Transaction
+		liability bool
+		// gaps are the expected balances of the rows that break continuity
+		gaps []float64
+	}{
+		{"continuous", []models.Transaction{
+			txn(0, models.Credit, 1000, balance(1000)),
+			txn(1, models.Debit, 400, balance(600)),
+		}, false, nil},
+		{"drained to zero", []models.Transaction{
+			txn(0, models.Credit, 500, balance(500)),
+			txn(1, models.Debit, 500, balance(0)),
+			txn(2, models.Credit, 200, balance(200)),
+		}, false, nil},
+		{"zero that does not follow", []models.Transaction{
+			txn(0, models.Credit, 500, balance(500)),
+			txn(1, models.Debit, 300, balance(0)),
+		}, false, []float64{200}},
+		{"no balance reported", []models.Transaction{
+			txn(0, models.Credit, 500, balance(500)),
+			txn(1, models.Debit, 300, nil),
+			txn(2, models.Debit, 100, balance(100)),
+		}, false, []float64{400}},
+		{"card reporting what is owed", []models.Transaction{
+			txn(0, models.Debit, 899, balance(899)),
+			txn(1, models.Debit, 1200, balance(2099)),
+			txn(2, models.Credit, 2099, balance(0)),
+		}, true, nil},
+		{"card reporting a negative balance", []models.Transaction{
+			txn(0, models.Debit, 899, balance(-899)),
+			txn(1, models.Debit, 1200, balance(-2099)),
+		}, true, nil},
+		{"card with a missing row", []models.Transaction{
+			txn(0, models.Debit, 899, balance(899)),
+			txn(1, models.Debit, 1200, balance(2500)),
+		}, true, []float64{2099}},
+		{"owed balance on a bank account", []models.Transaction{
+			txn(0, models.Debit, 899, balance(899)),
+			txn(1, models.Debit, 1200, balance(2099)),
+		}, false, []float64{-301}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gaps := CheckBalanceContinuity(tt.txns, tt.liability)
+			if len(gaps) != len(tt.gaps) {
+				t.Fatalf("got %d gaps, want %d: %+v", len(gaps), len(tt.gaps), gaps)
+			}
+			for i, gap := range gaps {
+				if gap.Expected != tt.gaps[i] {
+					t.Errorf("gap %d expected balance %v, want %v", i, gap.Expected, tt.gaps[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package helpers
+
+import "strings"
+
+const UncategorizedCategory = "Others"
+
+// categoryKeywords maps a category to narration keywords, checked in order so
+// more specific categories win.
+var categoryKeywords = []struct {
+	category string
+	keywords []string
+}{
+	{"Salary", []string{"SALARY", "SAL CR", "PAYROLL"}},
+	{"Rent", []string{"RENT", "NOBROKER", "HOUSING.COM"}},
+	{"Food & Dining", []string{"SWIGGY", "ZOMATO", "DOMINOS", "MCDONALD", "KFC", "STARBUCKS", "CAFE", "RESTAURANT", "EATCLUB", "BURGER"}},
+	{"Groceries", []string{"BIGBASKET", "BLINKIT", "GROFERS", "ZEPTO", "DMART", "INSTAMART", "JIOMART", "MORE RETAIL", "SUPERMARKET", "GROCERY"}},
+	{"Shopping", []string{"AMAZON", "FLIPKART", "MYNTRA", "AJIO", "NYKAA", "MEESHO", "TATACLIQ"}},
+	{"Travel", []string{"IRCTC", "UBER", "OLA", "RAPIDO", "MAKEMYTRIP", "GOIBIBO", "INDIGO", "AIR INDIA", "REDBUS", "CLEARTRIP", "METRO"}},
+	{"Fuel", []string{"PETROL", "FUEL", "HPCL", "BPCL", "IOCL", "INDIAN OIL", "SHELL"}},
+	{"Bills & Utilities", []string{"ELECTRICITY", "BESCOM", "TATA POWER", "AIRTEL", "JIO", "VODAFONE", "VI ", "BSNL", "BROADBAND", "GAS", "WATER", "DTH", "RECHARGE", "BILLDESK"}},
+	{"Entertainment", []string{"NETFLIX", "SPOTIFY", "HOTSTAR", "PRIME VIDEO", "BOOKMYSHOW", "PVR", "INOX", "YOUTUBE"}},
+	{"Health", []string{"PHARMACY", "APOLLO", "PHARMEASY", "1MG", "NETMEDS", "HOSPITAL", "CLINIC", "PRACTO"}},
+	{"Investments", []string{"ZERODHA", "GROWW", "MUTUAL FUND", "SIP", "UPSTOX", "NPS"}},
+	{"Insurance", []string{"INSURANCE", "LIC ", "POLICY"}},
+	{"EMI & Loans", []string{"EMI", "LOAN"}},
+	{"Cash", []string{"ATM", "CASH WDL", "CASH WITHDRAWAL"}},
+}
+
+// Categorize guesses a category from the narration and merchant of a
+// transaction, falling back to UncategorizedCategory.
+func Categorize(details, merchant string) string {
+	text := " " + strings.ToUpper(details+" "+merchant) + " "
+	for _, rule := range categoryKeywords {
+		for _, keyword := range rule.keywords {
+			if strings.Contains(text, keyword) {
+				return rule.category
+			}
+		}
+	}
+	return UncategorizedCategory
+}
+
+// foreignCategories maps keywords of the category names used by other
+// finance apps to ours, checked in order.
+var foreignCategories = []struct {
+	category string
+	keywords []string
+}{
+	{"Salary", []string{"paycheck", "salary", "wage", "bonus"}},
+	{"Fuel", []string{"fuel", "petrol"}},
+	// Before rent, for Mint's "Rental Car & Taxi"
+	{"Travel", []string{"travel", "transport", "taxi", "rental car", "flight", "hotel", "vacation", "parking", "train"}},
+	{"Rent", []string{"rent", "mortgage", "housing"}},
+	{"Groceries", []string{"grocer", "supermarket"}},
+	{"Food & Dining", []string{"food", "dining", "restaurant", "coffee", "eating out", "snack", "lunch", "dinner"}},
+	{"Shopping", []string{"shopping", "clothing", "apparel", "electronics", "books", "gifts", "household"}},
+	{"Bills & Utilities", []string{"bill", "utilit", "electric", "internet", "phone", "mobile", "water", "tv", "cable"}},
+	{"Entertainment", []string{"entertainment", "movie", "music", "game", "subscription", "streaming"}},
+	{"Health", []string{"health", "medical", "doctor", "pharmacy", "fitness", "gym", "dentist"}},
+	{"Investments", []string{"invest", "stocks", "mutual fund", "savings"}},
+	{"Insurance", []string{"insurance"}},
+	{"EMI & Loans", []string{"loan", "emi", "debt", "credit card payment"}},
+	{"Cash", []string{"cash", "atm"}},
+}
+
+// MapCategory maps a category from another finance app, such as Mint's
+// "Fast Food" or YNAB's "Bills: Electric", to one of ours. It returns "" when
+// nothing matches so the caller can fall back to Categorize.
+func MapCategory(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return ""
+	}
+	for _, rule := range foreignCategories {
+		for _, keyword := range rule.keywords {
+			if strings.Contains(name, keyword) {
+				return rule.category
+			}
+		}
+	}
+	return ""
+}
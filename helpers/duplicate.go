@@ -0,0 +1,251 @@
+package helpers
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// DuplicateAutoMergeThreshold is the score above which likely duplicates
+	// are merged without review, unless a shared number is all that links
+	// them.
+	DuplicateAutoMergeThreshold = 0.85
+	// DuplicateReviewThreshold is the lowest score surfaced for review.
+	DuplicateReviewThreshold = 0.55
+
+	duplicateMaxDays = 3
+	// DuplicateWindow is how far apart the dates of duplicates may be.
+	DuplicateWindow = duplicateMaxDays * 24 * time.Hour
+
+	// alertPairWindow is how far apart two alerts of one payment may say it
+	// happened.
+	alertPairWindow = 15 * time.Minute
+)
+
+var nonAlphanumeric = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// DuplicateMatch scores a pair of transactions that may be the same payment.
+type DuplicateMatch struct {
+	KeepID      primitive.ObjectID `json:"keep_id"`
+	DuplicateID primitive.ObjectID `json:"duplicate_id"`
+	Score       float64            `json:"score"`
+	Reasons     []string           `json:"reasons"`
+	// AutoMerge is set when the pair is certain enough to merge without
+	// review.
+	AutoMerge bool `json:"auto_merge"`
+}
+
+// NormaliseNarration reduces a narration to its meaningful words so the same
+// payment reads alike across a bank statement, a CSV export and a sync.
+func NormaliseNarration(details string) []string {
+	var tokens []string
+	for _, token := range strings.Fields(nonAlphanumeric.ReplaceAllString(strings.ToUpper(details), " ")) {
+		if narrationNoise[token] || len(token) < 2 {
+			continue
+		}
+		// Long digit runs are references, compared separately
+		if strings.Trim(token, "0123456789") == "" && len(token) > 4 {
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// NarrationSimilarity is the Jaccard similarity of two normalised narrations.
+func NarrationSimilarity(a, b string) float64 {
+	left, right := make(map[string]bool), make(map[string]bool)
+	for _, token := range NormaliseNarration(a) {
+		left[token] = true
+	}
+	for _, token := range NormaliseNarration(b) {
+		right[token] = true
+	}
+	if len(left) == 0 || len(right) == 0 {
+		return 0
+	}
+
+	common := 0
+	for token := range left {
+		if right[token] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(left)+len(right)-common)
+}
+
+// ScoreDuplicate rates how likely two transactions are the same payment from
+// amount, date proximity, shared reference numbers and narration similarity.
+// Only transactions that came in through different uploads can be
+// duplicates, and never two rows whose running balances disagree.
+func ScoreDuplicate(a, b models.Transaction) (float64, []string) {
+	score, reasons, _ := scoreDuplicate(a, b)
+	return score, reasons
+}
+
+// scoreDuplicate is ScoreDuplicate, also telling whether the pair may be
+// merged without review: a shared number alone, which may be an account or
+// card number rather than a reference, only earns a suggestion.
+func scoreDuplicate(a, b models.Transaction) (float64, []string, bool) {
+	if a.Type != b.Type || math.Abs(a.Amount-b.Amount) > balanceTolerance {
+		return 0, nil, false
+	}
+	if !a.AccountID.IsZero() && !b.AccountID.IsZero() && a.AccountID != b.AccountID {
+		return 0, nil, false
+	}
+	// Two rows of one upload are two payments, however alike they look
+	if a.Source == b.Source && a.BatchID == b.BatchID {
+		return 0, nil, false
+	}
+	if a.Balance != nil && b.Balance != nil && math.Abs(*a.Balance-*b.Balance) > balanceTolerance {
+		return 0, nil, false
+	}
+
+	days := math.Abs(a.TransactionDate.Time().Sub(b.TransactionDate.Time()).Hours() / 24)
+	if days > duplicateMaxDays {
+		return 0, nil, false
+	}
+
+	reasons := []string{"amount"}
+	score := 0.2 + 0.25*(1-days/duplicateMaxDays)
+	if days < 1 {
+		reasons = append(reasons, "same_day")
+	}
+
+	strong, numeric := false, false
+	refs := make(map[string]bool)
+	for _, ref := range ExtractReferences(a.Details) {
+		refs[ref] = true
+	}
+	for _, ref := range ExtractReferences(b.Details) {
+		if refs[ref] {
+			score += 0.4
+			if strings.Trim(ref, "0123456789") == "" {
+				numeric = true
+				reasons = append(reasons, "numeric_reference")
+			} else {
+				strong = true
+				reasons = append(reasons, "reference")
+			}
+			break
+		}
+	}
+
+	// An alert names little more than the account, amount and day, so a
+	// posted transaction matching those in the same account is very likely
+	// the one it announced
+	if a.Provisional != b.Provisional && !a.AccountID.IsZero() && a.AccountID == b.AccountID {
+		score += 0.25
+		strong = true
+		reasons = append(reasons, "provisional")
+	}
+	// The SMS and the email a bank sends for one payment carry the same
+	// account, amount and, give or take delivery, time. Two alerts of one
+	// kind so close together are more likely two payments
+	if a.Provisional && b.Provisional && a.Source != b.Source && !a.AccountID.IsZero() && a.AccountID == b.AccountID {
+		at, aok := transactionInstant(a)
+		bt, bok := transactionInstant(b)
+		if aok && bok && math.Abs(at.Sub(bt).Minutes()) <= alertPairWindow.Minutes() {
+			score += 0.4
+			strong = true
+			reasons = append(reasons, "alert_time")
+		}
+	}
+
+	similarity := NarrationSimilarity(a.Details, b.Details)
+	if merchantOf(a) != "" && merchantOf(a) == merchantOf(b) {
+		similarity = math.Max(similarity, 0.8)
+	}
+	if similarity > 0 {
+		score += 0.35 * similarity
+		reasons = append(reasons, "narration")
+	}
+	if similarity >= 0.5 {
+		strong = true
+	}
+
+	score = round2(math.Min(score, 1))
+	auto := score >= DuplicateAutoMergeThreshold && (strong || !numeric)
+	return score, reasons, auto
+}
+
+// FindDuplicates compares candidates with the rest of txns and returns the
+// pairs scoring at least DuplicateReviewThreshold, best first. A transaction
+// is either kept or a duplicate, and a duplicate of at most one original.
+func FindDuplicates(txns []models.Transaction, candidates []models.Transaction) []DuplicateMatch {
+	type bucket struct {
+		kind  models.TransactionType
+		cents int64
+	}
+
+	byAmount := make(map[bucket][]models.Transaction)
+	for _, txn := range txns {
+		key := bucket{txn.Type, int64(math.Round(txn.Amount * 100))}
+		byAmount[key] = append(byAmount[key], txn)
+	}
+
+	seen := make(map[[2]primitive.ObjectID]bool)
+	var matches []DuplicateMatch
+	for _, candidate := range candidates {
+		key := bucket{candidate.Type, int64(math.Round(candidate.Amount * 100))}
+		for _, other := range byAmount[key] {
+			if other.ID == candidate.ID {
+				continue
+			}
+			keep, duplicate := preferredOriginal(candidate, other)
+			pair := [2]primitive.ObjectID{keep.ID, duplicate.ID}
+			if seen[pair] {
+				continue
+			}
+			seen[pair] = true
+
+			score, reasons, auto := scoreDuplicate(keep, duplicate)
+			if score >= DuplicateReviewThreshold {
+				matches = append(matches, DuplicateMatch{KeepID: keep.ID, DuplicateID: duplicate.ID, Score: score, Reasons: reasons, AutoMerge: auto})
+			}
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+
+	// A payment stored three times yields two duplicates of one original
+	used, duplicates := make(map[primitive.ObjectID]bool), make(map[primitive.ObjectID]bool)
+	var unique []DuplicateMatch
+	for _, match := range matches {
+		if used[match.DuplicateID] || duplicates[match.KeepID] {
+			continue
+		}
+		used[match.KeepID], used[match.DuplicateID] = true, true
+		duplicates[match.DuplicateID] = true
+		unique = append(unique, match)
+	}
+	return unique
+}
+
+// preferredOriginal decides which of two duplicates survives: the posted one
+// over a provisional alert, then the one with a statement balance, then the
+// one stored first.
+func preferredOriginal(a, b models.Transaction) (keep, duplicate models.Transaction) {
+	if a.Provisional != b.Provisional {
+		if b.Provisional {
+			return a, b
+		}
+		return b, a
+	}
+	if (a.Balance != nil) != (b.Balance != nil) {
+		if a.Balance != nil {
+			return a, b
+		}
+		return b, a
+	}
+	if a.ID.Timestamp().Before(b.ID.Timestamp()) || (a.ID.Timestamp().Equal(b.ID.Timestamp()) && a.ID.Hex() < b.ID.Hex()) {
+		return a, b
+	}
+	return b, a
+}
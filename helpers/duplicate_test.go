@@ -0,0 +1,50 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFindDuplicatesAlertPairs(t *testing.T) {
+	card, other := primitive.NewObjectID(), primitive.NewObjectID()
+	day := primitive.NewDateTimeFromTime(time.Date(2024, 3, 13, 0, 0, 0, 0, time.UTC))
+	alert := func(source string, account primitive.ObjectID, clock, details string) models.Transaction {
+		return models.Transaction{
+			ID:              primitive.NewObjectID(),
+			AccountID:       account,
+			TransactionDate: day,
+			TransactionTime: clock,
+			Amount:          2340,
+			Type:            models.Debit,
+			Details:         details,
+			Provisional:     true,
+			Source:          source,
+			BatchID:         primitive.NewObjectID(),
+		}
+	}
+
+	tests := []struct {
+		name string
+		a, b models.Transaction
+		// merge is whether the pair is merged without review
+		merge bool
+	}{
+		{"sms and email minutes apart", alert("sms", card, "18:45:10", "Card MMT INDIA"), alert("email", card, "18:47:02", "Card MAKEMYTRIP"), true},
+		{"sms and email hours apart", alert("sms", card, "10:05:00", "Card MMT INDIA"), alert("email", card, "18:47:02", "Card MAKEMYTRIP"), false},
+		{"two sms minutes apart", alert("sms", card, "18:45:10", "Card MMT INDIA"), alert("sms", card, "18:47:02", "Card MAKEMYTRIP"), false},
+		{"different accounts", alert("sms", card, "18:45:10", "Card MMT INDIA"), alert("email", other, "18:45:10", "Card MAKEMYTRIP"), false},
+		{"no time of day", alert("sms", card, "", "Card MMT INDIA"), alert("email", card, "", "Card MAKEMYTRIP"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := FindDuplicates([]models.Transaction{tt.a, tt.b}, []models.Transaction{tt.b})
+			if merge := len(matches) > 0 && matches[0].AutoMerge; merge != tt.merge {
+				t.Errorf("auto merge %v (%+v), want %v", merge, matches, tt.merge)
+			}
+		})
+	}
+}
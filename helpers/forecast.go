@@ -0,0 +1,276 @@
+package helpers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+const (
+	recurringMinOccurrences = 3
+	recurringTolerance      = 0.25
+	// recurringStaleIntervals is how many intervals overdue a series may be
+	// before it is taken to have stopped.
+	recurringStaleIntervals = 2
+	forecastSpendWindowDays = 90
+	// forecastBandZ gives a 90% confidence band on the projected balance.
+	forecastBandZ = 1.645
+)
+
+// RecurringItem is a transaction series that repeats at a steady interval,
+// such as a salary credit, rent or a subscription.
+type RecurringItem struct {
+	Merchant     string                 `json:"merchant"`
+	Type         models.TransactionType `json:"type"`
+	Amount       float64                `json:"amount"`
+	IntervalDays int                    `json:"interval_days"`
+	Occurrences  int                    `json:"occurrences"`
+	LastDate     time.Time              `json:"last_date"`
+	NextDate     time.Time              `json:"next_date"`
+
+	ids map[string]bool
+}
+
+type ForecastDay struct {
+	Date    string   `json:"date"`
+	Balance float64  `json:"balance"`
+	Low     float64  `json:"low"`
+	High    float64  `json:"high"`
+	Events  []string `json:"events,omitempty"`
+}
+
+type ForecastWarning struct {
+	Date    string  `json:"date"`
+	Kind    string  `json:"kind"`
+	Balance float64 `json:"balance"`
+	Message string  `json:"message"`
+}
+
+type Forecast struct {
+	StartingBalance float64           `json:"starting_balance"`
+	AsOf            string            `json:"as_of"`
+	DailySpendMean  float64           `json:"daily_spend_mean"`
+	DailySpendStd   float64           `json:"daily_spend_std"`
+	Recurring       []RecurringItem   `json:"recurring"`
+	NextSalary      *RecurringItem    `json:"next_salary,omitempty"`
+	Days            []ForecastDay     `json:"days"`
+	Warnings        []ForecastWarning `json:"warnings"`
+}
+
+// DetectRecurring finds series of same merchant, same type transactions that
+// repeat at a regular interval with a similar amount.
+func DetectRecurring(txns []models.Transaction) []RecurringItem {
+	type seriesKey struct {
+		merchant string
+		kind     models.TransactionType
+	}
+
+	series := make(map[seriesKey][]models.Transaction)
+	for _, txn := range txns {
+		if merchant := merchantOf(txn); merchant != "" {
+			key := seriesKey{merchant, txn.Type}
+			series[key] = append(series[key], txn)
+		}
+	}
+
+	var items []RecurringItem
+	for key, group := range series {
+		if len(group) < recurringMinOccurrences {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool { return group[i].TransactionDate < group[j].TransactionDate })
+
+		var intervals, amounts []float64
+		for i, txn := range group {
+			amounts = append(amounts, txn.Amount)
+			if i > 0 {
+				intervals = append(intervals, group[i].TransactionDate.Time().Sub(group[i-1].TransactionDate.Time()).Hours()/24)
+			}
+		}
+
+		interval := median(intervals)
+		amount := median(amounts)
+		if interval < 6 || !mostlyWithin(intervals, interval, recurringTolerance) || !mostlyWithin(amounts, amount, recurringTolerance) {
+			continue
+		}
+
+		ids := make(map[string]bool)
+		for _, txn := range group {
+			ids[txn.ID.Hex()] = true
+		}
+
+		last := group[len(group)-1].TransactionDate.Time().UTC()
+		items = append(items, RecurringItem{
+			Merchant:     key.merchant,
+			Type:         key.kind,
+			Amount:       round2(amount),
+			IntervalDays: int(math.Round(interval)),
+			Occurrences:  len(group),
+			LastDate:     last,
+			NextDate:     nextOccurrence(last, interval),
+			ids:          ids,
+		})
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].NextDate.Before(items[j].NextDate) })
+	return items
+}
+
+// BuildForecast projects the daily balance from asOf for the next days using
+// the recurring items and the spread of the remaining (discretionary) daily
+// spend. Series that are more than recurringStaleIntervals overdue are taken
+// to have stopped, and an occurrence that is due but not seen yet is expected
+// on the first day. A warning is raised when the balance dips below zero or
+// floor before the next salary credit.
+func BuildForecast(balance float64, asOf time.Time, history []models.Transaction, days int, floor float64) Forecast {
+	asOf = asOf.UTC().Truncate(24 * time.Hour)
+	detected := DetectRecurring(history)
+
+	// Stopped series still say which past spend was not discretionary
+	inSeries := make(map[string]bool)
+	var recurring []RecurringItem
+	for _, item := range detected {
+		for id := range item.ids {
+			inSeries[id] = true
+		}
+		overdue := asOf.Sub(item.NextDate).Hours() / 24
+		if overdue <= float64(recurringStaleIntervals*item.IntervalDays) {
+			recurring = append(recurring, item)
+		}
+	}
+
+	// Daily discretionary spend over the window, counting days with no spend
+	windowStart := asOf.AddDate(0, 0, -forecastSpendWindowDays)
+	daily := make(map[string]float64)
+	for _, txn := range history {
+		at := txn.TransactionDate.Time().UTC()
+		if txn.Type != models.Debit || inSeries[txn.ID.Hex()] || at.Before(windowStart) || at.After(asOf.Add(24*time.Hour)) {
+			continue
+		}
+		daily[at.Format(time.DateOnly)] += txn.Amount
+	}
+	var spend []float64
+	for d := windowStart; !d.After(asOf); d = d.AddDate(0, 0, 1) {
+		spend = append(spend, daily[d.Format(time.DateOnly)])
+	}
+	mean, std := meanStd(spend)
+
+	forecast := Forecast{
+		StartingBalance: round2(balance),
+		AsOf:            asOf.Format(time.DateOnly),
+		DailySpendMean:  round2(mean),
+		DailySpendStd:   round2(std),
+		Recurring:       recurring,
+		Warnings:        []ForecastWarning{},
+	}
+
+	for i := range recurring {
+		item := recurring[i]
+		if item.Type == models.Credit && item.IntervalDays >= 27 && item.IntervalDays <= 33 {
+			if forecast.NextSalary == nil || item.Amount > forecast.NextSalary.Amount {
+				forecast.NextSalary = &item
+			}
+		}
+	}
+
+	// Upcoming occurrences of every recurring item inside the horizon, which
+	// starts today
+	end := asOf.AddDate(0, 0, days-1)
+	today := asOf.Format(time.DateOnly)
+	events := make(map[string][]RecurringItem)
+	for _, item := range recurring {
+		next := item.NextDate
+		if !next.After(asOf) {
+			events[today] = append(events[today], item)
+			for !next.After(asOf) {
+				next = nextOccurrence(next, float64(item.IntervalDays))
+			}
+		}
+		for ; !next.After(end); next = nextOccurrence(next, float64(item.IntervalDays)) {
+			events[next.Format(time.DateOnly)] = append(events[next.Format(time.DateOnly)], item)
+		}
+	}
+
+	warned := make(map[string]bool)
+	projected := balance
+	for i := 0; i < days; i++ {
+		date := asOf.AddDate(0, 0, i)
+		key := date.Format(time.DateOnly)
+
+		day := ForecastDay{Date: key}
+		projected -= mean
+		for _, item := range events[key] {
+			if item.Type == models.Credit {
+				projected += item.Amount
+			} else {
+				projected -= item.Amount
+			}
+			day.Events = append(day.Events, fmt.Sprintf("%s %s %.2f", item.Type, item.Merchant, item.Amount))
+		}
+
+		band := forecastBandZ * std * math.Sqrt(float64(i+1))
+		day.Balance = round2(projected)
+		day.Low = round2(projected - band)
+		day.High = round2(projected + band)
+		forecast.Days = append(forecast.Days, day)
+
+		if forecast.NextSalary != nil && !date.Before(forecast.NextSalary.NextDate) {
+			continue
+		}
+		if day.Balance < 0 && !warned["below_zero"] {
+			warned["below_zero"] = true
+			forecast.Warnings = append(forecast.Warnings, ForecastWarning{
+				Date: key, Kind: "below_zero", Balance: day.Balance,
+				Message: fmt.Sprintf("Balance is projected to go negative on %s", key),
+			})
+		}
+		if floor != 0 && day.Balance < floor && !warned["below_floor"] {
+			warned["below_floor"] = true
+			forecast.Warnings = append(forecast.Warnings, ForecastWarning{
+				Date: key, Kind: "below_floor", Balance: day.Balance,
+				Message: fmt.Sprintf("Balance is projected to drop below %.2f on %s", floor, key),
+			})
+		}
+	}
+
+	return forecast
+}
+
+func nextOccurrence(last time.Time, interval float64) time.Time {
+	// Monthly series follow the calendar so salaries stay on the same date
+	if interval >= 27 && interval <= 33 {
+		return last.AddDate(0, 1, 0)
+	}
+	return last.AddDate(0, 0, int(math.Round(interval)))
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// mostlyWithin reports whether at least three quarters of values are within
+// tolerance of target.
+func mostlyWithin(values []float64, target, tolerance float64) bool {
+	if target == 0 {
+		return false
+	}
+	within := 0
+	for _, v := range values {
+		if math.Abs(v-target)/target <= tolerance {
+			within++
+		}
+	}
+	return float64(within) >= 0.75*float64(len(values))
+}
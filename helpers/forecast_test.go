@@ -0,0 +1,44 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBuildForecastRecurring(t *testing.T) {
+	monthly := func(merchant string, amount float64, months ...int) []models.Transaction {
+		var txns []models.Transaction
+		for _, month := range months {
+			txns = append(txns, models.Transaction{
+				ID:              primitive.NewObjectID(),
+				Type:            models.Debit,
+				Amount:          amount,
+				Merchant:        merchant,
+				TransactionDate: primitive.NewDateTimeFromTime(time.Date(2024, time.Month(month), 5, 0, 0, 0, 0, time.UTC)),
+			})
+		}
+		return txns
+	}
+
+	// Rent is due on June 5th and not seen yet; the gym stopped after March
+	history := append(monthly("LANDLORD", 20000, 1, 2, 3, 4, 5), monthly("GYM", 1500, 1, 2, 3)...)
+	asOf := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+
+	forecast := BuildForecast(100000, asOf, history, 30, 0)
+
+	if len(forecast.Recurring) != 1 || forecast.Recurring[0].Merchant != "LANDLORD" {
+		t.Fatalf("recurring %+v, want only LANDLORD", forecast.Recurring)
+	}
+	if first := forecast.Days[0]; first.Date != "2024-06-10" || len(first.Events) != 1 {
+		t.Errorf("first day %+v, want the overdue rent on 2024-06-10", first)
+	}
+	if len(forecast.Days) != 30 {
+		t.Errorf("got %d days, want 30", len(forecast.Days))
+	}
+	if rent := forecast.Days[25]; rent.Date != "2024-07-05" || len(rent.Events) != 1 {
+		t.Errorf("day %+v, want the next rent on 2024-07-05", rent)
+	}
+}
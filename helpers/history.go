@@ -0,0 +1,43 @@
+package helpers
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+// historyIgnoredFields are identity and derived fields left out of diffs.
+var historyIgnoredFields = map[string]bool{
+	"id":             true,
+	"user_id":        true,
+	"transaction_id": true,
+}
+
+// DiffTransactions lists the fields that differ between two versions of a
+// transaction, named by their JSON keys.
+func DiffTransactions(before, after models.Transaction) []models.FieldChange {
+	var changes []models.FieldChange
+
+	oldValue, newValue := reflect.ValueOf(before), reflect.ValueOf(after)
+	fields := oldValue.Type()
+
+	for i := 0; i < fields.NumField(); i++ {
+		name := strings.Split(fields.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || historyIgnoredFields[name] {
+			continue
+		}
+
+		o, n := oldValue.Field(i), newValue.Field(i)
+		if reflect.DeepEqual(o.Interface(), n.Interface()) {
+			continue
+		}
+		// A nil slice and an emptied one are the same thing
+		if o.Kind() == reflect.Slice && o.Len() == 0 && n.Len() == 0 {
+			continue
+		}
+		changes = append(changes, models.FieldChange{Field: name, Old: o.Interface(), New: n.Interface()})
+	}
+
+	return changes
+}
@@ -0,0 +1,74 @@
+package helpers
+
+import (
+	"regexp"
+	"strings"
+)
+
+var narrationSeparators = regexp.MustCompile(`[/\-|:*]+`)
+
+var narrationNoise = map[string]bool{
+	"UPI": true, "DR": true, "CR": true, "IMPS": true, "NEFT": true, "RTGS": true,
+	"POS": true, "ATM": true, "ECOM": true, "TO": true, "FROM": true, "BY": true,
+	"TRANSFER": true, "PAYMENT": true, "P2A": true, "P2M": true, "MMT": true,
+	"ACH": true, "NACH": true, "BIL": true, "ONL": true, "INB": true, "REF": true,
+	"TXN": true, "INR": true, "PAY": true, "SENT": true, "USING": true,
+	"WDL": true, "VPS": true, "IPS": true, "CHQ": true, "CLG": true,
+}
+
+var ifscPattern = regexp.MustCompile(`^[A-Z]{4}0[A-Z0-9]{6}$`)
+
+// ExtractMerchant makes a best effort guess of the merchant or counterparty
+// from a bank narration such as "UPI/DR/123456789012/SWIGGY/YESB/swiggy@ybl".
+func ExtractMerchant(details string) string {
+	upper := strings.ToUpper(strings.TrimSpace(details))
+	if upper == "" {
+		return ""
+	}
+
+	for _, part := range narrationSeparators.Split(upper, -1) {
+		if merchant := cleanMerchantPart(part); isMerchantToken(merchant) {
+			return merchant
+		}
+	}
+
+	words := strings.Fields(upper)
+	if len(words) > 3 {
+		words = words[:3]
+	}
+	return strings.Join(words, " ")
+}
+
+// cleanMerchantPart drops noise words and card or account numbers from one
+// segment of a narration.
+func cleanMerchantPart(part string) string {
+	var words []string
+	for _, word := range strings.Fields(part) {
+		if narrationNoise[word] || strings.ContainsAny(word, "0123456789") {
+			continue
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " ")
+}
+
+func isMerchantToken(part string) bool {
+	if len(part) < 3 || strings.Contains(part, "@") || ifscPattern.MatchString(part) {
+		return false
+	}
+
+	letters, digits := 0, 0
+	for _, r := range part {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			letters++
+		case r >= '0' && r <= '9':
+			digits++
+		}
+	}
+	if letters < 3 || digits > letters {
+		return false
+	}
+
+	return true
+}
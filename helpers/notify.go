@@ -0,0 +1,166 @@
+package helpers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+// webhookClient posts alerts to user supplied URLs. It dials only public
+// addresses, checked on the address actually dialed so that a name that
+// resolves elsewhere by the time of the request cannot reach the internal
+// network, and follows only redirects that pass ValidateWebhookURL.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+					return fmt.Errorf("webhook address %s is not public", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 3 {
+			return errors.New("too many webhook redirects")
+		}
+		return ValidateWebhookURL(req.URL.String())
+	},
+}
+
+// ValidateWebhookURL checks that a webhook URL is https and that its host
+// resolves only to public addresses, so alerts cannot be pointed at the
+// server's own network.
+func ValidateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid webhook_url: %w", err)
+	}
+	if u.Scheme != "https" || u.Hostname() == "" {
+		return errors.New("webhook_url must be an https URL")
+	}
+
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return fmt.Errorf("webhook_url host does not resolve: %w", err)
+	}
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			return fmt.Errorf("webhook_url host %s is not a public address", u.Hostname())
+		}
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, private in all but name.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is routable on the internet, rather than
+// loopback, private, link-local (which includes cloud metadata services),
+// multicast or unspecified.
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+// DeliverAlert pushes an alert to the out of band channels of its rule.
+// In-app delivery is the stored alert itself, so it needs no work here.
+func DeliverAlert(alert models.Alert, rule models.AlertRule, user models.User) []error {
+	var errs []error
+
+	for _, channel := range rule.Channels {
+		var err error
+		switch channel {
+		case models.ChannelEmail:
+			err = sendAlertEmail(alert, rule, user)
+		case models.ChannelWebhook:
+			err = sendAlertWebhook(alert, rule)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", channel, err))
+		}
+	}
+
+	return errs
+}
+
+func sendAlertEmail(alert models.Alert, rule models.AlertRule, user models.User) error {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return fmt.Errorf("SMTP_HOST not set")
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM")
+
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USER"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	// The rule name is the user's own text, so it may neither end the header
+	// nor carry raw non-ASCII characters
+	subject := mime.QEncoding.Encode("utf-8", "PaymentX alert: "+headerText(rule.Name))
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		headerText(from), headerText(user.Email), subject, alert.Message)
+
+	return smtp.SendMail(host+":"+port, auth, from, []string{user.Email}, []byte(body))
+}
+
+// headerText makes s safe to use as the value of a mail header by turning
+// line breaks into spaces.
+func headerText(s string) string {
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool { return r == '\r' || r == '\n' }), " ")
+}
+
+func sendAlertWebhook(alert models.Alert, rule models.AlertRule) error {
+	if rule.WebhookURL == "" {
+		return fmt.Errorf("webhook_url not set")
+	}
+	if err := ValidateWebhookURL(rule.WebhookURL); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(struct {
+		Rule  string       `json:"rule"`
+		Alert models.Alert `json:"alert"`
+	}{
+		Rule:  rule.Name,
+		Alert: alert,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := webhookClient.Post(rule.WebhookURL, "application/json", strings.NewReader(string(payload)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
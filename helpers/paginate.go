@@ -1,47 +1,251 @@
 package helpers
 
 import (
-	"fmt"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type mongoPaginate struct {
-	limit int64
-	page  int64
-	sort  map[string]interface{}
+const (
+	DefaultPageLimit = 10
+	MaxPageLimit     = 500
+)
+
+// Paginator pages through a sorted Find, either by offset with page and
+// limit, or by keyset with the opaque cursors it hands out. The sort must end
+// in _id so that every position is unique.
+type Paginator struct {
+	Limit int64
+	Page  int64
+	sort  bson.D
+	// cursor holds the sort values of the document the page starts after,
+	// or before when prev is set
+	cursor *pageCursor
+}
+
+// PageInfo is the paging part of a list response. Next and Prev are empty
+// at either end of the list.
+type PageInfo struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
 }
 
-func NewMongoPaginate(limit, page int64, sort map[string]interface{}) *mongoPaginate {
-	if sort == nil {
-		sort = make(map[string]interface{})
+type pageCursor struct {
+	Keys   []string `bson:"k"`
+	Values bson.A   `bson:"v"`
+	Prev   bool     `bson:"p,omitempty"`
+}
+
+// NewPaginator reads limit, page and cursor from a list request. A cursor
+// takes precedence over page.
+func NewPaginator(values url.Values, sort bson.D) (*Paginator, error) {
+	if len(sort) == 0 || sort[len(sort)-1].Key != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: 1})
 	}
-	fmt.Println("Limit: ", limit, "Page: ", page, "Sort: ", sort)
+	p := &Paginator{Limit: DefaultPageLimit, sort: sort}
 
-	return &mongoPaginate{
-		limit: limit,
-		page:  page,
-		sort:  sort,
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit <= 0 || limit > MaxPageLimit {
+			return nil, &QueryError{"limit", "expected a number from 1 to " + strconv.Itoa(MaxPageLimit)}
+		}
+		p.Limit = limit
 	}
+
+	if v := values.Get("cursor"); v != "" {
+		cursor, err := decodeCursor(v)
+		if err != nil || len(cursor.Keys) != len(sort) || len(cursor.Values) != len(sort) {
+			return nil, &QueryError{"cursor", "malformed cursor"}
+		}
+		for i, key := range cursor.Keys {
+			if key != sort[i].Key {
+				return nil, &QueryError{"cursor", "cursor was issued for a different sort"}
+			}
+		}
+		p.cursor = &cursor
+		return p, nil
+	}
+
+	if v := values.Get("page"); v != "" {
+		page, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || page < 0 {
+			return nil, &QueryError{"page", "expected a non-negative number"}
+		}
+		p.Page = page
+	}
+
+	return p, nil
 }
-func (mp *mongoPaginate) GetPaginatedOpts() *mongoPaginate {
-	return mp
+
+// Filter adds the keyset condition of the cursor, if any, to filter.
+func (p *Paginator) Filter(filter bson.M) bson.M {
+	if p.cursor == nil {
+		return filter
+	}
+
+	// (k1 > v1) or (k1 = v1 and k2 > v2) or ... with the comparison
+	// flipped for descending keys and for prev cursors
+	or := bson.A{}
+	for i := range p.sort {
+		clause := bson.A{}
+		for j := 0; j < i; j++ {
+			// {k: null} also matches documents without k
+			clause = append(clause, bson.M{p.sort[j].Key: p.cursor.Values[j]})
+		}
+		after, ok := beyond(p.sort[i].Key, p.cursor.Values[i], descending(p.sort[i].Value) != p.cursor.Prev)
+		if !ok {
+			continue
+		}
+		or = append(or, bson.M{"$and": append(clause, after)})
+	}
+
+	if existing, ok := filter["$and"].(bson.A); ok {
+		filter["$and"] = append(existing, bson.M{"$or": or})
+	} else {
+		filter["$and"] = bson.A{bson.M{"$or": or}}
+	}
+	return filter
+}
+
+// beyond is the condition for key to sort after value, or before it when
+// before is set. Null and missing values sort before everything else, which
+// $gt and $lt do not see: nothing sorts before them, and they sort before any
+// other value. ok is false when no value qualifies.
+func beyond(key string, value interface{}, before bool) (bson.M, bool) {
+	switch {
+	case value == nil && before:
+		return nil, false
+	case value == nil:
+		return bson.M{key: bson.M{"$ne": nil}}, true
+	case before:
+		return bson.M{"$or": bson.A{bson.M{key: bson.M{"$lt": value}}, bson.M{key: nil}}}, true
+	}
+	return bson.M{key: bson.M{"$gt": value}}, true
 }
 
-func (mp *mongoPaginate) SortQuery(sort map[string]interface{}) *mongoPaginate {
-	if sort != nil {
-		mp.sort = sort
+// FindOptions sorts and limits the Find. One extra document is fetched to
+// tell whether there is a further page.
+func (p *Paginator) FindOptions() *options.FindOptions {
+	opts := options.Find().SetSort(p.fetchSort()).SetLimit(p.Limit + 1)
+	if p.cursor == nil && p.Page > 0 {
+		opts.SetSkip(p.Page * p.Limit)
 	}
-	return mp
+	return opts
 }
 
-func (mp *mongoPaginate) BuildFindOptions() *options.FindOptions {
+// Stages are the aggregation stages that page a pipeline the way Filter and
+// FindOptions page a Find, for sorts on fields the pipeline computes.
+func (p *Paginator) Stages() []bson.M {
+	var stages []bson.M
+	if p.cursor != nil {
+		stages = append(stages, bson.M{"$match": p.Filter(bson.M{})})
+	}
+	stages = append(stages, bson.M{"$sort": p.fetchSort()})
+	if p.cursor == nil && p.Page > 0 {
+		stages = append(stages, bson.M{"$skip": p.Page * p.Limit})
+	}
+	return append(stages, bson.M{"$limit": p.Limit + 1})
+}
 
-	fmt.Println("limt: ", mp.limit, "page: ", mp.page, "sort: ", mp.sort)
+// fetchSort is the sort documents are fetched in, reversed for a prev
+// cursor.
+func (p *Paginator) fetchSort() bson.D {
+	if p.cursor == nil || !p.cursor.Prev {
+		return p.sort
+	}
+	sort := make(bson.D, len(p.sort))
+	for i, e := range p.sort {
+		sort[i] = bson.E{Key: e.Key, Value: -direction(e.Value)}
+	}
+	return sort
+}
+
+// Paginate trims the documents returned by a Find made with FindOptions to
+// the page, in sort order, and works out the cursors around it.
+func (p *Paginator) Paginate(docs []bson.Raw) ([]bson.Raw, PageInfo) {
+	var info PageInfo
+
+	more := int64(len(docs)) > p.Limit
+	if more {
+		docs = docs[:p.Limit]
+	}
+
+	prev := p.cursor != nil && p.cursor.Prev
+	if prev {
+		for i, j := 0, len(docs)-1; i < j; i, j = i+1, j-1 {
+			docs[i], docs[j] = docs[j], docs[i]
+		}
+	}
+	if len(docs) == 0 {
+		return docs, info
+	}
+
+	// Going forward there is a previous page unless this is the first;
+	// going back there is a next page, the one the cursor came from
+	hasNext := more || prev
+	hasPrev := (prev && more) || (!prev && (p.cursor != nil || p.Page > 0))
+
+	if hasNext {
+		info.Next = p.encode(docs[len(docs)-1], false)
+	}
+	if hasPrev {
+		info.Prev = p.encode(docs[0], true)
+	}
+	return docs, info
+}
+
+func (p *Paginator) encode(doc bson.Raw, prev bool) string {
+	cursor := pageCursor{Prev: prev}
+	for _, e := range p.sort {
+		cursor.Keys = append(cursor.Keys, e.Key)
+		value := doc.Lookup(e.Key)
+		var v interface{}
+		if err := value.Unmarshal(&v); err != nil {
+			v = nil
+		}
+		cursor.Values = append(cursor.Values, v)
+	}
+
+	data, err := bson.Marshal(cursor)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(v string) (pageCursor, error) {
+	var cursor pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return cursor, err
+	}
+	if err := bson.Unmarshal(data, &cursor); err != nil {
+		return cursor, errors.New("malformed cursor")
+	}
+	return cursor, nil
+}
+
+func direction(v interface{}) int {
+	if descending(v) {
+		return -1
+	}
+	return 1
+}
 
-	return &options.FindOptions{
-		Limit: &mp.limit,
-		Skip:  &mp.page,
-		Sort:  mp.sort,
+func descending(v interface{}) bool {
+	switch d := v.(type) {
+	case int:
+		return d < 0
+	case int32:
+		return d < 0
+	case int64:
+		return d < 0
+	case float64:
+		return d < 0
 	}
+	return false
 }
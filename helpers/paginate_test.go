@@ -0,0 +1,58 @@
+package helpers
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPaginatorNullSortValue(t *testing.T) {
+	sort := bson.D{{Key: "category", Value: 1}, {Key: "_id", Value: 1}}
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+	raw := func(doc bson.M) bson.Raw {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			panic(err)
+		}
+		return data
+	}
+
+	paginator, err := NewPaginator(url.Values{"limit": {"1"}}, sort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, info := paginator.Paginate([]bson.Raw{raw(bson.M{"_id": first}), raw(bson.M{"_id": second, "category": nil})})
+	if info.Next == "" {
+		t.Fatal("no next cursor")
+	}
+
+	tests := []struct {
+		cursor string
+		want   bson.A
+	}{
+		// After a row without a category come the rows of any category and
+		// the other rows without one
+		{info.Next, bson.A{
+			bson.M{"$and": bson.A{bson.M{"category": bson.M{"$ne": nil}}}},
+			bson.M{"$and": bson.A{bson.M{"category": nil}, bson.M{"_id": bson.M{"$gt": first}}}},
+		}},
+		// Nothing sorts before a missing category
+		{paginator.encode(raw(bson.M{"_id": first}), true), bson.A{
+			bson.M{"$and": bson.A{bson.M{"category": nil}, bson.M{"$or": bson.A{bson.M{"_id": bson.M{"$lt": first}}, bson.M{"_id": nil}}}}},
+		}},
+	}
+
+	for _, tt := range tests {
+		p, err := NewPaginator(url.Values{"cursor": {tt.cursor}}, sort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter := p.Filter(bson.M{})
+		if got := filter["$and"].(bson.A)[0].(bson.M)["$or"]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("got %v, want %v", got, tt.want)
+		}
+	}
+}
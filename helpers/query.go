@@ -0,0 +1,227 @@
+package helpers
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// transactionFields maps the JSON names of transaction fields that can be
+// projected to their names in Mongo.
+var transactionFields = map[string]string{
+	"id":               "_id",
+	"account_id":       "account_id",
+	"amount":           "amount",
+	"transaction_date": "transactiondate",
+	"transaction_time": "transactiontime",
+	"value_date":       "valuedate",
+	"details":          "details",
+	"type":             "type",
+	"balance":          "balance",
+	"transaction_id":   "transactionid",
+	"merchant":         "merchant",
+	"category":         "category",
+	"notes":            "notes",
+	"tags":             "tags",
+	"splits":           "splits",
+	"excluded":         "excluded",
+	"source":           "source",
+	"statement_id":     "statement_id",
+	"is_transfer":      "istransfer",
+	"refund_of":        "refund_of",
+	"refunded_amount":  "refundedamount",
+	"batch_id":         "batch_id",
+}
+
+// sortableFields are the fields the list can be sorted on. Each is covered
+// by a {user_id, field} index or one that starts the same way.
+var sortableFields = map[string]string{
+	"transaction_date": "transactiondate",
+	"amount":           "amount",
+	"merchant":         "merchant",
+	"category":         "category",
+	"type":             "type",
+	"balance":          "balance",
+}
+
+// TransactionQuery is a validated transaction list query, ready to hand to
+// Find. Filter does not include the user scope.
+type TransactionQuery struct {
+	Filter     bson.M
+	Sort       bson.D
+	Projection bson.M
+}
+
+// QueryError is a query parameter that could not be understood.
+type QueryError struct {
+	Param   string
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Param, e.Message)
+}
+
+// ParseTransactionQuery builds a transaction query from list parameters:
+// start_date and end_date (YYYY-MM-DD, both inclusive), min_amount,
+// max_amount, type, account_id, category, merchant and tags (comma separated
+// lists), transfers (exclude or only), q (words starting with each term, as
+// searched from the searchterms index), sort (comma separated fields, - for
+// descending) and fields (comma separated projection).
+func ParseTransactionQuery(values url.Values) (TransactionQuery, error) {
+	query := TransactionQuery{Filter: bson.M{}}
+
+	daterange := bson.M{}
+	if v := values.Get("start_date"); v != "" {
+		start, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return query, &QueryError{"start_date", "expected YYYY-MM-DD"}
+		}
+		daterange["$gte"] = primitive.NewDateTimeFromTime(start)
+	}
+	if v := values.Get("end_date"); v != "" {
+		end, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return query, &QueryError{"end_date", "expected YYYY-MM-DD"}
+		}
+		if start, ok := daterange["$gte"].(primitive.DateTime); ok && end.Before(start.Time()) {
+			return query, &QueryError{"end_date", "before start_date"}
+		}
+		daterange["$lt"] = primitive.NewDateTimeFromTime(end.AddDate(0, 0, 1))
+	}
+	if len(daterange) > 0 {
+		query.Filter["transactiondate"] = daterange
+	}
+
+	amount := bson.M{}
+	var min, max float64
+	var err error
+	if v := values.Get("min_amount"); v != "" {
+		if min, err = strconv.ParseFloat(v, 64); err != nil || min < 0 {
+			return query, &QueryError{"min_amount", "expected a non-negative number"}
+		}
+		amount["$gte"] = min
+	}
+	if v := values.Get("max_amount"); v != "" {
+		if max, err = strconv.ParseFloat(v, 64); err != nil || max < 0 {
+			return query, &QueryError{"max_amount", "expected a non-negative number"}
+		}
+		if _, ok := amount["$gte"]; ok && max < min {
+			return query, &QueryError{"max_amount", "less than min_amount"}
+		}
+		amount["$lte"] = max
+	}
+	if len(amount) > 0 {
+		query.Filter["amount"] = amount
+	}
+
+	if v := values.Get("type"); v != "" {
+		t := strings.ToUpper(v)
+		if t != "DEBIT" && t != "CREDIT" {
+			return query, &QueryError{"type", "expected DEBIT or CREDIT"}
+		}
+		query.Filter["type"] = t
+	}
+
+	if v := values.Get("account_id"); v != "" {
+		var ids bson.A
+		for _, hex := range splitList(v) {
+			id, err := primitive.ObjectIDFromHex(hex)
+			if err != nil {
+				return query, &QueryError{"account_id", fmt.Sprintf("%q is not an id", hex)}
+			}
+			ids = append(ids, id)
+		}
+		query.Filter["account_id"] = bson.M{"$in": ids}
+	}
+
+	if v := splitList(values.Get("category")); len(v) > 0 {
+		query.Filter["category"] = bson.M{"$in": v}
+	}
+	if v := splitList(values.Get("merchant")); len(v) > 0 {
+		query.Filter["merchant"] = bson.M{"$in": v}
+	}
+	if v := splitList(values.Get("tags")); len(v) > 0 {
+		query.Filter["tags"] = bson.M{"$all": v}
+	}
+
+	switch values.Get("transfers") {
+	case "":
+	case "exclude":
+		query.Filter["istransfer"] = bson.M{"$ne": true}
+	case "only":
+		query.Filter["istransfer"] = true
+	default:
+		return query, &QueryError{"transfers", "expected exclude or only"}
+	}
+
+	if terms := SearchTerms(values.Get("q")); len(terms) > 0 {
+		for key, value := range SearchFilter(terms) {
+			query.Filter[key] = value
+		}
+	}
+
+	query.Sort, err = parseSort(values.Get("sort"))
+	if err != nil {
+		return query, err
+	}
+
+	if v := values.Get("fields"); v != "" {
+		query.Projection = bson.M{}
+		for _, field := range splitList(v) {
+			name, ok := transactionFields[field]
+			if !ok {
+				return query, &QueryError{"fields", fmt.Sprintf("unknown field %q", field)}
+			}
+			query.Projection[name] = 1
+		}
+	}
+
+	return query, nil
+}
+
+// parseSort reads a sort such as "-transaction_date,amount". The default is
+// newest first, and _id always breaks ties so the order is stable.
+func parseSort(v string) (bson.D, error) {
+	fields := splitList(v)
+	if len(fields) == 0 {
+		fields = []string{"-transaction_date"}
+	}
+
+	sort := bson.D{}
+	seen := make(map[string]bool)
+	for _, field := range fields {
+		direction := 1
+		if strings.HasPrefix(field, "-") {
+			direction = -1
+			field = field[1:]
+		}
+
+		name, ok := sortableFields[field]
+		if !ok {
+			return nil, &QueryError{"sort", fmt.Sprintf("cannot sort on %q", field)}
+		}
+		if seen[name] {
+			return nil, &QueryError{"sort", fmt.Sprintf("%q given twice", field)}
+		}
+		seen[name] = true
+		sort = append(sort, bson.E{Key: name, Value: direction})
+	}
+
+	return append(sort, bson.E{Key: "_id", Value: sort[len(sort)-1].Value}), nil
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
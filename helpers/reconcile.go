@@ -0,0 +1,108 @@
+package helpers
+
+import (
+	"math"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+// DeriveStatement reads the period and the opening and closing balances off
+// the transactions' own running balance. txns must be sorted with
+// SortForBalance. ok is false when the transactions carry no balance.
+func DeriveStatement(txns []models.Transaction) (statement models.Statement, ok bool) {
+	var first, last *models.Transaction
+	for i := range txns {
+		if txns[i].Balance == nil {
+			continue
+		}
+		if first == nil {
+			first = &txns[i]
+		}
+		last = &txns[i]
+	}
+	if first == nil {
+		return statement, false
+	}
+
+	statement.PeriodStart = txns[0].TransactionDate
+	statement.PeriodEnd = txns[len(txns)-1].TransactionDate
+	statement.OpeningBalance = round2(*first.Balance - SignedAmount(*first))
+	statement.ClosingBalance = round2(*last.Balance)
+	statement.Derived = true
+	return statement, true
+}
+
+// ReconcileStatement checks that the opening balance plus the period's
+// transactions adds up to the closing balance and lists the rows that break
+// the running balance. txns must be the statement's transactions sorted with
+// SortForBalance, and liability is as for CheckBalanceContinuity. The
+// statement's totals, issues and status are filled in.
+func ReconcileStatement(statement models.Statement, txns []models.Transaction, liability bool) models.Statement {
+	statement.TransactionCount = len(txns)
+	statement.TotalCredits, statement.TotalDebits = 0, 0
+	statement.Issues = []models.StatementIssue{}
+
+	for _, txn := range txns {
+		if txn.Type == models.Debit {
+			statement.TotalDebits += txn.Amount
+		} else {
+			statement.TotalCredits += txn.Amount
+		}
+	}
+	statement.TotalCredits = round2(statement.TotalCredits)
+	statement.TotalDebits = round2(statement.TotalDebits)
+	statement.ComputedClosing = round2(statement.OpeningBalance + statement.TotalCredits - statement.TotalDebits)
+	statement.Difference = round2(statement.ClosingBalance - statement.ComputedClosing)
+
+	// The first row with a balance has to follow from the opening balance
+	for _, txn := range txns {
+		if txn.Balance == nil {
+			continue
+		}
+		expected := statement.OpeningBalance + SignedAmount(txn)
+		if math.Abs(expected-*txn.Balance) > balanceTolerance {
+			statement.Issues = append(statement.Issues, models.StatementIssue{
+				Kind:          "opening_mismatch",
+				TransactionID: txn.ID,
+				Date:          txn.TransactionDate.Time().UTC().Format(time.DateOnly),
+				Expected:      round2(expected),
+				Actual:        round2(*txn.Balance),
+				Difference:    round2(*txn.Balance - expected),
+			})
+		}
+		break
+	}
+
+	for _, gap := range CheckBalanceContinuity(txns, liability) {
+		kind := "unmatched_row"
+		if gap.Reason == "missing_period" {
+			kind = "missing_rows"
+		}
+		statement.Issues = append(statement.Issues, models.StatementIssue{
+			Kind:          kind,
+			TransactionID: gap.TransactionID,
+			Date:          gap.To,
+			Expected:      gap.Expected,
+			Actual:        gap.Actual,
+			Difference:    gap.Difference,
+		})
+	}
+
+	if math.Abs(statement.Difference) > balanceTolerance {
+		statement.Issues = append(statement.Issues, models.StatementIssue{
+			Kind:       "closing_mismatch",
+			Date:       statement.PeriodEnd.Time().UTC().Format(time.DateOnly),
+			Expected:   statement.ClosingBalance,
+			Actual:     statement.ComputedClosing,
+			Difference: statement.Difference,
+		})
+	}
+
+	if len(statement.Issues) == 0 {
+		statement.Status = models.StatementReconciled
+	} else {
+		statement.Status = models.StatementUnreconciled
+	}
+	return statement
+}
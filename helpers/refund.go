@@ -0,0 +1,170 @@
+package helpers
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefundMatchThreshold is the score above which a credit is linked to its
+// originating debit automatically.
+const RefundMatchThreshold = 0.6
+
+var (
+	labelledReference = regexp.MustCompile(`\b(?:REF|RRN|UTR|TXN|ORDER)\s*(?:NO|ID)?[\s.:#/-]*([A-Z0-9]{6,22})\b`)
+	// upiReference is the RRN in the slash separated UPI narration, as in
+	// "UPI/DR/412345678901/SWIGGY/..." and the "UPI/CR/412345678901/..." of
+	// its reversal.
+	upiReference    = regexp.MustCompile(`\bUPI/(?:(?:DR|CR|REV|P2M|P2A)/)?(\d{12})(?:/|$)`)
+	refundNarration = regexp.MustCompile(`\b(REFUND|REFD|REVERSAL|REVERSED|REV|RETURN|CHARGEBACK|FAILED)\b`)
+)
+
+// RefundMatch links a CREDIT to the DEBIT it gives money back for. Amount is
+// the credit amount, which may be less than the debit for partial refunds.
+type RefundMatch struct {
+	CreditID primitive.ObjectID `json:"credit_id"`
+	DebitID  primitive.ObjectID `json:"debit_id"`
+	Amount   float64            `json:"amount"`
+	Partial  bool               `json:"partial"`
+	Score    float64            `json:"score"`
+	Reasons  []string           `json:"reasons"`
+}
+
+// ExtractReferences pulls UPI RRNs, UTRs and other reference numbers out of a
+// narration. Only numbers following a reference label or in the RRN place of
+// a UPI narration count: a bare long number may as well be an account, card
+// or phone number.
+func ExtractReferences(details string) []string {
+	upper := strings.ToUpper(details)
+	seen := make(map[string]bool)
+	var refs []string
+
+	matches := labelledReference.FindAllStringSubmatch(upper, -1)
+	matches = append(matches, upiReference.FindAllStringSubmatch(upper, -1)...)
+	for _, m := range matches {
+		if !seen[m[1]] && strings.ContainsAny(m[1], "0123456789") {
+			seen[m[1]] = true
+			refs = append(refs, m[1])
+		}
+	}
+	return refs
+}
+
+// IsRefundNarration reports whether a narration reads like a refund or a
+// reversal.
+func IsRefundNarration(details string) bool {
+	return refundNarration.MatchString(strings.ToUpper(details))
+}
+
+// MatchRefunds links credits to earlier debits by reference number, merchant,
+// amount and time. A debit can absorb several partial refunds up to its
+// amount, less what was already refunded.
+func MatchRefunds(debits, credits []models.Transaction, window time.Duration) []RefundMatch {
+	remaining := make(map[primitive.ObjectID]float64)
+	for _, debit := range debits {
+		remaining[debit.ID] = debit.Amount - debit.RefundedAmount
+	}
+
+	var candidates []RefundMatch
+	for _, credit := range credits {
+		if !credit.RefundOf.IsZero() || credit.Type != models.Credit {
+			continue
+		}
+		for _, debit := range debits {
+			if debit.Type != models.Debit {
+				continue
+			}
+			if match, ok := scoreRefund(debit, credit, window); ok {
+				candidates = append(candidates, match)
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+
+	used := make(map[primitive.ObjectID]bool)
+	var matches []RefundMatch
+	for _, match := range candidates {
+		if used[match.CreditID] || match.Amount > remaining[match.DebitID]+balanceTolerance {
+			continue
+		}
+		used[match.CreditID] = true
+		remaining[match.DebitID] -= match.Amount
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+func scoreRefund(debit, credit models.Transaction, window time.Duration) (RefundMatch, bool) {
+	match := RefundMatch{CreditID: credit.ID, DebitID: debit.ID, Amount: credit.Amount}
+
+	gap := credit.TransactionDate.Time().Sub(debit.TransactionDate.Time())
+	if gap < 0 || gap > window || credit.Amount > debit.Amount+balanceTolerance {
+		return match, false
+	}
+
+	score := 0.0
+	strong := false
+
+	debitRefs := make(map[string]bool)
+	for _, ref := range ExtractReferences(debit.Details) {
+		debitRefs[ref] = true
+	}
+	for _, ref := range ExtractReferences(credit.Details) {
+		if debitRefs[ref] {
+			score += 0.6
+			strong = true
+			match.Reasons = append(match.Reasons, "reference")
+			break
+		}
+	}
+
+	// The same UPI address on both sides counts like the same merchant,
+	// which the narration of a reversal often leaves out
+	if m := merchantOf(debit); m != "" && m == merchantOf(credit) {
+		score += 0.3
+		strong = true
+		match.Reasons = append(match.Reasons, "merchant")
+	} else if c := counterpartyOf(debit); c != "" && c == counterpartyOf(credit) {
+		score += 0.3
+		strong = true
+		match.Reasons = append(match.Reasons, "counterparty")
+	}
+
+	if IsRefundNarration(credit.Details) {
+		score += 0.2
+		match.Reasons = append(match.Reasons, "refund_narration")
+	}
+
+	if math.Abs(credit.Amount-debit.Amount) <= balanceTolerance {
+		score += 0.2
+		match.Reasons = append(match.Reasons, "full_amount")
+	} else {
+		match.Partial = true
+		score += 0.05
+	}
+
+	if !strong {
+		return match, false
+	}
+
+	if window > 0 {
+		score += 0.1 * (1 - float64(gap)/float64(window))
+	}
+
+	match.Score = round2(math.Min(score, 1))
+	return match, true
+}
+
+// counterpartyOf is the UPI address on the other side of a transaction.
+func counterpartyOf(txn models.Transaction) string {
+	if txn.Counterparty != "" {
+		return strings.ToLower(txn.Counterparty)
+	}
+	return ExtractCounterparty(txn.Details)
+}
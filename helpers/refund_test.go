@@ -0,0 +1,98 @@
+package helpers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestExtractReferences(t *testing.T) {
+	tests := []struct {
+		details string
+		want    []string
+	}{
+		{"NEFT UTR: 412345678901 ACME PAYROLL", []string{"412345678901"}},
+		{"UPI RRN 412345678901 SWIGGY", []string{"412345678901"}},
+		{"IMPS Ref No. 1234567890 RAHUL", []string{"1234567890"}},
+		{"Txn ID: AXB12345678 AMAZON", []string{"AXB12345678"}},
+		{"TRANSFER TO A/C 501004567890123", nil},
+		{"CARD 4111111111111111 DMART", nil},
+		{"REFUND FROM FLIPKART 9876543210", nil},
+		{"PREF 1234567890", nil},
+		{"UPI/DR/412345678901/SWIGGY/YESB/swiggy@ybl/Payment", []string{"412345678901"}},
+		{"UPI/CR/412345678901/Swiggy Limi/YESB/swiggy@ybl/REV", []string{"412345678901"}},
+		{"UPI/98765432109876/RAHUL", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.details, func(t *testing.T) {
+			if got := ExtractReferences(tt.details); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchRefundsUPIReversal(t *testing.T) {
+	day := time.Date(2024, 3, 13, 0, 0, 0, 0, time.UTC)
+	txn := func(kind models.TransactionType, days int, details string) models.Transaction {
+		return models.Transaction{
+			ID:              primitive.NewObjectID(),
+			Type:            kind,
+			Amount:          349,
+			TransactionDate: primitive.NewDateTimeFromTime(day.AddDate(0, 0, days)),
+			Details:         details,
+		}
+	}
+
+	tests := []struct {
+		name          string
+		debit, credit models.Transaction
+		// reason is the one that links the pair, or empty for no link
+		reason string
+	}{
+		{
+			"same rrn",
+			txn(models.Debit, 0, "UPI/DR/412345678901/SWIGGY/YESB/swiggy@ybl/Payment"),
+			txn(models.Credit, 2, "UPI/CR/412345678901/Swiggy Limi/YESB/swiggy@ybl/REV"),
+			"reference",
+		},
+		{
+			"same upi address under another name",
+			txn(models.Debit, 0, "UPI/DR/412345678900/SWIGGY/YESB/swiggy@ybl/Payment"),
+			txn(models.Credit, 3, "UPI/CR/498765432109/BUNDL TECH/YESB/swiggy@ybl/Reversal"),
+			"counterparty",
+		},
+		{
+			"unrelated",
+			txn(models.Debit, 0, "UPI/DR/498765432100/PAYU/HDFC/payu@hdfc/order"),
+			txn(models.Credit, 3, "UPI/CR/498765432109/BUNDL TECH/YESB/swiggy@ybl/Reversal"),
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := MatchRefunds([]models.Transaction{tt.debit}, []models.Transaction{tt.credit}, 30*24*time.Hour)
+			if tt.reason == "" {
+				if len(matches) != 0 {
+					t.Errorf("got %+v, want no match", matches)
+				}
+				return
+			}
+			if len(matches) != 1 || matches[0].Score < RefundMatchThreshold {
+				t.Fatalf("got %+v, want one match above the threshold", matches)
+			}
+			found := false
+			for _, reason := range matches[0].Reasons {
+				found = found || reason == tt.reason
+			}
+			if !found {
+				t.Errorf("reasons %v, want %q", matches[0].Reasons, tt.reason)
+			}
+		})
+	}
+}
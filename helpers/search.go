@@ -0,0 +1,222 @@
+package helpers
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var vpaPattern = regexp.MustCompile(`(?i)\b[a-z0-9][a-z0-9._-]{1,}@[a-z][a-z0-9]{1,}\b`)
+
+// ExtractCounterparty pulls the UPI address of the other side out of a
+// narration, such as "swiggy@ybl" in "UPI/DR/1234/SWIGGY/YESB/swiggy@ybl".
+func ExtractCounterparty(details string) string {
+	return strings.ToLower(vpaPattern.FindString(details))
+}
+
+// searchFields are the fields search looks in, with how much a hit in each
+// one counts towards the score.
+var searchFields = []struct {
+	json, bson string
+	weight     float64
+}{
+	{"merchant", "merchant", 3},
+	{"counterparty", "counterparty", 2},
+	{"details", "details", 1},
+	{"notes", "notes", 1.5},
+}
+
+// SearchHit is a transaction matching a search, with its relevance and a
+// highlighted snippet for each field that matched.
+type SearchHit struct {
+	models.Transaction `bson:",inline"`
+	Score              float64           `json:"score" bson:"score"`
+	Highlights         map[string]string `json:"highlights" bson:"-"`
+}
+
+// SearchTerms splits a search into lowercase terms on anything that is not a
+// letter or digit, so "IRCTC/UPI" searches for "irctc" and "upi".
+func SearchTerms(q string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, term := range strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// searchPrefixMax is the longest word prefix stored in the search index.
+// Longer terms are looked up by their first searchPrefixMax letters and then
+// matched in full.
+const searchPrefixMax = 16
+
+// SearchTokens is the search index of a transaction: every prefix of every
+// word of its merchant, counterparty, narration and notes, up to
+// searchPrefixMax letters, so that a term is found by an exact match on an
+// indexed array.
+func SearchTokens(txn models.Transaction) []string {
+	tokens := []string{}
+	seen := make(map[string]bool)
+	for _, value := range []string{txn.Merchant, txn.Counterparty, txn.Details, txn.Notes} {
+		for _, word := range SearchTerms(value) {
+			runes := []rune(word)
+			for n := 1; n <= len(runes) && n <= searchPrefixMax; n++ {
+				prefix := string(runes[:n])
+				if !seen[prefix] {
+					seen[prefix] = true
+					tokens = append(tokens, prefix)
+				}
+			}
+		}
+	}
+	return tokens
+}
+
+// termPattern matches term at the start of a word.
+func termPattern(term string) string {
+	return `(^|[^a-z0-9])` + regexp.QuoteMeta(term)
+}
+
+// SearchFilter is the Mongo filter for transactions where every term starts
+// a word in at least one of the searched fields. It is answered from the
+// searchterms index; terms longer than the prefixes stored there are then
+// checked against the fields themselves.
+func SearchFilter(terms []string) bson.M {
+	prefixes := bson.A{}
+	and := bson.A{}
+	for _, term := range terms {
+		runes := []rune(term)
+		if len(runes) <= searchPrefixMax {
+			prefixes = append(prefixes, term)
+			continue
+		}
+		prefixes = append(prefixes, string(runes[:searchPrefixMax]))
+		or := bson.A{}
+		for _, field := range searchFields {
+			or = append(or, bson.M{field.bson: primitive.Regex{Pattern: termPattern(term), Options: "i"}})
+		}
+		and = append(and, bson.M{"$or": or})
+	}
+
+	filter := bson.M{"searchterms": bson.M{"$all": prefixes}}
+	if len(and) > 0 {
+		filter["$and"] = and
+	}
+	return filter
+}
+
+// SearchScore is the aggregation expression scoring a transaction against
+// the terms: for each term, the weight of every field a word of which it
+// starts, doubled when it is the whole word.
+func SearchScore(terms []string) bson.M {
+	add := bson.A{}
+	for _, term := range terms {
+		for _, field := range searchFields {
+			input := bson.M{"$ifNull": bson.A{"$" + field.bson, ""}}
+			add = append(add, bson.M{"$cond": bson.A{
+				bson.M{"$regexMatch": bson.M{"input": input, "regex": termPattern(term) + `($|[^a-z0-9])`, "options": "i"}},
+				field.weight * 2,
+				bson.M{"$cond": bson.A{
+					bson.M{"$regexMatch": bson.M{"input": input, "regex": termPattern(term), "options": "i"}},
+					field.weight,
+					0,
+				}},
+			}})
+		}
+	}
+	return bson.M{"$round": bson.A{bson.M{"$add": add}, 2}}
+}
+
+// SearchHighlights are the highlighted snippets of the fields of txn that
+// the terms match, keyed by their JSON names.
+func SearchHighlights(txn models.Transaction, terms []string) map[string]string {
+	patterns := make([]*regexp.Regexp, len(terms))
+	for i, term := range terms {
+		patterns[i] = regexp.MustCompile(`(?i)` + termPattern(term))
+	}
+
+	values := map[string]string{
+		"merchant":     txn.Merchant,
+		"counterparty": txn.Counterparty,
+		"details":      txn.Details,
+		"notes":        txn.Notes,
+	}
+	highlights := make(map[string]string)
+	for _, field := range searchFields {
+		if snippet := highlight(values[field.json], patterns); snippet != "" {
+			highlights[field.json] = snippet
+		}
+	}
+	return highlights
+}
+
+const snippetRadius = 40
+
+// highlight wraps the term matches in value with <mark> tags, trimmed to a
+// window of snippetRadius characters around the first one. The text around
+// the tags is HTML escaped.
+func highlight(value string, patterns []*regexp.Regexp) string {
+	type span struct{ start, end int }
+	var spans []span
+	for _, pattern := range patterns {
+		for _, loc := range pattern.FindAllStringIndex(value, -1) {
+			// Skip the separator matched before the word
+			start := loc[0]
+			if r, size := utf8.DecodeRuneInString(value[start:]); size > 0 && !isWordRune(r) {
+				start += size
+			}
+			spans = append(spans, span{start, loc[1]})
+		}
+	}
+	if len(spans) == 0 {
+		return ""
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	from, to := spans[0].start, spans[0].end
+	for n := 0; n < snippetRadius && from > 0; n++ {
+		_, size := utf8.DecodeLastRuneInString(value[:from])
+		from -= size
+	}
+	for n := 0; n < snippetRadius && to < len(value); n++ {
+		_, size := utf8.DecodeRuneInString(value[to:])
+		to += size
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, s := range spans {
+		if s.start < pos || s.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(value[pos:s.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(value[s.start:s.end]))
+		b.WriteString("</mark>")
+		pos = s.end
+	}
+	b.WriteString(html.EscapeString(value[pos:to]))
+	if to < len(value) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
@@ -0,0 +1,94 @@
+package helpers
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		terms []string
+		want  string
+	}{
+		{"prefix", "UPI/DR/SWIGGY/swiggy@ybl", []string{"swig"}, "UPI/DR/<mark>SWIG</mark>GY/<mark>swig</mark>gy@ybl"},
+		{"escapes markup", `<script>alert("x")</script> AMAZON & co`, []string{"amazon"}, `&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; <mark>AMAZON</mark> &amp; co`},
+		{"after a multibyte separator", "₹SWIGGY", []string{"swiggy"}, "₹<mark>SWIGGY</mark>"},
+		{"no match", "ZOMATO", []string{"swiggy"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patterns []*regexp.Regexp
+			for _, term := range tt.terms {
+				patterns = append(patterns, regexp.MustCompile(`(?i)`+termPattern(term)))
+			}
+			if got := highlight(tt.value, patterns); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHighlightTrimsByRune(t *testing.T) {
+	value := strings.Repeat("चाय ", 30) + "SWIGGY " + strings.Repeat("नमस्ते ", 30)
+	got := highlight(value, []*regexp.Regexp{regexp.MustCompile(`(?i)` + termPattern("swiggy"))})
+	if !utf8.ValidString(got) {
+		t.Fatalf("snippet is not valid UTF-8: %q", got)
+	}
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") || !strings.Contains(got, "<mark>SWIGGY</mark>") {
+		t.Errorf("got %q", got)
+	}
+	if n := utf8.RuneCountInString(got); n > 2*snippetRadius+len("<mark>SWIGGY</mark>")+2 {
+		t.Errorf("snippet of %d characters is longer than the window", n)
+	}
+}
+
+func TestSearchTokens(t *testing.T) {
+	txn := models.Transaction{Merchant: "Swiggy", Counterparty: "swiggy@ybl", Details: "UPI/DR/407512345678", Notes: "Team lunch"}
+	tokens := SearchTokens(txn)
+	for _, want := range []string{"s", "sw", "swiggy", "ybl", "upi", "dr", "407512345678", "team", "lunch"} {
+		if !slices.Contains(tokens, want) {
+			t.Errorf("tokens lack %q: %v", want, tokens)
+		}
+	}
+	if slices.Contains(tokens, "iggy") {
+		t.Errorf("tokens hold a word's middle: %v", tokens)
+	}
+}
+
+func TestSearchFilterLongTerms(t *testing.T) {
+	long := "supercalifragilisticexpialidocious"
+	filter := SearchFilter([]string{"swig", long})
+
+	all := filter["searchterms"].(bson.M)["$all"].(bson.A)
+	if len(all) != 2 || all[0] != "swig" || all[1] != long[:searchPrefixMax] {
+		t.Errorf("searchterms $all %v", all)
+	}
+	// The long term is matched in full against the fields
+	if and, ok := filter["$and"].(bson.A); !ok || len(and) != 1 {
+		t.Errorf("$and %v, want one clause for the long term", filter["$and"])
+	}
+}
+
+func TestParseTransactionQuerySearchesTheIndex(t *testing.T) {
+	query, err := ParseTransactionQuery(url.Values{"q": {"Swiggy  BLR"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := query.Filter["details"]; ok {
+		t.Errorf("q filters details directly: %v", query.Filter)
+	}
+	all, _ := query.Filter["searchterms"].(bson.M)["$all"].(bson.A)
+	if len(all) != 2 || all[0] != "swiggy" || all[1] != "blr" {
+		t.Errorf("searchterms $all %v", all)
+	}
+}
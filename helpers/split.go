@@ -0,0 +1,60 @@
+package helpers
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+// maxSplits caps how many parts a transaction can be split into.
+const maxSplits = 50
+
+// NormalizeSplits checks that the parts of a split transaction add up to its
+// amount, to the paisa, and tidies their categories and tags. A part without
+// a category falls in UncategorizedCategory. No parts at all is valid and
+// means the transaction is not split.
+func NormalizeSplits(amount float64, splits []models.TransactionSplit) ([]models.TransactionSplit, error) {
+	if len(splits) == 0 {
+		return nil, nil
+	}
+	if len(splits) == 1 {
+		return nil, fmt.Errorf("a split needs at least two parts")
+	}
+	if len(splits) > maxSplits {
+		return nil, fmt.Errorf("a split can have at most %d parts", maxSplits)
+	}
+
+	var total int64
+	normalized := make([]models.TransactionSplit, len(splits))
+	for i, part := range splits {
+		if part.Amount <= 0 {
+			return nil, fmt.Errorf("part %d: amount must be positive", i+1)
+		}
+		total += paise(part.Amount)
+
+		part.Category = strings.TrimSpace(part.Category)
+		if part.Category == "" {
+			part.Category = UncategorizedCategory
+		}
+		var tags []string
+		for _, tag := range part.Tags {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+		part.Tags = tags
+		part.Note = strings.TrimSpace(part.Note)
+		normalized[i] = part
+	}
+
+	if total != paise(amount) {
+		return nil, fmt.Errorf("parts add up to %.2f, not the amount of %.2f", float64(total)/100, amount)
+	}
+	return normalized, nil
+}
+
+func paise(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
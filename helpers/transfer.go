@@ -0,0 +1,177 @@
+package helpers
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TransferMatchThreshold is the score above which a pair is marked as an
+// internal transfer without asking the user, unless being on two accounts
+// is all that links the pair.
+const TransferMatchThreshold = 0.7
+
+var selfTransferHints = []string{"SELF", "OWN ACCOUNT", "OWN A/C", "CC PAYMENT", "CREDIT CARD PAYMENT", "CARD PAYMENT", "AUTOPAY", "SWEEP"}
+
+// TransferMatch pairs a DEBIT with the CREDIT on another of the user's
+// accounts that received the same money.
+type TransferMatch struct {
+	DebitID  primitive.ObjectID `json:"debit_id"`
+	CreditID primitive.ObjectID `json:"credit_id"`
+	Amount   float64            `json:"amount"`
+	Score    float64            `json:"score"`
+	Reasons  []string           `json:"reasons"`
+	// AutoLink is set when the pair is certain enough to link without
+	// asking the user.
+	AutoLink bool `json:"auto_link"`
+}
+
+// MatchTransfers pairs opposite type transactions of equal amount within
+// window of each other across the user's accounts. Narrations that mention
+// one of the user's own account numbers or VPAs raise the score. Pairs the
+// user dismissed are skipped. Each transaction is used in at most one pair,
+// best scores first.
+func MatchTransfers(txns []models.Transaction, accounts []models.Account, window time.Duration) []TransferMatch {
+	var debits, credits []models.Transaction
+	for _, txn := range txns {
+		if txn.IsTransfer {
+			continue
+		}
+		if txn.Type == models.Debit {
+			debits = append(debits, txn)
+		} else if txn.Type == models.Credit {
+			credits = append(credits, txn)
+		}
+	}
+
+	var candidates []TransferMatch
+	for _, debit := range debits {
+		for _, credit := range credits {
+			if math.Abs(debit.Amount-credit.Amount) > balanceTolerance {
+				continue
+			}
+			gap := debit.TransactionDate.Time().Sub(credit.TransactionDate.Time())
+			if gap < 0 {
+				gap = -gap
+			}
+			if gap > window || transferDismissed(debit, credit) {
+				continue
+			}
+			if match, ok := scoreTransfer(debit, credit, accounts, gap, window); ok {
+				candidates = append(candidates, match)
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+
+	used := make(map[primitive.ObjectID]bool)
+	var matches []TransferMatch
+	for _, match := range candidates {
+		if used[match.DebitID] || used[match.CreditID] {
+			continue
+		}
+		used[match.DebitID], used[match.CreditID] = true, true
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// transferDismissed tells whether the user unlinked debit and credit from
+// each other before.
+func transferDismissed(debit, credit models.Transaction) bool {
+	for _, id := range debit.DismissedTransfers {
+		if id == credit.ID {
+			return true
+		}
+	}
+	for _, id := range credit.DismissedTransfers {
+		if id == debit.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func scoreTransfer(debit, credit models.Transaction, accounts []models.Account, gap, window time.Duration) (TransferMatch, bool) {
+	match := TransferMatch{DebitID: debit.ID, CreditID: credit.ID, Amount: debit.Amount}
+
+	differentAccounts := !debit.AccountID.IsZero() && !credit.AccountID.IsZero() && debit.AccountID != credit.AccountID
+	if !debit.AccountID.IsZero() && debit.AccountID == credit.AccountID {
+		return match, false
+	}
+
+	score := 0.3
+	if differentAccounts {
+		score += 0.3
+		match.Reasons = append(match.Reasons, "different_accounts")
+	}
+
+	for _, account := range accounts {
+		if account.ID == credit.AccountID && mentionsAccount(debit.Details, account) {
+			score += 0.3
+			match.Reasons = append(match.Reasons, "debit_mentions_destination")
+			break
+		}
+	}
+	for _, account := range accounts {
+		if account.ID == debit.AccountID && mentionsAccount(credit.Details, account) {
+			score += 0.3
+			match.Reasons = append(match.Reasons, "credit_mentions_source")
+			break
+		}
+	}
+
+	for _, details := range []string{debit.Details, credit.Details} {
+		upper := strings.ToUpper(details)
+		for _, hint := range selfTransferHints {
+			if strings.Contains(upper, hint) {
+				score += 0.1
+				match.Reasons = append(match.Reasons, "self_transfer_narration")
+				break
+			}
+		}
+	}
+
+	// Without separate accounts only narration hints can tell a transfer apart
+	if !differentAccounts && len(match.Reasons) == 0 {
+		return match, false
+	}
+
+	if window > 0 {
+		score += 0.1 * (1 - float64(gap)/float64(window))
+	}
+
+	match.Score = round2(math.Min(score, 1))
+	// Equal amounts on two accounts close together are common enough that a
+	// narration has to point at the transfer too
+	match.AutoLink = match.Score >= TransferMatchThreshold && (!differentAccounts || len(match.Reasons) > 1)
+	return match, true
+}
+
+// mentionsAccount reports whether a narration refers to the account through
+// the last digits of its number or one of its VPAs.
+func mentionsAccount(details string, account models.Account) bool {
+	lower := strings.ToLower(details)
+
+	for _, vpa := range account.VPAs {
+		if vpa != "" && strings.Contains(lower, strings.ToLower(vpa)) {
+			return true
+		}
+	}
+
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, account.Number)
+	if len(digits) >= 4 {
+		return strings.Contains(lower, digits[len(digits)-4:])
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMatchTransfersAutoLink(t *testing.T) {
+	savings := models.Account{ID: primitive.NewObjectID(), Name: "HDFC Savings", Number: "50100123454321", VPAs: []string{"asha@okhdfc"}}
+	card := models.Account{ID: primitive.NewObjectID(), Name: "ICICI Card", Number: "XXXX XXXX XXXX 9876"}
+	accounts := []models.Account{savings, card}
+	day := time.Date(2024, 3, 13, 0, 0, 0, 0, time.UTC)
+	txn := func(account models.Account, kind models.TransactionType, days int, details string) models.Transaction {
+		return models.Transaction{
+			ID:              primitive.NewObjectID(),
+			AccountID:       account.ID,
+			TransactionDate: primitive.NewDateTimeFromTime(day.AddDate(0, 0, days)),
+			Amount:          15000,
+			Type:            kind,
+			Details:         details,
+		}
+	}
+
+	tests := []struct {
+		name          string
+		debit, credit models.Transaction
+		// link is whether the pair is linked without asking the user
+		link bool
+	}{
+		{"same day, nothing in common but the amount", txn(savings, models.Debit, 0, "NEFT ACME TRADERS"), txn(card, models.Credit, 0, "NEFT RAHUL SHARMA"), false},
+		{"debit names the card", txn(savings, models.Debit, 0, "BILLPAY ICICI CARD 9876"), txn(card, models.Credit, 1, "PAYMENT RECEIVED"), true},
+		{"credit names the vpa", txn(savings, models.Debit, 0, "UPI/DR/412345678901/ICICI"), txn(card, models.Credit, 0, "UPI FROM ASHA@OKHDFC"), true},
+		{"card payment narration", txn(savings, models.Debit, 0, "CC PAYMENT"), txn(card, models.Credit, 0, "THANK YOU FOR THE PAYMENT"), true},
+		{"same account", txn(savings, models.Debit, 0, "SELF TRANSFER"), txn(savings, models.Credit, 0, "SELF TRANSFER"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := MatchTransfers([]models.Transaction{tt.debit, tt.credit}, accounts, 3*24*time.Hour)
+			if link := len(matches) > 0 && matches[0].AutoLink; link != tt.link {
+				t.Errorf("auto link %v (%+v), want %v", link, matches, tt.link)
+			}
+		})
+	}
+}
+
+func TestMatchTransfersDismissed(t *testing.T) {
+	savings, card := primitive.NewObjectID(), primitive.NewObjectID()
+	day := primitive.NewDateTimeFromTime(time.Date(2024, 3, 13, 0, 0, 0, 0, time.UTC))
+	debit := models.Transaction{ID: primitive.NewObjectID(), AccountID: savings, TransactionDate: day, Amount: 500, Type: models.Debit, Details: "SELF TRANSFER"}
+	credit := models.Transaction{ID: primitive.NewObjectID(), AccountID: card, TransactionDate: day, Amount: 500, Type: models.Credit, Details: "SELF TRANSFER"}
+	debit.DismissedTransfers = []primitive.ObjectID{credit.ID}
+
+	if matches := MatchTransfers([]models.Transaction{debit, credit}, nil, 24*time.Hour); len(matches) != 0 {
+		t.Errorf("got %+v, want no match", matches)
+	}
+}
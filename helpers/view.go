@@ -0,0 +1,96 @@
+package helpers
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+// viewFilters are the list parameters a saved view may store.
+var viewFilters = map[string]bool{
+	"start_date":        true,
+	"end_date":          true,
+	"min_amount":        true,
+	"max_amount":        true,
+	"type":              true,
+	"account_id":        true,
+	"category":          true,
+	"merchant":          true,
+	"tags":              true,
+	"transfers":         true,
+	"q":                 true,
+	"include_transfers": true,
+	"net":               true,
+}
+
+// PeriodStart is the first day of a rolling period such as "30d", "3m" or
+// "1y" that ends today.
+func PeriodStart(period string, now time.Time) (time.Time, error) {
+	if len(period) < 2 {
+		return time.Time{}, errors.New("expected a number followed by d, w, m or y")
+	}
+	n, err := strconv.Atoi(period[:len(period)-1])
+	if err != nil || n <= 0 {
+		return time.Time{}, errors.New("expected a number followed by d, w, m or y")
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch period[len(period)-1] {
+	case 'd':
+		return today.AddDate(0, 0, -n), nil
+	case 'w':
+		return today.AddDate(0, 0, -7*n), nil
+	case 'm':
+		return today.AddDate(0, -n, 0), nil
+	case 'y':
+		return today.AddDate(-n, 0, 0), nil
+	}
+	return time.Time{}, errors.New("expected a number followed by d, w, m or y")
+}
+
+// ValidateView checks that a view only stores known filters and that they,
+// its period and its sort make a valid transaction query.
+func ValidateView(view models.SavedView) error {
+	for key := range view.Filters {
+		if !viewFilters[key] {
+			return &QueryError{key, "cannot be saved in a view"}
+		}
+	}
+	if view.Period != "" {
+		if _, err := PeriodStart(view.Period, time.Now()); err != nil {
+			return &QueryError{"period", err.Error()}
+		}
+		if view.Filters["start_date"] != "" {
+			return &QueryError{"period", "cannot be combined with start_date"}
+		}
+	}
+	_, err := ParseTransactionQuery(ViewValues(view, nil, time.Now()))
+	return err
+}
+
+// ViewValues are the list parameters of a view, with its period resolved
+// against now. Parameters in override take precedence over the view's own.
+func ViewValues(view models.SavedView, override url.Values, now time.Time) url.Values {
+	values := url.Values{}
+	for key, value := range view.Filters {
+		values.Set(key, value)
+	}
+	if view.Period != "" {
+		if start, err := PeriodStart(view.Period, now); err == nil {
+			values.Set("start_date", start.Format("2006-01-02"))
+		}
+	}
+	if view.Sort != "" {
+		values.Set("sort", view.Sort)
+	}
+	if view.GroupBy != "" {
+		values.Set("group_by", view.GroupBy)
+	}
+	for key, value := range override {
+		values[key] = value
+	}
+	return values
+}
@@ -0,0 +1,377 @@
+package importers
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+// ErrNotTransaction is returned for messages that are not about money
+// moving, such as OTPs, declined payments and payment reminders.
+var ErrNotTransaction = errors.New("not a transaction alert")
+
+// Message is an SMS or email as received, with the sender ID or address it
+// came from. Row is its number in the file it was read from, when that is not
+// its place in the batch.
+type Message struct {
+	Sender   string
+	Text     string
+	Received time.Time
+	Row      int
+}
+
+// Alert is a transaction read from a bank's SMS or email alert. The
+// record's Account holds the masked account or card number the alert names,
+// such as "XX1234".
+type Alert struct {
+	Bank     string
+	Template string
+	Record   Record
+}
+
+// alertBank is an issuer whose alerts are recognised, by the words its
+// sender IDs and messages use.
+type alertBank struct {
+	name     string
+	bank     string
+	keywords []string
+}
+
+var alertBanks = []alertBank{
+	{name: "hdfc", bank: "HDFC Bank", keywords: []string{"hdfc"}},
+	{name: "icici", bank: "ICICI Bank", keywords: []string{"icici"}},
+	{name: "sbi", bank: "SBI", keywords: []string{"sbi", "state bank"}},
+	{name: "axis", bank: "Axis Bank", keywords: []string{"axis"}},
+	{name: "kotak", bank: "Kotak Mahindra Bank", keywords: []string{"kotak"}},
+	{name: "amex", bank: "American Express", keywords: []string{"amex", "american express"}},
+	{name: "idfc", bank: "IDFC FIRST Bank", keywords: []string{"idfc"}},
+}
+
+// alertTemplate is the wording of one kind of alert. Spaces in the template
+// match any run of whitespace, including none, and the placeholders below
+// capture the fields. kind is empty when a dir placeholder says which way
+// the money went.
+type alertTemplate struct {
+	name     string
+	bank     string
+	kind     models.TransactionType
+	template string
+	pattern  *regexp.Regexp
+}
+
+var alertPlaceholders = strings.NewReplacer(
+	"{amount}", `(?:rs\.?|inr|₹)\s*(?P<amount>\d[\d,]*(?:\.\d+)?)`,
+	"{value}", `(?:rs\.?|inr|₹)?\s*(?P<amount>\d[\d,]*(?:\.\d+)?)`,
+	"{account}", `(?P<account>[x*.]*\s?\d{3,6})\b`,
+	"{date}", `(?P<date>\d{4}-\d{2}-\d{2}|\d{1,2}[-/.]\d{1,2}[-/.]\d{2,4}|\d{1,2}[- ]?[a-z]{3,9}[- ,]*\d{2,4}|[a-z]{3,9}\.? \d{1,2},? \d{4})`+
+		`(?:(?:\s*at)?[:,\s]\s*(?P<time>\d{1,2}:\d{2}(?::\d{2})?(?:[ \t]*[ap]m)?))?`,
+	"{party}", `(?P<party>[^\n]+?)`,
+	"{ref}", `(?P<ref>[a-z0-9]{6,22})`,
+	"{dir}", `(?P<dir>debited|credited|spent|sent|received|deposited|withdrawn)`,
+	" ", `\s*`,
+)
+
+// alertTemplates are tried in order: the wording of each issuer first, only
+// for messages that mention it, then generic wordings for any bank.
+var alertTemplates = compileAlertTemplates([]alertTemplate{
+	{name: "hdfc_upi_sent", bank: "hdfc", kind: models.Debit,
+		template: `sent {amount} from hdfc bank a/c {account} to {party} on {date}`},
+	{name: "hdfc_debited", bank: "hdfc", kind: models.Debit,
+		template: `{amount} (?:has been )?debited from (?:your )?(?:hdfc bank )?(?:a/c|account) {account} to (?:vpa )?{party} on {date}`},
+	{name: "hdfc_debited_vpa", bank: "hdfc", kind: models.Debit,
+		template: `{amount} (?:has been )?debited from (?:your )?(?:hdfc bank )?(?:a/c|account) {account} on {date} to (?:vpa )?{party} (?:\(|\.(?:\s|$))`},
+	{name: "hdfc_card_spent", bank: "hdfc", kind: models.Debit,
+		template: `spent {amount} on hdfc bank (?:credit |debit )?card {account} at {party} on {date}`},
+	{name: "hdfc_card_email", bank: "hdfc", kind: models.Debit,
+		template: `using your hdfc bank (?:credit|debit) card (?:ending )?{account} for {amount} at {party} on {date}`},
+	{name: "hdfc_deposited", bank: "hdfc", kind: models.Credit,
+		template: `{amount} deposited (?:in|to) (?:your )?hdfc bank a/c {account} on {date} for {party}(?:\.\s*avl|\.\s*$|\n|$)`},
+	{name: "hdfc_upi_received", bank: "hdfc", kind: models.Credit,
+		template: `received\W*{amount} in (?:your )?hdfc bank a/c {account} on {date} by {party} \(`},
+	{name: "hdfc_credited", bank: "hdfc", kind: models.Credit,
+		template: `{amount} (?:has been )?credited to (?:your )?(?:hdfc bank )?(?:a/c|account) {account} (?:from|by) {party} on {date}`},
+
+	{name: "icici_debited", bank: "icici", kind: models.Debit,
+		template: `icici bank acc?t {account} (?:is )?debited (?:for|with) {amount} on {date}[;.]? {party} credited`},
+	{name: "icici_card_spent", bank: "icici", kind: models.Debit,
+		template: `{amount} spent (?:using|on) icici bank card {account} on {date} (?:on|at) {party}\.(?:\s|$)`},
+	{name: "icici_card_email", bank: "icici", kind: models.Debit,
+		template: `icici bank credit card {account} has been used for a transaction of {amount} on {date}\.? info: {party}\.(?:\s|$)`},
+	{name: "icici_credited", bank: "icici", kind: models.Credit,
+		template: `acc?t {account} (?:is )?credited (?:with|by) {amount} on {date} (?:from|by) {party}\.(?:\s|$)`},
+
+	{name: "sbi_upi_debited", bank: "sbi", kind: models.Debit,
+		template: `a/c {account} debited by {value} on date {date} trf to {party} ref ?no`},
+	{name: "sbi_upi_credited", bank: "sbi", kind: models.Credit,
+		template: `a/c ?{account} (?:is )?credited by {value} on {date} by {party} \(?ref`},
+	{name: "sbi_transfer_debit", bank: "sbi", kind: models.Debit,
+		template: `a/c (?:no\.? )?{account} has a debit by [a-z]+(?: [a-z]+)? of {amount} on {date}`},
+	{name: "sbi_credited", bank: "sbi", kind: models.Credit,
+		template: `a/c (?:no\.? )?{account} is credited by {amount} on {date} by {party} \(`},
+	{name: "sbi_card_spent", bank: "sbi", kind: models.Debit,
+		template: `(?:transaction of )?{amount} (?:spent|made) on your sbi credit card ending (?:with )?{account} at {party} on {date}`},
+
+	{name: "axis_account", bank: "axis", kind: "",
+		template: `{amount} {dir} a/c no\. {account} {date}(?: ist)? (?P<party>(?:upi|neft|imps|rtgs)/[^\n]+)`},
+	{name: "axis_card_spent", bank: "axis", kind: models.Debit,
+		template: `spent card no\. {account} {amount} {date} {party} (?:\n|$)`},
+	{name: "axis_card_email", bank: "axis", kind: models.Debit,
+		template: `{amount} spent on axis bank credit card no\. {account} at {party} on {date}`},
+
+	{name: "kotak_upi_sent", bank: "kotak", kind: models.Debit,
+		template: `sent {amount} from kotak bank a/?c {account} to {party} on {date}`},
+	{name: "kotak_upi_received", bank: "kotak", kind: models.Credit,
+		template: `received {amount} in (?:your )?kotak bank a/?c {account} from {party} on {date}`},
+	{name: "kotak_card_spent", bank: "kotak", kind: models.Debit,
+		template: `{amount} spent on kotak (?:credit )?card {account} at {party} on {date}`},
+
+	{name: "amex_card_spent", bank: "amex", kind: models.Debit,
+		template: `spent {amount} on your amex card {account} at {party} on {date}`},
+	{name: "idfc_card_used", bank: "idfc", kind: models.Debit,
+		template: `credit card ending {account} has been used for {amount} at {party} on {date}`},
+
+	{name: "generic_card_spent", kind: models.Debit,
+		template: `{amount} (?:spent|used) (?:on|using|at)[^\n]{0,40}?card[^\n]{0,20}? {account} at {party} on {date}`},
+	{name: "generic_amount_first", kind: "",
+		template: `{amount} (?:has been |is )?{dir} (?:from |to |in |on )?(?:your )?(?:a/c|acc?t|account|card)(?: no\.?)? {account}[\s\S]{0,80}?on {date}`},
+	{name: "generic_account_first", kind: "",
+		template: `(?:a/c|acc?t|account|card)(?: no\.?)? {account} (?:is |has been )?{dir} (?:with |by |for )?{value}[\s\S]{0,80}?on {date}`},
+})
+
+func compileAlertTemplates(templates []alertTemplate) []alertTemplate {
+	for i := range templates {
+		templates[i].pattern = regexp.MustCompile(`(?i)` + alertPlaceholders.Replace(templates[i].template))
+	}
+	return templates
+}
+
+var (
+	// alertIgnored marks messages that mention an amount without money
+	// having moved
+	alertIgnored   = regexp.MustCompile(`(?i)\b(?:otp|one time password|declined|failed|unsuccessful|not been processed|will be debited|is due|due date|min(?:imum)? amount due|requested money|collect request|statement (?:is|has been) generated|mandate)\b`)
+	alertReference = regexp.MustCompile(`(?i)(?:ref(?:erence)?|rrn|utr|upi)\s*(?:no\.?|number|id)?\s*(?:is)?[\s.:/-]*(?:p2[am]/)?(\d{10,16})\b`)
+	alertModes     = []struct {
+		mode    string
+		pattern *regexp.Regexp
+	}{
+		{"ATM", regexp.MustCompile(`(?i)\batm\b|cash withdrawal`)},
+		{"UPI", regexp.MustCompile(`(?i)\bupi\b|\bvpa\b`)},
+		{"NEFT", regexp.MustCompile(`(?i)\bneft\b`)},
+		{"IMPS", regexp.MustCompile(`(?i)\bimps\b`)},
+		{"RTGS", regexp.MustCompile(`(?i)\brtgs\b`)},
+		{"Card", regexp.MustCompile(`(?i)\bcard\b`)},
+	}
+	alertPartyPrefix = regexp.MustCompile(`(?i)^(?:a/c linked to\s*)?(?:vpa|mobile(?: no\.?)?)?\s*(?:[0-9x]{10}-)?`)
+	alertPartyCode   = regexp.MustCompile(`(?i)^(?:upi|neft|imps|rtgs|p2[am]|neft cr|neft dr|imps cr|[a-z0-9]*\d[a-z0-9]*)$`)
+)
+
+// ParseAlerts reads the transactions of a batch of alerts. Messages that are
+// not about money moving are skipped, and those no template matches are
+// returned as errors, numbered from 1 or by their Row.
+func ParseAlerts(messages []Message) (alerts []Alert, skipped, errs []RowError) {
+	for i, message := range messages {
+		row := i + 1
+		if message.Row > 0 {
+			row = message.Row
+		}
+		alert, err := ParseAlert(message)
+		switch {
+		case errors.Is(err, ErrNotTransaction):
+			skipped = append(skipped, RowError{Row: row, Message: err.Error()})
+		case err != nil:
+			errs = append(errs, RowError{Row: row, Message: err.Error()})
+		default:
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts, skipped, errs
+}
+
+// ParseAlert reads the transaction of one alert with the first template that
+// matches it. Alerts without a date are dated when they were received.
+func ParseAlert(message Message) (Alert, error) {
+	text := strings.TrimSpace(message.Text)
+	if alertIgnored.MatchString(text) {
+		return Alert{}, ErrNotTransaction
+	}
+	issuer := alertIssuer(message.Sender, text)
+
+	for _, t := range alertTemplates {
+		if t.bank != "" && t.bank != issuer.name {
+			continue
+		}
+		m := t.pattern.FindStringSubmatch(text)
+		if m == nil {
+			continue
+		}
+		fields := make(map[string]string)
+		for i, name := range t.pattern.SubexpNames() {
+			if name != "" && m[i] != "" {
+				fields[name] = strings.TrimSpace(m[i])
+			}
+		}
+		return alertFromFields(t, issuer, fields, text, message.Received)
+	}
+	return Alert{}, errors.New("no alert template matches the message")
+}
+
+// alertIssuer is the issuer the sender ID names, or else the one mentioned
+// first in the message. Payees' UPI handles such as "shop@icici" name other
+// banks later on.
+func alertIssuer(sender, text string) alertBank {
+	lower := strings.ToLower(sender)
+	for _, b := range alertBanks {
+		for _, keyword := range b.keywords {
+			if strings.Contains(lower, keyword) {
+				return b
+			}
+		}
+	}
+
+	var issuer alertBank
+	first := -1
+	lower = strings.ToLower(text)
+	for _, b := range alertBanks {
+		for _, keyword := range b.keywords {
+			if i := strings.Index(lower, keyword); i >= 0 && (first < 0 || i < first) {
+				issuer, first = b, i
+			}
+		}
+	}
+	return issuer
+}
+
+func alertFromFields(t alertTemplate, issuer alertBank, fields map[string]string, text string, received time.Time) (Alert, error) {
+	amount, err := parseAmount(fields["amount"])
+	if err != nil {
+		return Alert{}, err
+	}
+	if amount <= 0 {
+		return Alert{}, errors.New("the alert has no amount")
+	}
+
+	kind := t.kind
+	if kind == "" {
+		switch strings.ToLower(fields["dir"]) {
+		case "credited", "received", "deposited":
+			kind = models.Credit
+		default:
+			kind = models.Debit
+		}
+	}
+
+	date, err := alertDate(fields["date"], fields["time"], received)
+	if err != nil {
+		return Alert{}, err
+	}
+
+	ref := fields["ref"]
+	if m := alertReference.FindStringSubmatch(text); ref == "" && m != nil {
+		ref = m[1]
+	}
+
+	party := alertParty(fields["party"])
+	var details []string
+	switch {
+	case strings.Contains(t.name, "card"):
+		details = append(details, "Card")
+	case strings.Contains(party, "@"):
+		details = append(details, "UPI")
+	default:
+		for _, mode := range alertModes {
+			if mode.pattern.MatchString(text) {
+				details = append(details, mode.mode)
+				break
+			}
+		}
+	}
+	if party != "" {
+		details = append(details, party)
+	} else if issuer.bank != "" {
+		details = append(details, issuer.bank)
+	}
+	if ref != "" {
+		details = append(details, "Ref "+ref)
+	}
+
+	return Alert{
+		Bank:     issuer.bank,
+		Template: t.name,
+		Record: Record{
+			Date:    date,
+			Amount:  amount,
+			Type:    kind,
+			Details: strings.Join(details, " "),
+			Payee:   party,
+			Account: strings.ToUpper(strings.Join(strings.Fields(fields["account"]), "")),
+		},
+	}, nil
+}
+
+var alertDateLayouts = []string{
+	"02-01-06", "2-1-06", "02.01.06", "02Jan06", "02Jan2006", "2Jan06", "2Jan2006",
+	"2 Jan 2006", "2 January 2006", "2-January-2006", "January 2, 2006", "Jan. 2, 2006",
+}
+
+var alertTimeLayouts = []string{"15:04:05", "15:04", "3:04:05 PM", "3:04 PM", "3:04:05PM", "3:04PM"}
+
+// alertDate reads the date of an alert, in the day first order of Indian
+// banks. The time of day is taken from the alert, or else from when it was
+// received if that was the same day. A date missing, or more than a day
+// after the alert arrived, which would be a misread, falls back on the day
+// it was received.
+func alertDate(date, clock string, received time.Time) (time.Time, error) {
+	var t time.Time
+	var err error
+	if date != "" {
+		t, err = parseBankDate(date, "dmy")
+		for _, layout := range alertDateLayouts {
+			if err == nil {
+				break
+			}
+			t, err = time.Parse(layout, strings.Join(strings.Fields(date), " "))
+		}
+	}
+	if !received.IsZero() {
+		day := time.Date(received.Year(), received.Month(), received.Day(), 0, 0, 0, 0, time.UTC)
+		if date == "" || err != nil || t.After(day.Add(24*time.Hour)) {
+			t, err = day, nil
+		}
+	}
+	if err != nil || t.IsZero() {
+		return time.Time{}, errors.New("the alert has no date")
+	}
+
+	for _, layout := range alertTimeLayouts {
+		if c, err := time.Parse(layout, strings.ToUpper(clock)); err == nil {
+			return t.Add(time.Duration(c.Hour())*time.Hour + time.Duration(c.Minute())*time.Minute + time.Duration(c.Second())*time.Second), nil
+		}
+	}
+	if !received.IsZero() && received.Year() == t.Year() && received.YearDay() == t.YearDay() {
+		h, m, s := received.Clock()
+		return t.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second), nil
+	}
+	return t, nil
+}
+
+// alertParty cleans the payee or payer of an alert, reducing narrations
+// such as "UPI/P2M/506412345678/SWIGGY" and "NEFT Cr-HDFC0000123-JOHN
+// DOE-SALARY" to the name in them.
+func alertParty(party string) string {
+	party = strings.Trim(strings.TrimSpace(party), " .,;:-()")
+	party = strings.TrimSpace(alertPartyPrefix.ReplaceAllString(party, ""))
+
+	lower := strings.ToLower(party)
+	if strings.HasPrefix(lower, "upi") || strings.HasPrefix(lower, "neft") ||
+		strings.HasPrefix(lower, "imps") || strings.HasPrefix(lower, "rtgs") {
+		for _, part := range strings.FieldsFunc(party, func(r rune) bool { return r == '/' || r == '-' }) {
+			if part = strings.TrimSpace(part); part != "" && !alertPartyCode.MatchString(part) {
+				return part
+			}
+		}
+	}
+	return strings.Join(strings.Fields(party), " ")
+}
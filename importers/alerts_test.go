@@ -0,0 +1,157 @@
+package importers
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+// alertWant is what an alert in testdata should read as.
+type alertWant struct {
+	bank     string
+	template string
+	date     string
+	amount   float64
+	kind     models.TransactionType
+	details  string
+	payee    string
+	account  string
+}
+
+func (want alertWant) check(t *testing.T, alert Alert) {
+	t.Helper()
+	rec := alert.Record
+	if alert.Bank != want.bank || alert.Template != want.template {
+		t.Errorf("read by %q %q, want %q %q", alert.Bank, alert.Template, want.bank, want.template)
+	}
+	if !rec.Date.Equal(date(want.date)) || rec.Amount != want.amount || rec.Type != want.kind {
+		t.Errorf("got %s %v %s, want %s %v %s", rec.Date, rec.Amount, rec.Type, want.date, want.amount, want.kind)
+	}
+	if rec.Details != want.details || rec.Payee != want.payee || rec.Account != want.account {
+		t.Errorf("got %q %q %q, want %q %q %q", rec.Details, rec.Payee, rec.Account, want.details, want.payee, want.account)
+	}
+}
+
+func TestParseAlertSMS(t *testing.T) {
+	// A week after the alerts were sent, so only times in the text are kept
+	received := time.Date(2024, 3, 20, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		file string
+		want alertWant
+		err  error
+	}{
+		{"hdfc_upi_sent.txt", alertWant{"HDFC Bank", "hdfc_upi_sent", "2024-03-05", 250, models.Debit, "UPI SWIGGY Ref 406512345678", "SWIGGY", "*1234"}, nil},
+		{"hdfc_card_spent.txt", alertWant{"HDFC Bank", "hdfc_card_spent", "2024-03-06 14:22:10", 1499, models.Debit, "Card AMAZON PAY INDIA", "AMAZON PAY INDIA", "5678"}, nil},
+		{"hdfc_deposited.txt", alertWant{"HDFC Bank", "hdfc_deposited", "2024-03-01", 75000, models.Credit, "NEFT ACME PAYROLL", "ACME PAYROLL", "XX1234"}, nil},
+		{"hdfc_otp.txt", alertWant{}, ErrNotTransaction},
+		{"icici_debited.txt", alertWant{"ICICI Bank", "icici_debited", "2024-03-07", 1200, models.Debit, "UPI RAVI KUMAR Ref 406712345678", "RAVI KUMAR", "XX567"}, nil},
+		{"icici_card_spent.txt", alertWant{"ICICI Bank", "icici_card_spent", "2024-03-08", 3250, models.Debit, "Card DMART", "DMART", "XX9012"}, nil},
+		{"sbi_upi_debited.txt", alertWant{"SBI", "sbi_upi_debited", "2024-03-09", 500, models.Debit, "UPI ZOMATO Ref 406912345678", "ZOMATO", "X4321"}, nil},
+		{"sbi_upi_credited.txt", alertWant{"SBI", "sbi_upi_credited", "2024-03-10", 2000, models.Credit, "UPI PRIYA SHARMA Ref 407012345678", "PRIYA SHARMA", "X4321"}, nil},
+		{"axis_account.txt", alertWant{"Axis Bank", "axis_account", "2024-03-11 09:15:22", 899, models.Debit, "UPI NETFLIX Ref 407112345678", "NETFLIX", "XX3456"}, nil},
+		{"kotak_upi_sent.txt", alertWant{"Kotak Mahindra Bank", "kotak_upi_sent", "2024-03-12", 150, models.Debit, "UPI chai@ybl Ref 407212345678", "chai@ybl", "X7890"}, nil},
+		{"amex_card_spent.txt", alertWant{"American Express", "amex_card_spent", "2024-03-16 19:02:00", 4999, models.Debit, "Card CROMA", "CROMA", "**31007"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "sms", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			alert, err := ParseAlert(Message{Text: string(data), Received: received})
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.want.check(t, alert)
+		})
+	}
+}
+
+func TestReadMailAlerts(t *testing.T) {
+	hdfc := alertWant{"HDFC Bank", "hdfc_card_email", "2024-03-13 18:45:10", 2340, models.Debit, "Card MAKEMYTRIP", "MAKEMYTRIP", "5678"}
+	icici := alertWant{"ICICI Bank", "icici_card_email", "2024-03-14 10:05:11", 649, models.Debit, "Card BOOKMYSHOW", "BOOKMYSHOW", "XX9012"}
+
+	tests := []struct {
+		file    string
+		format  string
+		senders []string
+		alerts  []alertWant
+		// skipped and errs are the rows not read as alerts
+		skipped []int
+		errs    []int
+	}{
+		{"hdfc_card.eml", "eml", []string{"alerts@hdfcbank.net"}, []alertWant{hdfc}, nil, nil},
+		{"icici_card.eml", "eml", []string{"credit_cards@icicibank.com"}, []alertWant{icici}, nil, nil},
+		{"axis_card.eml", "eml", []string{"alerts@axisbank.com"},
+			[]alertWant{{"Axis Bank", "axis_card_email", "2024-03-15 22:10:05", 1150, models.Debit, "Card UBER INDIA", "UBER INDIA", "XX3456"}}, nil, nil},
+		// The second email is malformed and the third an OTP
+		{"alerts.mbox", "mbox", []string{"alerts@hdfcbank.net", "otp@hdfcbank.net", "credit_cards@icicibank.com"}, []alertWant{hdfc, icici}, []int{3}, []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "mail", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format := DetectMail(data); format != tt.format {
+				t.Fatalf("detected %q, want %q", format, tt.format)
+			}
+			messages, unreadable, err := ReadMail(tt.format, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != len(tt.senders) {
+				t.Fatalf("read %d emails, want %d", len(messages), len(tt.senders))
+			}
+			for i, sender := range tt.senders {
+				if messages[i].Sender != sender {
+					t.Errorf("email %d is from %q, want %q", i, messages[i].Sender, sender)
+				}
+			}
+
+			alerts, skipped, errs := ParseAlerts(messages)
+			errs = append(unreadable, errs...)
+			if len(alerts) != len(tt.alerts) {
+				t.Fatalf("got %d alerts, want %d", len(alerts), len(tt.alerts))
+			}
+			for i, want := range tt.alerts {
+				want.check(t, alerts[i])
+			}
+			if got := rowNumbers(skipped); !slices.Equal(got, tt.skipped) {
+				t.Errorf("skipped rows %v, want %v", got, tt.skipped)
+			}
+			if got := rowNumbers(errs); !slices.Equal(got, tt.errs) {
+				t.Errorf("failed rows %v, want %v", got, tt.errs)
+			}
+		})
+	}
+}
+
+func TestReadMailNothingReadable(t *testing.T) {
+	data := []byte("From a@example.com Thu Mar 14 09:00:00 2024\nnot a header\n\nbody\n")
+	if _, unreadable, err := ReadMail("mbox", data); err == nil || len(unreadable) != 1 {
+		t.Errorf("got %v and %d unreadable emails, want an error and 1", err, len(unreadable))
+	}
+}
+
+func rowNumbers(errs []RowError) []int {
+	var rows []int
+	for _, e := range errs {
+		rows = append(rows, e.Row)
+	}
+	return rows
+}
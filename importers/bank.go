@@ -0,0 +1,418 @@
+package importers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// bankHeaderRows is how far down a statement the header row is looked for,
+// past the bank's address, the account details and the period.
+const bankHeaderRows = 40
+
+// bankProfile maps the columns of one bank's statement. Each field lists the
+// header names it may appear under, normalised with normalizeColumn.
+// Money out and in are either separate debit and credit columns, or an
+// amount with a column saying which way it went.
+type bankProfile struct {
+	name string
+	// bank is what the bank is called, for naming accounts
+	bank      string
+	date      []string
+	details   []string
+	debit     []string
+	credit    []string
+	amount    []string
+	direction []string
+	balance   []string
+	// reference is the cheque or bank reference number, when printed
+	reference []string
+}
+
+// bankProfiles are checked in order, so the generic profile, which accepts
+// the column names of any of them, comes last.
+var bankProfiles = []bankProfile{
+	{
+		name:      "hdfc",
+		bank:      "HDFC Bank",
+		date:      []string{"date"},
+		details:   []string{"narration"},
+		debit:     []string{"withdrawal amt"},
+		credit:    []string{"deposit amt"},
+		balance:   []string{"closing balance"},
+		reference: []string{"chq ref no"},
+	},
+	{
+		name:      "icici",
+		bank:      "ICICI Bank",
+		date:      []string{"transaction date", "value date"},
+		details:   []string{"transaction remarks"},
+		debit:     []string{"withdrawal amount inr", "withdrawal amount"},
+		credit:    []string{"deposit amount inr", "deposit amount"},
+		balance:   []string{"balance inr", "balance"},
+		reference: []string{"cheque number"},
+	},
+	{
+		name:      "axis",
+		bank:      "Axis Bank",
+		date:      []string{"tran date"},
+		details:   []string{"particulars"},
+		debit:     []string{"dr"},
+		credit:    []string{"cr"},
+		balance:   []string{"bal"},
+		reference: []string{"chqno"},
+	},
+	{
+		name:      "sbi",
+		bank:      "SBI",
+		date:      []string{"txn date"},
+		details:   []string{"description"},
+		debit:     []string{"debit"},
+		credit:    []string{"credit"},
+		balance:   []string{"balance"},
+		reference: []string{"ref no cheque no"},
+	},
+	{
+		name:      "kotak",
+		bank:      "Kotak Mahindra Bank",
+		date:      []string{"transaction date", "date"},
+		details:   []string{"description", "narration"},
+		amount:    []string{"amount"},
+		direction: []string{"dr cr"},
+		balance:   []string{"balance"},
+		reference: []string{"chq ref no", "reference no"},
+	},
+	{
+		name:      "bank",
+		date:      []string{"date", "txn date", "tran date", "transaction date", "posting date", "value date", "value dt"},
+		details:   []string{"narration", "description", "particulars", "transaction remarks", "remarks", "details", "transaction details"},
+		debit:     []string{"withdrawal amt", "withdrawal amount inr", "withdrawal amount", "withdrawals", "debit", "debit amount", "debit inr", "dr"},
+		credit:    []string{"deposit amt", "deposit amount inr", "deposit amount", "deposits", "credit", "credit amount", "credit inr", "cr"},
+		amount:    []string{"amount", "amount inr", "transaction amount"},
+		direction: []string{"dr cr", "cr dr", "debit credit", "type"},
+		balance:   []string{"closing balance", "balance", "balance inr", "bal", "running balance", "available balance"},
+		reference: []string{"chq ref no", "ref no cheque no", "cheque number", "chqno", "reference no", "ref no", "cheque no"},
+	},
+}
+
+// BankSheet is a bank statement read from a spreadsheet.
+type BankSheet struct {
+	// Bank is the profile the header matched and Account the account
+	// number printed above it, when there is one
+	Bank    string
+	Account string
+	Records []Record
+	// Skipped are rows below the header that are not transactions, such as
+	// separators, totals and footers
+	Skipped []RowError
+	Errors  []RowError
+}
+
+// BankName is what the bank of a profile is called, or "" when unknown.
+func BankName(profile string) string {
+	for _, p := range bankProfiles {
+		if p.name == profile {
+			return p.bank
+		}
+	}
+	return ""
+}
+
+// IsBankFormat reports whether format names a bank statement profile.
+func IsBankFormat(format string) bool {
+	return BankName(format) != "" || format == "bank"
+}
+
+// ImportBankSheet reads the rows of a bank statement spreadsheet. The header
+// row is found among the first rows by its column names, with format naming
+// the bank's profile or empty to try them all.
+func ImportBankSheet(format string, rows [][]string, opts Options) (BankSheet, error) {
+	var sheet BankSheet
+
+	p, headerRow, h, ok := findBankHeader(format, rows)
+	if !ok {
+		if format != "" {
+			return sheet, fmt.Errorf("no %s statement header found in the first %d rows", format, bankHeaderRows)
+		}
+		return sheet, errors.New("could not find the header row of the statement")
+	}
+	sheet.Bank = p.name
+	sheet.Account = findAccountNumber(rows[:headerRow])
+
+	body := rows[headerRow+1:]
+	if opts.DateOrder == "" {
+		var dates []string
+		for _, row := range body {
+			dates = append(dates, h.get(row, p.date...))
+		}
+		opts.DateOrder = detectDateOrder(dates, "dmy")
+	}
+
+	for i, row := range body {
+		number := headerRow + i + 2
+		if !meaningful(row) {
+			continue
+		}
+
+		rec, ok, err := bankRecord(p, h, row, opts)
+		switch {
+		case err != nil:
+			sheet.Errors = append(sheet.Errors, RowError{Row: number, Message: err.Error()})
+		case ok:
+			sheet.Records = append(sheet.Records, rec)
+		case continuation(p, h, row) && len(sheet.Records) > 0:
+			// Long narrations wrap onto rows of their own
+			last := &sheet.Records[len(sheet.Records)-1]
+			last.Details = joinDetails(last.Details, h.get(row, p.details...))
+		default:
+			sheet.Skipped = append(sheet.Skipped, RowError{Row: number, Message: "not a transaction: " + rowText(row)})
+		}
+	}
+	if len(sheet.Records) == 0 && len(sheet.Errors) == 0 {
+		return sheet, errors.New("no transactions found below the header row")
+	}
+
+	// A reference tells a transaction apart only if no other row has it,
+	// and a bounced cheque or a reversal repeats the number of the original
+	seen := make(map[string]int)
+	for _, rec := range sheet.Records {
+		if rec.Reference != "" {
+			seen[rec.Reference]++
+		}
+	}
+	for i := range sheet.Records {
+		if seen[sheet.Records[i].Reference] > 1 {
+			sheet.Records[i].Reference = ""
+		}
+	}
+	return sheet, nil
+}
+
+// findBankHeader finds the header row and the profile it matches.
+func findBankHeader(format string, rows [][]string) (bankProfile, int, header, bool) {
+	for i, row := range rows[:min(len(rows), bankHeaderRows)] {
+		names := make([]string, len(row))
+		for j, name := range row {
+			names[j] = normalizeColumn(name)
+		}
+		h := newHeader(names)
+
+		for _, p := range bankProfiles {
+			if format != "" && p.name != format {
+				continue
+			}
+			if p.matches(h) {
+				return p, i, h, true
+			}
+		}
+	}
+	return bankProfile{}, 0, nil, false
+}
+
+// matches reports whether a header has the columns the profile needs.
+func (p bankProfile) matches(h header) bool {
+	hasAny := func(names []string) bool {
+		for _, name := range names {
+			if h.has(name) {
+				return true
+			}
+		}
+		return false
+	}
+	if !hasAny(p.date) || !hasAny(p.details) {
+		return false
+	}
+	if len(p.debit) > 0 && hasAny(p.debit) && hasAny(p.credit) {
+		return true
+	}
+	return len(p.amount) > 0 && hasAny(p.amount) && hasAny(p.direction)
+}
+
+// bankRecord reads a transaction row. ok is false for rows without a date
+// or an amount, which are not transactions.
+func bankRecord(p bankProfile, h header, row []string, opts Options) (rec Record, ok bool, err error) {
+	date, err := parseBankDate(h.get(row, p.date...), opts.DateOrder)
+	if err != nil {
+		return rec, false, nil
+	}
+
+	debit, err := parseBankAmount(h.get(row, p.debit...))
+	if err != nil {
+		return rec, false, err
+	}
+	credit, err := parseBankAmount(h.get(row, p.credit...))
+	if err != nil {
+		return rec, false, err
+	}
+	if debit == 0 && credit == 0 {
+		value := h.get(row, p.amount...)
+		amount, err := parseBankAmount(value)
+		if err != nil {
+			return rec, false, err
+		}
+		if isDebit(h.get(row, p.direction...)) || isDebit(value) {
+			debit = amount
+		} else {
+			credit = amount
+		}
+	}
+	if debit != 0 && credit != 0 {
+		return rec, false, fmt.Errorf("both a withdrawal of %v and a deposit of %v", debit, credit)
+	}
+	if debit == 0 && credit == 0 {
+		return rec, false, nil
+	}
+
+	rec = Record{Date: date, Details: h.get(row, p.details...), Reference: bankReference(h.get(row, p.reference...))}
+	rec.signed(credit - debit)
+	value := h.get(row, p.balance...)
+	if balance, err := parseBankAmount(value); err == nil && !blankBankAmount(bankAmountText(value)) {
+		// Overdrawn balances are marked Dr on some statements
+		if isDebit(value) {
+			balance = -balance
+		}
+		rec.Balance = &balance
+	}
+	return rec, true, nil
+}
+
+// bankReference cleans a cheque or reference number. Statements fill the
+// column with zeros or a dash for rows that have none.
+func bankReference(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.Trim(s, "0-. ") == "" {
+		return ""
+	}
+	return s
+}
+
+// continuation reports whether a row only carries more of the narration
+// above it.
+func continuation(p bankProfile, h header, row []string) bool {
+	if h.get(row, p.details...) == "" {
+		return false
+	}
+	for _, names := range [][]string{p.date, p.debit, p.credit, p.amount, p.balance} {
+		if h.get(row, names...) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+var bankDateLayouts = []string{
+	"02 Jan 2006", "2 Jan 2006", "02-Jan-2006", "2-Jan-2006", "02/Jan/2006",
+	"02 Jan 06", "02-Jan-06", "2-Jan-06", "Jan 02, 2006", "Jan 2, 2006", "02 January 2006",
+}
+
+// parseBankDate reads the numeric dates parseDate does, dates with month
+// names, and Excel serial numbers left in unformatted cells.
+func parseBankDate(s, order string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := parseDate(s, order); err == nil {
+		return t, nil
+	}
+	for _, layout := range bankDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	if serial, err := strconv.ParseFloat(s, 64); err == nil && serial > 20000 && serial < 80000 {
+		return parseDate(excelValue(serial, true, false), "ymd")
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+// parseBankAmount reads an amount, taking a dash or NA for none and
+// ignoring a Cr or Dr suffix.
+func parseBankAmount(s string) (float64, error) {
+	lower := bankAmountText(s)
+	if blankBankAmount(lower) {
+		return 0, nil
+	}
+	v, err := parseAmount(lower)
+	if v < 0 {
+		v = -v
+	}
+	return v, err
+}
+
+// bankAmountText is an amount cell in lower case without its Cr or Dr
+// suffix.
+func bankAmountText(s string) string {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	for _, suffix := range []string{"cr", "dr", "cr.", "dr."} {
+		lower = strings.TrimSpace(strings.TrimSuffix(lower, suffix))
+	}
+	return lower
+}
+
+// blankBankAmount tells whether an amount cell, as read by bankAmountText,
+// holds no amount.
+func blankBankAmount(lower string) bool {
+	switch lower {
+	case "", "-", "--", "na", "n/a", "nil":
+		return true
+	}
+	return false
+}
+
+// isDebit reports whether a direction column or amount marks money going
+// out.
+func isDebit(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return s == "d" || s == "debit" || s == "withdrawal" || strings.HasSuffix(s, "dr") ||
+		strings.HasSuffix(s, "dr.") || strings.HasSuffix(s, "(dr)")
+}
+
+var accountNumber = regexp.MustCompile(`(?i)\b(?:a/?c|account)\.?\s*(?:no|number|num|#)?\.?\s*[:\-]?\s*([0-9Xx*]{6,})`)
+
+// findAccountNumber looks for the account number in the rows above the
+// header.
+func findAccountNumber(rows [][]string) string {
+	for _, row := range rows {
+		if m := accountNumber.FindStringSubmatch(strings.Join(row, " ")); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// normalizeColumn lowers a header name and reduces it to words, so that
+// "Withdrawal Amt." and "Balance (INR )" read as "withdrawal amt" and
+// "balance inr".
+func normalizeColumn(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// meaningful reports whether a row has any letters or digits, which the
+// rows of asterisks or dashes separating parts of a statement do not.
+func meaningful(row []string) bool {
+	for _, value := range row {
+		if strings.IndexFunc(value, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// rowText is the start of a row's text, to say which row was skipped.
+func rowText(row []string) string {
+	var parts []string
+	for _, value := range row {
+		if value = strings.TrimSpace(value); value != "" {
+			parts = append(parts, value)
+		}
+	}
+	text := strings.Join(parts, " ")
+	if r := []rune(text); len(r) > 60 {
+		text = string(r[:60]) + "…"
+	}
+	return text
+}
@@ -0,0 +1,228 @@
+package importers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// camtDocument is the part of an ISO 20022 camt.053 bank to customer
+// statement that is imported. Tags are matched without their namespace so
+// every version of the message reads the same.
+type camtDocument struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtStatement struct {
+	ID       string `xml:"Id"`
+	FromDate string `xml:"FrToDt>FrDtTm"`
+	ToDate   string `xml:"FrToDt>ToDtTm"`
+	Account  struct {
+		IBAN     string `xml:"Id>IBAN"`
+		Other    string `xml:"Id>Othr>Id"`
+		Currency string `xml:"Ccy"`
+		Name     string `xml:"Nm"`
+		Servicer string `xml:"Svcr>FinInstnId>Nm"`
+	} `xml:"Acct"`
+	Balances []camtBalance `xml:"Bal"`
+	Entries  []camtEntry   `xml:"Ntry"`
+}
+
+type camtBalance struct {
+	Code   string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount camtAmount `xml:"Amt"`
+	Sign   string     `xml:"CdtDbtInd"`
+	Date   camtDate   `xml:"Dt"`
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+// camtStatus is written as the code itself up to version 7 and inside Cd
+// from version 8.
+type camtStatus struct {
+	Value string `xml:",chardata"`
+	Code  string `xml:"Cd"`
+}
+
+type camtEntry struct {
+	Amount      camtAmount `xml:"Amt"`
+	Sign        string     `xml:"CdtDbtInd"`
+	Status      camtStatus `xml:"Sts"`
+	BookingDate camtDate   `xml:"BookgDt"`
+	ValueDate   camtDate   `xml:"ValDt"`
+	BankRef     string     `xml:"AcctSvcrRef"`
+	Info        string     `xml:"AddtlNtryInf"`
+	Details     []struct {
+		BankRef     string   `xml:"Refs>AcctSvcrRef"`
+		Remittance  []string `xml:"RmtInf>Ustrd"`
+		Info        string   `xml:"AddtlTxInf"`
+		Debtor      string   `xml:"RltdPties>Dbtr>Nm"`
+		DebtorParty string   `xml:"RltdPties>Dbtr>Pty>Nm"`
+		Creditor    string   `xml:"RltdPties>Cdtr>Nm"`
+		CreditParty string   `xml:"RltdPties>Cdtr>Pty>Nm"`
+	} `xml:"NtryDtls>TxDtls"`
+}
+
+// isCAMT053 reports whether data is a camt.053 statement.
+func isCAMT053(data []byte) bool {
+	head := data[:min(len(data), 4096)]
+	return bytes.Contains(head, []byte("camt.053")) || bytes.Contains(head, []byte("<BkToCstmrStmt"))
+}
+
+// parseCAMT053 reads the statements of a camt.053 file. Only booked entries
+// are imported; the account servicer's reference is the bank's id for an
+// entry.
+func parseCAMT053(data []byte) ([]Statement, error) {
+	var doc camtDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	statements := make([]Statement, 0, len(doc.Statements))
+	for _, stmt := range doc.Statements {
+		s := Statement{
+			Account:  stmt.Account.IBAN,
+			Name:     stmt.Account.Name,
+			Currency: stmt.Account.Currency,
+		}
+		if s.Account == "" {
+			s.Account = stmt.Account.Other
+		}
+		if s.Name == "" {
+			s.Name = stmt.Account.Servicer
+		}
+		s.Start, _ = parseCAMTDate(stmt.FromDate)
+		s.End, _ = parseCAMTDate(stmt.ToDate)
+
+		var opening, closing *camtBalance
+		for i, balance := range stmt.Balances {
+			switch balance.Code {
+			case "OPBD":
+				opening = &stmt.Balances[i]
+			case "PRCD":
+				// The previous statement's closing balance, used when the
+				// opening one is left out
+				if opening == nil {
+					opening = &stmt.Balances[i]
+				}
+			case "CLBD":
+				closing = &stmt.Balances[i]
+			}
+		}
+		if opening != nil && closing != nil {
+			var err error
+			if s.Opening, err = camtSigned(opening.Amount.Value, opening.Sign); err != nil {
+				return nil, err
+			}
+			if s.Closing, err = camtSigned(closing.Amount.Value, closing.Sign); err != nil {
+				return nil, err
+			}
+			s.HasBalances = true
+			if s.Currency == "" {
+				s.Currency = closing.Amount.Currency
+			}
+		}
+
+		for _, entry := range stmt.Entries {
+			status := strings.TrimSpace(entry.Status.Code)
+			if status == "" {
+				status = strings.TrimSpace(entry.Status.Value)
+			}
+			if status != "" && status != "BOOK" {
+				continue
+			}
+
+			rec, err := camtRecord(entry)
+			if err != nil {
+				return nil, fmt.Errorf("statement %s: %w", stmt.ID, err)
+			}
+			rec.Account = s.Account
+			s.Records = append(s.Records, rec)
+		}
+		statements = append(statements, s)
+	}
+	return statements, nil
+}
+
+func camtRecord(entry camtEntry) (Record, error) {
+	var rec Record
+
+	date, err := parseCAMTDate(entry.BookingDate.Date + entry.BookingDate.DateTime)
+	if err != nil {
+		date, err = parseCAMTDate(entry.ValueDate.Date + entry.ValueDate.DateTime)
+		if err != nil {
+			return rec, err
+		}
+	}
+	amount, err := camtSigned(entry.Amount.Value, entry.Sign)
+	if err != nil {
+		return rec, err
+	}
+
+	rec = Record{Date: date, Reference: entry.BankRef}
+	var details []string
+	for _, tx := range entry.Details {
+		details = append(details, tx.Remittance...)
+		details = append(details, tx.Info)
+		if rec.Payee == "" {
+			// The other party: who paid a credit, or who was paid by a debit
+			if amount < 0 {
+				rec.Payee = firstNonEmpty(tx.Creditor, tx.CreditParty)
+			} else {
+				rec.Payee = firstNonEmpty(tx.Debtor, tx.DebtorParty)
+			}
+		}
+		if rec.Reference == "" && len(entry.Details) == 1 {
+			rec.Reference = tx.BankRef
+		}
+	}
+	details = append(details, entry.Info)
+	rec.Details = joinDetails(append([]string{rec.Payee}, details...)...)
+	rec.signed(amount)
+	return rec, nil
+}
+
+// camtSigned reads an amount made negative by a DBIT indicator.
+func camtSigned(value, sign string) (float64, error) {
+	amount, err := parseOFXAmount(value)
+	if err != nil {
+		return 0, err
+	}
+	if strings.TrimSpace(sign) == "DBIT" {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// parseCAMTDate reads an ISO date or date time, keeping the time of day as
+// written.
+func parseCAMTDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02"} {
+		if len(s) >= len(layout) {
+			if t, err := time.Parse(layout, s[:len(layout)]); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package importers
+
+import (
+	"bytes"
+	"errors"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// isHTMLTable reports whether data is an HTML page with a table, which is
+// what many banks send as a .xls statement.
+func isHTMLTable(data []byte) bool {
+	head := bytes.ToLower(bytes.TrimSpace(data[:min(len(data), 4096)]))
+	return bytes.HasPrefix(head, []byte("<")) && bytes.Contains(bytes.ToLower(data), []byte("<table"))
+}
+
+var (
+	htmlTag      = regexp.MustCompile(`(?is)<(/?)([a-z0-9]+)([^>]*)>`)
+	htmlColspan  = regexp.MustCompile(`(?i)colspan\s*=\s*["']?(\d+)`)
+	htmlSkipped  = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>|<!--.*?-->`)
+	htmlLineTags = map[string]bool{"br": true, "p": true, "div": true}
+)
+
+// readHTMLTable reads the rows of every table in an HTML page, one after the
+// other. Cells spanning several columns are followed by empty ones so
+// columns stay aligned with the header.
+func readHTMLTable(data []byte) ([][]string, error) {
+	page := htmlSkipped.ReplaceAllString(string(data), "")
+
+	var rows [][]string
+	var row []string
+	var cell *strings.Builder
+	span := 1
+	inRow := false
+
+	endCell := func() {
+		if cell == nil {
+			return
+		}
+		row = append(row, strings.Join(strings.Fields(html.UnescapeString(cell.String())), " "))
+		for i := 1; i < span; i++ {
+			row = append(row, "")
+		}
+		cell = nil
+	}
+	endRow := func() {
+		endCell()
+		if inRow {
+			rows = append(rows, row)
+		}
+		row, inRow = nil, false
+	}
+
+	last := 0
+	for _, m := range htmlTag.FindAllStringSubmatchIndex(page, -1) {
+		if cell != nil {
+			cell.WriteString(page[last:m[0]])
+		}
+		last = m[1]
+
+		closing := m[3] > m[2]
+		name := strings.ToLower(page[m[4]:m[5]])
+		switch {
+		case name == "tr" && !closing:
+			endRow()
+			inRow = true
+		case name == "tr" || name == "table":
+			endRow()
+		case (name == "td" || name == "th") && !closing:
+			endCell()
+			inRow = true
+			cell = &strings.Builder{}
+			span = 1
+			if s := htmlColspan.FindStringSubmatch(page[m[6]:m[7]]); s != nil {
+				if n, err := strconv.Atoi(s[1]); err == nil && n > 1 && n < 100 {
+					span = n
+				}
+			}
+		case name == "td" || name == "th":
+			endCell()
+		case htmlLineTags[name] && cell != nil:
+			cell.WriteString(" ")
+		}
+	}
+	endRow()
+
+	if len(rows) == 0 {
+		return nil, errors.New("the page has no table rows")
+	}
+	return rows, nil
+}
@@ -0,0 +1,326 @@
+// Package importers reads the exports of other finance apps into records
+// that can be stored as transactions.
+package importers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+// Record is one transaction read from an export. Amount is never negative;
+// Type says which way the money went. Category is already mapped to ours,
+// or empty when the export's category has no match. Reference is the id the
+// bank gave the transaction and Balance the account's balance after it;
+// they are empty and nil when the file does not have them.
+type Record struct {
+	Date      time.Time
+	Amount    float64
+	Type      models.TransactionType
+	Details   string
+	Payee     string
+	Category  string
+	Account   string
+	Notes     string
+	Tags      []string
+	Reference string
+	Balance   *float64
+}
+
+// Options are the choices that cannot be read from an export itself.
+type Options struct {
+	// DateOrder is dmy, mdy or ymd. When empty it is worked out from the
+	// dates in the export.
+	DateOrder string
+	// Member is the Splitwise column of the user importing.
+	Member string
+}
+
+// RowError is a row that could not be imported. Row counts from 1 for the
+// header.
+type RowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+// profile describes the export of one app.
+type profile struct {
+	name string
+	// required are headers that together identify the export
+	required []string
+	// dates are the headers that may hold the date, and order how they are
+	// written when it cannot be worked out
+	dates []string
+	order string
+	// check, if set, validates the options against the header before any
+	// row is read
+	check func(h header, opts Options) error
+	parse func(h header, row []string, opts Options) (rec Record, skip bool, err error)
+}
+
+// profiles are checked in order, so more specific exports come first.
+var profiles = []profile{ynab, mint, splitwise, moneyManager}
+
+// Formats lists the exports that can be imported.
+func Formats() []string {
+	names := make([]string, len(profiles))
+	for i, p := range profiles {
+		names[i] = p.name
+	}
+	return names
+}
+
+// Detect names the app that produced an export from its header row.
+func Detect(headerRow []string) (string, bool) {
+	h := newHeader(headerRow)
+	for _, p := range profiles {
+		if h.has(p.required...) {
+			return p.name, true
+		}
+	}
+	return "", false
+}
+
+// Import reads rows, the first of which is the header, as the export of
+// format, or of whichever app is detected when format is empty. Rows that
+// cannot be read are reported and skipped.
+func Import(format string, rows [][]string, opts Options) ([]Record, []RowError, error) {
+	if len(rows) == 0 {
+		return nil, nil, errors.New("the file is empty")
+	}
+
+	if format == "" {
+		var ok bool
+		if format, ok = Detect(rows[0]); !ok {
+			return nil, nil, errors.New("could not tell which app the file was exported from")
+		}
+	}
+
+	var p *profile
+	for i := range profiles {
+		if profiles[i].name == format {
+			p = &profiles[i]
+		}
+	}
+	if p == nil {
+		return nil, nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	h := newHeader(rows[0])
+	if !h.has(p.required...) {
+		return nil, nil, fmt.Errorf("missing columns for %s: expected %s", format, strings.Join(p.required, ", "))
+	}
+	if p.check != nil {
+		if err := p.check(h, opts); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if opts.DateOrder == "" {
+		var dates []string
+		for _, row := range rows[1:] {
+			dates = append(dates, h.get(row, p.dates...))
+		}
+		opts.DateOrder = detectDateOrder(dates, p.order)
+	}
+
+	var records []Record
+	var rowErrors []RowError
+	for i, row := range rows[1:] {
+		if blank(row) {
+			continue
+		}
+		rec, skip, err := p.parse(h, row, opts)
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Row: i + 2, Message: err.Error()})
+			continue
+		}
+		if !skip {
+			records = append(records, rec)
+		}
+	}
+	return records, rowErrors, nil
+}
+
+// ReadCSV reads a comma, semicolon or tab separated file.
+func ReadCSV(r io.Reader) ([][]string, error) {
+	br := bufio.NewReader(r)
+	first, err := br.Peek(4096)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	// A byte order mark before a quoted header would break its quoting
+	if bytes.HasPrefix(first, []byte("\ufeff")) {
+		br.Discard(len("\ufeff"))
+		first = first[len("\ufeff"):]
+	}
+	if end := bytes.IndexByte(first, '\n'); end >= 0 {
+		first = first[:end]
+	}
+
+	reader := csv.NewReader(br)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.Comma = ','
+	for _, delimiter := range []rune{'\t', ';'} {
+		if bytes.Count(first, []byte(string(delimiter))) > bytes.Count(first, []byte{byte(reader.Comma)}) {
+			reader.Comma = delimiter
+		}
+	}
+
+	return reader.ReadAll()
+}
+
+// AccountType guesses the kind of an account from its name in another app.
+func AccountType(name string) models.AccountType {
+	name = strings.ToLower(name)
+	switch {
+	case strings.Contains(name, "credit") || strings.Contains(name, "card"):
+		return models.CreditCardAccount
+	case strings.Contains(name, "cash") || strings.Contains(name, "wallet") || strings.Contains(name, "splitwise"):
+		return models.WalletAccount
+	case strings.Contains(name, "current") || strings.Contains(name, "checking"):
+		return models.CurrentAccount
+	}
+	return models.SavingsAccount
+}
+
+// header finds columns by name, ignoring case. A name used twice refers to
+// its first column.
+type header map[string]int
+
+func newHeader(row []string) header {
+	h := make(header)
+	for i, name := range row {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := h[name]; !ok {
+			h[name] = i
+		}
+	}
+	return h
+}
+
+func (h header) has(names ...string) bool {
+	for _, name := range names {
+		if _, ok := h[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// get returns the value of the first of names present in the row.
+func (h header) get(row []string, names ...string) string {
+	for _, name := range names {
+		if i, ok := h[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+	}
+	return ""
+}
+
+func blank(row []string) bool {
+	for _, value := range row {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+var notAmount = regexp.MustCompile(`[^0-9.\-]`)
+
+// parseAmount reads amounts such as "₹1,234.50", "$-12.00", "(45.00)" or
+// "Rs. 99". Parentheses mean a negative amount.
+func parseAmount(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	negative := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	// "Rs." would otherwise leave a stray dot
+	s = strings.NewReplacer("Rs.", "", "rs.", "", "RS.", "").Replace(s)
+	v, err := strconv.ParseFloat(notAmount.ReplaceAllString(s, ""), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if negative {
+		v = -v
+	}
+	return v, nil
+}
+
+var datePattern = regexp.MustCompile(`^(\d{1,4})[/.-](\d{1,2})[/.-](\d{1,4})`)
+
+// detectDateOrder works out whether dates are written day or month first
+// from the first one that can only be read one way.
+func detectDateOrder(dates []string, fallback string) string {
+	for _, date := range dates {
+		m := datePattern.FindStringSubmatch(strings.TrimSpace(date))
+		if m == nil {
+			continue
+		}
+		if len(m[1]) == 4 {
+			return "ymd"
+		}
+		a, _ := strconv.Atoi(m[1])
+		b, _ := strconv.Atoi(m[2])
+		switch {
+		case a > 12:
+			return "dmy"
+		case b > 12:
+			return "mdy"
+		}
+	}
+	return fallback
+}
+
+var (
+	dateLayouts = map[string][]string{
+		"dmy": {"02/01/2006", "2/1/2006", "02-01-2006", "2-1-2006", "02.01.2006", "02/01/06", "2/1/06"},
+		"mdy": {"01/02/2006", "1/2/2006", "01-02-2006", "1-2-2006", "01.02.2006", "01/02/06", "1/2/06"},
+		"ymd": {"2006-01-02", "2006/01/02", "2006.01.02"},
+	}
+	timeLayouts = []string{"", " 15:04:05", " 15:04", " 3:04:05 PM", " 3:04 PM"}
+)
+
+// parseDate reads a date, optionally followed by a time of day, written in
+// order. ISO dates are always understood.
+func parseDate(s, order string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	layouts := append([]string{}, dateLayouts[order]...)
+	if order != "ymd" {
+		layouts = append(layouts, dateLayouts["ymd"]...)
+	}
+	for _, layout := range layouts {
+		for _, clock := range timeLayouts {
+			if t, err := time.Parse(layout+clock, s); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+// signed sets the amount and type of a record from a signed amount.
+func (rec *Record) signed(amount float64) {
+	rec.Type = models.Credit
+	if amount < 0 {
+		rec.Type = models.Debit
+		amount = -amount
+	}
+	rec.Amount = amount
+}
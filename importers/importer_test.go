@@ -0,0 +1,131 @@
+package importers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		csv    string
+		opts   Options
+		want   []Record
+		errors []RowError
+	}{
+		{
+			name:   "mint",
+			format: "mint",
+			csv: `"Date","Description","Original Description","Amount","Transaction Type","Category","Account Name","Labels","Notes"
+"3/05/2024","Swiggy","SWIGGY BANGALORE","1,249.50","debit","Fast Food","HDFC Savings","",""
+"3/01/2024","Acme Payroll","ACME PAYROLL MAR","$75,000.00","credit","Paycheck","HDFC Savings","salary work",""
+"3/12/2024","DMart","DMART","-3500","debit","Groceries","HDFC Credit Card","","split with flat"
+"March 31","Broken","BROKEN","10","debit","","HDFC Savings","",""
+`,
+			want: []Record{
+				{Date: date("2024-03-05"), Amount: 1249.50, Type: models.Debit, Details: "SWIGGY BANGALORE", Payee: "Swiggy", Category: "Food & Dining", Account: "HDFC Savings", Tags: []string{}},
+				{Date: date("2024-03-01"), Amount: 75000, Type: models.Credit, Details: "ACME PAYROLL MAR", Payee: "Acme Payroll", Category: "Salary", Account: "HDFC Savings", Tags: []string{"salary", "work"}},
+				{Date: date("2024-03-12"), Amount: 3500, Type: models.Debit, Details: "DMART", Payee: "DMart", Category: "Groceries", Account: "HDFC Credit Card", Notes: "split with flat", Tags: []string{}},
+			},
+			errors: []RowError{{Row: 5, Message: `invalid date "March 31"`}},
+		},
+		{
+			name: "ynab, day first",
+			csv: "Account\tFlag\tDate\tPayee\tCategory Group/Category\tMemo\tOutflow\tInflow\n" +
+				"Savings\t\t01/03/2024\tStarting Balance\tInflow: Ready to Assign\t\t₹0.00\t₹10,000.00\n" +
+				"Savings\tRed\t15/03/2024\tNoBroker\tBills: Rent\tMarch\t₹25,000.00\t₹0.00\n" +
+				"Savings\t\t16/03/2024\tTransfer : Wallet\t\t\t₹2,000.00\t₹0.00\n" +
+				"Wallet\t\t16/03/2024\tTransfer : Savings\t\t\t₹0.00\t₹2,000.00\n" +
+				"Savings\t\t20/03/2024\tFlipkart\tShopping: Electronics\trefund\t₹0.00\t₹499.00\n" +
+				"Savings\t\t21/03/2024\tNothing\t\t\t₹0.00\t₹0.00\n",
+			want: []Record{
+				{Date: date("2024-03-15"), Amount: 25000, Type: models.Debit, Details: "NoBroker March", Payee: "NoBroker", Category: "Rent", Account: "Savings", Notes: "March", Tags: []string{"red"}},
+				{Date: date("2024-03-16"), Amount: 2000, Type: models.Debit, Details: "Transfer : Wallet", Account: "Savings"},
+				{Date: date("2024-03-16"), Amount: 2000, Type: models.Credit, Details: "Transfer : Savings", Account: "Wallet"},
+				{Date: date("2024-03-20"), Amount: 499, Type: models.Credit, Details: "Flipkart refund", Payee: "Flipkart", Category: "Shopping", Account: "Savings", Notes: "refund"},
+			},
+		},
+		{
+			name: "ynab 4, month first",
+			csv: `"Account","Flag","Check Number","Date","Payee","Category","Master Category","Sub Category","Memo","Outflow","Inflow","Cleared","Running Balance"
+"Checking","","","03/02/2024","Starbucks","Everyday: Coffee","Everyday","Coffee","","$4.50","$0.00","C","$95.50"
+`,
+			want: []Record{
+				{Date: date("2024-03-02"), Amount: 4.5, Type: models.Debit, Details: "Starbucks", Payee: "Starbucks", Category: "Food & Dining", Account: "Checking"},
+			},
+		},
+		{
+			name:   "splitwise",
+			format: "splitwise",
+			csv: `Date,Description,Category,Cost,Currency,Asha,Ravi
+2024-03-02,Dinner at Toit,Dining out,3000.00,INR,1500.00,-1500.00
+2024-03-04,Groceries,Groceries,800.00,INR,-400.00,400.00
+2024-03-05,Cab Ravi took alone,Taxi,300.00,INR,0.00,0.00
+2024-03-10,Ravi paid Asha,Payment,1100.00,INR,-1100.00,1100.00
+
+,Total balance,,,INR,0.00,0.00
+`,
+			opts: Options{Member: "Asha"},
+			want: []Record{
+				{Date: date("2024-03-02"), Amount: 1500, Type: models.Credit, Details: "Dinner at Toit", Category: "Food & Dining", Account: "Splitwise", Tags: []string{"splitwise"}},
+				{Date: date("2024-03-04"), Amount: 400, Type: models.Debit, Details: "Groceries", Category: "Groceries", Account: "Splitwise", Tags: []string{"splitwise"}},
+				{Date: date("2024-03-10"), Amount: 1100, Type: models.Debit, Details: "Ravi paid Asha", Account: "Splitwise", Tags: []string{"splitwise", "settlement"}},
+			},
+		},
+		{
+			name: "money manager, older export with a currency column",
+			csv: `Period,Accounts,Category,Subcategory,Note,INR,Income/Expense,Description
+03/05/2024 12:30:00,Cash,Food,Lunch,Udupi Cafe,"Rs. 180",Expense,
+03/01/2024,HDFC,Salary,,,"75,000.00",Income,March pay
+03/07/2024,HDFC,Transfer,,To wallet,"(2,000.00)",Transfer-Out,
+03/07/2024,Cash,Transfer,,From bank,"2,000.00",Transfer-In,
+03/08/2024,Cash,Other,,,50,Lent,
+`,
+			want: []Record{
+				{Date: date("2024-03-05 12:30:00"), Amount: 180, Type: models.Debit, Details: "Udupi Cafe", Category: "Food & Dining", Account: "Cash"},
+				{Date: date("2024-03-01"), Amount: 75000, Type: models.Credit, Details: "Salary", Category: "Salary", Account: "HDFC", Notes: "March pay"},
+				{Date: date("2024-03-07"), Amount: 2000, Type: models.Debit, Details: "To wallet", Account: "HDFC"},
+				{Date: date("2024-03-07"), Amount: 2000, Type: models.Credit, Details: "From bank", Account: "Cash"},
+			},
+			errors: []RowError{{Row: 6, Message: `unknown Income/Expense "lent"`}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := ReadCSV(strings.NewReader(tt.csv))
+			if err != nil {
+				t.Fatal(err)
+			}
+			records, rowErrors, err := Import(tt.format, rows, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(tt.want) {
+				t.Fatalf("got %d records, want %d: %+v", len(records), len(tt.want), records)
+			}
+			for i := range records {
+				if !reflect.DeepEqual(records[i], tt.want[i]) {
+					t.Errorf("record %d:\n got %+v\nwant %+v", i, records[i], tt.want[i])
+				}
+			}
+			if !reflect.DeepEqual(rowErrors, tt.errors) {
+				t.Errorf("row errors %+v, want %+v", rowErrors, tt.errors)
+			}
+		})
+	}
+}
+
+func TestImportSplitwiseMember(t *testing.T) {
+	rows := [][]string{{"Date", "Description", "Category", "Cost", "Currency", "Asha", "Ravi"}}
+	if _, _, err := Import("splitwise", rows, Options{}); err == nil || !strings.Contains(err.Error(), "member is required") {
+		t.Errorf("got %v, want member is required", err)
+	}
+	if _, _, err := Import("splitwise", rows, Options{Member: "Meera"}); err == nil || !strings.Contains(err.Error(), "no column") {
+		t.Errorf("got %v, want no column for member", err)
+	}
+}
@@ -0,0 +1,209 @@
+package importers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// mailMaxParts bounds how many parts of a multipart email are looked at.
+const mailMaxParts = 50
+
+var mailHeader = regexp.MustCompile(`(?im)^(?:from|to|subject|date|received|message-id|mime-version|return-path|delivered-to):`)
+
+// DetectMail reports whether data is an mbox of emails or a single .eml
+// message, returning "mbox", "eml" or "".
+func DetectMail(data []byte) string {
+	if bytes.HasPrefix(data, []byte("From ")) {
+		return "mbox"
+	}
+	head := data
+	if i := bytes.Index(head, []byte("\n\n")); i >= 0 {
+		head = head[:i]
+	} else if i := bytes.Index(head, []byte("\r\n\r\n")); i >= 0 {
+		head = head[:i]
+	}
+	if len(mailHeader.FindAll(head, 3)) >= 3 {
+		return "eml"
+	}
+	return ""
+}
+
+// IsMailFormat reports whether format names an email file.
+func IsMailFormat(format string) bool {
+	return format == "eml" || format == "mbox"
+}
+
+// ReadMail reads the emails of an mbox or .eml file as messages whose text
+// is the subject followed by the plain text of the body, or the text of its
+// HTML when there is no plain part. Emails that cannot be read are left out
+// and returned as errors, numbered by their place in the file like the Row
+// of the messages.
+func ReadMail(format string, data []byte) ([]Message, []RowError, error) {
+	raw := [][]byte{data}
+	if format == "mbox" {
+		raw = splitMbox(data)
+	}
+
+	var messages []Message
+	var errs []RowError
+	for i, m := range raw {
+		message, err := readMailMessage(m)
+		if err != nil {
+			errs = append(errs, RowError{Row: i + 1, Message: err.Error()})
+			continue
+		}
+		message.Row = i + 1
+		messages = append(messages, message)
+	}
+	if len(messages) == 0 {
+		if len(errs) > 0 {
+			return nil, errs, fmt.Errorf("no email could be read: %s", errs[0].Message)
+		}
+		return nil, nil, errors.New("the file has no emails")
+	}
+	return messages, errs, nil
+}
+
+// readMailMessage reads one email.
+func readMailMessage(data []byte) (Message, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return Message{}, err
+	}
+	body, err := mailBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body, 0)
+	if err != nil {
+		return Message{}, err
+	}
+
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	message := Message{Text: strings.TrimSpace(subject + "\n" + strings.ReplaceAll(body, "\r\n", "\n"))}
+	if from, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+		message.Sender = from.Address
+	} else {
+		message.Sender = msg.Header.Get("From")
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		message.Received = date
+	}
+	return message, nil
+}
+
+// splitMbox splits an mbox at its "From " separator lines, undoing the
+// quoting of body lines that began with "From ".
+func splitMbox(data []byte) [][]byte {
+	var messages [][]byte
+	var current bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if bytes.HasPrefix(line, []byte("From ")) {
+			if current.Len() > 0 {
+				messages = append(messages, append([]byte(nil), current.Bytes()...))
+				current.Reset()
+			}
+			continue
+		}
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			line = line[1:]
+		}
+		current.Write(line)
+		current.WriteByte('\n')
+	}
+	if len(bytes.TrimSpace(current.Bytes())) > 0 {
+		messages = append(messages, current.Bytes())
+	}
+	return messages
+}
+
+// mailBody returns the text of a message body, preferring a plain text part
+// to an HTML one in multipart messages.
+func mailBody(contentType, encoding string, body io.Reader, depth int) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && depth < 5 {
+		reader := multipart.NewReader(body, params["boundary"])
+		var plain, htmlText string
+		for i := 0; i < mailMaxParts; i++ {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			text, err := mailBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part, depth+1)
+			if err != nil {
+				return "", err
+			}
+			partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			switch {
+			case partType == "text/html" && htmlText == "":
+				htmlText = text
+			case plain == "" && text != "" && partType != "text/html":
+				plain = text
+			}
+		}
+		if plain != "" {
+			return plain, nil
+		}
+		return htmlText, nil
+	}
+	if !strings.HasPrefix(mediaType, "text/") {
+		return "", nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	if mediaType == "text/html" {
+		return htmlToText(string(data)), nil
+	}
+	return string(data), nil
+}
+
+// htmlToText reduces an HTML email to its text, one line per block.
+func htmlToText(page string) string {
+	page = htmlSkipped.ReplaceAllString(page, "")
+	page = htmlTag.ReplaceAllStringFunc(page, func(tag string) string {
+		name := strings.ToLower(htmlTag.FindStringSubmatch(tag)[2])
+		if htmlLineTags[name] || name == "tr" || name == "table" || name == "li" {
+			return "\n"
+		}
+		return " "
+	})
+	page = html.UnescapeString(page)
+
+	var lines []string
+	for _, line := range strings.Split(page, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
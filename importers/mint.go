@@ -0,0 +1,48 @@
+package importers
+
+import (
+	"strings"
+
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+// mint reads the transactions.csv Mint exports. Amounts are unsigned with a
+// separate debit or credit column, and dates are month first.
+var mint = profile{
+	name:     "mint",
+	required: []string{"date", "description", "amount", "transaction type", "category", "account name"},
+	dates:    []string{"date"},
+	order:    "mdy",
+	parse: func(h header, row []string, opts Options) (Record, bool, error) {
+		var rec Record
+
+		date, err := parseDate(h.get(row, "date"), opts.DateOrder)
+		if err != nil {
+			return rec, false, err
+		}
+		amount, err := parseAmount(h.get(row, "amount"))
+		if err != nil {
+			return rec, false, err
+		}
+		if amount < 0 {
+			amount = -amount
+		}
+
+		rec = Record{
+			Date:     date,
+			Amount:   amount,
+			Type:     models.Debit,
+			Details:  h.get(row, "original description", "description"),
+			Payee:    h.get(row, "description"),
+			Category: helpers.MapCategory(h.get(row, "category")),
+			Account:  h.get(row, "account name"),
+			Notes:    h.get(row, "notes"),
+			Tags:     strings.Fields(h.get(row, "labels")),
+		}
+		if strings.EqualFold(h.get(row, "transaction type"), "credit") {
+			rec.Type = models.Credit
+		}
+		return rec, false, nil
+	},
+}
@@ -0,0 +1,80 @@
+package importers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/UmangSachdeva/PaymentX/helpers"
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+// moneyManager reads the export of Money Manager by Realbyte. The date is in
+// a Period column, amounts are unsigned with an Income/Expense column, and
+// transfers are written as Transfer-Out and Transfer-In.
+var moneyManager = profile{
+	name:     "moneymanager",
+	required: []string{"category", "income/expense"},
+	dates:    []string{"period", "date"},
+	order:    "mdy",
+	parse: func(h header, row []string, opts Options) (Record, bool, error) {
+		var rec Record
+
+		date, err := parseDate(h.get(row, "period", "date"), opts.DateOrder)
+		if err != nil {
+			return rec, false, err
+		}
+
+		// The amount is under Amount in newer versions and under the
+		// currency code in older ones
+		value := h.get(row, "amount")
+		if value == "" {
+			value = h.get(row, "inr", "usd", "eur", "gbp")
+		}
+		amount, err := parseAmount(value)
+		if err != nil {
+			return rec, false, err
+		}
+		if amount < 0 {
+			amount = -amount
+		}
+
+		category := h.get(row, "category")
+		subcategory := h.get(row, "subcategory")
+		note := h.get(row, "note")
+		description := h.get(row, "description")
+
+		rec = Record{
+			Date:    date,
+			Amount:  amount,
+			Details: note,
+			Account: h.get(row, "accounts", "account"),
+			Notes:   description,
+		}
+		if rec.Details == "" {
+			rec.Details = strings.TrimSpace(category + " " + subcategory)
+		}
+
+		kind := strings.ToLower(h.get(row, "income/expense"))
+		switch {
+		case strings.HasPrefix(kind, "transfer"):
+			// Left for transfer detection to pair
+			rec.Type = models.Debit
+			if strings.HasSuffix(kind, "in") {
+				rec.Type = models.Credit
+			}
+			return rec, false, nil
+		case strings.HasPrefix(kind, "inc"):
+			rec.Type = models.Credit
+		case strings.HasPrefix(kind, "exp"):
+			rec.Type = models.Debit
+		default:
+			return rec, false, fmt.Errorf("unknown Income/Expense %q", kind)
+		}
+
+		rec.Category = helpers.MapCategory(subcategory)
+		if rec.Category == "" {
+			rec.Category = helpers.MapCategory(category)
+		}
+		return rec, false, nil
+	},
+}
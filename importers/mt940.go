@@ -0,0 +1,219 @@
+package importers
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// isMT940 reports whether data is a SWIFT MT940 statement.
+func isMT940(data []byte) bool {
+	head := data[:min(len(data), 4096)]
+	return bytes.Contains(head, []byte(":20:")) && bytes.Contains(head, []byte(":25:")) &&
+		(bytes.Contains(data, []byte(":60F:")) || bytes.Contains(data, []byte(":60M:")))
+}
+
+// mt940Field is one tagged field of a statement, with its continuation
+// lines.
+type mt940Field struct {
+	tag   string
+	value string
+}
+
+var mt940Tag = regexp.MustCompile(`^:([0-9]{2}[A-Z]?):`)
+
+// mt940Fields splits a file into its tagged fields. SWIFT envelopes such as
+// {1:...}{4: and the closing -} are dropped.
+func mt940Fields(data []byte) []mt940Field {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+
+	var fields []mt940Field
+	for _, line := range strings.Split(text, "\n") {
+		if i := strings.Index(line, "{4:"); i >= 0 {
+			line = line[i+len("{4:"):]
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "-}" || trimmed == "-" || strings.HasPrefix(trimmed, "{") {
+			continue
+		}
+		if m := mt940Tag.FindStringSubmatch(line); m != nil {
+			fields = append(fields, mt940Field{tag: m[1], value: strings.TrimSpace(line[len(m[0]):])})
+			continue
+		}
+		if len(fields) > 0 && trimmed != "" {
+			fields[len(fields)-1].value += "\n" + trimmed
+		}
+	}
+	return fields
+}
+
+// mt940Line matches a :61: statement line: the value date, an optional entry
+// date, the debit or credit mark, an optional funds code, the amount, the
+// transaction type, the customer's reference, the bank's reference after //
+// and supplementary details on the next line.
+var mt940Line = regexp.MustCompile(`^(\d{6})(\d{4})?(RC|RD|C|D)([A-Z])?(\d+,\d*)([NFS][A-Z0-9]{3})([^/\n]*)(?://([^\n]*))?(?:\n(.*))?$`)
+
+// mt940Balance matches a balance field: the mark, the date, the currency
+// and the amount.
+var mt940Balance = regexp.MustCompile(`^([CD])(\d{6})([A-Z]{3})(\d+,\d*)`)
+
+// parseMT940 reads the statements of an MT940 file, each of which starts
+// with a :20: field. The bank's reference of a :61: line is its id; the
+// customer's reference is not used since it need not be unique.
+func parseMT940(data []byte) ([]Statement, error) {
+	var statements []Statement
+	var s *Statement
+	var rec *Record
+
+	for _, field := range mt940Fields(data) {
+		if field.tag == "20" {
+			statements = append(statements, Statement{})
+			s = &statements[len(statements)-1]
+			rec = nil
+			continue
+		}
+		if s == nil {
+			continue
+		}
+
+		switch field.tag {
+		case "25":
+			s.Account = field.value
+		case "60F", "60M":
+			amount, date, currency, err := mt940ParseBalance(field.value)
+			if err != nil {
+				return nil, err
+			}
+			s.Opening, s.Currency = amount, currency
+			s.Start = date
+		case "62F", "62M":
+			amount, date, _, err := mt940ParseBalance(field.value)
+			if err != nil {
+				return nil, err
+			}
+			s.Closing, s.End = amount, date
+			s.HasBalances = true
+		case "61":
+			r, err := mt940Record(field.value)
+			if err != nil {
+				return nil, err
+			}
+			r.Account = s.Account
+			s.Records = append(s.Records, r)
+			rec = &s.Records[len(s.Records)-1]
+		case "86":
+			if rec != nil {
+				payee, details := mt940Information(field.value)
+				rec.Payee = payee
+				rec.Details = joinDetails(payee, details, rec.Details)
+			}
+		}
+	}
+	return statements, nil
+}
+
+// mt940ParseBalance reads a :60: or :62: balance.
+func mt940ParseBalance(value string) (float64, time.Time, string, error) {
+	m := mt940Balance.FindStringSubmatch(value)
+	if m == nil {
+		return 0, time.Time{}, "", fmt.Errorf("invalid balance %q", value)
+	}
+	date, err := mt940Date(m[2])
+	if err != nil {
+		return 0, time.Time{}, "", err
+	}
+	amount, err := parseOFXAmount(m[4])
+	if err != nil {
+		return 0, time.Time{}, "", err
+	}
+	if m[1] == "D" {
+		amount = -amount
+	}
+	return amount, date, m[3], nil
+}
+
+func mt940Record(value string) (Record, error) {
+	var rec Record
+
+	m := mt940Line.FindStringSubmatch(value)
+	if m == nil {
+		return rec, fmt.Errorf("invalid statement line %q", value)
+	}
+	date, err := mt940Date(m[1])
+	if err != nil {
+		return rec, err
+	}
+	// The entry date has no year of its own; a December value date can be
+	// booked in January
+	if m[2] != "" {
+		entry, err := time.Parse("20060102", date.Format("2006")+m[2])
+		if err != nil {
+			return rec, fmt.Errorf("invalid entry date %q", m[2])
+		}
+		switch {
+		case entry.Sub(date) > 180*24*time.Hour:
+			entry = entry.AddDate(-1, 0, 0)
+		case date.Sub(entry) > 180*24*time.Hour:
+			entry = entry.AddDate(1, 0, 0)
+		}
+		date = entry
+	}
+
+	amount, err := parseOFXAmount(m[5])
+	if err != nil {
+		return rec, err
+	}
+	// A reversed credit takes money out, and a reversed debit puts it back
+	if m[3] == "D" || m[3] == "RC" {
+		amount = -amount
+	}
+
+	rec = Record{Date: date, Details: strings.TrimSpace(m[9])}
+	if ref := strings.TrimSpace(m[8]); ref != "" && ref != "NONREF" {
+		rec.Reference = ref
+	}
+	rec.signed(amount)
+	return rec, nil
+}
+
+// mt940Subfield matches the ?NN subfields some banks structure :86: with.
+var mt940Subfield = regexp.MustCompile(`\?(\d{2})`)
+
+// mt940Information reads the payee and narration from a :86: field. When it
+// is structured with ?NN subfields, ?20-?29 and ?60-?63 hold the purpose and
+// ?32-?33 the other party's name; otherwise the whole field is the
+// narration.
+func mt940Information(value string) (payee, details string) {
+	value = strings.ReplaceAll(value, "\n", "")
+	bounds := mt940Subfield.FindAllStringSubmatchIndex(value, -1)
+	if len(bounds) == 0 {
+		return "", value
+	}
+
+	var name, purpose []string
+	for i, b := range bounds {
+		end := len(value)
+		if i+1 < len(bounds) {
+			end = bounds[i+1][0]
+		}
+		code, text := value[b[2]:b[3]], strings.TrimSpace(value[b[1]:end])
+		switch {
+		case code == "32" || code == "33":
+			name = append(name, text)
+		case code >= "20" && code <= "29", code >= "60" && code <= "63":
+			purpose = append(purpose, text)
+		}
+	}
+	return strings.Join(name, ""), strings.Join(purpose, " ")
+}
+
+// mt940Date reads a YYMMDD date.
+func mt940Date(s string) (time.Time, error) {
+	t, err := time.Parse("060102", s)
+	if err != nil {
+		return t, fmt.Errorf("invalid date %q", s)
+	}
+	return t, nil
+}
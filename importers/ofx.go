@@ -0,0 +1,257 @@
+package importers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+// ofxNode is an element of an OFX file. Leaves have a value, aggregates
+// children.
+type ofxNode struct {
+	name     string
+	value    string
+	children []*ofxNode
+}
+
+// find returns the first descendant named name.
+func (n *ofxNode) find(name string) *ofxNode {
+	for _, child := range n.children {
+		if child.name == name {
+			return child
+		}
+		if found := child.find(name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// get returns the value of the first of names found below n.
+func (n *ofxNode) get(names ...string) string {
+	if n == nil {
+		return ""
+	}
+	for _, name := range names {
+		if found := n.find(name); found != nil && found.value != "" {
+			return found.value
+		}
+	}
+	return ""
+}
+
+// all returns the descendants named one of names, not looking inside the
+// ones found.
+func (n *ofxNode) all(names ...string) []*ofxNode {
+	var found []*ofxNode
+	for _, child := range n.children {
+		matched := false
+		for _, name := range names {
+			if child.name == name {
+				matched = true
+			}
+		}
+		if matched {
+			found = append(found, child)
+		} else {
+			found = append(found, child.all(names...)...)
+		}
+	}
+	return found
+}
+
+// isOFX reports whether data is an OFX or QFX file, in either its SGML
+// (1.x) or XML (2.x) form.
+func isOFX(data []byte) bool {
+	head := bytes.ToUpper(data[:min(len(data), 4096)])
+	return bytes.Contains(head, []byte("OFXHEADER")) || bytes.Contains(head, []byte("<OFX>"))
+}
+
+// parseOFXTree reads the elements of an OFX file. SGML files leave the tags
+// of leaves unclosed, so a tag directly followed by text is taken as a leaf
+// whatever comes after it, an empty tag is only an aggregate if the file
+// closes that element somewhere, and a closing tag closes every aggregate
+// opened since its own.
+func parseOFXTree(data []byte) (*ofxNode, error) {
+	s := string(data)
+	start := strings.Index(strings.ToUpper(s), "<OFX>")
+	if start < 0 {
+		return nil, errors.New("no <OFX> element")
+	}
+	s = s[start:]
+
+	closed := make(map[string]bool)
+	for _, m := range ofxClosingTag.FindAllStringSubmatch(s, -1) {
+		closed[strings.ToUpper(m[1])] = true
+	}
+
+	root := &ofxNode{}
+	stack := []*ofxNode{root}
+	for {
+		open := strings.IndexByte(s, '<')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(s[open:], '>')
+		if end < 0 {
+			return nil, errors.New("unterminated tag")
+		}
+		tag := strings.ToUpper(strings.TrimSpace(s[open+1 : open+end]))
+		s = s[open+end+1:]
+
+		switch {
+		case tag == "" || tag[0] == '?' || tag[0] == '!':
+			continue
+		case tag[0] == '/':
+			name := tag[1:]
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+			continue
+		}
+		// Attributes are not used by OFX, and a self-closing tag is empty
+		selfClosing := strings.HasSuffix(tag, "/")
+		tag = strings.TrimSuffix(strings.Fields(tag)[0], "/")
+
+		text := s
+		if next := strings.IndexByte(s, '<'); next >= 0 {
+			text = s[:next]
+		}
+		node := &ofxNode{name: tag, value: html.UnescapeString(strings.TrimSpace(text))}
+		parent := stack[len(stack)-1]
+		parent.children = append(parent.children, node)
+		if node.value == "" && !selfClosing && closed[tag] {
+			stack = append(stack, node)
+		}
+	}
+	return root, nil
+}
+
+var ofxClosingTag = regexp.MustCompile(`</\s*([A-Za-z0-9.]+)\s*>`)
+
+// ofxAccountTypes maps the ACCTTYPE of a bank account to ours.
+var ofxAccountTypes = map[string]models.AccountType{
+	"CHECKING":   models.CurrentAccount,
+	"SAVINGS":    models.SavingsAccount,
+	"MONEYMRKT":  models.SavingsAccount,
+	"CREDITLINE": models.CreditCardAccount,
+}
+
+// parseOFX reads the bank and credit card statements of an OFX file. FITID
+// is the bank's id for a transaction, unique within the account.
+func parseOFX(data []byte) ([]Statement, error) {
+	root, err := parseOFXTree(data)
+	if err != nil {
+		return nil, err
+	}
+	institution := root.find("FI").get("ORG")
+
+	var statements []Statement
+	for _, stmt := range root.all("STMTRS", "CCSTMTRS") {
+		account := stmt.find("BANKACCTFROM")
+		if account == nil {
+			account = stmt.find("CCACCTFROM")
+		}
+
+		s := Statement{
+			Account:  account.get("ACCTID"),
+			Name:     institution,
+			Type:     ofxAccountTypes[account.get("ACCTTYPE")],
+			Currency: stmt.get("CURDEF"),
+		}
+		if stmt.name == "CCSTMTRS" {
+			s.Type = models.CreditCardAccount
+		}
+		list := stmt.find("BANKTRANLIST")
+		if list != nil {
+			s.Start, _ = parseOFXDate(list.get("DTSTART"))
+			s.End, _ = parseOFXDate(list.get("DTEND"))
+
+			for _, trn := range list.all("STMTTRN") {
+				rec, err := ofxRecord(trn)
+				if err != nil {
+					return nil, err
+				}
+				rec.Account = s.Account
+				s.Records = append(s.Records, rec)
+			}
+		}
+
+		if balance := stmt.find("LEDGERBAL"); balance != nil {
+			s.Closing, err = parseOFXAmount(balance.get("BALAMT"))
+			if err != nil {
+				return nil, err
+			}
+			s.HasBalances = true
+			s.deriveOpening()
+		}
+		statements = append(statements, s)
+	}
+	return statements, nil
+}
+
+func ofxRecord(trn *ofxNode) (Record, error) {
+	var rec Record
+
+	date, err := parseOFXDate(trn.get("DTPOSTED", "DTUSER"))
+	if err != nil {
+		return rec, err
+	}
+	amount, err := parseOFXAmount(trn.get("TRNAMT"))
+	if err != nil {
+		return rec, fmt.Errorf("transaction %s: %w", trn.get("FITID"), err)
+	}
+
+	name := trn.get("NAME")
+	rec = Record{
+		Date:      date,
+		Details:   joinDetails(name, trn.get("MEMO")),
+		Payee:     name,
+		Reference: trn.get("FITID"),
+	}
+	if check := trn.get("CHECKNUM"); check != "" {
+		rec.Details = joinDetails(rec.Details, "Cheque "+check)
+	}
+	rec.signed(amount)
+	return rec, nil
+}
+
+// parseOFXDate reads dates such as 20240115, 20240115120000 or
+// 20240115120000.000[+5.30:IST]. The time zone is dropped so the date stays
+// the one the bank printed.
+func parseOFXDate(s string) (time.Time, error) {
+	digits := s
+	if i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		digits = s[:i]
+	}
+	switch {
+	case len(digits) >= 14:
+		return time.Parse("20060102150405", digits[:14])
+	case len(digits) >= 8:
+		return time.Parse("20060102", digits[:8])
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+// parseOFXAmount reads a signed amount. Some banks write a decimal comma.
+func parseOFXAmount(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, ".") {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	v, err := strconv.ParseFloat(strings.TrimPrefix(s, "+"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	return v, nil
+}
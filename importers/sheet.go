@@ -0,0 +1,84 @@
+package importers
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadSheet reads the rows of an uploaded file, telling an Excel workbook,
+// an HTML table saved as .xls and a comma, semicolon or tab separated file
+// apart by their contents. Only the first sheet of a workbook is read.
+func ReadSheet(data []byte) ([][]string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return readXLSX(data)
+	case bytes.HasPrefix(data, cfbSignature):
+		return readXLS(data)
+	case isHTMLTable(data):
+		return readHTMLTable(data)
+	}
+	return ReadCSV(bytes.NewReader(data))
+}
+
+// Built in number formats that display a date.
+var excelDateFormats = map[int]bool{
+	14: true, 15: true, 16: true, 17: true, 18: true, 19: true, 20: true, 21: true, 22: true,
+	45: true, 46: true, 47: true,
+}
+
+// isDateFormat reports whether a custom number format code displays a date,
+// ignoring quoted text and bracketed colours and conditions.
+func isDateFormat(code string) bool {
+	inQuote, inBracket := false, false
+	for _, r := range strings.ToLower(code) {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case r == '[':
+			inBracket = true
+		case r == ']':
+			inBracket = false
+		case inBracket:
+		case r == 'd' || r == 'm' || r == 'y':
+			return true
+		}
+	}
+	return false
+}
+
+// excelValue writes a number read from a workbook the way it would read in
+// a CSV export, as a date when its cell is formatted as one.
+func excelValue(v float64, date, date1904 bool) string {
+	if !date {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	days := math.Floor(v)
+	seconds := math.Round((v - days) * 24 * 60 * 60)
+	t := epoch.AddDate(0, 0, int(days)).Add(time.Duration(seconds) * time.Second)
+	if seconds == 0 {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// setCell stores a value in rows at a zero based row and column, growing
+// rows as needed so row numbers match the sheet's.
+func setCell(rows [][]string, row, col int, value string) [][]string {
+	for len(rows) <= row {
+		rows = append(rows, nil)
+	}
+	for len(rows[row]) <= col {
+		rows[row] = append(rows[row], "")
+	}
+	rows[row][col] = value
+	return rows
+}
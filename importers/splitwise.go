@@ -0,0 +1,95 @@
+package importers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/UmangSachdeva/PaymentX/helpers"
+)
+
+// splitwiseAccount is the account Splitwise balances are kept in.
+const splitwiseAccount = "Splitwise"
+
+// splitwise reads a group or friend export from Splitwise. After the cost
+// and currency there is a column per member with their share of the balance:
+// positive when they are owed, negative when they owe. Only the importing
+// member's column is kept, so the Splitwise account tracks what they are
+// owed overall.
+var splitwise = profile{
+	name:     "splitwise",
+	required: []string{"date", "description", "category", "cost", "currency"},
+	dates:    []string{"date"},
+	order:    "ymd",
+	check: func(h header, opts Options) error {
+		_, err := splitwiseMember(h, opts.Member)
+		return err
+	},
+	parse: func(h header, row []string, opts Options) (Record, bool, error) {
+		var rec Record
+
+		member, err := splitwiseMember(h, opts.Member)
+		if err != nil {
+			return rec, false, err
+		}
+
+		description := h.get(row, "description")
+		// The export ends with the overall balances
+		if strings.EqualFold(description, "Total balance") || h.get(row, "date") == "" {
+			return rec, true, nil
+		}
+
+		date, err := parseDate(h.get(row, "date"), opts.DateOrder)
+		if err != nil {
+			return rec, false, err
+		}
+		share, err := parseAmount(h.get(row, member))
+		if err != nil {
+			return rec, false, err
+		}
+		// Expenses the member was not part of
+		if share == 0 {
+			return rec, true, nil
+		}
+
+		category := h.get(row, "category")
+		rec = Record{
+			Date:     date,
+			Details:  description,
+			Category: helpers.MapCategory(category),
+			Account:  splitwiseAccount,
+			Tags:     []string{"splitwise"},
+		}
+		rec.signed(share)
+		if strings.EqualFold(category, "Payment") {
+			// Settling up moves money rather than spending it
+			rec.Category = ""
+			rec.Tags = append(rec.Tags, "settlement")
+		}
+		return rec, false, nil
+	},
+}
+
+// splitwiseMember finds the column of the importing member. It can be left
+// out when the export has only one member.
+func splitwiseMember(h header, member string) (string, error) {
+	if member != "" {
+		member = strings.ToLower(strings.TrimSpace(member))
+		if _, ok := h[member]; !ok {
+			return "", fmt.Errorf("no column for member %q", member)
+		}
+		return member, nil
+	}
+
+	currency := h["currency"]
+	var members []string
+	for name, i := range h {
+		if i > currency {
+			members = append(members, name)
+		}
+	}
+	if len(members) != 1 {
+		return "", errors.New("member is required to pick your column")
+	}
+	return members[0], nil
+}
@@ -0,0 +1,179 @@
+package importers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+// Statement is one account's statement read from a bank file. Unlike the
+// exports of finance apps, bank files carry the account number and the
+// balances the period opened and closed with.
+type Statement struct {
+	// Account is the account number or IBAN, and Name what the bank calls
+	// the account, when the file says
+	Account  string
+	Name     string
+	Type     models.AccountType
+	Currency string
+	Start    time.Time
+	End      time.Time
+	// Opening and Closing are only meaningful when HasBalances is set.
+	// Derived is set when the file only gave the closing balance and the
+	// opening one was worked back from the transactions.
+	Opening     float64
+	Closing     float64
+	HasBalances bool
+	Derived     bool
+	Records     []Record
+}
+
+// statementFormat reads one kind of bank file.
+type statementFormat struct {
+	name   string
+	detect func(data []byte) bool
+	parse  func(data []byte) ([]Statement, error)
+}
+
+// statementFormats are checked in order by DetectStatement.
+var statementFormats = []statementFormat{
+	{"camt053", isCAMT053, parseCAMT053},
+	{"ofx", isOFX, parseOFX},
+	{"mt940", isMT940, parseMT940},
+}
+
+// StatementFormats lists the bank files that can be imported. qfx is read
+// as ofx.
+func StatementFormats() []string {
+	names := make([]string, len(statementFormats))
+	for i, f := range statementFormats {
+		names[i] = f.name
+	}
+	return names
+}
+
+// IsStatementFormat reports whether format names a bank file rather than
+// the export of a finance app.
+func IsStatementFormat(format string) bool {
+	if format == "qfx" {
+		return true
+	}
+	for _, f := range statementFormats {
+		if f.name == format {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectStatement names the kind of bank file data is, if it is one.
+func DetectStatement(data []byte) (string, bool) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	for _, f := range statementFormats {
+		if f.detect(data) {
+			return f.name, true
+		}
+	}
+	return "", false
+}
+
+// ParseStatements reads a bank file as format, or as whichever kind is
+// detected when format is empty. Statements without transactions are kept
+// so their balances can still be reconciled.
+func ParseStatements(format string, data []byte) ([]Statement, error) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	if format == "" {
+		var ok bool
+		if format, ok = DetectStatement(data); !ok {
+			return nil, errors.New("could not tell what kind of bank file this is")
+		}
+	}
+	if format == "qfx" {
+		format = "ofx"
+	}
+
+	for _, f := range statementFormats {
+		if f.name != format {
+			continue
+		}
+		statements, err := f.parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", format, err)
+		}
+		if len(statements) == 0 {
+			return nil, fmt.Errorf("no statements found in the %s file", format)
+		}
+		for i := range statements {
+			statements[i].fillPeriod()
+		}
+		return statements, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// fillPeriod falls back on the dates of the records for a period the file
+// left out.
+func (s *Statement) fillPeriod() {
+	if len(s.Records) == 0 {
+		return
+	}
+	sort.SliceStable(s.Records, func(i, j int) bool {
+		return s.Records[i].Date.Before(s.Records[j].Date)
+	})
+	if s.Start.IsZero() {
+		s.Start = s.Records[0].Date
+	}
+	if s.End.IsZero() {
+		s.End = s.Records[len(s.Records)-1].Date
+	}
+}
+
+// deriveOpening works the opening balance back from the closing one.
+func (s *Statement) deriveOpening() {
+	opening := s.Closing
+	for _, rec := range s.Records {
+		opening -= rec.signedAmount()
+	}
+	s.Opening = round2(opening)
+	s.Derived = true
+}
+
+// signedAmount is the amount of a record, negative for a debit.
+func (rec Record) signedAmount() float64 {
+	if rec.Type == models.Debit {
+		return -rec.Amount
+	}
+	return rec.Amount
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// joinDetails joins the non-empty parts of a narration, leaving out parts
+// that repeat an earlier one.
+func joinDetails(parts ...string) string {
+	var kept []string
+	for _, part := range parts {
+		part = strings.Join(strings.Fields(part), " ")
+		if part == "" {
+			continue
+		}
+		repeated := false
+		for _, k := range kept {
+			if strings.Contains(k, part) {
+				repeated = true
+			}
+		}
+		if !repeated {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, " ")
+}
@@ -0,0 +1,188 @@
+package importers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/models"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04:05", s)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", s)
+	}
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseStatements(t *testing.T) {
+	type statement struct {
+		account  string
+		name     string
+		kind     models.AccountType
+		currency string
+		start    string
+		end      string
+		opening  float64
+		closing  float64
+		records  []Record
+	}
+	tests := []struct {
+		file       string
+		format     string
+		statements []statement
+	}{
+		{
+			file:   "ofx_sgml.ofx",
+			format: "ofx",
+			statements: []statement{
+				{
+					account: "50100012345678", name: "HDFC Bank", kind: models.SavingsAccount, currency: "INR",
+					start: "2024-03-01", end: "2024-03-31", opening: 10000, closing: 80250.50,
+					records: []Record{
+						{Date: date("2024-03-01"), Amount: 75000, Type: models.Credit, Details: "ACME PAYROLL SALARY MAR 2024", Payee: "ACME PAYROLL", Reference: "S2024030100001"},
+						{Date: date("2024-03-05 12:05:12"), Amount: 1249.50, Type: models.Debit, Details: "SWIGGY Cheque 000451", Payee: "SWIGGY", Reference: "S2024030500002"},
+						{Date: date("2024-03-12"), Amount: 3500, Type: models.Debit, Details: "DMART & CO POS 4321XXXX1234 DMART", Payee: "DMART & CO", Reference: "S2024031200003"},
+					},
+				},
+				{
+					account: "4321XXXXXXXX1234", name: "HDFC Bank", kind: models.CreditCardAccount, currency: "INR",
+					start: "2024-03-01", end: "2024-03-31", opening: 0, closing: -899,
+					records: []Record{
+						{Date: date("2024-03-10"), Amount: 899, Type: models.Debit, Details: "NETFLIX", Payee: "NETFLIX", Reference: "C9001"},
+					},
+				},
+			},
+		},
+		{
+			file:   "ofx_xml.qfx",
+			format: "ofx",
+			statements: []statement{
+				{
+					account: "000401234567", name: "ICICI Bank", kind: models.CurrentAccount, currency: "INR",
+					start: "2024-03-01", end: "2024-03-31", opening: 50000, closing: 48700,
+					records: []Record{
+						{Date: date("2024-03-02"), Amount: 2500, Type: models.Debit, Details: "NOBROKER RENT", Payee: "NOBROKER RENT", Reference: "IC240302A1"},
+						{Date: date("2024-03-15"), Amount: 1200, Type: models.Credit, Details: "RAVI KUMAR UPI/407512345678/dinner share", Payee: "RAVI KUMAR", Reference: "IC240315B7"},
+					},
+				},
+			},
+		},
+		{
+			file:   "camt053.xml",
+			format: "camt053",
+			statements: []statement{
+				{
+					account: "DE89370400440532013000", name: "Commerzbank", currency: "EUR",
+					start: "2024-03-01", end: "2024-03-31", opening: 1000, closing: 2154.55,
+					records: []Record{
+						{Date: date("2024-03-01"), Amount: 2500, Type: models.Credit, Details: "Acme GmbH Gehalt Maerz 2024", Payee: "Acme GmbH", Reference: "2024030100017"},
+						{Date: date("2024-03-04 10:15:00"), Amount: 1345.45, Type: models.Debit, Details: "Hausverwaltung Schmidt Miete Maerz Whg 4B SEPA-UEBERWEISUNG", Payee: "Hausverwaltung Schmidt", Reference: "2024030400231"},
+					},
+				},
+			},
+		},
+		{
+			file:   "mt940.sta",
+			format: "mt940",
+			statements: []statement{
+				{
+					account: "37040044/0532013000", currency: "EUR",
+					start: "2024-03-01", end: "2024-03-31", opening: 1000, closing: 2142.05,
+					records: []Record{
+						{Date: date("2024-03-01"), Amount: 2500, Type: models.Credit, Details: "Acme GmbH Gehalt Maerz 2024", Payee: "Acme GmbH", Reference: "2024030100017"},
+						{Date: date("2024-03-04"), Amount: 1345.45, Type: models.Debit, Details: "Miete Maerz Whg 4B Hausverwaltung Schmidt SEPA-UEBERWEISUNG", Reference: "2024030400231"},
+						// RD reverses a debit, so the bank charge comes back
+						{Date: date("2024-03-29"), Amount: 12.50, Type: models.Credit},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			format, ok := DetectStatement(data)
+			if !ok || format != tt.format {
+				t.Fatalf("detected %q, want %q", format, tt.format)
+			}
+			statements, err := ParseStatements(format, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(statements) != len(tt.statements) {
+				t.Fatalf("got %d statements, want %d", len(statements), len(tt.statements))
+			}
+
+			for i, want := range tt.statements {
+				got := statements[i]
+				if got.Account != want.account || got.Name != want.name || got.Type != want.kind || got.Currency != want.currency {
+					t.Errorf("statement %d is %q %q %q %q, want %q %q %q %q", i, got.Account, got.Name, got.Type, got.Currency, want.account, want.name, want.kind, want.currency)
+				}
+				if got.Start.Format("2006-01-02") != want.start || got.End.Format("2006-01-02") != want.end {
+					t.Errorf("statement %d covers %s to %s, want %s to %s", i, got.Start, got.End, want.start, want.end)
+				}
+				if !got.HasBalances || got.Opening != want.opening || got.Closing != want.closing {
+					t.Errorf("statement %d balances %v %v %v, want %v %v", i, got.HasBalances, got.Opening, got.Closing, want.opening, want.closing)
+				}
+				if len(got.Records) != len(want.records) {
+					t.Fatalf("statement %d has %d records, want %d", i, len(got.Records), len(want.records))
+				}
+				for j, rec := range want.records {
+					rec.Account = want.account
+					if g := got.Records[j]; !g.Date.Equal(rec.Date) || g.Amount != rec.Amount || g.Type != rec.Type || g.Details != rec.Details || g.Payee != rec.Payee || g.Reference != rec.Reference || g.Account != rec.Account {
+						t.Errorf("statement %d record %d is\n%+v\nwant\n%+v", i, j, g, rec)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseOFXTreeEmptyLeaves(t *testing.T) {
+	tests := []struct {
+		name string
+		ofx  string
+		// want lists the children of the first STMTTRN
+		want []string
+	}{
+		{"unclosed empty leaf", "<OFX><STMTTRN><NAME>SWIGGY<MEMO><CHECKNUM>12<FITID>A1</STMTTRN></OFX>", []string{"NAME", "MEMO", "CHECKNUM", "FITID"}},
+		{"closed empty leaf", "<OFX><STMTTRN><NAME>SWIGGY</NAME><MEMO></MEMO><FITID>A1</FITID></STMTTRN></OFX>", []string{"NAME", "MEMO", "FITID"}},
+		{"self-closing leaf", "<OFX><STMTTRN><MEMO/><FITID>A1</STMTTRN></OFX>", []string{"MEMO", "FITID"}},
+		{"empty leaf ends aggregate", "<OFX><STMTTRN><FITID>A1<MEMO></STMTTRN><STMTTRN><FITID>A2</STMTTRN></OFX>", []string{"FITID", "MEMO"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := parseOFXTree([]byte(tt.ofx))
+			if err != nil {
+				t.Fatal(err)
+			}
+			trns := root.all("STMTTRN")
+			if len(trns) == 0 {
+				t.Fatal("no STMTTRN")
+			}
+			var got []string
+			for _, child := range trns[0].children {
+				got = append(got, child.name)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got children %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got children %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
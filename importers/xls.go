@@ -0,0 +1,506 @@
+package importers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode/utf16"
+)
+
+// cfbSignature starts a compound file, the container of Excel 97-2003
+// workbooks.
+var cfbSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+const (
+	cfbEndOfChain = 0xFFFFFFFE
+	cfbFree       = 0xFFFFFFFF
+)
+
+// xlsRowLimit and xlsColLimit are the size of a BIFF8 sheet; cells claimed
+// beyond them are dropped.
+const (
+	xlsRowLimit = 1 << 16
+	xlsColLimit = 1 << 8
+)
+
+// compoundFile reads the streams of a compound file.
+type compoundFile struct {
+	data       []byte
+	sectorSize int
+	fat        []uint32
+	miniFAT    []uint32
+	miniStream []byte
+	cutoff     uint32
+	entries    []cfbEntry
+}
+
+type cfbEntry struct {
+	name  string
+	kind  byte
+	start uint32
+	size  uint32
+}
+
+func openCompoundFile(data []byte) (*compoundFile, error) {
+	if len(data) < 512 || !bytes.HasPrefix(data, cfbSignature) {
+		return nil, errors.New("not an Excel 97-2003 workbook")
+	}
+	le := binary.LittleEndian
+	shift := le.Uint16(data[0x1E:])
+	if shift != 9 && shift != 12 {
+		return nil, errors.New("invalid sector size in workbook")
+	}
+	cf := &compoundFile{data: data, sectorSize: 1 << shift, cutoff: le.Uint32(data[0x38:])}
+
+	// The sectors of the FAT are listed in the header, and then in a chain
+	// of DIFAT sectors. The file cannot hold more sectors than its size
+	// allows, and a chain that comes back on itself is broken
+	var fatSectors []uint32
+	for i := 0; i < 109; i++ {
+		fatSectors = append(fatSectors, le.Uint32(data[0x4C+4*i:]))
+	}
+	difat, count := le.Uint32(data[0x44:]), le.Uint32(data[0x48:])
+	count = min(count, uint32(len(data)/cf.sectorSize))
+	visited := make(map[uint32]bool)
+	for i := uint32(0); i < count && difat != cfbEndOfChain && difat != cfbFree; i++ {
+		if visited[difat] {
+			return nil, errors.New("broken DIFAT chain in workbook")
+		}
+		visited[difat] = true
+		sector, err := cf.sector(difat)
+		if err != nil {
+			return nil, err
+		}
+		perSector := cf.sectorSize/4 - 1
+		for j := 0; j < perSector; j++ {
+			fatSectors = append(fatSectors, le.Uint32(sector[4*j:]))
+		}
+		difat = le.Uint32(sector[4*perSector:])
+	}
+	for _, id := range fatSectors[:min(len(fatSectors), int(le.Uint32(data[0x2C:])))] {
+		sector, err := cf.sector(id)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < cf.sectorSize/4; j++ {
+			cf.fat = append(cf.fat, le.Uint32(sector[4*j:]))
+		}
+	}
+
+	dir, err := cf.chain(le.Uint32(data[0x30:]))
+	if err != nil {
+		return nil, err
+	}
+	for off := 0; off+128 <= len(dir); off += 128 {
+		entry := dir[off : off+128]
+		nameLen := int(le.Uint16(entry[64:]))
+		if nameLen > 64 || nameLen < 2 {
+			nameLen = 2
+		}
+		name := make([]uint16, nameLen/2-1)
+		for i := range name {
+			name[i] = le.Uint16(entry[2*i:])
+		}
+		cf.entries = append(cf.entries, cfbEntry{
+			name:  string(utf16.Decode(name)),
+			kind:  entry[66],
+			start: le.Uint32(entry[116:]),
+			size:  le.Uint32(entry[120:]),
+		})
+	}
+	if len(cf.entries) == 0 || cf.entries[0].kind != 5 {
+		return nil, errors.New("workbook has no root entry")
+	}
+
+	root := cf.entries[0]
+	if cf.miniStream, err = cf.chain(root.start); err != nil {
+		return nil, err
+	}
+	miniFAT, err := cf.chain(le.Uint32(data[0x3C:]))
+	if err != nil {
+		return nil, err
+	}
+	for j := 0; j+4 <= len(miniFAT); j += 4 {
+		cf.miniFAT = append(cf.miniFAT, le.Uint32(miniFAT[j:]))
+	}
+	return cf, nil
+}
+
+func (cf *compoundFile) sector(id uint32) ([]byte, error) {
+	start := (int(id) + 1) * cf.sectorSize
+	if id >= cfbEndOfChain || start+cf.sectorSize > len(cf.data) {
+		return nil, fmt.Errorf("sector %d is out of range", id)
+	}
+	return cf.data[start : start+cf.sectorSize], nil
+}
+
+// chain reads the sectors linked from start in the FAT.
+func (cf *compoundFile) chain(start uint32) ([]byte, error) {
+	var out []byte
+	for id, n := start, 0; id != cfbEndOfChain && id != cfbFree; n++ {
+		if n > len(cf.fat) || int(id) >= len(cf.fat) {
+			return nil, errors.New("broken sector chain in workbook")
+		}
+		sector, err := cf.sector(id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sector...)
+		id = cf.fat[id]
+	}
+	return out, nil
+}
+
+// stream returns the contents of the stream named one of names.
+func (cf *compoundFile) stream(names ...string) ([]byte, error) {
+	for _, name := range names {
+		for _, entry := range cf.entries {
+			if entry.kind != 2 || entry.name != name {
+				continue
+			}
+			if entry.size >= cf.cutoff {
+				data, err := cf.chain(entry.start)
+				if err != nil {
+					return nil, err
+				}
+				return data[:min(len(data), int(entry.size))], nil
+			}
+
+			// Small streams live in 64 byte sectors of the mini stream
+			var out []byte
+			for id, n := entry.start, 0; id != cfbEndOfChain && id != cfbFree; n++ {
+				start := int(id) * 64
+				if n > len(cf.miniFAT) || int(id) >= len(cf.miniFAT) || start+64 > len(cf.miniStream) {
+					return nil, errors.New("broken mini sector chain in workbook")
+				}
+				out = append(out, cf.miniStream[start:start+64]...)
+				id = cf.miniFAT[id]
+			}
+			return out[:min(len(out), int(entry.size))], nil
+		}
+	}
+	return nil, errors.New("workbook stream not found")
+}
+
+// BIFF8 record types that are read.
+const (
+	biffFormula    = 0x0006
+	biffEOF        = 0x000A
+	biffDateMode   = 0x0022
+	biffContinue   = 0x003C
+	biffBoundSheet = 0x0085
+	biffMulRK      = 0x00BD
+	biffXF         = 0x00E0
+	biffSST        = 0x00FC
+	biffLabelSST   = 0x00FD
+	biffNumber     = 0x0203
+	biffLabel      = 0x0204
+	biffBoolErr    = 0x0205
+	biffString     = 0x0207
+	biffRKNumber   = 0x027E
+	biffFormat     = 0x041E
+	biffBOF        = 0x0809
+)
+
+type biffRecord struct {
+	kind uint16
+	data []byte
+	// continues are the CONTINUE records that follow it
+	continues [][]byte
+}
+
+// readXLS reads the first sheet of an Excel 97-2003 workbook.
+func readXLS(data []byte) ([][]string, error) {
+	cf, err := openCompoundFile(data)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := cf.stream("Workbook", "Book")
+	if err != nil {
+		return nil, err
+	}
+	records, err := biffRecords(stream)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || records[0].kind != biffBOF || len(records[0].data) < 2 ||
+		binary.LittleEndian.Uint16(records[0].data) != 0x0600 {
+		return nil, errors.New("only Excel 97 and later .xls workbooks can be read")
+	}
+
+	le := binary.LittleEndian
+	var strs []string
+	var xfFormats []int
+	formats := make(map[int]bool)
+	for id := range excelDateFormats {
+		formats[id] = true
+	}
+	date1904 := false
+	sheetOffset := -1
+
+	// The workbook globals come first, up to their EOF
+	for _, rec := range records[1:] {
+		if rec.kind == biffEOF {
+			break
+		}
+		switch rec.kind {
+		case biffSST:
+			if strs, err = biffSharedStrings(rec); err != nil {
+				return nil, err
+			}
+		case biffFormat:
+			if len(rec.data) > 2 {
+				code := biffString16(rec.data[2:])
+				formats[int(le.Uint16(rec.data))] = isDateFormat(code)
+			}
+		case biffXF:
+			if len(rec.data) >= 4 {
+				xfFormats = append(xfFormats, int(le.Uint16(rec.data[2:])))
+			}
+		case biffDateMode:
+			date1904 = len(rec.data) >= 2 && le.Uint16(rec.data) == 1
+		case biffBoundSheet:
+			// The first worksheet; charts and macro sheets are skipped
+			if sheetOffset < 0 && len(rec.data) >= 6 && rec.data[5] == 0 {
+				sheetOffset = int(le.Uint32(rec.data))
+			}
+		}
+	}
+	if sheetOffset < 0 || sheetOffset >= len(stream) {
+		return nil, errors.New("the workbook has no sheets")
+	}
+
+	var rows [][]string
+	set := func(row, col int, value string) {
+		if row < xlsRowLimit && col < xlsColLimit {
+			rows = setCell(rows, row, col, value)
+		}
+	}
+	isDate := func(xf int) bool {
+		return xf < len(xfFormats) && formats[xfFormats[xf]]
+	}
+	number := func(xf int, v float64) string {
+		return excelValue(v, isDate(xf), date1904)
+	}
+
+	sheet, err := biffRecords(stream[sheetOffset:])
+	if err != nil {
+		return nil, err
+	}
+	// A formula returning a string is followed by a STRING record with it
+	formulaRow, formulaCol := -1, -1
+	for _, rec := range sheet[1:] {
+		if rec.kind == biffEOF {
+			break
+		}
+		d := rec.data
+		if rec.kind != biffString && rec.kind != biffMulRK && len(d) < 6 {
+			continue
+		}
+
+		switch rec.kind {
+		case biffLabelSST:
+			if len(d) < 10 {
+				continue
+			}
+			if i := int(le.Uint32(d[6:])); i < len(strs) {
+				set(int(le.Uint16(d)), int(le.Uint16(d[2:])), strs[i])
+			}
+		case biffLabel:
+			text := biffString16(d[6:])
+			set(int(le.Uint16(d)), int(le.Uint16(d[2:])), text)
+		case biffNumber:
+			if len(d) < 14 {
+				continue
+			}
+			v := math.Float64frombits(le.Uint64(d[6:]))
+			set(int(le.Uint16(d)), int(le.Uint16(d[2:])), number(int(le.Uint16(d[4:])), v))
+		case biffRKNumber:
+			if len(d) < 10 {
+				continue
+			}
+			v := biffRK(le.Uint32(d[6:]))
+			set(int(le.Uint16(d)), int(le.Uint16(d[2:])), number(int(le.Uint16(d[4:])), v))
+		case biffMulRK:
+			if len(d) < 6 {
+				continue
+			}
+			row, col := int(le.Uint16(d)), int(le.Uint16(d[2:]))
+			for off := 4; off+6 <= len(d)-2; off += 6 {
+				v := biffRK(le.Uint32(d[off+2:]))
+				set(row, col, number(int(le.Uint16(d[off:])), v))
+				col++
+			}
+		case biffBoolErr:
+			if len(d) < 8 || d[7] != 0 {
+				continue
+			}
+			set(int(le.Uint16(d)), int(le.Uint16(d[2:])), strconv.Itoa(int(d[6])))
+		case biffFormula:
+			if len(d) < 14 {
+				continue
+			}
+			row, col := int(le.Uint16(d)), int(le.Uint16(d[2:]))
+			if le.Uint16(d[12:]) != 0xFFFF {
+				v := math.Float64frombits(le.Uint64(d[6:]))
+				set(row, col, number(int(le.Uint16(d[4:])), v))
+			} else if d[6] == 0 {
+				formulaRow, formulaCol = row, col
+			}
+		case biffString:
+			if formulaRow >= 0 {
+				text := biffString16(d)
+				set(formulaRow, formulaCol, text)
+				formulaRow, formulaCol = -1, -1
+			}
+		}
+	}
+	return rows, nil
+}
+
+// biffRecords splits a stream into records, attaching CONTINUE records to
+// the one they continue. It stops after the first EOF that closes the
+// substream it started in.
+func biffRecords(stream []byte) ([]biffRecord, error) {
+	var records []biffRecord
+	depth := 0
+	for off := 0; off+4 <= len(stream); {
+		kind := binary.LittleEndian.Uint16(stream[off:])
+		size := int(binary.LittleEndian.Uint16(stream[off+2:]))
+		if off+4+size > len(stream) {
+			return nil, errors.New("truncated workbook record")
+		}
+		data := stream[off+4 : off+4+size]
+		off += 4 + size
+
+		if kind == biffContinue && len(records) > 0 {
+			last := &records[len(records)-1]
+			last.continues = append(last.continues, data)
+			continue
+		}
+		records = append(records, biffRecord{kind: kind, data: data})
+
+		switch kind {
+		case biffBOF:
+			depth++
+		case biffEOF:
+			if depth--; depth <= 0 {
+				return records, nil
+			}
+		}
+	}
+	return records, nil
+}
+
+// biffRK decodes a number stored in RK form: a float with its low 32 bits
+// dropped, or a 30 bit integer, either optionally multiplied by 100.
+func biffRK(rk uint32) float64 {
+	var v float64
+	if rk&0x02 != 0 {
+		v = float64(int32(rk) >> 2)
+	} else {
+		v = math.Float64frombits(uint64(rk&0xFFFFFFFC) << 32)
+	}
+	if rk&0x01 != 0 {
+		v /= 100
+	}
+	return v
+}
+
+// biffString16 reads a string with a 16 bit length and an options byte.
+func biffString16(d []byte) string {
+	if len(d) < 3 {
+		return ""
+	}
+	r := &biffReader{chunks: [][]byte{d}}
+	return r.unicode(int(r.uint16()))
+}
+
+// biffSharedStrings reads the shared string table, which may run over
+// several CONTINUE records.
+func biffSharedStrings(rec biffRecord) ([]string, error) {
+	r := &biffReader{chunks: append([][]byte{rec.data}, rec.continues...)}
+	r.skip(4)
+	count := int(r.uint32())
+	if count > 1<<22 {
+		return nil, errors.New("invalid shared string table")
+	}
+
+	strs := make([]string, 0, count)
+	for i := 0; i < count && !r.done(); i++ {
+		n := int(r.uint16())
+		strs = append(strs, r.unicode(n))
+	}
+	return strs, nil
+}
+
+// biffReader reads across a record and its CONTINUE records. A string's
+// characters that run into a CONTINUE record are preceded there by a fresh
+// options byte saying whether they are compressed.
+type biffReader struct {
+	chunks [][]byte
+	chunk  int
+	off    int
+}
+
+func (r *biffReader) done() bool {
+	for r.chunk < len(r.chunks) && r.off >= len(r.chunks[r.chunk]) {
+		r.chunk++
+		r.off = 0
+	}
+	return r.chunk >= len(r.chunks)
+}
+
+func (r *biffReader) byte() byte {
+	if r.done() {
+		return 0
+	}
+	b := r.chunks[r.chunk][r.off]
+	r.off++
+	return b
+}
+
+func (r *biffReader) skip(n int) {
+	for i := 0; i < n; i++ {
+		r.byte()
+	}
+}
+
+func (r *biffReader) uint16() uint16 {
+	return uint16(r.byte()) | uint16(r.byte())<<8
+}
+
+func (r *biffReader) uint32() uint32 {
+	return uint32(r.uint16()) | uint32(r.uint16())<<16
+}
+
+// unicode reads a string of n characters after its options byte, then skips
+// its rich text runs and phonetic data.
+func (r *biffReader) unicode(n int) string {
+	options := r.byte()
+	runs, ext := 0, 0
+	if options&0x08 != 0 {
+		runs = int(r.uint16())
+	}
+	if options&0x04 != 0 {
+		ext = int(r.uint32())
+	}
+
+	wide := options&0x01 != 0
+	chars := make([]uint16, 0, n)
+	for len(chars) < n && !r.done() {
+		if r.off == 0 && r.chunk > 0 && len(chars) > 0 {
+			wide = r.byte()&0x01 != 0
+		}
+		if wide {
+			chars = append(chars, r.uint16())
+		} else {
+			chars = append(chars, uint16(r.byte()))
+		}
+	}
+	r.skip(4*runs + ext)
+	return string(utf16.Decode(chars))
+}
@@ -0,0 +1,88 @@
+package importers
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// compoundHeader builds a compound file of a header and n empty 512 byte
+// sectors, with no FAT and the DIFAT chain starting at difat.
+func compoundHeader(n int, difat, count uint32) []byte {
+	data := make([]byte, 512*(n+1))
+	copy(data, cfbSignature)
+	le := binary.LittleEndian
+	le.PutUint16(data[0x1E:], 9)
+	le.PutUint32(data[0x44:], difat)
+	le.PutUint32(data[0x48:], count)
+	for i := 0; i < 109; i++ {
+		le.PutUint32(data[0x4C+4*i:], cfbFree)
+	}
+	return data
+}
+
+func TestOpenCompoundFileDIFAT(t *testing.T) {
+	le := binary.LittleEndian
+
+	// Sector 0 links back to itself
+	cycle := compoundHeader(1, 0, 1<<31)
+	le.PutUint32(cycle[512+508:], 0)
+
+	// Sectors 0 and 1 link to each other
+	loop := compoundHeader(2, 0, 1<<31)
+	le.PutUint32(loop[512+508:], 1)
+	le.PutUint32(loop[1024+508:], 0)
+
+	// The count claims more sectors than the file has
+	short := compoundHeader(1, 0, 1<<31)
+	le.PutUint32(short[512+508:], 5)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"self loop", cycle, "broken DIFAT chain"},
+		{"two sector loop", loop, "broken DIFAT chain"},
+		{"past the end", short, "out of range"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := openCompoundFile(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportBankSheetReferences(t *testing.T) {
+	rows := [][]string{
+		{"HDFC BANK Ltd."},
+		{"Account No :50100012345678"},
+		{"Date", "Narration", "Chq./Ref.No.", "Value Dt", "Withdrawal Amt.", "Deposit Amt.", "Closing Balance"},
+		{"01/03/24", "UPI-SWIGGY-swiggy@axis-406512345678", "0000406512345678", "01/03/24", "250.00", "", "9750.00"},
+		{"02/03/24", "CHQ PAID-MICR CTS-RAVI KUMAR", "000123", "02/03/24", "5000.00", "", "4750.00"},
+		{"04/03/24", "CHQ RETURN-INSUFFICIENT FUNDS", "000123", "04/03/24", "", "5000.00", "9750.00"},
+		{"05/03/24", "INTEREST PAID", "0000000000000000", "05/03/24", "", "12.00", "9762.00"},
+	}
+	sheet, err := ImportBankSheet("", rows, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sheet.Bank != "hdfc" || sheet.Account != "50100012345678" {
+		t.Fatalf("read as %q %q", sheet.Bank, sheet.Account)
+	}
+
+	// The cheque and its return share a number, so neither keeps it
+	want := []string{"0000406512345678", "", "", ""}
+	if len(sheet.Records) != len(want) {
+		t.Fatalf("got %d records, want %d", len(sheet.Records), len(want))
+	}
+	for i, ref := range want {
+		if sheet.Records[i].Reference != ref {
+			t.Errorf("record %d has reference %q, want %q", i, sheet.Records[i].Reference, ref)
+		}
+	}
+}
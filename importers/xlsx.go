@@ -0,0 +1,261 @@
+package importers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// xlsxRowLimit guards against sheets that claim an absurd row number.
+const xlsxRowLimit = 1 << 20
+
+// readXLSX reads the first sheet of an Office Open XML workbook.
+func readXLSX(data []byte) ([][]string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	sheet, err := xlsxFirstSheet(files)
+	if err != nil {
+		return nil, err
+	}
+	strs, err := xlsxSharedStrings(files["xl/sharedStrings.xml"])
+	if err != nil {
+		return nil, err
+	}
+	dates, date1904, err := xlsxDateStyles(files["xl/styles.xml"], files["xl/workbook.xml"])
+	if err != nil {
+		return nil, err
+	}
+
+	f, ok := files[sheet]
+	if !ok {
+		return nil, errors.New("the workbook has no sheets")
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var rows [][]string
+	// Writers may leave out the references of rows and cells, which then
+	// follow on from the previous one
+	currentRow, nextCol := -1, 0
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if ok && start.Name.Local == "row" {
+			currentRow++
+			for _, attr := range start.Attr {
+				if n, err := strconv.Atoi(attr.Value); attr.Name.Local == "r" && err == nil && n > 0 {
+					currentRow = n - 1
+				}
+			}
+			nextCol = 0
+			continue
+		}
+		if !ok || start.Name.Local != "c" {
+			continue
+		}
+
+		var cell struct {
+			Ref    string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Style  int    `xml:"s,attr"`
+			Value  string `xml:"v"`
+			Inline []struct {
+				Text string `xml:",chardata"`
+			} `xml:"is>t"`
+			Runs []struct {
+				Text string `xml:",chardata"`
+			} `xml:"is>r>t"`
+		}
+		if err := decoder.DecodeElement(&cell, &start); err != nil {
+			return nil, err
+		}
+		row, col, ok := xlsxCellRef(cell.Ref)
+		if !ok {
+			row, col = currentRow, nextCol
+		}
+		nextCol = col + 1
+		if row < 0 || row >= xlsxRowLimit {
+			continue
+		}
+
+		value := cell.Value
+		switch cell.Type {
+		case "s":
+			i, err := strconv.Atoi(cell.Value)
+			if err != nil || i < 0 || i >= len(strs) {
+				return nil, errors.New("invalid shared string " + cell.Value)
+			}
+			value = strs[i]
+		case "inlineStr":
+			var b strings.Builder
+			for _, t := range cell.Inline {
+				b.WriteString(t.Text)
+			}
+			for _, t := range cell.Runs {
+				b.WriteString(t.Text)
+			}
+			value = b.String()
+		case "", "n":
+			if v, err := strconv.ParseFloat(cell.Value, 64); err == nil {
+				value = excelValue(v, dates[cell.Style], date1904)
+			}
+		}
+		rows = setCell(rows, row, col, value)
+	}
+	return rows, nil
+}
+
+// xlsxFirstSheet returns the path of the workbook's first sheet.
+func xlsxFirstSheet(files map[string]*zip.File) (string, error) {
+	var workbook struct {
+		Sheets []struct {
+			ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xlsxDecode(files["xl/workbook.xml"], &workbook); err != nil {
+		return "", err
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := xlsxDecode(files["xl/_rels/workbook.xml.rels"], &rels); err != nil {
+		return "", err
+	}
+
+	if len(workbook.Sheets) == 0 {
+		return "xl/worksheets/sheet1.xml", nil
+	}
+	for _, rel := range rels.Relationships {
+		if rel.ID != workbook.Sheets[0].ID {
+			continue
+		}
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/"), nil
+		}
+		return path.Join("xl", rel.Target), nil
+	}
+	return "xl/worksheets/sheet1.xml", nil
+}
+
+// xlsxSharedStrings reads the shared string table, joining the runs of rich
+// text.
+func xlsxSharedStrings(f *zip.File) ([]string, error) {
+	if f == nil {
+		return nil, nil
+	}
+	var sst struct {
+		Items []struct {
+			Text string `xml:"t"`
+			Runs []struct {
+				Text string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	if err := xlsxDecode(f, &sst); err != nil {
+		return nil, err
+	}
+
+	strs := make([]string, len(sst.Items))
+	for i, item := range sst.Items {
+		text := item.Text
+		for _, run := range item.Runs {
+			text += run.Text
+		}
+		strs[i] = text
+	}
+	return strs, nil
+}
+
+// xlsxDateStyles reports which cell styles display a date, and whether the
+// workbook counts dates from 1904.
+func xlsxDateStyles(styles, workbook *zip.File) (map[int]bool, bool, error) {
+	var sheet struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		CellXfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if styles != nil {
+		if err := xlsxDecode(styles, &sheet); err != nil {
+			return nil, false, err
+		}
+	}
+	var props struct {
+		Pr struct {
+			Date1904 string `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+	}
+	if err := xlsxDecode(workbook, &props); err != nil {
+		return nil, false, err
+	}
+
+	formats := make(map[int]bool)
+	for id := range excelDateFormats {
+		formats[id] = true
+	}
+	for _, format := range sheet.NumFmts {
+		formats[format.ID] = isDateFormat(format.Code)
+	}
+
+	dates := make(map[int]bool)
+	for i, xf := range sheet.CellXfs {
+		dates[i] = formats[xf.NumFmtID]
+	}
+	date1904 := props.Pr.Date1904 == "1" || props.Pr.Date1904 == "true"
+	return dates, date1904, nil
+}
+
+func xlsxDecode(f *zip.File, v interface{}) error {
+	if f == nil {
+		return nil
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// xlsxCellRef reads a reference such as "B12" as a zero based row and
+// column.
+func xlsxCellRef(ref string) (row, col int, ok bool) {
+	i := 0
+	for i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z' {
+		col = col*26 + int(ref[i]-'A'+1)
+		i++
+	}
+	n, err := strconv.Atoi(ref[i:])
+	if i == 0 || err != nil || n < 1 {
+		return 0, 0, false
+	}
+	return n - 1, col - 1, true
+}
@@ -0,0 +1,64 @@
+package importers
+
+import (
+	"strings"
+
+	"github.com/UmangSachdeva/PaymentX/helpers"
+)
+
+// ynab reads a YNAB register export, from either YNAB 4 or the current app.
+// Money out and in are separate columns, and the date order follows the
+// user's YNAB settings.
+var ynab = profile{
+	name:     "ynab",
+	required: []string{"account", "date", "payee", "outflow", "inflow"},
+	dates:    []string{"date"},
+	order:    "mdy",
+	parse: func(h header, row []string, opts Options) (Record, bool, error) {
+		var rec Record
+
+		payee := h.get(row, "payee")
+		// The opening balance of each account is not a transaction
+		if strings.EqualFold(payee, "Starting Balance") {
+			return rec, true, nil
+		}
+
+		date, err := parseDate(h.get(row, "date"), opts.DateOrder)
+		if err != nil {
+			return rec, false, err
+		}
+		outflow, err := parseAmount(h.get(row, "outflow"))
+		if err != nil {
+			return rec, false, err
+		}
+		inflow, err := parseAmount(h.get(row, "inflow"))
+		if err != nil {
+			return rec, false, err
+		}
+		if inflow == 0 && outflow == 0 {
+			return rec, true, nil
+		}
+
+		memo := h.get(row, "memo")
+		rec = Record{
+			Date:    date,
+			Details: strings.TrimSpace(payee + " " + memo),
+			Payee:   payee,
+			Account: h.get(row, "account"),
+			Notes:   memo,
+		}
+		rec.signed(inflow - outflow)
+
+		// Transfers between accounts are left for transfer detection to pair
+		if strings.HasPrefix(payee, "Transfer : ") {
+			rec.Payee = ""
+			return rec, false, nil
+		}
+		// YNAB 4 calls the category Sub Category
+		rec.Category = helpers.MapCategory(h.get(row, "category", "sub category", "category group/category"))
+		if flag := h.get(row, "flag"); flag != "" {
+			rec.Tags = []string{strings.ToLower(flag)}
+		}
+		return rec, false, nil
+	},
+}
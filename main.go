@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/UmangSachdeva/PaymentX/handlers"
 	"github.com/UmangSachdeva/PaymentX/middleware"
 	"github.com/UmangSachdeva/PaymentX/router"
 	"github.com/joho/godotenv"
@@ -16,6 +18,21 @@ func main() {
 	// Load Env file
 	godotenv.Load(".env")
 
+	// Permanently remove transactions whose trash retention has run out
+	handlers.StartTrashPurger(time.Hour)
+
+	// Index transactions stored before search had its own index
+	handlers.StartSearchBackfill()
+
+	// Recompute dedup hashes stored before the key last changed
+	handlers.StartHashBackfill()
+
+	// Drop the zero balances that stood for no balance before it was optional
+	handlers.StartBalanceMigration()
+
+	// Run queued imports in the background, four at a time
+	handlers.StartJobWorkers(4)
+
 	r := router.Router()
 	paymentRouter := router.PaymentRouter()
 
@@ -0,0 +1,263 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/UmangSachdeva/PaymentX/config"
+	"github.com/UmangSachdeva/PaymentX/models"
+	"github.com/dgrijalva/jwt-go"
+	cont "github.com/gorilla/context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// IdempotencyHeader names the key a client sends with a write request it
+	// may retry.
+	IdempotencyHeader = "Idempotency-Key"
+
+	// defaultIdempotencyTTL is how long keys are kept unless IDEMPOTENCY_TTL
+	// says otherwise.
+	defaultIdempotencyTTL = 24 * time.Hour
+	// idempotencyMaxKey caps the length of a key.
+	idempotencyMaxKey = 255
+	// idempotencyMaxBody caps the body read to fingerprint the request.
+	idempotencyMaxBody = 20 << 20
+)
+
+// idempotencyTTL reads IDEMPOTENCY_TTL, a duration such as "24h" or "30m".
+func idempotencyTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return defaultIdempotencyTTL
+}
+
+// IdempotencyMiddleware makes write requests sent with an Idempotency-Key
+// header safe to retry. The first response given for a key is stored and
+// replayed for retries with the same payload; a retry with a different
+// payload, or one sent while the first is still being served, gets 409
+// Conflict. Server errors are not stored so the request can be retried, nor
+// is a request that panicked. Keys belong to the signed in user, so it must
+// run after AuthenticationMiddleware on restricted routes; before signing
+// in they belong to the client's address. Tokens are left out of the
+// stored response, so a replay does not hand out a credential again.
+func IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > idempotencyMaxKey {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, idempotencyMaxBody))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		client, err := config.ConnectToMongo()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer client.Disconnect(context.Background())
+		collection := client.Database("paymentx").Collection("idempotency_keys")
+
+		now := time.Now()
+		hash := requestHash(r, body)
+		record := models.IdempotencyKey{
+			ID:          primitive.NewObjectID(),
+			Scope:       idempotencyScope(r),
+			Key:         key,
+			RequestHash: hash,
+			CreatedAt:   primitive.NewDateTimeFromTime(now),
+			ExpiresAt:   primitive.NewDateTimeFromTime(now.Add(idempotencyTTL())),
+		}
+
+		existing, err := claimIdempotencyKey(collection, record)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.RequestHash != record.RequestHash:
+				http.Error(w, "Idempotency-Key was already used with a different request", http.StatusConflict)
+			case !existing.Completed:
+				http.Error(w, "A request with this Idempotency-Key is still being processed", http.StatusConflict)
+			default:
+				if existing.ContentType != "" {
+					w.Header().Set("Content-Type", existing.ContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.StatusCode)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		// Server errors and panics release the key so the client can try
+		// again
+		release := func() {
+			if _, err := collection.DeleteOne(context.Background(), bson.M{"_id": record.ID}); err != nil {
+				fmt.Println("Failed to release idempotency key:", err)
+			}
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				release()
+				panic(p)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status >= http.StatusInternalServerError {
+			release()
+			return
+		}
+		if _, err := collection.UpdateOne(context.Background(),
+			bson.M{"_id": record.ID},
+			bson.M{"$set": bson.M{
+				"completed":   true,
+				"statuscode":  recorder.status,
+				"contenttype": recorder.Header().Get("Content-Type"),
+				"body":        redactTokens(recorder.body.Bytes()),
+			}},
+		); err != nil {
+			fmt.Println("Failed to store idempotent response:", err)
+		}
+	})
+}
+
+// claimIdempotencyKey stores the record for a new key and returns nil, or
+// returns the record already stored under the key. A record past its
+// expiry that Mongo has not dropped yet is replaced.
+func claimIdempotencyKey(collection *mongo.Collection, record models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err := collection.InsertOne(context.Background(), record)
+		if err == nil {
+			return nil, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+
+		var existing models.IdempotencyKey
+		err = collection.FindOne(context.Background(), bson.M{"scope": record.Scope, "key": record.Key}).Decode(&existing)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if existing.ExpiresAt.Time().After(time.Now()) {
+			return &existing, nil
+		}
+		if _, err := collection.DeleteOne(context.Background(), bson.M{"_id": existing.ID}); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("could not claim idempotency key")
+}
+
+// idempotencyScope is the user a request was made by. When no one is signed
+// in it is the address the request came from, so that a key one client
+// picked cannot replay another client's response, while a retry from the
+// same client with a different payload is still caught.
+func idempotencyScope(r *http.Request) string {
+	if claims, ok := cont.Get(r, "user").(jwt.MapClaims); ok {
+		if userID, ok := claims["user_id"].(string); ok {
+			return "user:" + userID
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "client:" + host
+}
+
+// redactTokens drops the fields of a JSON response whose names mention a
+// token, such as the session token signing up hands out. A body that is not
+// JSON is not stored at all.
+func redactTokens(body []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil
+	}
+	redacted, err := json.Marshal(redactTokenFields(value))
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+func redactTokenFields(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, field := range v {
+			if strings.Contains(strings.ToLower(name), "token") {
+				delete(v, name)
+				continue
+			}
+			v[name] = redactTokenFields(field)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactTokenFields(v[i])
+		}
+	}
+	return value
+}
+
+// requestHash fingerprints what a request asks for: its method, path, query
+// and body.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s?%s\n", r.Method, r.URL.Path, r.URL.RawQuery)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,25 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type AccountType string
+
+const (
+	SavingsAccount    AccountType = "savings"
+	CurrentAccount    AccountType = "current"
+	CreditCardAccount AccountType = "credit_card"
+	WalletAccount     AccountType = "wallet"
+)
+
+// Account is one of the user's bank accounts, cards or wallets. Number holds
+// the (masked) account number as it appears in narrations.
+type Account struct {
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Name        string             `json:"name"`
+	Institution string             `json:"institution,omitempty"`
+	Type        AccountType        `json:"type,omitempty"`
+	Number      string             `json:"number,omitempty"`
+	VPAs        []string           `json:"vpas,omitempty"`
+	CreatedAt   primitive.DateTime `json:"created_at"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type AlertRuleKind string
+
+const (
+	AlertDebitAbove        AlertRuleKind = "debit_above"
+	AlertBalanceBelow      AlertRuleKind = "balance_below"
+	AlertMerchantFrequency AlertRuleKind = "merchant_frequency"
+	AlertCreditContains    AlertRuleKind = "credit_contains"
+)
+
+type AlertChannel string
+
+const (
+	ChannelInApp   AlertChannel = "in_app"
+	ChannelEmail   AlertChannel = "email"
+	ChannelWebhook AlertChannel = "webhook"
+)
+
+// AlertRule is a user defined condition that is evaluated against every
+// newly inserted transaction.
+type AlertRule struct {
+	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Name       string             `json:"name"`
+	Kind       AlertRuleKind      `json:"kind"`
+	Threshold  float64            `json:"threshold,omitempty"`
+	Count      int                `json:"count,omitempty"`
+	Keyword    string             `json:"keyword,omitempty"`
+	Channels   []AlertChannel     `json:"channels"`
+	WebhookURL string             `json:"webhook_url,omitempty"`
+	Enabled    bool               `json:"enabled"`
+	CreatedAt  primitive.DateTime `json:"created_at"`
+}
+
+// Alert is a triggered AlertRule. DedupKey is unique so the same rule never
+// fires twice for the same transaction (or merchant and day).
+type Alert struct {
+	ID            primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID        primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	RuleID        primitive.ObjectID `json:"rule_id,omitempty" bson:"rule_id,omitempty"`
+	TransactionID primitive.ObjectID `json:"transaction_id,omitempty" bson:"transaction_id,omitempty"`
+	Kind          AlertRuleKind      `json:"kind"`
+	Message       string             `json:"message"`
+	DedupKey      string             `json:"-"`
+	Read          bool               `json:"read"`
+	CreatedAt     primitive.DateTime `json:"created_at"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type AnomalyKind string
+
+const (
+	AnomalyMerchantOutlier AnomalyKind = "merchant_outlier"
+	AnomalyCategoryOutlier AnomalyKind = "category_outlier"
+	AnomalyDailySpike      AnomalyKind = "daily_spike"
+	AnomalyDuplicateCharge AnomalyKind = "duplicate_charge"
+	AnomalyUnusualHour     AnomalyKind = "unusual_hour"
+)
+
+// Anomaly is unusual activity flagged on a user's transactions. Score is
+// relative to the kind, higher meaning more unusual.
+type Anomaly struct {
+	ID            primitive.ObjectID   `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID        primitive.ObjectID   `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	TransactionID primitive.ObjectID   `json:"transaction_id,omitempty" bson:"transaction_id,omitempty"`
+	RelatedIDs    []primitive.ObjectID `json:"related_ids,omitempty" bson:"related_ids,omitempty"`
+	Kind          AnomalyKind          `json:"kind"`
+	Score         float64              `json:"score"`
+	Explanation   string               `json:"explanation"`
+	Date          primitive.DateTime   `json:"date"`
+	DedupKey      string               `json:"-"`
+	Dismissed     bool                 `json:"dismissed"`
+	CreatedAt     primitive.DateTime   `json:"created_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type DuplicateStatus string
+
+const (
+	DuplicatePending   DuplicateStatus = "pending"
+	DuplicateMerged    DuplicateStatus = "merged"
+	DuplicateDismissed DuplicateStatus = "dismissed"
+)
+
+// DuplicateCandidate is a pair of transactions that look like the same real
+// world payment imported from different sources.
+type DuplicateCandidate struct {
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	KeepID      primitive.ObjectID `json:"keep_id" bson:"keep_id"`
+	DuplicateID primitive.ObjectID `json:"duplicate_id" bson:"duplicate_id"`
+	Score       float64            `json:"score"`
+	Reasons     []string           `json:"reasons"`
+	Status      DuplicateStatus    `json:"status"`
+	Auto        bool               `json:"auto"`
+	DedupKey    string             `json:"-"`
+	CreatedAt   primitive.DateTime `json:"created_at"`
+	ResolvedAt  primitive.DateTime `json:"resolved_at,omitempty"`
+}
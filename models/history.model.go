@@ -0,0 +1,47 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type ChangeSource string
+
+const (
+	ChangeManual ChangeSource = "manual"
+	ChangeImport ChangeSource = "import"
+	ChangeSync   ChangeSource = "sync"
+	ChangeRule   ChangeSource = "rule"
+)
+
+type ChangeAction string
+
+const (
+	ChangeCreate  ChangeAction = "create"
+	ChangeUpdate  ChangeAction = "update"
+	ChangeDelete  ChangeAction = "delete"
+	ChangeRestore ChangeAction = "restore"
+	ChangeRevert  ChangeAction = "revert"
+)
+
+// FieldChange is one field of a transaction before and after a change.
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// TransactionChange is an entry in a transaction's change log. Entries are
+// only ever inserted. Snapshot is the transaction as it was right after the
+// change, which is what a revert to Version restores.
+type TransactionChange struct {
+	ID            primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID        primitive.ObjectID `json:"-" bson:"user_id,omitempty"`
+	TransactionID primitive.ObjectID `json:"transaction_id" bson:"transaction_id"`
+	Version       int                `json:"version"`
+	Action        ChangeAction       `json:"action"`
+	Source        ChangeSource       `json:"source"`
+	// Actor is the user id for manual changes, the rule name for rules and
+	// the upload source for imports and syncs.
+	Actor     string             `json:"actor"`
+	Changes   []FieldChange      `json:"changes,omitempty"`
+	Snapshot  Transaction        `json:"snapshot"`
+	CreatedAt primitive.DateTime `json:"created_at"`
+}
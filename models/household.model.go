@@ -0,0 +1,35 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Household is a group of users who share saved views. The owner is always
+// one of the members and is the only one who can invite or remove others.
+type Household struct {
+	ID        primitive.ObjectID   `json:"id,omitempty" bson:"_id,omitempty"`
+	Name      string               `json:"name"`
+	OwnerID   primitive.ObjectID   `json:"owner_id" bson:"owner_id"`
+	MemberIDs []primitive.ObjectID `json:"member_ids" bson:"member_ids"`
+	CreatedAt primitive.DateTime   `json:"created_at"`
+}
+
+type InvitationStatus string
+
+const (
+	InvitationPending  InvitationStatus = "pending"
+	InvitationAccepted InvitationStatus = "accepted"
+	InvitationDeclined InvitationStatus = "declined"
+)
+
+// HouseholdInvitation asks whoever signs in with Email to join a household.
+// The invitee only becomes a member once they accept it.
+type HouseholdInvitation struct {
+	ID            primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	HouseholdID   primitive.ObjectID `json:"household_id" bson:"household_id"`
+	HouseholdName string             `json:"household_name"`
+	InvitedBy     primitive.ObjectID `json:"invited_by" bson:"invited_by"`
+	// Email is stored lowercased
+	Email       string             `json:"email"`
+	Status      InvitationStatus   `json:"status"`
+	CreatedAt   primitive.DateTime `json:"created_at"`
+	RespondedAt primitive.DateTime `json:"responded_at,omitempty"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// IdempotencyKey records the first response given to a write request sent
+// with an Idempotency-Key header, so that retries get the same response.
+// Scope is the user the key belongs to, or the client address for requests
+// made before signing in. Body is the response with any tokens left out.
+// Mongo drops the record once ExpiresAt has passed.
+type IdempotencyKey struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	Scope       string
+	Key         string
+	RequestHash string
+	// Completed is false while the first request is still being served
+	Completed   bool
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   primitive.DateTime
+	ExpiresAt   primitive.DateTime
+}
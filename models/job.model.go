@@ -0,0 +1,61 @@
+package models
+
+import (
+	"encoding/json"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCanceled  JobStatus = "canceled"
+)
+
+// Kinds of job, named after the endpoint whose work they run.
+const (
+	JobUpload    = "upload"
+	JobImport    = "import"
+	JobSMSAlerts = "sms_alerts"
+)
+
+// JobProgress is how far a running job has got. Total is 0 until the job
+// knows how much there is to do.
+type JobProgress struct {
+	Stage string `json:"stage"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+// Job is an import run in the background. The request it was queued with
+// is kept, its body in the job_payloads GridFS bucket, until the job has
+// finished, and Result holds the response the endpoint would have given.
+// Failed attempts are retried with backoff from NextRunAt.
+type Job struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id,omitempty"`
+	Kind        string             `json:"kind"`
+	Status      JobStatus          `json:"status"`
+	Query       string             `json:"-"`
+	ContentType string             `json:"-"`
+	Progress    JobProgress        `json:"progress"`
+	Attempts    int                `json:"attempts"`
+	MaxAttempts int                `json:"max_attempts"`
+	// Cancel asks the worker running the job to stop
+	Cancel     bool               `json:"cancel,omitempty"`
+	StatusCode int                `json:"status_code,omitempty"`
+	Result     json.RawMessage    `json:"result,omitempty" bson:"result,omitempty"`
+	Error      string             `json:"error,omitempty"`
+	NextRunAt  primitive.DateTime `json:"next_run_at"`
+	LeaseUntil primitive.DateTime `json:"-"`
+	CreatedAt  primitive.DateTime `json:"created_at"`
+	StartedAt  primitive.DateTime `json:"started_at,omitempty" bson:"startedat,omitempty"`
+	UpdatedAt  primitive.DateTime `json:"updated_at"`
+	FinishedAt primitive.DateTime `json:"finished_at,omitempty" bson:"finishedat,omitempty"`
+	// ExpiresAt lets Mongo drop the job a while after it finished
+	ExpiresAt primitive.DateTime `json:"-" bson:"expiresat,omitempty"`
+}
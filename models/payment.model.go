@@ -9,18 +9,87 @@ const (
 	Credit TransactionType = "CREDIT"
 )
 
+// TransactionSplit is one part of a transaction split across categories,
+// such as the household items in a supermarket bill.
+type TransactionSplit struct {
+	Amount   float64  `json:"amount"`
+	Category string   `json:"category"`
+	Tags     []string `json:"tags,omitempty" bson:"tags,omitempty"`
+	Note     string   `json:"note,omitempty"`
+}
+
 // Transaction represents a unique transaction in the database.
 // To ensure uniqueness, you should create a unique index in MongoDB on relevant fields.
 // For example, you can create a unique index on (UserID, TransactionDate, Amount, Details, Type).
 type Transaction struct {
 	ID              primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	UserID          primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	AccountID       primitive.ObjectID `json:"account_id,omitempty" bson:"account_id,omitempty"`
 	Amount          float64            `json:"amount,omitempty"`
 	TransactionDate primitive.DateTime `json:"transaction_date"`
 	TransactionTime string             `json:"transaction_time"`
 	ValueDate       string             `json:"value_date"`
 	Details         string             `json:"details"`
 	Type            TransactionType    `json:"type"`
-	Balance         float64            `json:"balance"`
-	TransactionID   string				`json:"transaction_id"`
+	Balance         *float64           `json:"balance,omitempty" bson:"balance,omitempty"`
+	TransactionID   string             `json:"transaction_id"`
+	Merchant        string             `json:"merchant,omitempty"`
+	Counterparty    string             `json:"counterparty,omitempty"`
+	Category        string             `json:"category,omitempty"`
+	Notes           string             `json:"notes,omitempty"`
+	Tags            []string           `json:"tags,omitempty" bson:"tags,omitempty"`
+	// Splits divides the amount across categories. The parts add up to
+	// Amount, and category analytics count the parts instead of the
+	// transaction itself.
+	Splits []TransactionSplit `json:"splits,omitempty" bson:"splits,omitempty"`
+	// Reference is the bank's own id for the transaction, such as an OFX
+	// FITID. When set it takes the place of the contents in the dedup hash.
+	Reference string `json:"reference,omitempty"`
+	// Occurrence tells apart rows of one import with the same contents, such
+	// as two coffees on one day: the second is 2, the third 3 and so on.
+	Occurrence int `json:"-" bson:"occurrence,omitempty"`
+	// HashVersion is the version of the dedup key TransactionID was made
+	// with, so hashes stored under an older key can be recomputed.
+	HashVersion int `json:"-" bson:"hashversion,omitempty"`
+	// Provisional marks a transaction read from a bank's SMS or email
+	// alert. It stands in until the statement or sync brings in the same
+	// transaction, which it is then merged into.
+	Provisional bool `json:"provisional,omitempty"`
+	// Excluded leaves a transaction out of spend and income analytics
+	// without deleting it.
+	Excluded       bool               `json:"excluded,omitempty"`
+	Source         string             `json:"source,omitempty"`
+	StatementID    primitive.ObjectID `json:"statement_id,omitempty" bson:"statement_id,omitempty"`
+	Locked         bool               `json:"locked,omitempty"`
+	IsTransfer     bool               `json:"is_transfer,omitempty"`
+	TransferPairID primitive.ObjectID `json:"transfer_pair_id,omitempty" bson:"transfer_pair_id,omitempty"`
+	// DismissedTransfers lists the transactions the user unlinked this one
+	// from as a transfer, so the matcher does not pair them again.
+	DismissedTransfers []primitive.ObjectID `json:"dismissed_transfers,omitempty" bson:"dismissed_transfers,omitempty"`
+	// RefundOf points a refund or reversal CREDIT at its originating DEBIT,
+	// whose RefundedAmount adds up the refunds it received.
+	RefundOf       primitive.ObjectID `json:"refund_of,omitempty" bson:"refund_of,omitempty"`
+	RefundedAmount float64            `json:"refunded_amount,omitempty"`
+	// MergedInto marks a transaction as a duplicate of another one imported
+	// from a different source. It is kept for provenance but hidden from
+	// listings and analytics; MergedFrom lists the duplicates folded in.
+	MergedInto primitive.ObjectID   `json:"merged_into,omitempty" bson:"merged_into,omitempty"`
+	MergedFrom []primitive.ObjectID `json:"merged_from,omitempty" bson:"merged_from,omitempty"`
+	// BatchID groups the transactions stored by one upload so the upload can
+	// be rolled back.
+	BatchID primitive.ObjectID `json:"batch_id,omitempty" bson:"batch_id,omitempty"`
+	// Unprocessed is set while the post-insert stages, such as transfer and
+	// refund matching, have yet to run over a stored upload, so a retried
+	// upload job runs them over the rows an earlier attempt stored.
+	Unprocessed bool `json:"-" bson:"unprocessed,omitempty"`
+	// SearchTerms indexes the words of the merchant, counterparty, details
+	// and notes for search, as every prefix of each word.
+	SearchTerms []string `json:"-" bson:"searchterms"`
+	// DeletedAt moves a transaction to the trash. Trashed transactions are
+	// hidden everywhere and purged once the retention window has passed;
+	// their TransactionID carries their id so the row can be uploaded again.
+	DeletedAt primitive.DateTime `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	// HistoryVersion is the latest version in the transaction's history,
+	// counted up as changes are recorded.
+	HistoryVersion int `json:"-" bson:"historyversion,omitempty"`
 }
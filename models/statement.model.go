@@ -0,0 +1,44 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type StatementStatus string
+
+const (
+	StatementReconciled   StatementStatus = "reconciled"
+	StatementUnreconciled StatementStatus = "unreconciled"
+)
+
+// Statement is a bank statement period with its opening and closing
+// balances. Once reconciled its transactions are locked against edits.
+type Statement struct {
+	ID               primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID           primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	AccountID        primitive.ObjectID `json:"account_id,omitempty" bson:"account_id,omitempty"`
+	PeriodStart      primitive.DateTime `json:"period_start"`
+	PeriodEnd        primitive.DateTime `json:"period_end"`
+	OpeningBalance   float64            `json:"opening_balance"`
+	ClosingBalance   float64            `json:"closing_balance"`
+	Derived          bool               `json:"derived"`
+	Status           StatementStatus    `json:"status"`
+	Locked           bool               `json:"locked"`
+	TransactionCount int                `json:"transaction_count"`
+	TotalCredits     float64            `json:"total_credits"`
+	TotalDebits      float64            `json:"total_debits"`
+	ComputedClosing  float64            `json:"computed_closing"`
+	Difference       float64            `json:"difference"`
+	Issues           []StatementIssue   `json:"issues"`
+	ReconciledAt     primitive.DateTime `json:"reconciled_at,omitempty"`
+	CreatedAt        primitive.DateTime `json:"created_at"`
+}
+
+// StatementIssue is a row that does not fit the statement's running balance,
+// or a gap where rows appear to be missing.
+type StatementIssue struct {
+	Kind          string             `json:"kind"`
+	TransactionID primitive.ObjectID `json:"transaction_id,omitempty" bson:"transaction_id,omitempty"`
+	Date          string             `json:"date,omitempty"`
+	Expected      float64            `json:"expected"`
+	Actual        float64            `json:"actual"`
+	Difference    float64            `json:"difference"`
+}
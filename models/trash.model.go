@@ -0,0 +1,15 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// UndoToken lets a user reverse a destructive bulk action for a short time.
+// Mongo drops the token once ExpiresAt has passed.
+type UndoToken struct {
+	ID             primitive.ObjectID   `json:"-" bson:"_id,omitempty"`
+	UserID         primitive.ObjectID   `json:"-" bson:"user_id,omitempty"`
+	Token          string               `json:"token"`
+	Action         string               `json:"action"`
+	TransactionIDs []primitive.ObjectID `json:"transaction_ids" bson:"transaction_ids"`
+	CreatedAt      primitive.DateTime   `json:"created_at"`
+	ExpiresAt      primitive.DateTime   `json:"expires_at"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// SavedView is a named transaction filter. Filters holds list parameters
+// such as category or transfers, and Period a rolling window like "3m" that
+// becomes start_date when the view is used. A view with a HouseholdID can be
+// used by every member of that household.
+type SavedView struct {
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	HouseholdID primitive.ObjectID `json:"household_id,omitempty" bson:"household_id,omitempty"`
+	Name        string             `json:"name"`
+	Filters     map[string]string  `json:"filters"`
+	Period      string             `json:"period,omitempty"`
+	Sort        string             `json:"sort,omitempty"`
+	GroupBy     string             `json:"group_by,omitempty"`
+	CreatedAt   primitive.DateTime `json:"created_at"`
+	UpdatedAt   primitive.DateTime `json:"updated_at"`
+}
@@ -1,6 +1,8 @@
 package router
 
 import (
+	"net/http"
+
 	"github.com/UmangSachdeva/PaymentX/handlers"
 	"github.com/UmangSachdeva/PaymentX/middleware"
 	"github.com/gorilla/mux"
@@ -10,7 +12,7 @@ func Router() *mux.Router {
 
 	r := mux.NewRouter()
 
-	r.HandleFunc("/api/v1/auth/signup", handlers.RegisterUser).Methods("POST", "OPTIONS")
+	r.Handle("/api/v1/auth/signup", middleware.IdempotencyMiddleware(http.HandlerFunc(handlers.RegisterUser))).Methods("POST", "OPTIONS")
 	r.HandleFunc("/api/v1/auth/login", handlers.Login).Methods("POST", "OPTIONS")
 
 	restricted := r.PathPrefix("/").Subrouter()
@@ -19,6 +21,7 @@ func Router() *mux.Router {
 	restricted.HandleFunc("/api/v1/auth/users", handlers.GetAllUsers).Methods("GET", "OPTIONS")
 	restricted.HandleFunc("/api/v1/auth", handlers.GetUserDetails).Methods("GET", "OPTIONS")
 	restricted.Use(middleware.AuthenticationMiddleware)
+	restricted.Use(middleware.IdempotencyMiddleware)
 
 	return r
 }
@@ -3,6 +3,7 @@ package router
 import (
 	"github.com/UmangSachdeva/PaymentX/handlers"
 	"github.com/UmangSachdeva/PaymentX/middleware"
+	"github.com/UmangSachdeva/PaymentX/models"
 	"github.com/gorilla/mux"
 )
 
@@ -14,13 +15,87 @@ func PaymentRouter() *mux.Router {
 
 	restricted := r.PathPrefix("/").Subrouter()
 	restricted.Use(middleware.AuthenticationMiddleware)
-	restricted.HandleFunc("/transactions", handlers.InputTransactionData).Methods("POST", "OPTIONS")
-	restricted.HandleFunc("/transactions", handlers.GetUserTransaction).Methods("GET", "OPTIONS")
-	restricted.HandleFunc("/transactions/analysis", handlers.GetTransactionAnalysis).Methods("GET", "OPTIONS")
-	restricted.HandleFunc("/transactions/monthly", handlers.GetMonthlyTransactions).Methods("OPTIONS", "GET")
-	restricted.HandleFunc("/transactions/average", handlers.GetUserAverageMonthlySpend).Methods("OPTIONS", "GET")
-	restricted.HandleFunc("/transactions/pattern", handlers.MonthlyWeeklyPattern).Methods("OPTIONS", "GET")
-	restricted.HandleFunc("/transactions/time", handlers.GetSpendingTimeAnalysis).Methods("OPTIONS", "GET")
-	restricted.HandleFunc("/transactions/debitvscredit", handlers.GetDebitVsCredit).Methods("OPTIONS", "GET")
+	restricted.Use(middleware.IdempotencyMiddleware)
+	restricted.HandleFunc("/transactions", handlers.QueueJob(models.JobUpload)).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/transactions", handlers.WithSavedView(handlers.GetUserTransaction)).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/transactions/manual", handlers.CreateTransaction).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/transactions/bulk", handlers.BulkUpdateTransactions).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/transactions/delete", handlers.DeleteTransactions).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/transactions/{id:[0-9a-f]{24}}", handlers.GetTransaction).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/transactions/{id:[0-9a-f]{24}}", handlers.UpdateTransaction).Methods("PATCH", "OPTIONS")
+	restricted.HandleFunc("/transactions/{id:[0-9a-f]{24}}", handlers.DeleteTransaction).Methods("DELETE", "OPTIONS")
+	restricted.HandleFunc("/transactions/{id:[0-9a-f]{24}}/history", handlers.GetTransactionHistory).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/transactions/{id:[0-9a-f]{24}}/revert", handlers.RevertTransaction).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/transactions/export", handlers.WithSavedView(handlers.ExportTransactions)).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/transactions/search", handlers.WithSavedView(handlers.SearchTransactions)).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/transactions/analysis", handlers.WithSavedView(handlers.GetTransactionAnalysis)).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/transactions/monthly", handlers.WithSavedView(handlers.GetMonthlyTransactions)).Methods("OPTIONS", "GET")
+	restricted.HandleFunc("/transactions/average", handlers.WithSavedView(handlers.GetUserAverageMonthlySpend)).Methods("OPTIONS", "GET")
+	restricted.HandleFunc("/transactions/pattern", handlers.WithSavedView(handlers.MonthlyWeeklyPattern)).Methods("OPTIONS", "GET")
+	restricted.HandleFunc("/transactions/time", handlers.WithSavedView(handlers.GetSpendingTimeAnalysis)).Methods("OPTIONS", "GET")
+	restricted.HandleFunc("/transactions/debitvscredit", handlers.WithSavedView(handlers.GetDebitVsCredit)).Methods("OPTIONS", "GET")
+	restricted.HandleFunc("/transactions/breakdown", handlers.WithSavedView(handlers.GetSpendBreakdown)).Methods("OPTIONS", "GET")
+	restricted.HandleFunc("/transactions/forecast", handlers.GetCashFlowForecast).Methods("OPTIONS", "GET")
+	restricted.HandleFunc("/transactions/balance-history", handlers.GetBalanceHistory).Methods("OPTIONS", "GET")
+	restricted.HandleFunc("/transactions/reconciliation", handlers.GetReconciliation).Methods("OPTIONS", "GET")
+
+	restricted.HandleFunc("/imports", handlers.QueueJob(models.JobImport)).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/imports/sms", handlers.QueueJob(models.JobSMSAlerts)).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/uploads/{id}", handlers.RollbackUpload).Methods("DELETE", "OPTIONS")
+	restricted.HandleFunc("/jobs", handlers.GetJobs).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/jobs/{id:[0-9a-f]{24}}", handlers.GetJob).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/jobs/{id:[0-9a-f]{24}}/events", handlers.StreamJobEvents).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/jobs/{id:[0-9a-f]{24}}/cancel", handlers.CancelJob).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/trash", handlers.GetTrash).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/trash/{id}/restore", handlers.RestoreTransaction).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/undo", handlers.Undo).Methods("POST", "OPTIONS")
+
+	restricted.HandleFunc("/views", handlers.CreateView).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/views", handlers.GetViews).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/views/aggregates", handlers.GetViewAggregates).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/views/{id:[0-9a-f]{24}}", handlers.UpdateView).Methods("PUT", "OPTIONS")
+	restricted.HandleFunc("/views/{id:[0-9a-f]{24}}", handlers.DeleteView).Methods("DELETE", "OPTIONS")
+
+	restricted.HandleFunc("/households", handlers.CreateHousehold).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/households", handlers.GetHouseholds).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/households/invitations", handlers.GetHouseholdInvitations).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/households/invitations/{id}/accept", handlers.AcceptHouseholdInvitation).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/households/invitations/{id}/decline", handlers.DeclineHouseholdInvitation).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/households/{id}/members", handlers.AddHouseholdMember).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/households/{id}/members/{member}", handlers.RemoveHouseholdMember).Methods("DELETE", "OPTIONS")
+
+	restricted.HandleFunc("/accounts", handlers.CreateAccount).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/accounts", handlers.GetAccounts).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/accounts/{id}", handlers.DeleteAccount).Methods("DELETE", "OPTIONS")
+
+	restricted.HandleFunc("/transfers/detect", handlers.DetectTransfers).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/transfers/link", handlers.LinkTransfer).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/transfers/{id}", handlers.UnlinkTransfer).Methods("DELETE", "OPTIONS")
+
+	restricted.HandleFunc("/refunds/detect", handlers.DetectRefunds).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/refunds/link", handlers.LinkRefund).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/refunds/{id}", handlers.UnlinkRefund).Methods("DELETE", "OPTIONS")
+
+	restricted.HandleFunc("/duplicates", handlers.GetDuplicates).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/duplicates/scan", handlers.ScanDuplicates).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/duplicates/merge", handlers.MergeDuplicate).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/duplicates/unmerge", handlers.UnmergeDuplicate).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/duplicates/{id}/dismiss", handlers.DismissDuplicate).Methods("POST", "OPTIONS")
+
+	restricted.HandleFunc("/statements", handlers.CreateStatement).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/statements", handlers.GetStatements).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/statements/{id}/reconcile", handlers.ReconcileStatementAgain).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/statements/{id}/lock", handlers.LockStatement).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/statements/{id}/unlock", handlers.UnlockStatement).Methods("POST", "OPTIONS")
+
+	restricted.HandleFunc("/alerts", handlers.GetAlerts).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/alerts/{id}/read", handlers.MarkAlertRead).Methods("PATCH", "OPTIONS")
+	restricted.HandleFunc("/alerts/rules", handlers.CreateAlertRule).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/alerts/rules", handlers.GetAlertRules).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/alerts/rules/{id}", handlers.DeleteAlertRule).Methods("DELETE", "OPTIONS")
+
+	restricted.HandleFunc("/anomalies", handlers.GetAnomalies).Methods("GET", "OPTIONS")
+	restricted.HandleFunc("/anomalies/scan", handlers.ScanAnomalies).Methods("POST", "OPTIONS")
+	restricted.HandleFunc("/anomalies/{id}/dismiss", handlers.DismissAnomaly).Methods("PATCH", "OPTIONS")
 	return r
 }